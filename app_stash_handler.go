@@ -57,12 +57,13 @@ func (handler *AppStashHandler) PostMatches(responseWriter http.ResponseWriter,
 		util.FprintDescriptionAsJSON(responseWriter, "The request is semantically invalid: must be a non-empty array.")
 		return
 	}
+	blobstore := handler.blobstoreFor(request)
 	matchedFingerprints := []Fingerprint{} // this must not be nil, because the JSON marshaller will not marshal it correctly in case of []
 	for _, entry := range fingerprints {
 		if entry.Size < handler.minimumSize || entry.Size > handler.maximumSize {
 			continue
 		}
-		exists, e := handler.blobstore.Exists(entry.Sha1)
+		exists, e := blobstore.Exists(entry.Sha1)
 		util.PanicOnError(e)
 		if exists {
 			matchedFingerprints = append(matchedFingerprints, entry)
@@ -99,12 +100,13 @@ func (handler *AppStashHandler) PostEntries(responseWriter http.ResponseWriter,
 	}
 	defer openZipFile.Close()
 
+	blobstore := handler.blobstoreFor(request)
 	fingerprints := []Fingerprint{} // this must not be nil, because the JSON marshaller will not marshal it correctly in case of []
 	for _, zipFileEntry := range openZipFile.File {
 		if !zipFileEntry.FileInfo().Mode().IsRegular() {
 			continue
 		}
-		sha, e := copyTo(handler.blobstore, zipFileEntry)
+		sha, e := copyTo(blobstore, zipFileEntry)
 		if _, isNoSpaceLeftError := e.(*NoSpaceLeftError); isNoSpaceLeftError {
 			http.Error(responseWriter, util.DescriptionAndCodeAsJSON(500000, "Request Entity Too Large"), http.StatusInsufficientStorage)
 			return
@@ -226,7 +228,9 @@ func (handler *AppStashHandler) PostBundles(responseWriter http.ResponseWriter,
 		return
 	}
 
-	tempZipFilename, e := CreateTempZipFileFrom(bundlesPayload, zipReader, handler.minimumSize, handler.maximumSize, handler.blobstore, handler.metricsService, logger.From(request))
+	ctx, span := Tracer().Start(request.Context(), "app_stash.assemble_bundle")
+	tempZipFilename, e := CreateTempZipFileFrom(bundlesPayload, zipReader, handler.minimumSize, handler.maximumSize, BlobstoreWithContext(handler.blobstore, ctx), handler.metricsService, logger.From(request))
+	EndSpan(span, e)
 	if e != nil {
 		if notFoundError, ok := e.(*NotFoundError); ok {
 			responseWriter.WriteHeader(http.StatusNotFound)
@@ -245,6 +249,10 @@ func (handler *AppStashHandler) PostBundles(responseWriter http.ResponseWriter,
 	util.PanicOnError(e)
 }
 
+func (handler *AppStashHandler) blobstoreFor(request *http.Request) Blobstore {
+	return BlobstoreWithContext(handler.blobstore, request.Context())
+}
+
 func anyKeyMissingIn(bundlesPayload []Fingerprint) (bool, string) {
 	for _, entry := range bundlesPayload {
 		if entry.Sha1 == "" {
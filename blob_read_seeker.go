@@ -0,0 +1,77 @@
+package bitsgo
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// blobReadSeeker provides the io.ReadSeeker http.ServeContent needs on top of Blobstore.Get.
+// Seeking only moves the offset. The blob is fetched from the offset on with the next Read,
+// which only reads the requested bytes from blobstores implementing RangeGetter.
+type blobReadSeeker struct {
+	blobstore Blobstore
+	path      string
+	size      int64
+	offset    int64
+	body      io.ReadCloser
+	bodyPos   int64
+}
+
+func newBlobReadSeeker(blobstore Blobstore, path string, body io.ReadCloser) *blobReadSeeker {
+	return &blobReadSeeker{blobstore: blobstore, path: path, body: body}
+}
+
+// ServeBlobContent serves the blob at path via http.ServeContent. This takes care of HEAD and Range requests
+// using only the blob's size, so that the blob is only fetched when content needs to be sent.
+// Callers should set a Content-Type. Otherwise, http.ServeContent fetches the blob to sniff it.
+func ServeBlobContent(responseWriter http.ResponseWriter, request *http.Request, blobstore Blobstore, path string, size int64, modTime time.Time) {
+	content := newBlobReadSeeker(blobstore, path, nil)
+	defer content.Close()
+	content.size = size
+	http.ServeContent(responseWriter, request, "", modTime, content)
+}
+
+func (r *blobReadSeeker) Read(p []byte) (int, error) {
+	if r.body != nil && r.bodyPos != r.offset {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.body == nil {
+		body, e := GetRange(r.blobstore, r.path, r.offset, -1)
+		if e != nil {
+			return 0, e
+		}
+		r.body, r.bodyPos = body, r.offset
+	}
+	n, e := r.body.Read(p)
+	r.bodyPos += int64(n)
+	r.offset += int64(n)
+	return n, e
+}
+
+func (r *blobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.Errorf("Invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("Negative position %v", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *blobReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -3,6 +3,10 @@ package bitsgo
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 type NotFoundError struct {
@@ -37,10 +41,31 @@ func NewNoSpaceLeftError() *NoSpaceLeftError {
 	return &NoSpaceLeftError{fmt.Errorf("NoSpaceLeftError")}
 }
 
+// BlobInfo holds the metadata a blobstore keeps about a blob.
+type BlobInfo struct {
+	Path         string
+	Size         int64
+	LastModified time.Time
+	// ETag is an opaque, unquoted identifier for the current content of the blob.
+	// It is empty if the backend does not provide one.
+	ETag string
+	// WeakETag is set when ETag does not guarantee byte-identical content, e.g. because it is derived from
+	// the blob's modification time and size.
+	WeakETag bool
+}
+
 //go:generate pegomock generate --use-experimental-model-gen --package bitsgo_test Blobstore
 type Blobstore interface {
 	Exists(path string) (bool, error)
 
+	// Implementers must return *NotFoundError when the resource cannot be found
+	Stat(path string) (*BlobInfo, error)
+
+	// List returns the blobs whose path starts with prefix in pages of a backend specific size.
+	// An empty pageToken returns the first page. The following pages are returned by passing the previous nextPageToken.
+	// nextPageToken is empty for the last page.
+	List(prefix string, pageToken string) (blobs []BlobInfo, nextPageToken string, err error)
+
 	// Implementers must return *NotFoundError when the resource cannot be found
 	GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error)
 	// Implementers must return *NotFoundError when the resource cannot be found
@@ -52,3 +77,49 @@ type Blobstore interface {
 	Delete(path string) error
 	DeleteDir(prefix string) error
 }
+
+// RangeGetter is implemented by Blobstores which can read a blob from an offset on, without fetching the bytes before it.
+type RangeGetter interface {
+	// GetRange returns length bytes of the blob at path, starting at offset. A negative length reads up to the end.
+	// offset must be smaller than the blob's size and length must not be 0.
+	// Implementers must return *NotFoundError when the resource cannot be found
+	GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error)
+}
+
+// GetRange uses blobstore's GetRange if it is a RangeGetter. Otherwise, it reads and skips the bytes before offset.
+func GetRange(blobstore Blobstore, path string, offset int64, length int64) (io.ReadCloser, error) {
+	if rangeGetter, ok := blobstore.(RangeGetter); ok {
+		return rangeGetter.GetRange(path, offset, length)
+	}
+	body, e := blobstore.Get(path)
+	if e != nil {
+		return nil, e
+	}
+	_, e = io.CopyN(ioutil.Discard, body, offset)
+	if e != nil {
+		body.Close()
+		return nil, errors.Wrapf(e, "Could not skip to offset %v of %v", offset, path)
+	}
+	return LimitReadCloser(body, length), nil
+}
+
+// HTTPByteRange formats a range as GetRange takes it as the value of an HTTP Range header.
+func HTTPByteRange(offset int64, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// LimitReadCloser reads at most length bytes from body. A negative length does not limit body.
+func LimitReadCloser(body io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return body
+	}
+	return &limitedReadCloser{io.LimitReader(body, length), body}
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
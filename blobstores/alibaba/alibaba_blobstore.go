@@ -3,6 +3,8 @@ package alibaba
 import (
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,14 +12,17 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/validate"
 	"github.com/cloudfoundry-incubator/bits-service/config"
 	"github.com/cloudfoundry-incubator/bits-service/logger"
 )
 
 type Blobstore struct {
-	Client *oss.Client
-	bucket *oss.Bucket
+	Client            *oss.Client
+	bucket            *oss.Bucket
+	partSize          int64
+	uploadConcurrency int
 }
 
 func NewBlobstore(config config.AlibabaBlobstoreConfig) *Blobstore {
@@ -35,8 +40,10 @@ func NewBlobstore(config config.AlibabaBlobstoreConfig) *Blobstore {
 		panic(fmt.Errorf("could not get bucket"))
 	}
 	return &Blobstore{
-		bucket: bucket,
-		Client: client,
+		bucket:            bucket,
+		Client:            client,
+		partSize:          config.PartSizeBytes(),
+		uploadConcurrency: config.UploadConcurrencyOrDefault(),
 	}
 }
 
@@ -94,6 +101,47 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return blobstore.bucket.IsObjectExist(path)
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	header, e := blobstore.bucket.GetObjectDetailedMeta(path)
+	if serviceError, ok := e.(oss.ServiceError); ok && serviceError.StatusCode == http.StatusNotFound {
+		return nil, bitsgo.NewNotFoundErrorWithKey(path)
+	}
+	if e != nil {
+		return nil, errors.Wrapf(e, "Failed to stat %v/%v", blobstore.bucket.BucketName, path)
+	}
+	size, e := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Invalid Content-Length for %v/%v", blobstore.bucket.BucketName, path)
+	}
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	return &bitsgo.BlobInfo{
+		Path:         path,
+		Size:         size,
+		LastModified: lastModified,
+		ETag:         strings.Trim(header.Get("ETag"), `"`),
+	}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	objList, e := blobstore.bucket.ListObjects(oss.MaxKeys(pagination.DefaultPageSize), oss.Marker(pageToken), oss.Prefix(prefix))
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list %v/%v", blobstore.bucket.BucketName, prefix)
+	}
+	blobs := make([]bitsgo.BlobInfo, len(objList.Objects))
+	for i, object := range objList.Objects {
+		blobs[i] = bitsgo.BlobInfo{
+			Path:         object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			ETag:         strings.Trim(object.ETag, `"`),
+		}
+	}
+	if !objList.IsTruncated {
+		return blobs, "", nil
+	}
+	return blobs, objList.NextMarker, nil
+}
+
 func (blobstore *Blobstore) Get(path string) (io.ReadCloser, error) {
 	logger.Log.Debugw("GET", "bucket", blobstore.bucket.BucketName, "path", path)
 	exists, _ := blobstore.Client.IsBucketExist(blobstore.bucket.BucketName)
@@ -118,6 +166,17 @@ func (blobstore *Blobstore) Put(path string, rs io.ReadSeeker) error {
 	if !exists {
 		return errors.Errorf("Bucket not found: '%v'", blobstore.bucket.BucketName)
 	}
+	size, e := rs.Seek(0, io.SeekEnd)
+	if e != nil {
+		return errors.Wrapf(e, "Path %v", path)
+	}
+	_, e = rs.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.Wrapf(e, "Path %v", path)
+	}
+	if size > blobstore.partSize {
+		return blobstore.putMultipart(path, rs, size)
+	}
 	return blobstore.bucket.PutObject(path, rs)
 }
 
@@ -0,0 +1,101 @@
+package alibaba
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// maxPartCount is the maximum number of parts OSS accepts for a multipart upload.
+const maxPartCount = 10000
+
+// putMultipart uploads src in parts of partSize, uploadConcurrency of them in parallel. Parts are read from src
+// one after the other, so at most uploadConcurrency parts are buffered in memory at a time.
+// When a part fails, the multipart upload is aborted, so that OSS does not keep the parts uploaded so far.
+func (blobstore *Blobstore) putMultipart(path string, src io.Reader, size int64) error {
+	upload, e := blobstore.bucket.InitiateMultipartUpload(path)
+	if e != nil {
+		return errors.Wrapf(e, "Could not initiate multipart upload for path %v", path)
+	}
+
+	partSize := blobstore.partSizeFor(size)
+	var (
+		parts     = make([]oss.UploadPart, (size+partSize-1)/partSize)
+		slots     = make(chan struct{}, blobstore.uploadConcurrency)
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		uploadErr error
+	)
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return uploadErr != nil
+	}
+	fail := func(e error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if uploadErr == nil {
+			uploadErr = e
+		}
+	}
+
+	for i := range parts {
+		slots <- struct{}{}
+		if failed() {
+			<-slots
+			break
+		}
+		dataSize := partSize
+		if remaining := size - int64(i)*partSize; remaining < dataSize {
+			dataSize = remaining
+		}
+		data := make([]byte, dataSize)
+		_, e = io.ReadFull(src, data)
+		if e != nil {
+			<-slots
+			fail(errors.Wrapf(e, "Could not read part %v of path %v", i+1, path))
+			break
+		}
+		waitGroup.Add(1)
+		go func(i int, data []byte) {
+			defer waitGroup.Done()
+			defer func() { <-slots }()
+
+			part, e := blobstore.bucket.UploadPart(upload, bytes.NewReader(data), int64(len(data)), i+1)
+			if e != nil {
+				fail(errors.Wrapf(e, "Could not upload part %v of path %v", i+1, path))
+				return
+			}
+			parts[i] = part
+		}(i, data)
+	}
+	waitGroup.Wait()
+
+	if uploadErr == nil {
+		_, e = blobstore.bucket.CompleteMultipartUpload(upload, parts)
+		if e != nil {
+			uploadErr = errors.Wrapf(e, "Could not complete multipart upload for path %v", path)
+		}
+	}
+	if uploadErr != nil {
+		abortErr := blobstore.bucket.AbortMultipartUpload(upload)
+		if abortErr != nil {
+			logger.Log.Errorw("Could not abort multipart upload", "bucket", blobstore.bucket.BucketName, "path", path, "upload-id", upload.UploadID, "error", abortErr)
+		}
+		return uploadErr
+	}
+	return nil
+}
+
+// partSizeFor returns the configured part size or, if size would need more than maxPartCount parts of it,
+// the smallest part size which does not.
+func (blobstore *Blobstore) partSizeFor(size int64) int64 {
+	if minPartSize := (size + maxPartCount - 1) / maxPartCount; minPartSize > blobstore.partSize {
+		return minPartSize
+	}
+	return blobstore.partSize
+}
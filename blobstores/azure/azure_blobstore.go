@@ -116,6 +116,41 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return exists, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	blob := blobstore.client.GetContainerReference(blobstore.containerName).GetBlobReference(path)
+	e := blob.GetProperties(nil)
+	if e != nil {
+		return nil, blobstore.handleError(e, "Failed to stat %v/%v", blobstore.containerName, path)
+	}
+	return &bitsgo.BlobInfo{
+		Path:         path,
+		Size:         blob.Properties.ContentLength,
+		LastModified: time.Time(blob.Properties.LastModified),
+		ETag:         strings.Trim(blob.Properties.Etag, `"`),
+	}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	response, e := blobstore.client.GetContainerReference(blobstore.containerName).ListBlobs(storage.ListBlobsParameters{
+		Prefix:     prefix,
+		MaxResults: blobstore.maxListResults,
+		Marker:     pageToken,
+	})
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list %v/%v", blobstore.containerName, prefix)
+	}
+	blobs := make([]bitsgo.BlobInfo, len(response.Blobs))
+	for i, blob := range response.Blobs {
+		blobs[i] = bitsgo.BlobInfo{
+			Path:         blob.Name,
+			Size:         blob.Properties.ContentLength,
+			LastModified: time.Time(blob.Properties.LastModified),
+			ETag:         strings.Trim(blob.Properties.Etag, `"`),
+		}
+	}
+	return blobs, response.NextMarker, nil
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	logger.Log.Debugw("Get", "bucket", blobstore.containerName, "path", path)
 
@@ -126,6 +161,22 @@ func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	return reader, nil
 }
 
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	logger.Log.Debugw("GetRange", "bucket", blobstore.containerName, "path", path, "offset", offset, "length", length)
+
+	blobRange := &storage.BlobRange{Start: uint64(offset)}
+	if length > 0 {
+		blobRange.End = uint64(offset + length - 1)
+	}
+	reader, e := blobstore.client.GetContainerReference(blobstore.containerName).GetBlobReference(path).
+		GetRange(&storage.GetBlobRangeOptions{Range: blobRange})
+	if e != nil {
+		return nil, blobstore.handleError(e, "Path %v", path)
+	}
+	// BlobRange treats an End of 0 as "up to the end", so a range of only the first byte needs limiting here
+	return bitsgo.LimitReadCloser(reader, length), nil
+}
+
 func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	signedUrl, e := blobstore.client.GetContainerReference(blobstore.containerName).GetBlobReference(path).GetSASURI(storage.BlobSASOptions{
 		BlobServiceSASPermissions: storage.BlobServiceSASPermissions{Read: true},
@@ -1,9 +1,18 @@
 package blobstores_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/onsi/ginkgo"
 
@@ -14,10 +23,19 @@ import (
 	"os"
 
 	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/decorator"
 	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/local"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/replicated"
 	"github.com/cloudfoundry-incubator/bits-service/config"
+	"github.com/cloudfoundry-incubator/bits-service/quota"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestInMemoryBlobstore(t *testing.T) {
@@ -26,7 +44,15 @@ func TestInMemoryBlobstore(t *testing.T) {
 }
 
 var _ = Describe("Blobstore", func() {
-	var blobstore bitsgo.Blobstore
+	var (
+		blobstore bitsgo.Blobstore
+		// Content addressing lists the sizes of references instead.
+		listsContentSizes bool
+	)
+
+	BeforeEach(func() {
+		listsContentSizes = true
+	})
 
 	itCanBeModifiedByItsMethods := func() {
 		It("can be modified by its methods", func() {
@@ -36,10 +62,22 @@ var _ = Describe("Blobstore", func() {
 
 			Expect(blobstore.Exists("/some/path")).To(BeTrue())
 
+			info, e := blobstore.Stat("/some/path")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len("some string")))
+			Expect(info.ETag).NotTo(BeEmpty())
+
 			body, redirectLocation, e := blobstore.GetOrRedirect("/some/path")
 			Expect(redirectLocation, e).To(BeEmpty())
 			Expect(ioutil.ReadAll(body)).To(MatchRegexp("some string"))
 
+			body, e = bitsgo.GetRange(blobstore, "/some/path", 5, 3)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("str")))
+			body, e = bitsgo.GetRange(blobstore, "/some/path", 5, -1)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("string")))
+
 			Expect(blobstore.Copy("/some/path", "/some/other/path")).To(Succeed())
 			Expect(blobstore.Copy("/some/other/path", "/some/yet/other/path")).To(Succeed())
 			Expect(blobstore.Copy("/some/other/path", "/yet/some/other/path")).To(Succeed())
@@ -53,8 +91,29 @@ var _ = Describe("Blobstore", func() {
 
 			Expect(blobstore.Exists("/some/path")).To(BeFalse())
 
+			_, e = blobstore.Stat("/some/path")
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+
 			Expect(blobstore.Exists("/some/other/path")).To(BeTrue())
 
+			blobs, nextPageToken, e := blobstore.List("/some/", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(nextPageToken).To(BeEmpty())
+			Expect(blobs).To(HaveLen(2))
+			Expect(blobs[0].Path).To(HaveSuffix("some/other/path"))
+			if listsContentSizes {
+				Expect(blobs[0].Size).To(BeEquivalentTo(len("some string")))
+			}
+			Expect(blobs[1].Path).To(HaveSuffix("some/yet/other/path"))
+
+			blobs, _, e = blobstore.List("/some/o", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(HaveLen(1))
+
+			blobs, _, e = blobstore.List("/does-not-exist/", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(BeEmpty())
+
 			Expect(blobstore.DeleteDir("/some")).To(Succeed())
 			Expect(blobstore.Exists("/some/other/path")).To(BeFalse())
 			Expect(blobstore.Exists("/some/yet/other/path")).To(BeFalse())
@@ -78,6 +137,14 @@ var _ = Describe("Blobstore", func() {
 		AfterEach(func() { os.RemoveAll(tempDirname) })
 
 		itCanBeModifiedByItsMethods()
+
+		It("marks its ETags as weak", func() {
+			Expect(blobstore.Put("some-path", strings.NewReader("some string"))).To(Succeed())
+
+			info, e := blobstore.Stat("some-path")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.WeakETag).To(BeTrue())
+		})
 	})
 
 	Describe("In-memory", func() {
@@ -85,4 +152,1007 @@ var _ = Describe("Blobstore", func() {
 
 		itCanBeModifiedByItsMethods()
 	})
+
+	Describe("Path partitioning", func() {
+		var delegate *inmemory.Blobstore
+
+		BeforeEach(func() {
+			delegate = inmemory.NewBlobstore()
+			blobstore = decorator.ForBlobstoreWithPathPartitioning(delegate)
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		It("lists identifiers and skips blobs which are not partitioned", func() {
+			Expect(blobstore.Put("abcdef", strings.NewReader("x"))).To(Succeed())
+			Expect(blobstore.Put("abc", strings.NewReader("x"))).To(Succeed())
+			Expect(blobstore.Put("abxyz", strings.NewReader("x"))).To(Succeed())
+			Expect(delegate.Put("ab/cd/not-partitioned", strings.NewReader("x"))).To(Succeed())
+
+			blobs, _, e := blobstore.List("abc", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(HaveLen(2))
+			Expect(blobs[0].Path).To(Equal("abc"))
+			Expect(blobs[1].Path).To(Equal("abcdef"))
+		})
+	})
+
+	Describe("Path prefixing", func() {
+		BeforeEach(func() {
+			blobstore = decorator.ForBlobstoreWithPathPrefixing(inmemory.NewBlobstore(), "the-prefix/")
+		})
+
+		itCanBeModifiedByItsMethods()
+	})
+
+	Describe("Content addressing", func() {
+		var (
+			delegate                    *inmemory.Blobstore
+			contentAddressableBlobstore *decorator.ContentAddressableBlobstoreDecorator
+		)
+
+		BeforeEach(func() {
+			delegate = inmemory.NewBlobstore()
+			contentAddressableBlobstore = decorator.ForBlobstoreWithContentAddressing(decorator.ForBlobstoreWithPathPartitioning(delegate))
+			blobstore = contentAddressableBlobstore
+			listsContentSizes = false
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		const someStringSha256 = "61d034473102d7dac305902770471fd50f4c5b26f6831a56dd90b5184b3c30fc"
+
+		It("stores identical content only once and deletes it with its last reference", func() {
+			Expect(blobstore.Put("package-guid-1", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Put("package-guid-2", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Copy("package-guid-1", "package-guid-3")).To(Succeed())
+
+			Expect(delegate.Entries).To(HaveKeyWithValue("61/d0/"+someStringSha256, []byte("some string")))
+			Expect(delegate.Entries["pa/ck/package-guid-1"]).NotTo(ContainSubstring("some string"))
+			Expect(delegate.Entries["pa/ck/package-guid-3"]).NotTo(ContainSubstring("some string"))
+
+			info, e := blobstore.Stat("package-guid-3")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(*info).To(MatchFields(IgnoreExtras, Fields{
+				"Path": Equal("package-guid-3"),
+				"Size": BeEquivalentTo(len("some string")),
+				"ETag": Equal(someStringSha256),
+			}))
+			body, e := blobstore.Get("package-guid-3")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+
+			blobs, _, e := blobstore.List("", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(HaveLen(3))
+
+			Expect(blobstore.Delete("package-guid-1")).To(Succeed())
+			Expect(blobstore.DeleteDir("package-guid-2")).To(Succeed())
+			Expect(delegate.Entries).To(HaveKey("61/d0/" + someStringSha256))
+
+			Expect(blobstore.Put("package-guid-3", strings.NewReader("other string"))).To(Succeed())
+			Expect(delegate.Entries).NotTo(HaveKey("61/d0/" + someStringSha256))
+			body, e = blobstore.Get("package-guid-3")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("other string")))
+
+			Expect(blobstore.Delete("package-guid-3")).To(Succeed())
+			Expect(delegate.Entries).To(BeEmpty())
+		})
+
+		It("serves and deletes blobs stored before content addressing was enabled", func() {
+			Expect(delegate.Put("pa/ck/package-guid", strings.NewReader("legacy content"))).To(Succeed())
+
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("legacy content")))
+			info, e := blobstore.Stat("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len("legacy content")))
+
+			Expect(blobstore.Copy("package-guid", "other-package-guid")).To(Succeed())
+			Expect(delegate.Entries).To(HaveKeyWithValue("ot/he/other-package-guid", []byte("legacy content")))
+
+			Expect(blobstore.Delete("package-guid")).To(Succeed())
+			Expect(delegate.Entries).NotTo(HaveKey("pa/ck/package-guid"))
+		})
+
+		It("lists blobs without reading them", func() {
+			countingDelegate := &getCountingBlobstore{Blobstore: delegate}
+			blobstore = decorator.ForBlobstoreWithContentAddressing(decorator.ForBlobstoreWithPathPartitioning(countingDelegate))
+			Expect(blobstore.Put("package-guid-1", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Put("package-guid-2", strings.NewReader("other string"))).To(Succeed())
+			countingDelegate.gets = 0
+
+			blobs, _, e := blobstore.List("", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Path": Equal("package-guid-1")}),
+				MatchFields(IgnoreExtras, Fields{"Path": Equal("package-guid-2")})))
+			Expect(countingDelegate.gets).To(BeZero())
+		})
+
+		It("does not take blobs stored before content addressing was enabled for references", func() {
+			legacyContent := `{"content_sha256":"` + someStringSha256 + `","size":11}`
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(delegate.Put("le/ga/legacy-package-guid", strings.NewReader(legacyContent))).To(Succeed())
+
+			body, e := blobstore.Get("legacy-package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte(legacyContent)))
+			contentPath, e := contentAddressableBlobstore.ContentPathFor("legacy-package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(contentPath).To(Equal("legacy-package-guid"))
+		})
+
+		It("does not copy references to content deleted by other instances in the meantime", func() {
+			Expect(blobstore.Put("package-guid-1", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Put("package-guid-2", strings.NewReader("other string"))).To(Succeed())
+			racingDelegate := &contentDeletingBlobstore{
+				Blobstore:   delegate,
+				markerPath:  "61/d0/" + someStringSha256 + "-refs/package-guid-2",
+				contentPath: "61/d0/" + someStringSha256,
+			}
+			blobstore = decorator.ForBlobstoreWithContentAddressing(decorator.ForBlobstoreWithPathPartitioning(racingDelegate))
+
+			e := blobstore.Copy("package-guid-1", "package-guid-2")
+			Expect(bitsgo.IsNotFoundError(e)).To(BeTrue())
+
+			body, e := blobstore.Get("package-guid-2")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("other string")))
+			Expect(delegate.Entries).NotTo(HaveKey("61/d0/" + someStringSha256 + "-refs/package-guid-2"))
+		})
+
+		It("signs the referenced content", func() {
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(delegate.Put("pa/ck/legacy-package-guid", strings.NewReader("legacy content"))).To(Succeed())
+
+			signer := decorator.ForResourceSignerWithContentAddressing(&pathEchoingSigner{}, contentAddressableBlobstore)
+
+			Expect(signer.Sign("package-guid", "get", time.Now())).To(Equal(someStringSha256))
+			Expect(signer.Sign("legacy-package-guid", "get", time.Now())).To(Equal("legacy-package-guid"))
+			Expect(signer.Sign("not-existing", "get", time.Now())).To(Equal("not-existing"))
+		})
+	})
+
+	Describe("Encryption", func() {
+		var (
+			delegate *inmemory.Blobstore
+			keyring  *decorator.Keyring
+		)
+
+		newKeyring := func(keys map[string][]byte, activeKeyID string) *decorator.Keyring {
+			keyring, e := decorator.NewKeyring(keys, activeKeyID)
+			Expect(e).NotTo(HaveOccurred())
+			return keyring
+		}
+
+		BeforeEach(func() {
+			delegate = inmemory.NewBlobstore()
+			keyring = newKeyring(map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")}, "key-1")
+			blobstore = decorator.ForBlobstoreWithEncryption(delegate, keyring, "packages")
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		It("stores only ciphertext in the delegate", func() {
+			Expect(blobstore.Put("package-guid", strings.NewReader("some secret string"))).To(Succeed())
+
+			Expect(delegate.Entries).To(HaveKey("package-guid"))
+			Expect(string(delegate.Entries["package-guid"])).NotTo(ContainSubstring("some secret string"))
+		})
+
+		It("round-trips blobs spanning multiple chunks", func() {
+			for _, size := range []int{0, 64 * 1024, 200 * 1000} {
+				content := bytes.Repeat([]byte("x"), size)
+				Expect(blobstore.Put("package-guid", bytes.NewReader(content))).To(Succeed())
+
+				info, e := blobstore.Stat("package-guid")
+				Expect(e).NotTo(HaveOccurred())
+				Expect(info.Size).To(BeEquivalentTo(size))
+				blobs, _, e := blobstore.List("", "")
+				Expect(e).NotTo(HaveOccurred())
+				Expect(blobs[0].Size).To(BeEquivalentTo(size))
+
+				body, e := blobstore.Get("package-guid")
+				Expect(e).NotTo(HaveOccurred())
+				Expect(ioutil.ReadAll(body)).To(Equal(content))
+			}
+		})
+
+		It("provides a seekable reader to the delegate", func() {
+			blobstore = decorator.ForBlobstoreWithEncryption(&seekingBlobstore{delegate}, keyring, "packages")
+			content := bytes.Repeat([]byte("0123456789"), 20000)
+
+			Expect(blobstore.Put("package-guid", bytes.NewReader(content))).To(Succeed())
+
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal(content))
+		})
+
+		It("reads blobs written before a key rotation while the old key is still in the keyring", func() {
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+
+			blobstore = decorator.ForBlobstoreWithEncryption(delegate, newKeyring(map[string][]byte{
+				"key-1": []byte("0123456789abcdef0123456789abcdef"),
+				"key-2": []byte("fedcba9876543210fedcba9876543210"),
+			}, "key-2"), "packages")
+			Expect(blobstore.Put("other-package-guid", strings.NewReader("other string"))).To(Succeed())
+
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+
+			blobstore = decorator.ForBlobstoreWithEncryption(delegate, newKeyring(map[string][]byte{
+				"key-2": []byte("fedcba9876543210fedcba9876543210"),
+			}, "key-2"), "packages")
+
+			body, e = blobstore.Get("other-package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("other string")))
+			_, e = blobstore.Get("package-guid")
+			Expect(e).To(MatchError(ContainSubstring("Master key 'key-1' is not in keyring")))
+		})
+
+		It("fails to read blobs which were tampered with or truncated", func() {
+			content := bytes.Repeat([]byte("x"), 100*1000)
+			Expect(blobstore.Put("package-guid", bytes.NewReader(content))).To(Succeed())
+			ciphertext := delegate.Entries["package-guid"]
+
+			delegate.Entries["package-guid"] = append([]byte{}, ciphertext...)
+			delegate.Entries["package-guid"][len(ciphertext)-1] ^= 1
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			_, e = ioutil.ReadAll(body)
+			Expect(e).To(MatchError(ContainSubstring("Blob is corrupted or was tampered with")))
+
+			delegate.Entries["package-guid"] = ciphertext[:len(ciphertext)-40*1000]
+			body, e = blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			_, e = ioutil.ReadAll(body)
+			Expect(e).To(MatchError(ContainSubstring("Blob is corrupted or was tampered with")))
+		})
+
+		It("does not decrypt blobs of another resource type", func() {
+			Expect(blobstore.Put("guid", strings.NewReader("some string"))).To(Succeed())
+
+			_, e := decorator.ForBlobstoreWithEncryption(delegate, keyring, "droplets").Get("guid")
+			Expect(e).To(MatchError(ContainSubstring("Could not unwrap data key")))
+		})
+
+		It("serves blobs stored before encryption was enabled", func() {
+			legacyContent := strings.Repeat("legacy content", 20)
+			Expect(delegate.Put("package-guid", strings.NewReader(legacyContent))).To(Succeed())
+			Expect(delegate.Put("small-package-guid", strings.NewReader("legacy"))).To(Succeed())
+
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte(legacyContent)))
+			info, e := blobstore.Stat("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len(legacyContent)))
+
+			blobs, _, e := blobstore.List("", "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(HaveLen(2))
+			Expect(blobs[0].Size).To(BeEquivalentTo(len(legacyContent)))
+			Expect(blobs[1].Size).To(BeEquivalentTo(len("legacy")))
+		})
+
+		It("uses a new data key for every blob", func() {
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Put("other-package-guid", strings.NewReader("some string"))).To(Succeed())
+
+			ciphertext, otherCiphertext := delegate.Entries["package-guid"], delegate.Entries["other-package-guid"]
+			Expect(ciphertext).To(HaveLen(len(otherCiphertext)))
+			Expect(ciphertext[len(ciphertext)-32:]).NotTo(Equal(otherCiphertext[len(otherCiphertext)-32:]))
+		})
+
+		It("rejects invalid keyrings", func() {
+			_, e := decorator.NewKeyring(map[string][]byte{"key-1": []byte("too short")}, "key-1")
+			Expect(e).To(HaveOccurred())
+			_, e = decorator.NewKeyring(map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")}, "key-2")
+			Expect(e).To(HaveOccurred())
+		})
+	})
+
+	Describe("Compression", func() {
+		var (
+			delegate       *inmemory.Blobstore
+			metricsService *recordingMetricsService
+		)
+
+		for _, algorithm := range []decorator.CompressionAlgorithm{decorator.Gzip, decorator.Zstd} {
+			algorithm := algorithm
+
+			Context(string(algorithm), func() {
+				BeforeEach(func() {
+					delegate = inmemory.NewBlobstore()
+					metricsService = newRecordingMetricsService()
+					blobstore = decorator.ForBlobstoreWithCompression(delegate, algorithm, 0, metricsService, "buildpack_cache")
+				})
+
+				itCanBeModifiedByItsMethods()
+
+				It("stores compressible blobs compressed and reports the compression ratio", func() {
+					content := bytes.Repeat([]byte("some string"), 10000)
+					Expect(blobstore.Put("entry", bytes.NewReader(content))).To(Succeed())
+
+					Expect(len(delegate.Entries["entry"])).To(BeNumerically("<", len(content)/10))
+					Expect(metricsService.gauges).To(HaveKeyWithValue("buildpack_cache-compression-ratio", BeNumerically(">", 1000)))
+
+					info, e := blobstore.Stat("entry")
+					Expect(e).NotTo(HaveOccurred())
+					Expect(info.Size).To(BeEquivalentTo(len(content)))
+					body, e := blobstore.Get("entry")
+					Expect(e).NotTo(HaveOccurred())
+					Expect(ioutil.ReadAll(body)).To(Equal(content))
+				})
+			})
+		}
+
+		BeforeEach(func() {
+			delegate = inmemory.NewBlobstore()
+			metricsService = newRecordingMetricsService()
+		})
+
+		It("stores blobs below the size threshold and incompressible blobs as they are", func() {
+			blobstore = decorator.ForBlobstoreWithCompression(delegate, decorator.Gzip, 100, metricsService, "app_stash")
+
+			Expect(blobstore.Put("small", strings.NewReader("some string"))).To(Succeed())
+			Expect(delegate.Entries).To(HaveKeyWithValue("small", []byte("some string")))
+
+			incompressible := make([]byte, 1000)
+			_, e := rand.Read(incompressible)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobstore.Put("incompressible", bytes.NewReader(incompressible))).To(Succeed())
+			Expect(delegate.Entries).To(HaveKeyWithValue("incompressible", incompressible))
+
+			body, e := blobstore.Get("incompressible")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal(incompressible))
+		})
+
+		It("serves blobs stored before compression was enabled", func() {
+			blobstore = decorator.ForBlobstoreWithCompression(delegate, decorator.Zstd, 0, metricsService, "app_stash")
+			Expect(delegate.Put("entry", strings.NewReader("legacy content"))).To(Succeed())
+
+			body, e := blobstore.Get("entry")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("legacy content")))
+			info, e := blobstore.Stat("entry")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len("legacy content")))
+		})
+
+		It("only redirects to blobs which are not compressed", func() {
+			compressingBlobstore := decorator.ForBlobstoreWithCompression(&redirectingBlobstore{delegate}, decorator.Gzip, 100, metricsService, "droplets")
+			content := bytes.Repeat([]byte("some string"), 100)
+			Expect(compressingBlobstore.Put("compressed", bytes.NewReader(content))).To(Succeed())
+			Expect(compressingBlobstore.Put("small", strings.NewReader("some string"))).To(Succeed())
+
+			body, redirectLocation, e := compressingBlobstore.GetOrRedirect("compressed")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(redirectLocation).To(BeEmpty())
+			Expect(ioutil.ReadAll(body)).To(Equal(content))
+
+			_, redirectLocation, e = compressingBlobstore.GetOrRedirect("small")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(redirectLocation).To(Equal("http://blobstore/small"))
+
+			signer := decorator.ForResourceSignerWithCompression(&pathEchoingSigner{}, &localPathEchoingSigner{}, compressingBlobstore)
+			Expect(signer.Sign("compressed", "get", time.Now())).To(Equal("http://bits-service/compressed"))
+			Expect(signer.Sign("small", "get", time.Now())).To(Equal("small"))
+		})
+
+		It("only reads the headers of blobs to stat them, to sign URLs for them or to redirect to them", func() {
+			countingDelegate := &getCountingBlobstore{Blobstore: delegate}
+			compressingBlobstore := decorator.ForBlobstoreWithCompression(countingDelegate, decorator.Gzip, 100, metricsService, "droplets")
+			content := bytes.Repeat([]byte("some string"), 100)
+			Expect(compressingBlobstore.Put("compressed", bytes.NewReader(content))).To(Succeed())
+			Expect(compressingBlobstore.Put("small", strings.NewReader("some string"))).To(Succeed())
+
+			info, e := compressingBlobstore.Stat("compressed")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len(content)))
+			Expect(compressingBlobstore.IsCompressed("small")).To(BeFalse())
+			signer := decorator.ForResourceSignerWithCompression(&pathEchoingSigner{}, &localPathEchoingSigner{}, compressingBlobstore)
+			Expect(signer.Sign("compressed", "get", time.Now())).To(Equal("http://bits-service/compressed"))
+			_, _, e = compressingBlobstore.GetOrRedirect("small")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(countingDelegate.gets).To(BeZero())
+
+			body, _, e := compressingBlobstore.GetOrRedirect("compressed")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal(content))
+			Expect(countingDelegate.gets).To(Equal(1))
+		})
+	})
+
+	Describe("Replicated", func() {
+		var (
+			primary, secondary *inmemory.Blobstore
+			metricsService     *recordingMetricsService
+		)
+
+		BeforeEach(func() {
+			primary = inmemory.NewBlobstore()
+			secondary = inmemory.NewBlobstore()
+			metricsService = newRecordingMetricsService()
+			blobstore = replicated.NewBlobstore(primary, []bitsgo.Blobstore{secondary}, 0, metricsService, "packages")
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		It("mirrors writes to secondaries before returning", func() {
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Copy("package-guid", "other-package-guid")).To(Succeed())
+			Expect(secondary.Entries).To(Equal(primary.Entries))
+			Expect(secondary.Entries).To(HaveLen(2))
+
+			Expect(blobstore.Delete("package-guid")).To(Succeed())
+			Expect(secondary.Entries).To(Equal(primary.Entries))
+			Expect(secondary.Entries).To(HaveLen(1))
+		})
+
+		It("falls back to secondaries only when the primary fails with errors other than NotFound", func() {
+			Expect(secondary.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+
+			_, e := blobstore.Get("package-guid")
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+
+			blobstore = replicated.NewBlobstore(&failingBlobstore{primary}, []bitsgo.Blobstore{secondary}, 0, metricsService, "packages")
+			body, e := blobstore.Get("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+			info, e := blobstore.Stat("package-guid")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len("some string")))
+
+			blobstore = replicated.NewBlobstore(&failingBlobstore{primary}, []bitsgo.Blobstore{&failingBlobstore{secondary}}, 0, metricsService, "packages")
+			_, e = blobstore.Get("package-guid")
+			Expect(e).To(MatchError("blobstore failure"))
+		})
+
+		It("fails writes when a secondary fails in sync mode", func() {
+			blobstore = replicated.NewBlobstore(primary, []bitsgo.Blobstore{&failingBlobstore{secondary}}, 0, metricsService, "packages")
+
+			Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(MatchError(ContainSubstring("Could not replicate put of package-guid to secondary 0")))
+		})
+
+		Context("async", func() {
+			var (
+				localSecondary bitsgo.Blobstore
+				tempDir        string
+			)
+
+			BeforeEach(func() {
+				var e error
+				tempDir, e = ioutil.TempDir("", "replicated")
+				Expect(e).NotTo(HaveOccurred())
+				// Unlike the in-memory blobstore, local blobstores can be used concurrently
+				localPrimary := local.NewBlobstore(config.LocalBlobstoreConfig{PathPrefix: tempDir + "/primary"})
+				localSecondary = local.NewBlobstore(config.LocalBlobstoreConfig{PathPrefix: tempDir + "/secondary"})
+				blobstore = replicated.NewBlobstore(localPrimary, []bitsgo.Blobstore{localSecondary}, 10, metricsService, "packages")
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tempDir)
+			})
+
+			It("mirrors writes to secondaries eventually", func() {
+				Expect(blobstore.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+				Expect(blobstore.Copy("package-guid", "other-package-guid")).To(Succeed())
+				Expect(blobstore.Delete("package-guid")).To(Succeed())
+
+				Eventually(func() (bool, error) { return localSecondary.Exists("other-package-guid") }).Should(BeTrue())
+				Eventually(func() (bool, error) { return localSecondary.Exists("package-guid") }).Should(BeFalse())
+				body, e := localSecondary.Get("other-package-guid")
+				Expect(e).NotTo(HaveOccurred())
+				Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+			})
+		})
+
+		It("repairs blobs which are missing in secondaries or differ", func() {
+			Expect(primary.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(primary.Put("other-package-guid", strings.NewReader("other string"))).To(Succeed())
+			Expect(primary.Put("changed-package-guid", strings.NewReader("new string"))).To(Succeed())
+			Expect(secondary.Put("other-package-guid", strings.NewReader("other string"))).To(Succeed())
+			Expect(secondary.Put("changed-package-guid", strings.NewReader("old string"))).To(Succeed())
+			Expect(secondary.Put("orphan", strings.NewReader("orphan"))).To(Succeed())
+
+			Expect(blobstore.(*replicated.Blobstore).Repair()).To(Succeed())
+
+			Expect(secondary.Entries).To(HaveKeyWithValue("package-guid", []byte("some string")))
+			Expect(secondary.Entries).To(HaveKeyWithValue("changed-package-guid", []byte("new string")))
+			Expect(secondary.Entries).To(HaveKey("orphan"))
+			Expect(metricsService.counters).To(HaveKeyWithValue("packages-replication-repaired", BeEquivalentTo(2)))
+			Expect(metricsService.counters).To(HaveKeyWithValue("packages-replication-removed", BeEquivalentTo(0)))
+		})
+
+		Context("with orphan removal", func() {
+			BeforeEach(func() {
+				blobstore = replicated.NewBlobstoreWithOrphanRemoval(primary, []bitsgo.Blobstore{secondary}, 0, 2, metricsService, "packages")
+			})
+
+			It("removes blobs which only exist in secondaries", func() {
+				Expect(primary.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+				Expect(secondary.Put("orphan", strings.NewReader("orphan"))).To(Succeed())
+
+				Expect(blobstore.(*replicated.Blobstore).Repair()).To(Succeed())
+
+				Expect(secondary.Entries).To(Equal(primary.Entries))
+				Expect(metricsService.counters).To(HaveKeyWithValue("packages-replication-removed", BeEquivalentTo(1)))
+			})
+
+			It("removes nothing when the primary is empty", func() {
+				Expect(secondary.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+
+				Expect(blobstore.(*replicated.Blobstore).Repair()).To(MatchError(ContainSubstring("the primary is empty")))
+
+				Expect(secondary.Entries).To(HaveKey("package-guid"))
+			})
+
+			It("removes nothing when more blobs than allowed only exist in a secondary", func() {
+				Expect(primary.Put("package-guid", strings.NewReader("some string"))).To(Succeed())
+				for _, path := range []string{"orphan-1", "orphan-2", "orphan-3"} {
+					Expect(secondary.Put(path, strings.NewReader("orphan"))).To(Succeed())
+				}
+
+				Expect(blobstore.(*replicated.Blobstore).Repair()).To(MatchError(ContainSubstring("Refusing to remove 3 blobs")))
+
+				Expect(secondary.Entries).To(HaveLen(4))
+			})
+		})
+	})
+
+	Describe("Caching", func() {
+		var (
+			delegate       *inmemory.Blobstore
+			metricsService *recordingMetricsService
+			cacheDir       string
+		)
+
+		newCachingBlobstore := func(delegate bitsgo.Blobstore, maxSize int64) *decorator.CachingBlobstoreDecorator {
+			cachingBlobstore, e := decorator.ForBlobstoreWithCaching(delegate, cacheDir, maxSize, metricsService, "droplets")
+			Expect(e).NotTo(HaveOccurred())
+			return cachingBlobstore
+		}
+
+		BeforeEach(func() {
+			var e error
+			cacheDir, e = ioutil.TempDir("", "cache")
+			Expect(e).NotTo(HaveOccurred())
+			delegate = inmemory.NewBlobstore()
+			metricsService = newRecordingMetricsService()
+			blobstore = newCachingBlobstore(delegate, 1000)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(cacheDir)
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		readAll := func(path string) string {
+			body, e := blobstore.Get(path)
+			Expect(e).NotTo(HaveOccurred())
+			defer body.Close()
+			content, e := ioutil.ReadAll(body)
+			Expect(e).NotTo(HaveOccurred())
+			return string(content)
+		}
+
+		It("serves blobs from the cache after the first read", func() {
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+
+			body, redirectLocation, e := blobstore.GetOrRedirect("droplet-guid")
+			Expect(redirectLocation, e).To(BeEmpty())
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(1))
+			Expect(metricsService.counter("droplets-cache-hit")).To(BeEquivalentTo(2))
+			Expect(metricsService.gauges).To(HaveKeyWithValue("droplets-cache-size", BeEquivalentTo(len("some string"))))
+		})
+
+		It("revalidates cached blobs, so that changes by other instances are visible", func() {
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+
+			delegate.Entries["droplet-guid"] = []byte("changed behind the cache's back")
+			Expect(readAll("droplet-guid")).To(Equal("changed behind the cache's back"))
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(2))
+
+			delete(delegate.Entries, "droplet-guid")
+			_, e := blobstore.Get("droplet-guid")
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+		})
+
+		It("streams blobs to the first reader while caching them", func() {
+			pipeReader, pipeWriter := io.Pipe()
+			blobstore = newCachingBlobstore(&streamingBlobstore{delegate, pipeReader}, 1000)
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+
+			body, e := blobstore.Get("droplet-guid")
+			Expect(e).NotTo(HaveOccurred())
+			go pipeWriter.Write([]byte("some "))
+			beginning := make([]byte, len("some "))
+			_, e = io.ReadFull(body, beginning)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(string(beginning)).To(Equal("some "))
+
+			go func() {
+				pipeWriter.Write([]byte("string"))
+				pipeWriter.Close()
+			}()
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("string")))
+			Expect(body.Close()).To(Succeed())
+
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(1))
+		})
+
+		It("keeps other files in its directory", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "operator-file"), []byte("x"), 0600)).To(Succeed())
+
+			newCachingBlobstore(delegate, 1000)
+
+			Expect(filepath.Join(cacheDir, "operator-file")).To(BeAnExistingFile())
+		})
+
+		It("invalidates blobs when they are written", func() {
+			Expect(blobstore.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+			Expect(blobstore.Put("other-droplet-guid", strings.NewReader("other string"))).To(Succeed())
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+			Expect(readAll("other-droplet-guid")).To(Equal("other string"))
+
+			Expect(blobstore.Put("droplet-guid", strings.NewReader("new string"))).To(Succeed())
+			Expect(readAll("droplet-guid")).To(Equal("new string"))
+
+			Expect(blobstore.Copy("droplet-guid", "other-droplet-guid")).To(Succeed())
+			Expect(readAll("other-droplet-guid")).To(Equal("new string"))
+
+			Expect(blobstore.Delete("droplet-guid")).To(Succeed())
+			_, e := blobstore.Get("droplet-guid")
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+
+			Expect(blobstore.DeleteDir("")).To(Succeed())
+			_, e = blobstore.Get("other-droplet-guid")
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+		})
+
+		It("evicts the least recently used blobs when exceeding its size", func() {
+			blobstore = newCachingBlobstore(delegate, 25)
+			for _, path := range []string{"a", "b", "c"} {
+				Expect(delegate.Put(path, strings.NewReader("0123456789"))).To(Succeed())
+			}
+			readAll("a")
+			readAll("b")
+			readAll("a")
+			readAll("c")
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(3))
+
+			readAll("a")
+			readAll("c")
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(3))
+			readAll("b")
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(4))
+
+			files, e := ioutil.ReadDir(filepath.Join(cacheDir, "bits-service-cache"))
+			Expect(e).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+		})
+
+		It("serves blobs larger than its size without caching them", func() {
+			blobstore = newCachingBlobstore(delegate, 5)
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+			Expect(readAll("droplet-guid")).To(Equal("some string"))
+
+			Expect(metricsService.counter("droplets-cache-miss")).To(BeEquivalentTo(2))
+			files, e := ioutil.ReadDir(filepath.Join(cacheDir, "bits-service-cache"))
+			Expect(e).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("fetches a blob only once for simultaneous misses", func() {
+			gatedDelegate := &gatedBlobstore{Blobstore: delegate, gate: make(chan struct{})}
+			blobstore = newCachingBlobstore(gatedDelegate, 1000)
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(readAll("droplet-guid")).To(Equal("some string"))
+				}()
+			}
+			Eventually(func() int64 { return metricsService.counter("droplets-cache-miss") }).Should(BeEquivalentTo(1))
+			close(gatedDelegate.gate)
+			wg.Wait()
+
+			Expect(atomic.LoadInt32(&gatedDelegate.gets)).To(BeEquivalentTo(1))
+			Expect(metricsService.counter("droplets-cache-hit")).To(BeEquivalentTo(9))
+		})
+
+		It("lets simultaneous readers read from the blobstore when fetching fails", func() {
+			gatedDelegate := &gatedBlobstore{Blobstore: delegate, gate: make(chan struct{}), failFirstGet: true}
+			blobstore = newCachingBlobstore(gatedDelegate, 1000)
+			Expect(delegate.Put("droplet-guid", strings.NewReader("some string"))).To(Succeed())
+
+			firstErr := make(chan error)
+			go func() {
+				_, e := blobstore.Get("droplet-guid")
+				firstErr <- e
+			}()
+			Eventually(func() int64 { return metricsService.counter("droplets-cache-miss") }).Should(BeEquivalentTo(1))
+			body, e := blobstore.Get("droplet-guid")
+			Expect(e).NotTo(HaveOccurred())
+			close(gatedDelegate.gate)
+
+			Expect(<-firstErr).To(MatchError("blobstore failure"))
+			Expect(ioutil.ReadAll(body)).To(Equal([]byte("some string")))
+			Expect(atomic.LoadInt32(&gatedDelegate.gets)).To(BeEquivalentTo(2))
+		})
+	})
+
+	Describe("Quotas", func() {
+		var (
+			delegate *inmemory.Blobstore
+			ledger   *quota.Ledger
+		)
+
+		BeforeEach(func() {
+			ledger = quota.NewLedger(inmemory.NewBlobstore(), 100, map[string]int64{"unlimited-space": 0})
+			delegate = inmemory.NewBlobstore()
+			blobstore = decorator.ForBlobstoreWithQuotas(delegate, ledger, "droplets").
+				WithContext(bitsgo.ContextWithTenant(context.Background(), "space-guid"))
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		It("rejects writes exceeding the tenant's quota", func() {
+			Expect(blobstore.Put("droplet-guid", strings.NewReader(strings.Repeat("x", 50)))).To(Succeed())
+			Expect(blobstore.Put("droplet-guid", strings.NewReader(strings.Repeat("x", 80)))).To(Succeed())
+			Expect(ledger.Usage("space-guid")).To(BeEquivalentTo(80))
+
+			e := blobstore.Put("other-droplet-guid", strings.NewReader(strings.Repeat("x", 30)))
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.QuotaExceededError{}))
+			Expect(e.(*bitsgo.QuotaExceededError).Tenant).To(Equal("space-guid"))
+			Expect(e.(*bitsgo.QuotaExceededError).Limit).To(BeEquivalentTo(100))
+			Expect(delegate.Exists("other-droplet-guid")).To(BeFalse())
+
+			Expect(blobstore.Copy("droplet-guid", "copied-droplet-guid")).To(BeAssignableToTypeOf(&bitsgo.QuotaExceededError{}))
+			Expect(ledger.Usage("space-guid")).To(BeEquivalentTo(80))
+		})
+
+		It("releases quota when blobs are deleted", func() {
+			Expect(blobstore.Put("droplet-guid", strings.NewReader("0123456789"))).To(Succeed())
+			Expect(blobstore.Put("dir/droplet-guid", strings.NewReader("0123456789"))).To(Succeed())
+			Expect(ledger.Usage("space-guid")).To(BeEquivalentTo(20))
+
+			Expect(blobstore.Delete("droplet-guid")).To(Succeed())
+			Expect(ledger.Usage("space-guid")).To(BeEquivalentTo(10))
+			Expect(blobstore.DeleteDir("dir/")).To(Succeed())
+			Expect(ledger.Usage("space-guid")).To(BeZero())
+		})
+
+		It("does not charge writes without tenant, but charges copies to the tenant of their source", func() {
+			unboundBlobstore := decorator.ForBlobstoreWithQuotas(delegate, ledger, "droplets")
+			Expect(unboundBlobstore.Put("droplet-guid", strings.NewReader(strings.Repeat("x", 250)))).To(Succeed())
+			Expect(ledger.Lookup("droplets:droplet-guid")).To(BeNil())
+
+			Expect(blobstore.Put("other-droplet-guid", strings.NewReader("0123456789"))).To(Succeed())
+			Expect(unboundBlobstore.Copy("other-droplet-guid", "copied-droplet-guid")).To(Succeed())
+			Expect(ledger.Lookup("droplets:copied-droplet-guid")).To(Equal(&quota.Entry{Tenant: "space-guid", Size: 10}))
+		})
+
+		It("does not limit tenants with unlimited quota", func() {
+			unlimitedBlobstore := decorator.ForBlobstoreWithQuotas(delegate, ledger, "droplets").
+				WithContext(bitsgo.ContextWithTenant(context.Background(), "unlimited-space"))
+			Expect(unlimitedBlobstore.Put("droplet-guid", strings.NewReader(strings.Repeat("x", 250)))).To(Succeed())
+			Expect(ledger.Usage("unlimited-space")).To(BeEquivalentTo(250))
+		})
+
+		It("restores the ledger when the delegate fails", func() {
+			blobstore = decorator.ForBlobstoreWithQuotas(&failingBlobstore{inmemory.NewBlobstore()}, ledger, "droplets").
+				WithContext(bitsgo.ContextWithTenant(context.Background(), "space-guid"))
+			Expect(blobstore.Put("droplet-guid", strings.NewReader("0123456789"))).NotTo(Succeed())
+			Expect(ledger.Usage("space-guid")).To(BeZero())
+		})
+	})
+
+	Describe("Tracing", func() {
+		var spanRecorder *tracetest.SpanRecorder
+
+		BeforeEach(func() {
+			spanRecorder = tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+			blobstore = decorator.ForBlobstoreWithTracing(inmemory.NewBlobstore(), "packages")
+		})
+
+		itCanBeModifiedByItsMethods()
+
+		It("records calls as children of the span in the bound context", func() {
+			ctx, parentSpan := otel.Tracer("test").Start(context.Background(), "parent")
+			blobstoreWithContext := bitsgo.BlobstoreWithContext(blobstore, ctx)
+
+			Expect(blobstoreWithContext.Put("some/path", strings.NewReader("x"))).To(Succeed())
+			_, e := blobstoreWithContext.Get("not-existing")
+			Expect(bitsgo.IsNotFoundError(e)).To(BeTrue())
+			parentSpan.End()
+
+			spans := spanRecorder.Ended()
+			Expect(spans).To(HaveLen(3))
+			Expect(spans[0].Name()).To(Equal("blobstore.put"))
+			Expect(spans[0].Parent().SpanID()).To(Equal(parentSpan.SpanContext().SpanID()))
+			Expect(spans[0].Attributes()).To(ContainElement(attribute.String("bits.resource_type", "packages")))
+			Expect(spans[0].Attributes()).To(ContainElement(attribute.String("bits.path", "some/path")))
+			Expect(spans[1].Name()).To(Equal("blobstore.get"))
+			Expect(spans[1].Parent().SpanID()).To(Equal(parentSpan.SpanContext().SpanID()))
+			Expect(spans[1].Status().Code).To(Equal(codes.Unset), "not found is not an error worth recording")
+		})
+	})
 })
+
+type pathEchoingSigner struct{}
+
+func (signer *pathEchoingSigner) Sign(resource string, method string, expirationTime time.Time) string {
+	return resource
+}
+
+// seekingBlobstore determines the size of blobs by seeking, before reading them in reverse order of their halves.
+type seekingBlobstore struct {
+	*inmemory.Blobstore
+}
+
+func (blobstore *seekingBlobstore) Put(path string, src io.ReadSeeker) error {
+	size, e := src.Seek(0, io.SeekEnd)
+	if e != nil {
+		return e
+	}
+	content := make([]byte, size)
+	_, e = src.Seek(size/2, io.SeekStart)
+	if e != nil {
+		return e
+	}
+	_, e = io.ReadFull(src, content[size/2:])
+	if e != nil {
+		return e
+	}
+	_, e = src.Seek(0, io.SeekStart)
+	if e != nil {
+		return e
+	}
+	_, e = io.ReadFull(src, content[:size/2])
+	if e != nil {
+		return e
+	}
+	return blobstore.Blobstore.Put(path, bytes.NewReader(content))
+}
+
+// redirectingBlobstore redirects to all blobs, like blobstores with signed URLs do.
+type redirectingBlobstore struct {
+	*inmemory.Blobstore
+}
+
+func (blobstore *redirectingBlobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	return nil, "http://blobstore/" + path, nil
+}
+
+// contentDeletingBlobstore deletes a content when a marker is put, like another instance deleting
+// the last other reference concurrently would.
+type contentDeletingBlobstore struct {
+	*inmemory.Blobstore
+	markerPath  string
+	contentPath string
+}
+
+func (blobstore *contentDeletingBlobstore) Put(path string, src io.ReadSeeker) error {
+	if path == blobstore.markerPath {
+		delete(blobstore.Entries, blobstore.contentPath)
+	}
+	return blobstore.Blobstore.Put(path, src)
+}
+
+type getCountingBlobstore struct {
+	*inmemory.Blobstore
+	gets int
+}
+
+func (blobstore *getCountingBlobstore) Get(path string) (io.ReadCloser, error) {
+	blobstore.gets++
+	return blobstore.Blobstore.Get(path)
+}
+
+type localPathEchoingSigner struct{}
+
+func (signer *localPathEchoingSigner) Sign(resource string, method string, expirationTime time.Time) string {
+	return "http://bits-service/" + resource
+}
+
+type recordingMetricsService struct {
+	mutex    sync.Mutex
+	gauges   map[string]int64
+	counters map[string]int64
+}
+
+func newRecordingMetricsService() *recordingMetricsService {
+	return &recordingMetricsService{gauges: map[string]int64{}, counters: map[string]int64{}}
+}
+
+func (service *recordingMetricsService) SendTimingMetric(name string, duration time.Duration) {}
+
+func (service *recordingMetricsService) SendGaugeMetric(name string, value int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.gauges[name] = value
+}
+
+func (service *recordingMetricsService) SendCounterMetric(name string, value int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.counters[name] += value
+}
+
+func (service *recordingMetricsService) counter(name string) int64 {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	return service.counters[name]
+}
+
+// failingBlobstore fails reads and Puts with errors other than NotFound.
+type failingBlobstore struct {
+	*inmemory.Blobstore
+}
+
+func (blobstore *failingBlobstore) Exists(path string) (bool, error) {
+	return false, errors.New("blobstore failure")
+}
+
+func (blobstore *failingBlobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	return nil, errors.New("blobstore failure")
+}
+
+func (blobstore *failingBlobstore) Get(path string) (io.ReadCloser, error) {
+	return nil, errors.New("blobstore failure")
+}
+
+func (blobstore *failingBlobstore) Put(path string, src io.ReadSeeker) error {
+	return errors.New("blobstore failure")
+}
+
+// gatedBlobstore blocks Gets until gate is closed.
+type gatedBlobstore struct {
+	*inmemory.Blobstore
+	gate         chan struct{}
+	gets         int32
+	failFirstGet bool
+}
+
+func (blobstore *gatedBlobstore) Get(path string) (io.ReadCloser, error) {
+	gets := atomic.AddInt32(&blobstore.gets, 1)
+	<-blobstore.gate
+	if blobstore.failFirstGet && gets == 1 {
+		return nil, errors.New("blobstore failure")
+	}
+	return blobstore.Blobstore.Get(path)
+}
+
+// streamingBlobstore returns body for every Get.
+type streamingBlobstore struct {
+	*inmemory.Blobstore
+	body io.ReadCloser
+}
+
+func (blobstore *streamingBlobstore) Get(path string) (io.ReadCloser, error) {
+	return blobstore.body, nil
+}
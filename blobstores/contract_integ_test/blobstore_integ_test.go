@@ -1,8 +1,10 @@
 package main_test
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
 	"strings"
@@ -41,6 +43,9 @@ var _ = Describe("Non-local blobstores", func() {
 		It("can put and get a resource there", func() {
 			Expect(blobstore.Exists(filepath)).To(BeFalse())
 
+			_, e := blobstore.Stat(filepath)
+			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
+
 			body, e := blobstore.Get(filepath)
 			Expect(e).To(BeAssignableToTypeOf(&bitsgo.NotFoundError{}))
 			Expect(body).To(BeNil())
@@ -55,6 +60,18 @@ var _ = Describe("Non-local blobstores", func() {
 
 			Expect(blobstore.Exists(filepath)).To(BeTrue())
 
+			info, e := blobstore.Stat(filepath)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(info.Size).To(BeEquivalentTo(len("the file content")))
+			Expect(info.LastModified).To(BeTemporally("~", time.Now(), 5*time.Minute))
+			Expect(info.ETag).NotTo(BeEmpty())
+
+			blobs, _, e := blobstore.List(filepath, "")
+			Expect(e).NotTo(HaveOccurred())
+			Expect(blobs).To(HaveLen(1))
+			Expect(blobs[0].Path).To(Equal(filepath))
+			Expect(blobs[0].Size).To(Equal(info.Size))
+
 			body, e = blobstore.Get(filepath)
 			Expect(e).NotTo(HaveOccurred())
 			Expect(ioutil.ReadAll(body)).To(ContainSubstring("the file content"))
@@ -175,6 +192,23 @@ var _ = Describe("Non-local blobstores", func() {
 		})
 	}
 
+	// Expects a part size of 5M
+	itCanPutABlobInParts := func() {
+		It("can put a blob larger than the part size", func() {
+			content := make([]byte, 12<<20)
+			rand.Read(content)
+
+			e := blobstore.Put(filepath, bytes.NewReader(content))
+			Expect(e).NotTo(HaveOccurred())
+			defer blobstore.Delete(filepath)
+
+			body, e := blobstore.Get(filepath)
+			Expect(e).NotTo(HaveOccurred())
+			defer body.Close()
+			Expect(md5sum(body)).To(Equal(md5sum(bytes.NewReader(content))))
+		})
+	}
+
 	ItDoesNotReturnNotFoundError := func() {
 		It("does not throw a NotFoundError", func() {
 			_, e := blobstore.Get("irrelevant-path")
@@ -233,6 +267,12 @@ var _ = Describe("Non-local blobstores", func() {
 
 				itCanPutAndGetAResourceThere()
 			})
+
+			Context("With multipart upload", func() {
+				BeforeEach(func() { s3Config.PartSize = "5M" })
+
+				itCanPutABlobInParts()
+			})
 		})
 
 		Context("With Server Side Encryption", func() {
@@ -358,6 +398,12 @@ var _ = Describe("Non-local blobstores", func() {
 
 		itCanPutAndGetAResourceThere()
 
+		Context("With multipart upload", func() {
+			BeforeEach(func() { alibabaConfig.PartSize = "5M" })
+
+			itCanPutABlobInParts()
+		})
+
 		Context("With non-existing bucket", func() {
 			BeforeEach(func() { alibabaConfig.BucketName += "non-existing" })
 
@@ -0,0 +1,86 @@
+package decorator
+
+import (
+	"io"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+)
+
+type AccessRecorder interface {
+	RecordAccess(path string)
+}
+
+// AccessRecordingBlobstoreDecorator records every successful Exists, Stat and Get,
+// so that garbage collection can tell which blobs are still in use.
+type AccessRecordingBlobstoreDecorator struct {
+	delegate bitsgo.Blobstore
+	recorder AccessRecorder
+}
+
+func ForBlobstoreWithAccessRecording(delegate bitsgo.Blobstore, recorder AccessRecorder) *AccessRecordingBlobstoreDecorator {
+	return &AccessRecordingBlobstoreDecorator{delegate, recorder}
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Exists(path string) (bool, error) {
+	exists, e := decorator.delegate.Exists(path)
+	if exists {
+		decorator.recorder.RecordAccess(path)
+	}
+	return exists, e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	info, e := decorator.delegate.Stat(path)
+	if e == nil {
+		decorator.recorder.RecordAccess(path)
+	}
+	return info, e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	return decorator.delegate.List(prefix, pageToken)
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	body, e := decorator.delegate.Get(path)
+	if e == nil {
+		decorator.recorder.RecordAccess(path)
+	}
+	return body, e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	body, e := bitsgo.GetRange(decorator.delegate, path, offset, length)
+	if e == nil {
+		decorator.recorder.RecordAccess(path)
+	}
+	return body, e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	body, redirectLocation, e := decorator.delegate.GetOrRedirect(path)
+	if e == nil {
+		decorator.recorder.RecordAccess(path)
+	}
+	return body, redirectLocation, e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	e := decorator.delegate.Put(path, src)
+	if e == nil {
+		decorator.recorder.RecordAccess(path)
+	}
+	return e
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Copy(src, dest string) error {
+	return decorator.delegate.Copy(src, dest)
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) Delete(path string) error {
+	return decorator.delegate.Delete(path)
+}
+
+func (decorator *AccessRecordingBlobstoreDecorator) DeleteDir(prefix string) error {
+	return decorator.delegate.DeleteDir(prefix)
+}
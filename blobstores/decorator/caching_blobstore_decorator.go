@@ -0,0 +1,429 @@
+package decorator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// The cache keeps its files in this subdirectory of the configured directory, so that clearing it on start
+// never removes anything else.
+const cacheSubdirectory = "bits-service-cache"
+
+// CachingBlobstoreDecorator keeps blobs read via Get in a local directory, up to maxSize bytes.
+// The least recently used blobs are evicted first. A miss streams the blob to the caller while it is written to
+// the cache. Simultaneous misses for the same path fetch the blob from the delegate only once and are streamed
+// the same way. Put, Copy, Delete and DeleteDir invalidate the paths they write.
+//
+// Cached blobs are revalidated with a Stat of the delegate on every read, so that writes by other bits-service
+// instances are visible, too.
+type CachingBlobstoreDecorator struct {
+	delegate       bitsgo.Blobstore
+	dir            string
+	maxSize        int64
+	metricsService bitsgo.MetricsService
+	resourceType   string
+
+	mutex sync.Mutex
+	// most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+	fills   map[string]*fill
+}
+
+type cacheEntry struct {
+	path string
+	file string
+	size int64
+	// what the delegate reported about the blob when it was fetched
+	info bitsgo.BlobInfo
+}
+
+// fill writes a blob fetched from the delegate to a temporary file, which its readers follow while it grows.
+type fill struct {
+	tempFile string
+
+	mutex   sync.Mutex
+	changed *sync.Cond
+	written int64
+	done    bool
+	err     error
+
+	// set when the path was written while fetching it. Guarded by the decorator's mutex.
+	invalidated bool
+}
+
+// ForBlobstoreWithCaching removes what a previous run left in dir, since the cache does not survive restarts.
+func ForBlobstoreWithCaching(delegate bitsgo.Blobstore, dir string, maxSize int64, metricsService bitsgo.MetricsService, resourceType string) (*CachingBlobstoreDecorator, error) {
+	cacheDir := filepath.Join(dir, cacheSubdirectory)
+	e := os.RemoveAll(cacheDir)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not clear cache directory %v", cacheDir)
+	}
+	e = os.MkdirAll(cacheDir, 0700)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not create cache directory %v", cacheDir)
+	}
+	return &CachingBlobstoreDecorator{
+		delegate:       delegate,
+		dir:            cacheDir,
+		maxSize:        maxSize,
+		metricsService: metricsService,
+		resourceType:   resourceType,
+		lru:            list.New(),
+		entries:        make(map[string]*list.Element),
+		fills:          make(map[string]*fill),
+	}, nil
+}
+
+func (decorator *CachingBlobstoreDecorator) Exists(path string) (bool, error) {
+	return decorator.delegate.Exists(path)
+}
+
+func (decorator *CachingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	return decorator.delegate.Stat(path)
+}
+
+func (decorator *CachingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	return decorator.delegate.List(prefix, pageToken)
+}
+
+func (decorator *CachingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	file, e := decorator.openFresh(path)
+	if e != nil {
+		return nil, e
+	}
+	if file != nil {
+		decorator.metricsService.SendCounterMetric(decorator.resourceType+"-cache-hit", 1)
+		return file, nil
+	}
+
+	decorator.mutex.Lock()
+	if inFlight, exists := decorator.fills[path]; exists {
+		reader, e := inFlight.newReader(decorator.delegate, path)
+		decorator.mutex.Unlock()
+		if e != nil {
+			logger.Log.Debugw("Could not follow cache fill. Reading from blobstore instead", "path", path, "error", e)
+			return decorator.delegate.Get(path)
+		}
+		decorator.metricsService.SendCounterMetric(decorator.resourceType+"-cache-hit", 1)
+		return reader, nil
+	}
+	f, e := decorator.startFill(path)
+	if e != nil {
+		decorator.mutex.Unlock()
+		return nil, e
+	}
+	reader, e := f.newReader(decorator.delegate, path)
+	decorator.mutex.Unlock()
+	if e != nil {
+		decorator.finishFill(path, f, nil, bitsgo.BlobInfo{}, e)
+		return nil, e
+	}
+
+	decorator.metricsService.SendCounterMetric(decorator.resourceType+"-cache-miss", 1)
+	info, e := decorator.delegate.Stat(path)
+	if e != nil {
+		reader.Close()
+		decorator.finishFill(path, f, nil, bitsgo.BlobInfo{}, e)
+		return nil, e
+	}
+	body, e = decorator.delegate.Get(path)
+	if e != nil {
+		reader.Close()
+		decorator.finishFill(path, f, nil, bitsgo.BlobInfo{}, e)
+		return nil, e
+	}
+	go decorator.finishFill(path, f, body, *info, nil)
+	return reader, nil
+}
+
+// GetOrRedirect serves cached blobs, but does not add blobs to the cache, since redirects are cheaper than fetching.
+func (decorator *CachingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	file, e := decorator.openFresh(path)
+	if e != nil {
+		return nil, "", e
+	}
+	if file != nil {
+		decorator.metricsService.SendCounterMetric(decorator.resourceType+"-cache-hit", 1)
+		return file, "", nil
+	}
+	return decorator.delegate.GetOrRedirect(path)
+}
+
+func (decorator *CachingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	// Invalidating afterwards as well covers fetches which started while putting.
+	decorator.invalidate(path)
+	defer decorator.invalidate(path)
+	return decorator.delegate.Put(path, src)
+}
+
+func (decorator *CachingBlobstoreDecorator) Copy(src, dest string) error {
+	decorator.invalidate(dest)
+	defer decorator.invalidate(dest)
+	return decorator.delegate.Copy(src, dest)
+}
+
+func (decorator *CachingBlobstoreDecorator) Delete(path string) error {
+	decorator.invalidate(path)
+	defer decorator.invalidate(path)
+	return decorator.delegate.Delete(path)
+}
+
+func (decorator *CachingBlobstoreDecorator) DeleteDir(prefix string) error {
+	decorator.invalidatePrefix(prefix)
+	defer decorator.invalidatePrefix(prefix)
+	return decorator.delegate.DeleteDir(prefix)
+}
+
+// openFresh returns nil, if path is not cached or the delegate holds different content by now.
+func (decorator *CachingBlobstoreDecorator) openFresh(path string) (*os.File, error) {
+	decorator.mutex.Lock()
+	element, cached := decorator.entries[path]
+	decorator.mutex.Unlock()
+	if !cached {
+		return nil, nil
+	}
+
+	info, e := decorator.delegate.Stat(path)
+	if bitsgo.IsNotFoundError(e) {
+		decorator.invalidate(path)
+		return nil, e
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	decorator.mutex.Lock()
+	defer decorator.mutex.Unlock()
+	if decorator.entries[path] != element {
+		// replaced or evicted meanwhile
+		return nil, nil
+	}
+	if !sameContent(element.Value.(*cacheEntry).info, *info) {
+		decorator.remove(element)
+		return nil, nil
+	}
+	// Evicting the file while it is read is fine, since open files stay readable after removing them.
+	file, e := os.Open(element.Value.(*cacheEntry).file)
+	if e != nil {
+		decorator.remove(element)
+		return nil, nil
+	}
+	decorator.lru.MoveToFront(element)
+	return file, nil
+}
+
+func sameContent(cached bitsgo.BlobInfo, current bitsgo.BlobInfo) bool {
+	if cached.ETag != "" || current.ETag != "" {
+		return cached.ETag == current.ETag && cached.Size == current.Size
+	}
+	return cached.Size == current.Size && cached.LastModified.Equal(current.LastModified)
+}
+
+// startFill must be called with mutex locked.
+func (decorator *CachingBlobstoreDecorator) startFill(path string) (*fill, error) {
+	tempFile, e := ioutil.TempFile(decorator.dir, "fetch")
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	tempFile.Close()
+	f := &fill{tempFile: tempFile.Name()}
+	f.changed = sync.NewCond(&f.mutex)
+	decorator.fills[path] = f
+	return f, nil
+}
+
+// finishFill copies body to the fill's file and moves it into the cache, unless fetching failed with e already.
+func (decorator *CachingBlobstoreDecorator) finishFill(path string, f *fill, body io.ReadCloser, info bitsgo.BlobInfo, e error) {
+	var size int64
+	if e == nil {
+		size, e = f.copyFrom(body)
+		body.Close()
+		if e != nil {
+			logger.Log.Errorw("Could not fetch blob into cache", "path", path, "error", e)
+		}
+	}
+
+	decorator.mutex.Lock()
+	// Readers have opened the file already, so it can be moved or removed now.
+	delete(decorator.fills, path)
+	if e != nil || f.invalidated || size > decorator.maxSize {
+		os.Remove(f.tempFile)
+	} else {
+		decorator.add(path, f.tempFile, size, info)
+	}
+	decorator.mutex.Unlock()
+
+	f.finish(e)
+}
+
+// add must be called with mutex locked.
+func (decorator *CachingBlobstoreDecorator) add(path string, tempFile string, size int64, info bitsgo.BlobInfo) {
+	cacheFile := filepath.Join(decorator.dir, cacheFileNameFor(path))
+	e := os.Rename(tempFile, cacheFile)
+	if e != nil {
+		os.Remove(tempFile)
+		return
+	}
+	decorator.entries[path] = decorator.lru.PushFront(&cacheEntry{path: path, file: cacheFile, size: size, info: info})
+	decorator.size += size
+	for decorator.size > decorator.maxSize {
+		decorator.remove(decorator.lru.Back())
+	}
+	decorator.metricsService.SendGaugeMetric(decorator.resourceType+"-cache-size", decorator.size)
+}
+
+func cacheFileNameFor(path string) string {
+	hash := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(hash[:])
+}
+
+func (decorator *CachingBlobstoreDecorator) invalidate(path string) {
+	decorator.mutex.Lock()
+	defer decorator.mutex.Unlock()
+	if element, cached := decorator.entries[path]; cached {
+		decorator.remove(element)
+	}
+	if f, inFlight := decorator.fills[path]; inFlight {
+		f.invalidated = true
+	}
+}
+
+func (decorator *CachingBlobstoreDecorator) invalidatePrefix(prefix string) {
+	decorator.mutex.Lock()
+	defer decorator.mutex.Unlock()
+	for path, element := range decorator.entries {
+		if strings.HasPrefix(path, prefix) {
+			decorator.remove(element)
+		}
+	}
+	for path, f := range decorator.fills {
+		if strings.HasPrefix(path, prefix) {
+			f.invalidated = true
+		}
+	}
+}
+
+// remove must be called with mutex locked.
+func (decorator *CachingBlobstoreDecorator) remove(element *list.Element) {
+	entry := decorator.lru.Remove(element).(*cacheEntry)
+	delete(decorator.entries, entry.path)
+	decorator.size -= entry.size
+	os.Remove(entry.file)
+}
+
+func (f *fill) copyFrom(body io.Reader) (int64, error) {
+	file, e := os.OpenFile(f.tempFile, os.O_WRONLY, 0600)
+	if e != nil {
+		return 0, errors.WithStack(e)
+	}
+	size, e := io.Copy(&fillWriter{f, file}, body)
+	if e != nil {
+		file.Close()
+		return 0, errors.WithStack(e)
+	}
+	return size, errors.WithStack(file.Close())
+}
+
+func (f *fill) finish(e error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.done = true
+	f.err = e
+	f.changed.Broadcast()
+}
+
+// waitBeyond blocks until more than offset bytes are written or the fill is done.
+func (f *fill) waitBeyond(offset int64) (written int64, done bool, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for f.written <= offset && !f.done {
+		f.changed.Wait()
+	}
+	return f.written, f.done, f.err
+}
+
+// newReader must be called with the decorator's mutex locked, so that the file still exists.
+func (f *fill) newReader(delegate bitsgo.Blobstore, path string) (*fillReader, error) {
+	file, e := os.Open(f.tempFile)
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	return &fillReader{fill: f, file: file, delegate: delegate, path: path}, nil
+}
+
+type fillWriter struct {
+	fill *fill
+	file *os.File
+}
+
+func (w *fillWriter) Write(p []byte) (int, error) {
+	n, e := w.file.Write(p)
+	w.fill.mutex.Lock()
+	w.fill.written += int64(n)
+	w.fill.changed.Broadcast()
+	w.fill.mutex.Unlock()
+	return n, e
+}
+
+// fillReader follows a fill while it grows. When the fill fails, it continues reading from the delegate.
+type fillReader struct {
+	fill     *fill
+	file     *os.File
+	offset   int64
+	delegate bitsgo.Blobstore
+	path     string
+	fallback io.ReadCloser
+}
+
+func (r *fillReader) Read(p []byte) (int, error) {
+	if r.fallback != nil {
+		n, e := r.fallback.Read(p)
+		r.offset += int64(n)
+		return n, e
+	}
+	written, done, fillErr := r.fill.waitBeyond(r.offset)
+	if written > r.offset {
+		if int64(len(p)) > written-r.offset {
+			p = p[:written-r.offset]
+		}
+		n, e := r.file.Read(p)
+		r.offset += int64(n)
+		if e == io.EOF {
+			e = nil
+		}
+		return n, errors.WithStack(e)
+	}
+	if done && fillErr == nil {
+		return 0, io.EOF
+	}
+	var e error
+	if r.offset == 0 {
+		r.fallback, e = r.delegate.Get(r.path)
+	} else {
+		r.fallback, e = bitsgo.GetRange(r.delegate, r.path, r.offset, -1)
+	}
+	if e != nil {
+		return 0, e
+	}
+	return r.Read(p)
+}
+
+func (r *fillReader) Close() error {
+	if r.fallback != nil {
+		r.fallback.Close()
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,322 @@
+package decorator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+type CompressionAlgorithm string
+
+const (
+	Gzip CompressionAlgorithm = "gzip"
+	Zstd CompressionAlgorithm = "zstd"
+)
+
+// Compressed blobs start with a header:
+//
+//	magic | algorithm (1 byte) | uncompressed size (8 bytes)
+const compressionHeaderSize = 8 + 1 + 8
+
+var compressionMagic = []byte("BITSZIP1")
+
+var algorithmIDs = map[CompressionAlgorithm]byte{Gzip: 1, Zstd: 2}
+
+// CompressingBlobstoreDecorator compresses blobs before they reach the delegate. Blobs smaller than minSize,
+// and blobs which do not get smaller by compressing them, are stored as they are. So are blobs stored before
+// compression was enabled. All of them are served as they are.
+//
+// Since the delegate only knows compressed bits, GetOrRedirect never redirects to compressed blobs.
+type CompressingBlobstoreDecorator struct {
+	delegate       bitsgo.Blobstore
+	algorithm      CompressionAlgorithm
+	minSize        int64
+	metricsService bitsgo.MetricsService
+	resourceType   string
+}
+
+func ForBlobstoreWithCompression(delegate bitsgo.Blobstore, algorithm CompressionAlgorithm, minSize int64, metricsService bitsgo.MetricsService, resourceType string) *CompressingBlobstoreDecorator {
+	if _, exists := algorithmIDs[algorithm]; !exists {
+		panic(errors.Errorf("Unknown compression algorithm '%v'", algorithm))
+	}
+	return &CompressingBlobstoreDecorator{delegate, algorithm, minSize, metricsService, resourceType}
+}
+
+func (decorator *CompressingBlobstoreDecorator) Exists(path string) (bool, error) {
+	return decorator.delegate.Exists(path)
+}
+
+// Stat only reads the header of the blob.
+func (decorator *CompressingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	info, e := decorator.delegate.Stat(path)
+	if e != nil {
+		return nil, e
+	}
+	header, e := decorator.readHeader(path, info.Size)
+	if e != nil {
+		return nil, e
+	}
+	if header != nil {
+		info.Size = header.uncompressedSize
+	}
+	return info, nil
+}
+
+// List returns the stored sizes of blobs. Finding out their uncompressed sizes would require reading all of them.
+func (decorator *CompressingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	return decorator.delegate.List(prefix, pageToken)
+}
+
+func (decorator *CompressingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	header, body, e := decorator.openHeader(path)
+	if e != nil {
+		return nil, e
+	}
+	if header == nil {
+		return body, nil
+	}
+	decompressingReader, e := newDecompressingReader(header.algorithm, body)
+	if e != nil {
+		body.Close()
+		return nil, errors.Wrapf(e, "Could not decompress %v", path)
+	}
+	return decompressingReader, nil
+}
+
+// GetOrRedirect only reads the header of blobs which are not compressed, before redirecting to them.
+func (decorator *CompressingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	compressed, e := decorator.IsCompressed(path)
+	if e != nil {
+		return nil, "", e
+	}
+	if !compressed {
+		return decorator.delegate.GetOrRedirect(path)
+	}
+	body, e = decorator.Get(path)
+	return body, "", e
+}
+
+func (decorator *CompressingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	size, e := src.Seek(0, io.SeekEnd)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	_, e = src.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	if size < decorator.minSize {
+		return decorator.delegate.Put(path, src)
+	}
+
+	compressedFile, e := ioutil.TempFile("", "compressed")
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	defer os.Remove(compressedFile.Name())
+	defer compressedFile.Close()
+
+	e = decorator.compress(compressedFile, src, size)
+	if e != nil {
+		return errors.Wrapf(e, "Could not compress %v", path)
+	}
+	compressedSize, e := compressedFile.Seek(0, io.SeekCurrent)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	if compressedSize > 0 {
+		decorator.metricsService.SendGaugeMetric(decorator.resourceType+"-compression-ratio", size*100/compressedSize)
+	}
+	if compressedSize >= size {
+		_, e = src.Seek(0, io.SeekStart)
+		if e != nil {
+			return errors.WithStack(e)
+		}
+		return decorator.delegate.Put(path, src)
+	}
+	_, e = compressedFile.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return decorator.delegate.Put(path, compressedFile)
+}
+
+func (decorator *CompressingBlobstoreDecorator) Copy(src, dest string) error {
+	return decorator.delegate.Copy(src, dest)
+}
+
+func (decorator *CompressingBlobstoreDecorator) Delete(path string) error {
+	return decorator.delegate.Delete(path)
+}
+
+func (decorator *CompressingBlobstoreDecorator) DeleteDir(prefix string) error {
+	return decorator.delegate.DeleteDir(prefix)
+}
+
+// IsCompressed tells whether the blob at path is stored compressed. It only reads the blob's header.
+func (decorator *CompressingBlobstoreDecorator) IsCompressed(path string) (bool, error) {
+	info, e := decorator.delegate.Stat(path)
+	if e != nil {
+		return false, e
+	}
+	header, e := decorator.readHeader(path, info.Size)
+	return header != nil, e
+}
+
+func (decorator *CompressingBlobstoreDecorator) compress(dest io.Writer, src io.Reader, size int64) error {
+	header := make([]byte, 0, compressionHeaderSize)
+	header = append(header, compressionMagic...)
+	header = append(header, algorithmIDs[decorator.algorithm])
+	header = append(header, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(header[len(compressionMagic)+1:], uint64(size))
+	_, e := dest.Write(header)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+
+	var compressor io.WriteCloser
+	switch decorator.algorithm {
+	case Gzip:
+		compressor = gzip.NewWriter(dest)
+	case Zstd:
+		compressor, e = zstd.NewWriter(dest)
+		if e != nil {
+			return errors.WithStack(e)
+		}
+	}
+	_, e = io.Copy(compressor, src)
+	if e != nil {
+		compressor.Close()
+		return errors.WithStack(e)
+	}
+	return errors.WithStack(compressor.Close())
+}
+
+type compressionHeader struct {
+	algorithm        CompressionAlgorithm
+	uncompressedSize int64
+}
+
+// readHeader returns the header of the blob at path, which has size bytes, without reading the rest of it.
+// For blobs which are not compressed, it returns nil.
+func (decorator *CompressingBlobstoreDecorator) readHeader(path string, size int64) (*compressionHeader, error) {
+	if size < compressionHeaderSize {
+		return nil, nil
+	}
+	body, e := bitsgo.GetRange(decorator.delegate, path, 0, compressionHeaderSize)
+	if e != nil {
+		return nil, e
+	}
+	defer body.Close()
+	headerBytes := make([]byte, compressionHeaderSize)
+	_, e = io.ReadFull(body, headerBytes)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not read header of %v", path)
+	}
+	return parseCompressionHeader(headerBytes, path)
+}
+
+// openHeader returns the header of the blob at path and the body following it.
+// For blobs which are not compressed, it returns a nil header and the complete body.
+func (decorator *CompressingBlobstoreDecorator) openHeader(path string) (*compressionHeader, io.ReadCloser, error) {
+	body, e := decorator.delegate.Get(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	reader := bufio.NewReader(body)
+	headerBytes, _ := reader.Peek(compressionHeaderSize)
+	header, e := parseCompressionHeader(headerBytes, path)
+	if e != nil {
+		body.Close()
+		return nil, nil, e
+	}
+	if header == nil {
+		return nil, &bufferedReadCloser{reader, body}, nil
+	}
+	_, e = reader.Discard(compressionHeaderSize)
+	if e != nil {
+		body.Close()
+		return nil, nil, errors.WithStack(e)
+	}
+	return header, &bufferedReadCloser{reader, body}, nil
+}
+
+// parseCompressionHeader returns nil, if headerBytes are not the header of a compressed blob.
+func parseCompressionHeader(headerBytes []byte, path string) (*compressionHeader, error) {
+	if len(headerBytes) < compressionHeaderSize || !bytes.Equal(headerBytes[:len(compressionMagic)], compressionMagic) {
+		return nil, nil
+	}
+	header := &compressionHeader{uncompressedSize: int64(binary.BigEndian.Uint64(headerBytes[len(compressionMagic)+1:]))}
+	for algorithm, id := range algorithmIDs {
+		if id == headerBytes[len(compressionMagic)] {
+			header.algorithm = algorithm
+		}
+	}
+	if header.algorithm == "" {
+		return nil, errors.Errorf("Unknown compression algorithm %v in %v", headerBytes[len(compressionMagic)], path)
+	}
+	return header, nil
+}
+
+type decompressingReader struct {
+	io.Reader
+	decompressor io.Closer
+	body         io.Closer
+}
+
+func newDecompressingReader(algorithm CompressionAlgorithm, body io.ReadCloser) (*decompressingReader, error) {
+	switch algorithm {
+	case Gzip:
+		gzipReader, e := gzip.NewReader(body)
+		if e != nil {
+			return nil, errors.WithStack(e)
+		}
+		return &decompressingReader{gzipReader, gzipReader, body}, nil
+	case Zstd:
+		zstdReader, e := zstd.NewReader(body)
+		if e != nil {
+			return nil, errors.WithStack(e)
+		}
+		return &decompressingReader{zstdReader, zstdReader.IOReadCloser(), body}, nil
+	default:
+		return nil, errors.Errorf("Unknown compression algorithm '%v'", algorithm)
+	}
+}
+
+func (reader *decompressingReader) Close() error {
+	reader.decompressor.Close()
+	return reader.body.Close()
+}
+
+// CompressingResourceSigner signs URLs pointing to the bits-service itself for compressed blobs,
+// since URLs pointing directly into the blobstore would deliver compressed bits.
+type CompressingResourceSigner struct {
+	blobstore     *CompressingBlobstoreDecorator
+	delegate      bitsgo.ResourceSigner
+	localDelegate bitsgo.ResourceSigner
+}
+
+func ForResourceSignerWithCompression(delegate bitsgo.ResourceSigner, localDelegate bitsgo.ResourceSigner, blobstore *CompressingBlobstoreDecorator) *CompressingResourceSigner {
+	return &CompressingResourceSigner{blobstore, delegate, localDelegate}
+}
+
+func (signer *CompressingResourceSigner) Sign(resource string, method string, expirationTime time.Time) (signedURL string) {
+	compressed, e := signer.blobstore.IsCompressed(resource)
+	if e != nil {
+		// The signed URL then leads to the same error
+		return signer.delegate.Sign(resource, method, expirationTime)
+	}
+	if compressed {
+		return signer.localDelegate.Sign(resource, method, expirationTime)
+	}
+	return signer.delegate.Sign(resource, method, expirationTime)
+}
@@ -0,0 +1,408 @@
+package decorator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/pkg/errors"
+)
+
+// ContentAddressableBlobstoreDecorator stores every content only once, under its SHA-256. The path a blob is put to
+// only holds a small reference to the content. For every reference, there is an empty marker blob
+// <sha256>-refs/<path>, so that a content is deleted together with its last reference.
+//
+// Blobs stored before content addressing was enabled are still served and deleted as they are. Once it is enabled,
+// user content only ends up in content blobs, so only those legacy blobs could imitate references. References
+// therefore start with a magic prefix no text or archive starts with.
+//
+// Markers are written before contents are checked for existence and Put and Copy check again after writing the reference.
+// This way, concurrent Puts, Copies and Deletes of the same content across instances cannot leave references to deleted content behind.
+type ContentAddressableBlobstoreDecorator struct {
+	delegate bitsgo.Blobstore
+}
+
+func ForBlobstoreWithContentAddressing(delegate bitsgo.Blobstore) *ContentAddressableBlobstoreDecorator {
+	return &ContentAddressableBlobstoreDecorator{delegate}
+}
+
+type contentReference struct {
+	Sha256 string `json:"content_sha256"`
+	Size   int64  `json:"size"`
+}
+
+// The JSON encoded contentReference follows the prefix.
+var referencePrefix = []byte("\x00bits-service-content-reference\x00")
+
+// Blobs larger than this cannot be references. This saves reading large blobs to find out whether they are references.
+const maxReferenceSize = 256
+
+var internalPathPattern = regexp.MustCompile(`^[0-9a-f]{64}(-refs/.*)?$`)
+
+func (decorator *ContentAddressableBlobstoreDecorator) Exists(path string) (bool, error) {
+	return decorator.delegate.Exists(path)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	_, info, e := decorator.resolve(path)
+	return info, e
+}
+
+// List does not return contents and markers, only the paths blobs were put to. To not read every small blob,
+// it does not resolve references: for them, Size and ETag are the ones of the reference. Stat returns the ones of the content.
+func (decorator *ContentAddressableBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobs, nextPageToken, e := decorator.delegate.List(prefix, pageToken)
+	if e != nil {
+		return nil, "", e
+	}
+	result := make([]bitsgo.BlobInfo, 0, len(blobs))
+	for _, blob := range blobs {
+		if internalPathPattern.MatchString(blob.Path) {
+			continue
+		}
+		result = append(result, blob)
+	}
+	return result, nextPageToken, nil
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	reference, body, e := decorator.openReference(path)
+	if e != nil {
+		return nil, e
+	}
+	if reference == nil {
+		return body, nil
+	}
+	return decorator.delegate.Get(reference.Sha256)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	contentPath, e := decorator.ContentPathFor(path)
+	if e != nil {
+		return nil, e
+	}
+	return bitsgo.GetRange(decorator.delegate, contentPath, offset, length)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	contentPath, e := decorator.ContentPathFor(path)
+	if e != nil {
+		return nil, "", e
+	}
+	return decorator.delegate.GetOrRedirect(contentPath)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	hash := sha256.New()
+	size, e := io.Copy(hash, src)
+	if e != nil {
+		return errors.Wrapf(e, "Could not calculate SHA-256 of %v", path)
+	}
+	reference := &contentReference{Sha256: hex.EncodeToString(hash.Sum(nil)), Size: size}
+
+	previousReference, e := decorator.previousReference(path)
+	if e != nil {
+		return e
+	}
+	e = decorator.putMarker(reference.Sha256, path)
+	if e != nil {
+		return e
+	}
+	e = decorator.ensureContent(reference.Sha256, src)
+	if e != nil {
+		return e
+	}
+	e = decorator.putReference(path, reference)
+	if e != nil {
+		return e
+	}
+	// The last other reference might have been deleted between ensureContent and putReference by another instance.
+	e = decorator.ensureContent(reference.Sha256, src)
+	if e != nil {
+		return e
+	}
+	return decorator.releaseIfReplaced(previousReference, reference, path)
+}
+
+// Copy only adds a reference to the content of src.
+func (decorator *ContentAddressableBlobstoreDecorator) Copy(src, dest string) error {
+	reference, _, e := decorator.resolve(src)
+	if e != nil {
+		return e
+	}
+	if reference == nil {
+		return decorator.delegate.Copy(src, dest)
+	}
+	previousReference, e := decorator.previousReference(dest)
+	if e != nil {
+		return e
+	}
+	e = decorator.putMarker(reference.Sha256, dest)
+	if e != nil {
+		return e
+	}
+	e = decorator.putReference(dest, reference)
+	if e != nil {
+		return e
+	}
+	// The last other reference might have been deleted together with the content by another instance.
+	// Unlike Put, Copy cannot restore the content, so it undoes the copy instead.
+	exists, e := decorator.delegate.Exists(reference.Sha256)
+	if e != nil {
+		return e
+	}
+	if !exists {
+		e = decorator.undoCopy(previousReference, reference, dest)
+		if e != nil {
+			return e
+		}
+		return bitsgo.NewNotFoundErrorWithKey(src)
+	}
+	return decorator.releaseIfReplaced(previousReference, reference, dest)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) undoCopy(previousReference, reference *contentReference, dest string) error {
+	if previousReference != nil {
+		e := decorator.putReference(dest, previousReference)
+		if e != nil {
+			return e
+		}
+		if previousReference.Sha256 == reference.Sha256 {
+			return nil
+		}
+	} else {
+		e := decorator.delegate.Delete(dest)
+		if e != nil && !bitsgo.IsNotFoundError(e) {
+			return e
+		}
+	}
+	e := decorator.delegate.Delete(markerPathFor(reference.Sha256, dest))
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	return nil
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) Delete(path string) error {
+	reference, _, e := decorator.resolve(path)
+	if e != nil {
+		return e
+	}
+	e = decorator.delegate.Delete(path)
+	if e != nil {
+		return e
+	}
+	if reference == nil {
+		return nil
+	}
+	return decorator.release(reference.Sha256, path)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) DeleteDir(prefix string) error {
+	if prefix == "" {
+		// Contents and markers are deleted as well
+		return decorator.delegate.DeleteDir(prefix)
+	}
+	references := make(map[string]*contentReference)
+	pageToken := ""
+	for {
+		blobs, nextPageToken, e := decorator.delegate.List(prefix, pageToken)
+		if e != nil {
+			return e
+		}
+		for _, blob := range blobs {
+			if internalPathPattern.MatchString(blob.Path) || blob.Size > maxReferenceSize {
+				continue
+			}
+			reference, _, e := decorator.resolveWith(blob)
+			if bitsgo.IsNotFoundError(e) {
+				continue
+			}
+			if e != nil {
+				return e
+			}
+			if reference != nil {
+				references[blob.Path] = reference
+			}
+		}
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+	e := decorator.delegate.DeleteDir(prefix)
+	if e != nil {
+		return e
+	}
+	for path, reference := range references {
+		e = decorator.release(reference.Sha256, path)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ContentPathFor returns the path of the content path references. For blobs which are not references, it's path itself.
+func (decorator *ContentAddressableBlobstoreDecorator) ContentPathFor(path string) (string, error) {
+	reference, _, e := decorator.resolve(path)
+	if e != nil {
+		return "", e
+	}
+	if reference == nil {
+		return path, nil
+	}
+	return reference.Sha256, nil
+}
+
+// resolve returns a nil reference for blobs which are not references. In both cases, info describes the content.
+func (decorator *ContentAddressableBlobstoreDecorator) resolve(path string) (reference *contentReference, info *bitsgo.BlobInfo, err error) {
+	info, e := decorator.delegate.Stat(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	return decorator.resolveWith(*info)
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) resolveWith(info bitsgo.BlobInfo) (*contentReference, *bitsgo.BlobInfo, error) {
+	if info.Size > maxReferenceSize {
+		return nil, &info, nil
+	}
+	reference, body, e := decorator.openReference(info.Path)
+	if e != nil {
+		return nil, nil, e
+	}
+	if reference == nil {
+		body.Close()
+		return nil, &info, nil
+	}
+	return reference, &bitsgo.BlobInfo{
+		Path:         info.Path,
+		Size:         reference.Size,
+		LastModified: info.LastModified,
+		ETag:         reference.Sha256,
+	}, nil
+}
+
+// openReference returns the reference stored at path. If the blob at path is not a reference,
+// it returns a nil reference and the blob's body instead.
+func (decorator *ContentAddressableBlobstoreDecorator) openReference(path string) (*contentReference, io.ReadCloser, error) {
+	body, e := decorator.delegate.Get(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	reader := bufio.NewReaderSize(body, maxReferenceSize)
+	prefix, _ := reader.Peek(len(referencePrefix))
+	if !bytes.Equal(prefix, referencePrefix) {
+		return nil, &bufferedReadCloser{reader, body}, nil
+	}
+	defer body.Close()
+	content, e := ioutil.ReadAll(io.LimitReader(reader, maxReferenceSize+1))
+	if e != nil {
+		return nil, nil, errors.Wrapf(e, "Could not read reference %v", path)
+	}
+	var reference contentReference
+	e = json.Unmarshal(content[len(referencePrefix):], &reference)
+	if e != nil {
+		return nil, nil, errors.Wrapf(e, "Could not parse reference %v", path)
+	}
+	return &reference, nil, nil
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) previousReference(path string) (*contentReference, error) {
+	reference, _, e := decorator.resolve(path)
+	if bitsgo.IsNotFoundError(e) {
+		return nil, nil
+	}
+	return reference, e
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) putReference(path string, reference *contentReference) error {
+	content, e := json.Marshal(reference)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return decorator.delegate.Put(path, bytes.NewReader(append(append([]byte{}, referencePrefix...), content...)))
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) ensureContent(sha256 string, src io.ReadSeeker) error {
+	exists, e := decorator.delegate.Exists(sha256)
+	if e != nil {
+		return e
+	}
+	if exists {
+		return nil
+	}
+	_, e = src.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return decorator.delegate.Put(sha256, src)
+}
+
+func markerPathFor(sha256 string, path string) string {
+	return sha256 + "-refs/" + path
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) putMarker(sha256 string, path string) error {
+	return decorator.delegate.Put(markerPathFor(sha256, path), bytes.NewReader(nil))
+}
+
+func (decorator *ContentAddressableBlobstoreDecorator) releaseIfReplaced(previousReference, reference *contentReference, path string) error {
+	if previousReference == nil || previousReference.Sha256 == reference.Sha256 {
+		return nil
+	}
+	return decorator.release(previousReference.Sha256, path)
+}
+
+// release removes the marker of the reference at path and deletes the content when it was the last reference.
+func (decorator *ContentAddressableBlobstoreDecorator) release(sha256 string, path string) error {
+	e := decorator.delegate.Delete(markerPathFor(sha256, path))
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	markers, _, e := decorator.delegate.List(sha256+"-refs/", "")
+	if e != nil {
+		return e
+	}
+	if len(markers) > 0 {
+		return nil
+	}
+	e = decorator.delegate.Delete(sha256)
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	return nil
+}
+
+type bufferedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ContentAddressingResourceSigner signs the content a path references, so that signed URLs
+// pointing directly into the blobstore deliver the content instead of the reference.
+type ContentAddressingResourceSigner struct {
+	blobstore *ContentAddressableBlobstoreDecorator
+	delegate  bitsgo.ResourceSigner
+}
+
+func ForResourceSignerWithContentAddressing(delegate bitsgo.ResourceSigner, blobstore *ContentAddressableBlobstoreDecorator) *ContentAddressingResourceSigner {
+	return &ContentAddressingResourceSigner{blobstore, delegate}
+}
+
+func (signer *ContentAddressingResourceSigner) Sign(resource string, method string, expirationTime time.Time) (signedURL string) {
+	contentPath, e := signer.blobstore.ContentPathFor(resource)
+	if e != nil {
+		// The signed URL then leads to the same error
+		return signer.delegate.Sign(resource, method, expirationTime)
+	}
+	return signer.delegate.Sign(contentPath, method, expirationTime)
+}
@@ -0,0 +1,212 @@
+package decorator
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Encrypted blobs consist of a header followed by the plaintext in chunks of encryptionChunkSize,
+// each sealed with AES-GCM. The header is:
+//
+//	magic | key ID length (1 byte) | key ID, zero-padded to keyIDFieldSize | wrapped data key
+//
+// Every blob has its own random data key, so nonces only need to be unique within a blob: A chunk's nonce is
+// the chunk's index (4 bytes) followed by a byte which is 1 only for the last chunk, left-padded with zeros.
+// This way, chunks cannot be reordered, and truncating a blob is detected. The last chunk is never full, so a plaintext
+// with a size that is a multiple of encryptionChunkSize ends with an empty chunk. All chunks authenticate the header.
+const (
+	encryptionChunkSize  = 64 * 1024
+	keyIDFieldSize       = 32
+	dataKeySize          = 32
+	wrappedDataKeySize   = 12 + dataKeySize + 16
+	nonceSize            = 12
+	tagSize              = 16
+	encryptionHeaderSize = 8 + 1 + keyIDFieldSize + wrappedDataKeySize
+)
+
+var encryptionMagic = []byte("BITSENC1")
+
+func ciphertextSizeFor(plaintextSize int64) int64 {
+	return encryptionHeaderSize + plaintextSize + (plaintextSize/encryptionChunkSize+1)*tagSize
+}
+
+func plaintextSizeFor(ciphertextSize int64) int64 {
+	body := ciphertextSize - encryptionHeaderSize
+	if body < tagSize {
+		return ciphertextSize
+	}
+	numChunks := (body + encryptionChunkSize + tagSize - 1) / (encryptionChunkSize + tagSize)
+	return body - numChunks*tagSize
+}
+
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint32(nonce[nonceSize-5:], uint32(index))
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// encryptingReader seeks to any position of the ciphertext by encrypting only the chunk containing it.
+// Blobstore clients rely on this to determine sizes and to retry uploads.
+type encryptingReader struct {
+	src            io.ReadSeeker
+	dataKey        cipher.AEAD
+	header         []byte
+	plaintextSize  int64
+	ciphertextSize int64
+	offset         int64
+
+	chunkIndex int64
+	chunk      []byte
+	plaintext  []byte
+}
+
+func newEncryptingReader(src io.ReadSeeker, dataKey cipher.AEAD, header []byte) (*encryptingReader, error) {
+	plaintextSize, e := src.Seek(0, io.SeekEnd)
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	if plaintextSize/encryptionChunkSize >= 1<<32 {
+		return nil, errors.Errorf("Blob of size %v is too large to be encrypted", plaintextSize)
+	}
+	return &encryptingReader{
+		src:            src,
+		dataKey:        dataKey,
+		header:         header,
+		plaintextSize:  plaintextSize,
+		ciphertextSize: ciphertextSizeFor(plaintextSize),
+		chunkIndex:     -1,
+		plaintext:      make([]byte, encryptionChunkSize),
+	}, nil
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.ciphertextSize {
+		return 0, io.EOF
+	}
+	if r.offset < encryptionHeaderSize {
+		n := copy(p, r.header[r.offset:])
+		r.offset += int64(n)
+		return n, nil
+	}
+	index := (r.offset - encryptionHeaderSize) / (encryptionChunkSize + tagSize)
+	if index != r.chunkIndex {
+		e := r.encryptChunk(index)
+		if e != nil {
+			return 0, e
+		}
+	}
+	n := copy(p, r.chunk[(r.offset-encryptionHeaderSize)%(encryptionChunkSize+tagSize):])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *encryptingReader) encryptChunk(index int64) error {
+	_, e := r.src.Seek(index*encryptionChunkSize, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	n, e := io.ReadFull(r.src, r.plaintext)
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return errors.WithStack(e)
+	}
+	last := index == r.plaintextSize/encryptionChunkSize
+	r.chunk = r.dataKey.Seal(r.chunk[:0], chunkNonce(index, last), r.plaintext[:n], r.header)
+	r.chunkIndex = index
+	return nil
+}
+
+func (r *encryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.ciphertextSize
+	default:
+		return 0, errors.Errorf("Invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("Negative position %v", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+type dataKeyLookup func(keyID string, wrappedDataKey []byte) (cipher.AEAD, error)
+
+type decryptingReader struct {
+	src     io.Reader
+	dataKey cipher.AEAD
+	header  []byte
+
+	chunkIndex int64
+	ciphertext []byte
+	buffer     []byte
+	// the not yet read part of buffer
+	plaintext []byte
+	done      bool
+}
+
+func newDecryptingReader(src io.Reader, lookupDataKey dataKeyLookup) (*decryptingReader, error) {
+	header := make([]byte, encryptionHeaderSize)
+	_, e := io.ReadFull(src, header)
+	if e != nil {
+		return nil, errors.Wrap(e, "Could not read header")
+	}
+	keyIDLength := int(header[len(encryptionMagic)])
+	if keyIDLength == 0 || keyIDLength > keyIDFieldSize {
+		return nil, errors.New("Invalid key ID in header")
+	}
+	keyIDStart := len(encryptionMagic) + 1
+	wrappedDataKeyStart := keyIDStart + keyIDFieldSize
+	dataKey, e := lookupDataKey(
+		string(header[keyIDStart:keyIDStart+keyIDLength]),
+		header[wrappedDataKeyStart:wrappedDataKeyStart+wrappedDataKeySize])
+	if e != nil {
+		return nil, e
+	}
+	return &decryptingReader{
+		src:        src,
+		dataKey:    dataKey,
+		header:     header,
+		ciphertext: make([]byte, encryptionChunkSize+tagSize),
+	}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		e := r.decryptNextChunk()
+		if e != nil {
+			return 0, e
+		}
+	}
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) decryptNextChunk() error {
+	n, e := io.ReadFull(r.src, r.ciphertext)
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return errors.WithStack(e)
+	}
+	last := n < len(r.ciphertext)
+	plaintext, e := r.dataKey.Open(r.buffer[:0], chunkNonce(r.chunkIndex, last), r.ciphertext[:n], r.header)
+	if e != nil {
+		return errors.Errorf("Blob is corrupted or was tampered with: chunk %v cannot be decrypted", r.chunkIndex)
+	}
+	r.buffer = plaintext
+	r.plaintext = plaintext
+	r.chunkIndex++
+	r.done = last
+	return nil
+}
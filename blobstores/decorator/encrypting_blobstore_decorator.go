@@ -0,0 +1,217 @@
+package decorator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/pkg/errors"
+)
+
+// Keyring holds the master keys which wrap the data keys of encrypted blobs.
+// New blobs use the active key. The other keys are only used to read blobs written before a key rotation.
+type Keyring struct {
+	activeKeyID string
+	keys        map[string]cipher.AEAD
+}
+
+// NewKeyring expects 32 byte keys and key IDs of at most 32 bytes.
+func NewKeyring(keys map[string][]byte, activeKeyID string) (*Keyring, error) {
+	keyring := &Keyring{activeKeyID: activeKeyID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for keyID, key := range keys {
+		if len(keyID) == 0 || len(keyID) > keyIDFieldSize {
+			return nil, errors.Errorf("Key ID '%v' must have between 1 and %v bytes", keyID, keyIDFieldSize)
+		}
+		if len(key) != dataKeySize {
+			return nil, errors.Errorf("Key '%v' must have %v bytes", keyID, dataKeySize)
+		}
+		aead, e := newAEAD(key)
+		if e != nil {
+			return nil, e
+		}
+		keyring.keys[keyID] = aead
+	}
+	if _, exists := keyring.keys[activeKeyID]; !exists {
+		return nil, errors.Errorf("Active key '%v' is not in keyring", activeKeyID)
+	}
+	return keyring, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	aead, e := cipher.NewGCM(block)
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	return aead, nil
+}
+
+// EncryptingBlobstoreDecorator encrypts blobs with AES-GCM before they reach the delegate.
+// Every blob has its own data key, which is stored in the blob's header, wrapped by the active master key.
+// Blobs stored before encryption was enabled are still served, as they are.
+//
+// Since the delegate only knows ciphertext, GetOrRedirect never redirects.
+type EncryptingBlobstoreDecorator struct {
+	delegate     bitsgo.Blobstore
+	keyring      *Keyring
+	resourceType string
+}
+
+func ForBlobstoreWithEncryption(delegate bitsgo.Blobstore, keyring *Keyring, resourceType string) *EncryptingBlobstoreDecorator {
+	return &EncryptingBlobstoreDecorator{delegate: delegate, keyring: keyring, resourceType: resourceType}
+}
+
+func (decorator *EncryptingBlobstoreDecorator) Exists(path string) (bool, error) {
+	return decorator.delegate.Exists(path)
+}
+
+// Stat only reads the beginning of the blob to tell whether it is encrypted.
+func (decorator *EncryptingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	info, e := decorator.delegate.Stat(path)
+	if e != nil {
+		return nil, e
+	}
+	encrypted, e := decorator.isEncrypted(*info)
+	if e != nil {
+		return nil, e
+	}
+	if encrypted {
+		info.Size = plaintextSizeFor(info.Size)
+	}
+	return info, nil
+}
+
+// List reads the beginning of every blob which is large enough to be encrypted, to tell whether it is.
+func (decorator *EncryptingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobs, nextPageToken, e := decorator.delegate.List(prefix, pageToken)
+	if e != nil {
+		return nil, "", e
+	}
+	for i := range blobs {
+		encrypted, e := decorator.isEncrypted(blobs[i])
+		if bitsgo.IsNotFoundError(e) {
+			continue
+		}
+		if e != nil {
+			return nil, "", e
+		}
+		if encrypted {
+			blobs[i].Size = plaintextSizeFor(blobs[i].Size)
+		}
+	}
+	return blobs, nextPageToken, nil
+}
+
+func (decorator *EncryptingBlobstoreDecorator) isEncrypted(info bitsgo.BlobInfo) (bool, error) {
+	if info.Size < ciphertextSizeFor(0) {
+		return false, nil
+	}
+	body, e := bitsgo.GetRange(decorator.delegate, info.Path, 0, int64(len(encryptionMagic)))
+	if e != nil {
+		return false, e
+	}
+	defer body.Close()
+	magic := make([]byte, len(encryptionMagic))
+	_, e = io.ReadFull(body, magic)
+	if e != nil {
+		return false, errors.Wrapf(e, "Could not read header of %v", info.Path)
+	}
+	return bytes.Equal(magic, encryptionMagic), nil
+}
+
+func (decorator *EncryptingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	body, e := decorator.delegate.Get(path)
+	if e != nil {
+		return nil, e
+	}
+	reader := bufio.NewReader(body)
+	magic, _ := reader.Peek(len(encryptionMagic))
+	if !bytes.Equal(magic, encryptionMagic) {
+		return &bufferedReadCloser{reader, body}, nil
+	}
+	decryptingReader, e := newDecryptingReader(reader, decorator.dataKeyFor)
+	if e != nil {
+		body.Close()
+		return nil, errors.Wrapf(e, "Could not decrypt %v", path)
+	}
+	return &bufferedReadCloser{decryptingReader, body}, nil
+}
+
+func (decorator *EncryptingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	body, e := decorator.Get(path)
+	return body, "", e
+}
+
+func (decorator *EncryptingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	dataKey, header, e := decorator.newDataKey()
+	if e != nil {
+		return errors.Wrapf(e, "Could not encrypt %v", path)
+	}
+	encryptingReader, e := newEncryptingReader(src, dataKey, header)
+	if e != nil {
+		return errors.Wrapf(e, "Could not encrypt %v", path)
+	}
+	return decorator.delegate.Put(path, encryptingReader)
+}
+
+// newDataKey returns a random data key and the header which stores it, wrapped by the active master key.
+func (decorator *EncryptingBlobstoreDecorator) newDataKey() (cipher.AEAD, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, e := rand.Read(dataKey)
+	if e != nil {
+		return nil, nil, errors.Wrap(e, "Could not generate data key")
+	}
+	dataKeyAEAD, e := newAEAD(dataKey)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	masterKey := decorator.keyring.keys[decorator.keyring.activeKeyID]
+	nonce := make([]byte, masterKey.NonceSize())
+	_, e = rand.Read(nonce)
+	if e != nil {
+		return nil, nil, errors.Wrap(e, "Could not generate nonce")
+	}
+	// Wrapping with the resource type as additional data prevents using blobs of one resource type as another one.
+	wrappedDataKey := masterKey.Seal(nonce, nonce, dataKey, []byte(decorator.resourceType))
+
+	header := make([]byte, 0, encryptionHeaderSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, byte(len(decorator.keyring.activeKeyID)))
+	header = append(header, decorator.keyring.activeKeyID...)
+	header = append(header, make([]byte, keyIDFieldSize-len(decorator.keyring.activeKeyID))...)
+	header = append(header, wrappedDataKey...)
+	return dataKeyAEAD, header, nil
+}
+
+func (decorator *EncryptingBlobstoreDecorator) Copy(src, dest string) error {
+	return decorator.delegate.Copy(src, dest)
+}
+
+func (decorator *EncryptingBlobstoreDecorator) Delete(path string) error {
+	return decorator.delegate.Delete(path)
+}
+
+func (decorator *EncryptingBlobstoreDecorator) DeleteDir(prefix string) error {
+	return decorator.delegate.DeleteDir(prefix)
+}
+
+func (decorator *EncryptingBlobstoreDecorator) dataKeyFor(keyID string, wrappedDataKey []byte) (cipher.AEAD, error) {
+	masterKey, exists := decorator.keyring.keys[keyID]
+	if !exists {
+		return nil, errors.Errorf("Master key '%v' is not in keyring", keyID)
+	}
+	nonceSize := masterKey.NonceSize()
+	dataKey, e := masterKey.Open(nil, wrappedDataKey[:nonceSize], wrappedDataKey[nonceSize:], []byte(decorator.resourceType))
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not unwrap data key with master key '%v'", keyID)
+	}
+	return newAEAD(dataKey)
+}
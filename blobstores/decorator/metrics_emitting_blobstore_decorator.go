@@ -20,14 +20,32 @@ func ForBlobstoreWithMetricsEmitter(delegate bitsgo.Blobstore, metricsService bi
 func (decorator *MetricsEmittingBlobstoreDecorator) Exists(path string) (bool, error) {
 	startTime := time.Now()
 	exists, e := decorator.delegate.Exists(path)
-	decorator.metricsService.SendTimingMetric(decorator.resourceType+"-exists_in_blobstore-time", time.Since(startTime))
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "exists", time.Since(startTime))
 	return exists, e
 }
 
+func (decorator *MetricsEmittingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	startTime := time.Now()
+	info, e := decorator.delegate.Stat(path)
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "stat", time.Since(startTime))
+	return info, e
+}
+
+func (decorator *MetricsEmittingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	startTime := time.Now()
+	blobs, nextPageToken, e := decorator.delegate.List(prefix, pageToken)
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "list", time.Since(startTime))
+	return blobs, nextPageToken, e
+}
+
 func (decorator *MetricsEmittingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
 	return decorator.delegate.Get(path)
 }
 
+func (decorator *MetricsEmittingBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	return bitsgo.GetRange(decorator.delegate, path, offset, length)
+}
+
 func (decorator *MetricsEmittingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	return decorator.delegate.GetOrRedirect(path)
 }
@@ -35,27 +53,27 @@ func (decorator *MetricsEmittingBlobstoreDecorator) GetOrRedirect(path string) (
 func (decorator *MetricsEmittingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
 	startTime := time.Now()
 	e := decorator.delegate.Put(path, src)
-	decorator.metricsService.SendTimingMetric(decorator.resourceType+"-cp_to_blobstore-time", time.Since(startTime))
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "put", time.Since(startTime))
 	return e
 }
 
 func (decorator *MetricsEmittingBlobstoreDecorator) Copy(src, dest string) error {
 	startTime := time.Now()
 	e := decorator.delegate.Copy(src, dest)
-	decorator.metricsService.SendTimingMetric(decorator.resourceType+"-copy_in_blobstore-time", time.Since(startTime))
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "copy", time.Since(startTime))
 	return e
 }
 
 func (decorator *MetricsEmittingBlobstoreDecorator) Delete(path string) error {
 	startTime := time.Now()
 	e := decorator.delegate.Delete(path)
-	decorator.metricsService.SendTimingMetric(decorator.resourceType+"-delete_from_blobstore-time", time.Since(startTime))
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "delete", time.Since(startTime))
 	return e
 }
 
 func (decorator *MetricsEmittingBlobstoreDecorator) DeleteDir(prefix string) error {
 	startTime := time.Now()
 	e := decorator.delegate.DeleteDir(prefix)
-	decorator.metricsService.SendTimingMetric(decorator.resourceType+"-delete_dir_from_blobstore-time", time.Since(startTime))
+	bitsgo.ObserveBlobstoreOperation(decorator.metricsService, decorator.resourceType, "delete_dir", time.Since(startTime))
 	return e
 }
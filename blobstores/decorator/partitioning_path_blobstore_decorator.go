@@ -3,6 +3,7 @@ package decorator
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"time"
 
@@ -21,10 +22,40 @@ func (decorator *PartitioningPathBlobstoreDecorator) Exists(path string) (bool,
 	return decorator.delegate.Exists(pathFor(path))
 }
 
+func (decorator *PartitioningPathBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	info, e := decorator.delegate.Stat(pathFor(path))
+	if info != nil {
+		info.Path = path
+	}
+	return info, e
+}
+
+func (decorator *PartitioningPathBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobs, nextPageToken, e := decorator.delegate.List(listPrefixFor(prefix), pageToken)
+	if e != nil {
+		return nil, "", e
+	}
+	identifierBlobs := make([]bitsgo.BlobInfo, 0, len(blobs))
+	for _, blob := range blobs {
+		identifier := identifierFor(blob.Path)
+		// Blobs which are not partitioned can be in the same blobstore. They must not show up here.
+		if identifier == "" || !strings.HasPrefix(identifier, prefix) {
+			continue
+		}
+		blob.Path = identifier
+		identifierBlobs = append(identifierBlobs, blob)
+	}
+	return identifierBlobs, nextPageToken, nil
+}
+
 func (decorator *PartitioningPathBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
 	return decorator.delegate.Get(pathFor(path))
 }
 
+func (decorator *PartitioningPathBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	return bitsgo.GetRange(decorator.delegate, pathFor(path), offset, length)
+}
+
 func (decorator *PartitioningPathBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	return decorator.delegate.GetOrRedirect(pathFor(path))
 }
@@ -49,6 +80,31 @@ func (decorator *PartitioningPathBlobstoreDecorator) DeleteDir(prefix string) er
 	}
 }
 
+// listPrefixFor returns the path prefix that contains all partitioned paths of identifiers starting with prefix.
+func listPrefixFor(prefix string) string {
+	switch {
+	case len(prefix) >= 4:
+		return pathFor(prefix)
+	case len(prefix) == 3:
+		return prefix[0:2] + "/" + prefix[2:3]
+	case len(prefix) == 2:
+		return prefix + "/"
+	default:
+		return prefix
+	}
+}
+
+// identifierFor is the inverse of pathFor. It returns "" if path is not a partitioned path.
+func identifierFor(path string) string {
+	// the lengths of the partition prefixes pathFor creates, starting with the one for the longest identifiers
+	for _, partitionPrefixLength := range []int{len("ab/cd/"), len("ab/c/"), len("ab/"), len("a/")} {
+		if len(path) > partitionPrefixLength && pathFor(path[partitionPrefixLength:]) == path {
+			return path[partitionPrefixLength:]
+		}
+	}
+	return ""
+}
+
 func pathFor(identifier string) string {
 	if len(identifier) >= 4 {
 		return fmt.Sprintf("%s/%s/%s", identifier[0:2], identifier[2:4], identifier)
@@ -2,6 +2,7 @@ package decorator
 
 import (
 	"io"
+	"strings"
 	"time"
 
 	"github.com/cloudfoundry-incubator/bits-service"
@@ -20,10 +21,30 @@ func (decorator *PrefixingPathBlobstoreDecorator) Exists(path string) (bool, err
 	return decorator.delegate.Exists(decorator.prefix + path)
 }
 
+func (decorator *PrefixingPathBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	info, e := decorator.delegate.Stat(decorator.prefix + path)
+	if info != nil {
+		info.Path = path
+	}
+	return info, e
+}
+
+func (decorator *PrefixingPathBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobs, nextPageToken, e := decorator.delegate.List(decorator.prefix+prefix, pageToken)
+	for i := range blobs {
+		blobs[i].Path = strings.TrimPrefix(blobs[i].Path, decorator.prefix)
+	}
+	return blobs, nextPageToken, e
+}
+
 func (decorator *PrefixingPathBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
 	return decorator.delegate.Get(decorator.prefix + path)
 }
 
+func (decorator *PrefixingPathBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	return bitsgo.GetRange(decorator.delegate, decorator.prefix+path, offset, length)
+}
+
 func (decorator *PrefixingPathBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	return decorator.delegate.GetOrRedirect(decorator.prefix + path)
 }
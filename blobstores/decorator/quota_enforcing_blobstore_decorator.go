@@ -0,0 +1,139 @@
+package decorator
+
+import (
+	"context"
+	"io"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/cloudfoundry-incubator/bits-service/quota"
+	"github.com/pkg/errors"
+)
+
+// QuotaEnforcingBlobstoreDecorator charges every blob written to the tenant of the context bound via WithContext.
+// Writes which would exceed the tenant's quota fail with *bitsgo.QuotaExceededError. Writes without tenant are not charged.
+//
+// It needs the size of blobs as uploaded, so it must be the outermost decorator, except for tracing.
+type QuotaEnforcingBlobstoreDecorator struct {
+	delegate     bitsgo.Blobstore
+	ledger       *quota.Ledger
+	resourceType string
+	ctx          context.Context
+}
+
+func ForBlobstoreWithQuotas(delegate bitsgo.Blobstore, ledger *quota.Ledger, resourceType string) *QuotaEnforcingBlobstoreDecorator {
+	return &QuotaEnforcingBlobstoreDecorator{delegate, ledger, resourceType, context.Background()}
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) WithContext(ctx context.Context) bitsgo.Blobstore {
+	return &QuotaEnforcingBlobstoreDecorator{bitsgo.BlobstoreWithContext(decorator.delegate, ctx), decorator.ledger, decorator.resourceType, ctx}
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) Exists(path string) (bool, error) {
+	return decorator.delegate.Exists(path)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	return decorator.delegate.Stat(path)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	return decorator.delegate.List(prefix, pageToken)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	return decorator.delegate.Get(path)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	return bitsgo.GetRange(decorator.delegate, path, offset, length)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	return decorator.delegate.GetOrRedirect(path)
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	size, e := src.Seek(0, io.SeekEnd)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	_, e = src.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return decorator.charge(path, bitsgo.TenantFrom(decorator.ctx), size, func() error {
+		return decorator.delegate.Put(path, src)
+	})
+}
+
+// Copy charges dest to the tenant of the context or, without one, to the tenant of src.
+func (decorator *QuotaEnforcingBlobstoreDecorator) Copy(src, dest string) error {
+	tenant := bitsgo.TenantFrom(decorator.ctx)
+	var size int64
+	entry, e := decorator.ledger.Lookup(decorator.keyFor(src))
+	if e != nil {
+		return e
+	}
+	if entry != nil {
+		if tenant == "" {
+			tenant = entry.Tenant
+		}
+		size = entry.Size
+	} else if tenant != "" {
+		info, e := decorator.delegate.Stat(src)
+		if e != nil {
+			return e
+		}
+		size = info.Size
+	}
+	return decorator.charge(dest, tenant, size, func() error {
+		return decorator.delegate.Copy(src, dest)
+	})
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) Delete(path string) error {
+	e := decorator.delegate.Delete(path)
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	releaseErr := decorator.ledger.Release(decorator.keyFor(path))
+	if releaseErr != nil {
+		logger.Log.Errorw("Could not release quota", "resource-type", decorator.resourceType, "path", path, "error", releaseErr)
+	}
+	return e
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) DeleteDir(prefix string) error {
+	e := decorator.delegate.DeleteDir(prefix)
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	releaseErr := decorator.ledger.ReleasePrefix(decorator.keyFor(prefix))
+	if releaseErr != nil {
+		logger.Log.Errorw("Could not release quota", "resource-type", decorator.resourceType, "prefix", prefix, "error", releaseErr)
+	}
+	return e
+}
+
+// charge records the blob before writing it, so that concurrent writes cannot exceed the quota together.
+func (decorator *QuotaEnforcingBlobstoreDecorator) charge(path string, tenant string, size int64, write func() error) error {
+	key := decorator.keyFor(path)
+	previous, e := decorator.ledger.Charge(key, tenant, size)
+	if e != nil {
+		return e
+	}
+	e = write()
+	if e != nil {
+		restoreErr := decorator.ledger.Restore(key, previous)
+		if restoreErr != nil {
+			logger.Log.Errorw("Could not restore quota", "resource-type", decorator.resourceType, "path", path, "error", restoreErr)
+		}
+		return e
+	}
+	return nil
+}
+
+func (decorator *QuotaEnforcingBlobstoreDecorator) keyFor(path string) string {
+	return decorator.resourceType + ":" + path
+}
@@ -0,0 +1,109 @@
+package decorator
+
+import (
+	"context"
+	"io"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingBlobstoreDecorator records a span for every blobstore call. Spans are children of the span
+// in the context bound via WithContext, so it must be the outermost decorator of a blobstore.
+// It binds the context to its delegate as well.
+type TracingBlobstoreDecorator struct {
+	delegate     bitsgo.Blobstore
+	resourceType string
+	ctx          context.Context
+}
+
+func ForBlobstoreWithTracing(delegate bitsgo.Blobstore, resourceType string) *TracingBlobstoreDecorator {
+	return &TracingBlobstoreDecorator{delegate, resourceType, context.Background()}
+}
+
+func (decorator *TracingBlobstoreDecorator) WithContext(ctx context.Context) bitsgo.Blobstore {
+	return &TracingBlobstoreDecorator{bitsgo.BlobstoreWithContext(decorator.delegate, ctx), decorator.resourceType, ctx}
+}
+
+func (decorator *TracingBlobstoreDecorator) startSpan(operation string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := bitsgo.Tracer().Start(decorator.ctx, "blobstore."+operation,
+		trace.WithAttributes(attribute.String("bits.resource_type", decorator.resourceType)),
+		trace.WithAttributes(attributes...))
+	return span
+}
+
+func (decorator *TracingBlobstoreDecorator) Exists(path string) (bool, error) {
+	span := decorator.startSpan("exists", attribute.String("bits.path", path))
+	exists, e := decorator.delegate.Exists(path)
+	span.SetAttributes(attribute.Bool("bits.exists", exists))
+	bitsgo.EndSpan(span, e)
+	return exists, e
+}
+
+func (decorator *TracingBlobstoreDecorator) Stat(path string) (*bitsgo.BlobInfo, error) {
+	span := decorator.startSpan("stat", attribute.String("bits.path", path))
+	info, e := decorator.delegate.Stat(path)
+	bitsgo.EndSpan(span, e)
+	return info, e
+}
+
+func (decorator *TracingBlobstoreDecorator) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	span := decorator.startSpan("list", attribute.String("bits.prefix", prefix))
+	blobs, nextPageToken, e := decorator.delegate.List(prefix, pageToken)
+	span.SetAttributes(attribute.Int("bits.blob_count", len(blobs)))
+	bitsgo.EndSpan(span, e)
+	return blobs, nextPageToken, e
+}
+
+// Get's span only covers opening the blob, not reading it.
+func (decorator *TracingBlobstoreDecorator) Get(path string) (body io.ReadCloser, err error) {
+	span := decorator.startSpan("get", attribute.String("bits.path", path))
+	body, e := decorator.delegate.Get(path)
+	bitsgo.EndSpan(span, e)
+	return body, e
+}
+
+func (decorator *TracingBlobstoreDecorator) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	span := decorator.startSpan("get_range",
+		attribute.String("bits.path", path), attribute.Int64("bits.offset", offset), attribute.Int64("bits.length", length))
+	body, e := bitsgo.GetRange(decorator.delegate, path, offset, length)
+	bitsgo.EndSpan(span, e)
+	return body, e
+}
+
+func (decorator *TracingBlobstoreDecorator) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	span := decorator.startSpan("get_or_redirect", attribute.String("bits.path", path))
+	body, redirectLocation, e := decorator.delegate.GetOrRedirect(path)
+	span.SetAttributes(attribute.Bool("bits.redirected", redirectLocation != ""))
+	bitsgo.EndSpan(span, e)
+	return body, redirectLocation, e
+}
+
+func (decorator *TracingBlobstoreDecorator) Put(path string, src io.ReadSeeker) error {
+	span := decorator.startSpan("put", attribute.String("bits.path", path))
+	e := decorator.delegate.Put(path, src)
+	bitsgo.EndSpan(span, e)
+	return e
+}
+
+func (decorator *TracingBlobstoreDecorator) Copy(src, dest string) error {
+	span := decorator.startSpan("copy", attribute.String("bits.path", src), attribute.String("bits.destination_path", dest))
+	e := decorator.delegate.Copy(src, dest)
+	bitsgo.EndSpan(span, e)
+	return e
+}
+
+func (decorator *TracingBlobstoreDecorator) Delete(path string) error {
+	span := decorator.startSpan("delete", attribute.String("bits.path", path))
+	e := decorator.delegate.Delete(path)
+	bitsgo.EndSpan(span, e)
+	return e
+}
+
+func (decorator *TracingBlobstoreDecorator) DeleteDir(prefix string) error {
+	span := decorator.startSpan("delete_dir", attribute.String("bits.prefix", prefix))
+	e := decorator.delegate.DeleteDir(prefix)
+	bitsgo.EndSpan(span, e)
+	return e
+}
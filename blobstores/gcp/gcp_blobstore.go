@@ -12,6 +12,7 @@ import (
 
 	"github.com/cenkalti/backoff"
 	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/validate"
 	"github.com/cloudfoundry-incubator/bits-service/config"
 	"github.com/cloudfoundry-incubator/bits-service/logger"
@@ -85,6 +86,49 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return true, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), blobstore.retryTimeout)
+	defer cancel()
+
+	var attrs *storage.ObjectAttrs
+	e := WithRetries(4, func() error {
+		var e error
+		attrs, e = blobstore.client.Bucket(blobstore.bucket).Object(path).Attrs(ctx)
+		return TimeoutOrPermanent(e)
+	})
+	if e != nil {
+		return nil, blobstore.handleError(e, "Failed to stat %v/%v", blobstore.bucket, path)
+	}
+	return &bitsgo.BlobInfo{Path: path, Size: attrs.Size, LastModified: attrs.Updated, ETag: attrs.Etag}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), blobstore.retryTimeout)
+	defer cancel()
+
+	var (
+		objects       []*storage.ObjectAttrs
+		nextPageToken string
+	)
+	e := WithRetries(4, func() error {
+		objects = nil
+		var e error
+		nextPageToken, e = iterator.NewPager(
+			blobstore.client.Bucket(blobstore.bucket).Objects(ctx, &storage.Query{Prefix: prefix}),
+			pagination.DefaultPageSize,
+			pageToken).NextPage(&objects)
+		return TimeoutOrPermanent(e)
+	})
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list %v/%v", blobstore.bucket, prefix)
+	}
+	blobs := make([]bitsgo.BlobInfo, len(objects))
+	for i, attrs := range objects {
+		blobs[i] = bitsgo.BlobInfo{Path: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated, ETag: attrs.Etag}
+	}
+	return blobs, nextPageToken, nil
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	logger.Log.Debugw("Get from GCP", "bucket", blobstore.bucket, "path", path)
 	reader, e := blobstore.client.Bucket(blobstore.bucket).Object(path).NewReader(context.TODO())
@@ -94,6 +138,15 @@ func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	return reader, nil
 }
 
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	logger.Log.Debugw("GetRange from GCP", "bucket", blobstore.bucket, "path", path, "offset", offset, "length", length)
+	reader, e := blobstore.client.Bucket(blobstore.bucket).Object(path).NewRangeReader(context.TODO(), offset, length)
+	if e != nil {
+		return nil, blobstore.handleError(e, "Path %v", path)
+	}
+	return reader, nil
+}
+
 func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	signedUrl, e := storage.SignedURL(blobstore.bucket, path, &storage.SignedURLOptions{
 		GoogleAccessID: blobstore.jwtConfig.Email,
@@ -1,11 +1,15 @@
 package inmemory_blobstore
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 
 	"bytes"
 
@@ -29,6 +33,32 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return hasKey, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	entry, hasKey := blobstore.Entries[path]
+	if !hasKey {
+		return nil, bitsgo.NewNotFoundErrorWithKey(path)
+	}
+	info := blobInfoFor(path, entry)
+	return &info, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobs := []bitsgo.BlobInfo{}
+	for key, entry := range blobstore.Entries {
+		if strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, blobInfoFor(key, entry))
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Path < blobs[j].Path })
+	blobs, nextPageToken := pagination.Page(blobs, pageToken, pagination.DefaultPageSize)
+	return blobs, nextPageToken, nil
+}
+
+func blobInfoFor(path string, entry []byte) bitsgo.BlobInfo {
+	sha := sha1.Sum(entry)
+	return bitsgo.BlobInfo{Path: path, Size: int64(len(entry)), ETag: hex.EncodeToString(sha[:])}
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	entry, hasKey := blobstore.Entries[path]
 	if !hasKey {
@@ -37,6 +67,18 @@ func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	return ioutil.NopCloser(bytes.NewBuffer(entry)), nil
 }
 
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	entry, hasKey := blobstore.Entries[path]
+	if !hasKey {
+		return nil, bitsgo.NewNotFoundError()
+	}
+	entry = entry[offset:]
+	if length >= 0 && length < int64(len(entry)) {
+		entry = entry[:length]
+	}
+	return ioutil.NopCloser(bytes.NewBuffer(entry)), nil
+}
+
 func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	body, e := blobstore.Get(path)
 	return body, "", e
@@ -4,13 +4,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/cloudfoundry-incubator/bits-service/config"
 
 	"syscall"
 
 	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 	"github.com/cloudfoundry-incubator/bits-service/logger"
 	"github.com/pkg/errors"
 )
@@ -34,10 +38,87 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return true, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	fileInfo, e := os.Stat(filepath.Join(blobstore.pathPrefix, path))
+	if os.IsNotExist(e) {
+		return nil, bitsgo.NewNotFoundErrorWithKey(path)
+	}
+	if e != nil {
+		return nil, fmt.Errorf("Could not stat on %v. Caused by: %v", filepath.Join(blobstore.pathPrefix, path), e)
+	}
+	info := blobInfoFor(path, fileInfo)
+	return &info, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	// Only walk the directory the prefix points into, not the whole blobstore
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = pathpkg.Dir(prefix)
+	}
+	blobs := []bitsgo.BlobInfo{}
+	e := filepath.Walk(filepath.Join(blobstore.pathPrefix, dir), func(fullPath string, fileInfo os.FileInfo, e error) error {
+		if os.IsNotExist(e) {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+		path, e := filepath.Rel(blobstore.pathPrefix, fullPath)
+		if e != nil {
+			return e
+		}
+		path = filepath.ToSlash(path)
+		if strings.HasPrefix(path, prefix) {
+			blobs = append(blobs, blobInfoFor(path, fileInfo))
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list %v", filepath.Join(blobstore.pathPrefix, prefix))
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Path < blobs[j].Path })
+	blobs, nextPageToken := pagination.Page(blobs, pageToken, pagination.DefaultPageSize)
+	return blobs, nextPageToken, nil
+}
+
+func blobInfoFor(path string, fileInfo os.FileInfo) bitsgo.BlobInfo {
+	return bitsgo.BlobInfo{
+		Path:         path,
+		Size:         fileInfo.Size(),
+		LastModified: fileInfo.ModTime(),
+		// Same scheme as nginx uses for static files. Avoids reading the whole file just to compute a checksum,
+		// but content written within the same second with the same size gets the same ETag, so it is weak.
+		ETag:     fmt.Sprintf("%x-%x", fileInfo.ModTime().Unix(), fileInfo.Size()),
+		WeakETag: true,
+	}
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	logger.Log.Debugw("GetNoRedirect", "local-path", filepath.Join(blobstore.pathPrefix, path))
-	file, e := os.Open(filepath.Join(blobstore.pathPrefix, path))
+	return blobstore.open(path)
+}
 
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	logger.Log.Debugw("GetRange", "local-path", filepath.Join(blobstore.pathPrefix, path), "offset", offset, "length", length)
+	file, e := blobstore.open(path)
+	if e != nil {
+		return nil, e
+	}
+	_, e = file.Seek(offset, io.SeekStart)
+	if e != nil {
+		file.Close()
+		return nil, fmt.Errorf("Error while seeking in file %v. Caused by: %v", path, e)
+	}
+	return bitsgo.LimitReadCloser(file, length), nil
+}
+
+func (blobstore *Blobstore) open(path string) (*os.File, error) {
+	file, e := os.Open(filepath.Join(blobstore.pathPrefix, path))
 	if os.IsNotExist(e) {
 		return nil, bitsgo.NewNotFoundError()
 	}
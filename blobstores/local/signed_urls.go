@@ -17,3 +17,12 @@ type LocalResourceSigner struct {
 func (signer *LocalResourceSigner) Sign(resource string, method string, expirationTime time.Time) (signedURL string) {
 	return fmt.Sprintf("%s%s", signer.DelegateEndpoint, signer.Signer.Sign(method, signer.ResourcePathPrefix+resource, expirationTime))
 }
+
+// SignForTenant falls back to Sign, if Signer cannot sign for tenants.
+func (signer *LocalResourceSigner) SignForTenant(resource string, method string, expirationTime time.Time, tenant string) (signedURL string) {
+	tenantSigner, ok := signer.Signer.(pathsigner.TenantPathSigner)
+	if !ok {
+		return signer.Sign(resource, method, expirationTime)
+	}
+	return fmt.Sprintf("%s%s", signer.DelegateEndpoint, tenantSigner.SignForTenant(method, signer.ResourcePathPrefix+resource, expirationTime, tenant))
+}
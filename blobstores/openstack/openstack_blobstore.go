@@ -17,6 +17,7 @@ import (
 	"strings"
 
 	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/validate"
 	"github.com/cloudfoundry-incubator/bits-service/config"
 	"github.com/cloudfoundry-incubator/bits-service/logger"
@@ -81,6 +82,45 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return true, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	if !blobstore.containerExists() {
+		return nil, errors.Errorf("Container not found: '%v'", blobstore.containerName)
+	}
+
+	object, _, e := blobstore.swiftConn.Object(blobstore.containerName, path)
+	if e == swift.ObjectNotFound {
+		return nil, bitsgo.NewNotFoundErrorWithKey(path)
+	}
+	if e != nil {
+		return nil, errors.Wrapf(e, "Failed to stat %v/%v", blobstore.containerName, path)
+	}
+	return &bitsgo.BlobInfo{Path: path, Size: object.Bytes, LastModified: object.LastModified, ETag: object.Hash}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	if !blobstore.containerExists() {
+		return nil, "", errors.Errorf("Container not found: '%v'", blobstore.containerName)
+	}
+
+	objects, e := blobstore.swiftConn.Objects(blobstore.containerName, &swift.ObjectsOpts{
+		Prefix: prefix,
+		Marker: pageToken,
+		Limit:  pagination.DefaultPageSize,
+	})
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Container: '%v', prefix: '%v'", blobstore.containerName, prefix)
+	}
+	blobs := make([]bitsgo.BlobInfo, len(objects))
+	for i, object := range objects {
+		blobs[i] = bitsgo.BlobInfo{Path: object.Name, Size: object.Bytes, LastModified: object.LastModified, ETag: object.Hash}
+	}
+	// Swift does not tell whether there are more objects. A full page means there might be.
+	if len(blobs) == pagination.DefaultPageSize {
+		return blobs, blobs[len(blobs)-1].Path, nil
+	}
+	return blobs, "", nil
+}
+
 func (blobstore *Blobstore) containerExists() bool {
 	_, _, e := blobstore.swiftConn.Container(blobstore.containerName)
 	return e != swift.ContainerNotFound
@@ -0,0 +1,21 @@
+package pagination
+
+import (
+	"sort"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+)
+
+// DefaultPageSize is the page size of blobstores that paginate themselves. It's the same as S3's maximum page size.
+const DefaultPageSize = 1000
+
+// Page is meant for blobstores that can only list all blobs at once. blobs must be sorted by path.
+// The page token is the path of the last blob of the previous page.
+func Page(blobs []bitsgo.BlobInfo, pageToken string, pageSize int) (page []bitsgo.BlobInfo, nextPageToken string) {
+	start := sort.Search(len(blobs), func(i int) bool { return blobs[i].Path > pageToken })
+	end := start + pageSize
+	if end >= len(blobs) {
+		return blobs[start:], ""
+	}
+	return blobs[start:end], blobs[end-1].Path
+}
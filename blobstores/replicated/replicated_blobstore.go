@@ -0,0 +1,371 @@
+package replicated
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// Blobstore writes to a primary and mirrors all writes to secondaries, either before returning
+// or asynchronously via a queue. Reads go to the primary and only fall back to the secondaries when
+// the primary fails with an error other than NotFound.
+//
+// Async writes which do not fit into the queue or fail are only replicated by Repair.
+type Blobstore struct {
+	primary        bitsgo.Blobstore
+	secondaries    []bitsgo.Blobstore
+	queue          chan replication
+	metricsService bitsgo.MetricsService
+	resourceType   string
+	// Repair only removes blobs which only exist in a secondary when this is greater than 0.
+	maxOrphanRemovals int
+}
+
+type replicationOperation string
+
+const (
+	put       replicationOperation = "put"
+	copyBlob  replicationOperation = "copy"
+	deleteOne replicationOperation = "delete"
+	deleteDir replicationOperation = "delete_dir"
+)
+
+type replication struct {
+	operation replicationOperation
+	path      string
+	// only for copy
+	destination string
+}
+
+// NewBlobstore replicates asynchronously when queueSize is greater than 0. Its Repair never removes blobs from secondaries.
+func NewBlobstore(primary bitsgo.Blobstore, secondaries []bitsgo.Blobstore, queueSize int, metricsService bitsgo.MetricsService, resourceType string) *Blobstore {
+	return NewBlobstoreWithOrphanRemoval(primary, secondaries, queueSize, 0, metricsService, resourceType)
+}
+
+// NewBlobstoreWithOrphanRemoval makes Repair remove blobs which only exist in a secondary, as long as there are
+// at most maxOrphanRemovals of them per secondary.
+func NewBlobstoreWithOrphanRemoval(primary bitsgo.Blobstore, secondaries []bitsgo.Blobstore, queueSize int, maxOrphanRemovals int, metricsService bitsgo.MetricsService, resourceType string) *Blobstore {
+	blobstore := &Blobstore{
+		primary:           primary,
+		secondaries:       secondaries,
+		metricsService:    metricsService,
+		resourceType:      resourceType,
+		maxOrphanRemovals: maxOrphanRemovals,
+	}
+	if queueSize > 0 {
+		blobstore.queue = make(chan replication, queueSize)
+		// A single worker keeps writes to the same path in order
+		go blobstore.replicateQueued()
+	}
+	return blobstore
+}
+
+func (blobstore *Blobstore) Exists(path string) (exists bool, err error) {
+	err = blobstore.read("exists", path, func(backend bitsgo.Blobstore) (e error) {
+		exists, e = backend.Exists(path)
+		return
+	})
+	return
+}
+
+func (blobstore *Blobstore) Stat(path string) (info *bitsgo.BlobInfo, err error) {
+	err = blobstore.read("stat", path, func(backend bitsgo.Blobstore) (e error) {
+		info, e = backend.Stat(path)
+		return
+	})
+	return
+}
+
+// List only falls back to secondaries for the first page, since page tokens are specific to a backend.
+func (blobstore *Blobstore) List(prefix string, pageToken string) (blobs []bitsgo.BlobInfo, nextPageToken string, err error) {
+	if pageToken != "" {
+		return blobstore.primary.List(prefix, pageToken)
+	}
+	err = blobstore.read("list", prefix, func(backend bitsgo.Blobstore) (e error) {
+		blobs, nextPageToken, e = backend.List(prefix, pageToken)
+		return
+	})
+	return
+}
+
+func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
+	err = blobstore.read("get", path, func(backend bitsgo.Blobstore) (e error) {
+		body, e = backend.Get(path)
+		return
+	})
+	return
+}
+
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	err = blobstore.read("get_range", path, func(backend bitsgo.Blobstore) (e error) {
+		body, e = bitsgo.GetRange(backend, path, offset, length)
+		return
+	})
+	return
+}
+
+func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
+	err = blobstore.read("get_or_redirect", path, func(backend bitsgo.Blobstore) (e error) {
+		body, redirectLocation, e = backend.GetOrRedirect(path)
+		return
+	})
+	return
+}
+
+func (blobstore *Blobstore) Put(path string, src io.ReadSeeker) error {
+	e := blobstore.primary.Put(path, src)
+	if e != nil {
+		return e
+	}
+	return blobstore.replicate(replication{operation: put, path: path}, func(secondary bitsgo.Blobstore) error {
+		_, e := src.Seek(0, io.SeekStart)
+		if e != nil {
+			return errors.WithStack(e)
+		}
+		return secondary.Put(path, src)
+	})
+}
+
+func (blobstore *Blobstore) Copy(src, dest string) error {
+	e := blobstore.primary.Copy(src, dest)
+	if e != nil {
+		return e
+	}
+	return blobstore.replicate(replication{operation: copyBlob, path: src, destination: dest}, nil)
+}
+
+func (blobstore *Blobstore) Delete(path string) error {
+	e := blobstore.primary.Delete(path)
+	if e != nil {
+		return e
+	}
+	return blobstore.replicate(replication{operation: deleteOne, path: path}, nil)
+}
+
+func (blobstore *Blobstore) DeleteDir(prefix string) error {
+	e := blobstore.primary.DeleteDir(prefix)
+	if e != nil {
+		return e
+	}
+	return blobstore.replicate(replication{operation: deleteDir, path: prefix}, nil)
+}
+
+// read tries the secondaries in order, when the primary fails. If they fail as well, it returns the primary's error.
+func (blobstore *Blobstore) read(operation string, path string, readFrom func(backend bitsgo.Blobstore) error) error {
+	e := readFrom(blobstore.primary)
+	if e == nil || bitsgo.IsNotFoundError(e) {
+		return e
+	}
+	for i, secondary := range blobstore.secondaries {
+		logger.Log.Errorw("Primary blobstore failed. Falling back to secondary.",
+			"resource-type", blobstore.resourceType, "operation", operation, "path", path, "secondary", i, "error", e)
+		blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-read-fallback", 1)
+		secondaryError := readFrom(secondary)
+		if secondaryError == nil || bitsgo.IsNotFoundError(secondaryError) {
+			return secondaryError
+		}
+		logger.Log.Errorw("Secondary blobstore failed",
+			"resource-type", blobstore.resourceType, "operation", operation, "path", path, "secondary", i, "error", secondaryError)
+	}
+	return e
+}
+
+// replicate applies r to all secondaries, or queues it in async mode. Sync puts use replicatePut instead of reading back the primary.
+func (blobstore *Blobstore) replicate(r replication, replicatePut func(secondary bitsgo.Blobstore) error) error {
+	if blobstore.queue != nil {
+		select {
+		case blobstore.queue <- r:
+		default:
+			logger.Log.Errorw("Replication queue is full. Blob will only be replicated by repair.",
+				"resource-type", blobstore.resourceType, "operation", r.operation, "path", r.path)
+			blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-dropped", 1)
+		}
+		return nil
+	}
+	for i, secondary := range blobstore.secondaries {
+		var e error
+		if r.operation == put {
+			e = replicatePut(secondary)
+		} else {
+			e = blobstore.apply(r, secondary)
+		}
+		if e != nil {
+			blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-failed", 1)
+			return errors.Wrapf(e, "Could not replicate %v of %v to secondary %v", r.operation, r.path, i)
+		}
+	}
+	return nil
+}
+
+func (blobstore *Blobstore) replicateQueued() {
+	for r := range blobstore.queue {
+		for i, secondary := range blobstore.secondaries {
+			e := blobstore.apply(r, secondary)
+			if e != nil {
+				logger.Log.Errorw("Could not replicate to secondary",
+					"resource-type", blobstore.resourceType, "operation", r.operation, "path", r.path, "secondary", i, "error", e)
+				blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-failed", 1)
+			}
+		}
+	}
+}
+
+func (blobstore *Blobstore) apply(r replication, secondary bitsgo.Blobstore) error {
+	switch r.operation {
+	case put:
+		return blobstore.copyFromPrimary(r.path, secondary)
+	case copyBlob:
+		e := secondary.Copy(r.path, r.destination)
+		if bitsgo.IsNotFoundError(e) {
+			// The source was never replicated
+			return blobstore.copyFromPrimary(r.destination, secondary)
+		}
+		return e
+	case deleteOne:
+		e := secondary.Delete(r.path)
+		if bitsgo.IsNotFoundError(e) {
+			return nil
+		}
+		return e
+	case deleteDir:
+		return secondary.DeleteDir(r.path)
+	default:
+		return errors.Errorf("Unknown replication operation %v", r.operation)
+	}
+}
+
+// copyFromPrimary does nothing when path does not exist in the primary anymore.
+func (blobstore *Blobstore) copyFromPrimary(path string, secondary bitsgo.Blobstore) error {
+	body, e := blobstore.primary.Get(path)
+	if bitsgo.IsNotFoundError(e) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	defer body.Close()
+
+	// Secondaries need an io.ReadSeeker
+	tempFile, e := ioutil.TempFile("", "replication")
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	_, e = io.Copy(tempFile, body)
+	if e != nil {
+		return errors.Wrapf(e, "Could not read %v from primary", path)
+	}
+	_, e = tempFile.Seek(0, io.SeekStart)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return secondary.Put(path, tempFile)
+}
+
+// Repair makes every secondary hold the same blobs as the primary: It copies blobs from the primary which are missing
+// in a secondary or differ in size or ETag. With orphan removal, it also removes blobs which only exist in a secondary.
+// Only one bits-service instance must repair at a time.
+//
+// Every secondary is listed before the primary. Since blobs only reach secondaries after they were written to the primary,
+// a blob which is in the secondary's listing, but not in the primary's one, was removed from the primary.
+//
+// An emptied or lost primary must not wipe the secondaries. So Repair removes nothing, when the primary is empty or
+// more than maxOrphanRemovals blobs only exist in a secondary, and fails instead.
+func (blobstore *Blobstore) Repair() error {
+	startTime := time.Now()
+	var repaired, removed int64
+	for i, secondary := range blobstore.secondaries {
+		existing, e := allBlobs(secondary)
+		if e != nil {
+			return errors.Wrapf(e, "Could not list secondary %v", i)
+		}
+		inPrimary, e := allBlobs(blobstore.primary)
+		if e != nil {
+			return errors.Wrap(e, "Could not list primary")
+		}
+		for path, blob := range inPrimary {
+			if replica, exists := existing[path]; exists && !differ(blob, replica) {
+				continue
+			}
+			logger.Log.Infow("Repairing missing or differing blob in secondary", "resource-type", blobstore.resourceType, "path", path, "secondary", i)
+			e = blobstore.copyFromPrimary(path, secondary)
+			if e != nil {
+				return errors.Wrapf(e, "Could not repair %v in secondary %v", path, i)
+			}
+			repaired++
+		}
+		if blobstore.maxOrphanRemovals <= 0 {
+			continue
+		}
+		var orphans []string
+		for path := range existing {
+			if _, exists := inPrimary[path]; !exists {
+				orphans = append(orphans, path)
+			}
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		if len(inPrimary) == 0 {
+			return errors.Errorf("Refusing to remove all %v blobs from secondary %v, because the primary is empty", len(orphans), i)
+		}
+		if len(orphans) > blobstore.maxOrphanRemovals {
+			return errors.Errorf("Refusing to remove %v blobs which only exist in secondary %v. At most %v are allowed to be removed.",
+				len(orphans), i, blobstore.maxOrphanRemovals)
+		}
+		for _, path := range orphans {
+			logger.Log.Infow("Removing blob which only exists in secondary", "resource-type", blobstore.resourceType, "path", path, "secondary", i)
+			e = secondary.Delete(path)
+			if e != nil && !bitsgo.IsNotFoundError(e) {
+				return errors.Wrapf(e, "Could not remove %v from secondary %v", path, i)
+			}
+			removed++
+		}
+	}
+	blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-repaired", repaired)
+	blobstore.metricsService.SendCounterMetric(blobstore.resourceType+"-replication-removed", removed)
+	blobstore.metricsService.SendTimingMetric(blobstore.resourceType+"-replication-repair-time", time.Since(startTime))
+	return nil
+}
+
+// differ compares ETags only when the replica is not newer than the blob in the primary, because backends
+// of different types compute ETags differently.
+func differ(blob, replica bitsgo.BlobInfo) bool {
+	if blob.Size != replica.Size {
+		return true
+	}
+	return blob.ETag != replica.ETag && !replica.LastModified.After(blob.LastModified)
+}
+
+func (blobstore *Blobstore) RepairPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		e := blobstore.Repair()
+		if e != nil {
+			logger.Log.Errorw("Replication repair failed", "resource-type", blobstore.resourceType, "error", e)
+		}
+	}
+}
+
+func allBlobs(blobstore bitsgo.Blobstore) (map[string]bitsgo.BlobInfo, error) {
+	blobs := make(map[string]bitsgo.BlobInfo)
+	pageToken := ""
+	for {
+		page, nextPageToken, e := blobstore.List("", pageToken)
+		if e != nil {
+			return nil, e
+		}
+		for _, blob := range page {
+			blobs[blob.Path] = blob
+		}
+		if nextPageToken == "" {
+			return blobs, nil
+		}
+		pageToken = nextPageToken
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/cloudfoundry-incubator/bits-service"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/s3/signer"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/validate"
@@ -22,6 +23,7 @@ import (
 
 type Blobstore struct {
 	s3Client             *s3.S3
+	uploader             *s3manager.Uploader
 	bucket               string
 	signer               S3Signer
 	serverSideEncryption *string
@@ -76,17 +78,24 @@ func NewBlobstoreWithLogger(config config.S3BlobstoreConfig, logger *zap.Sugared
 		}
 	}
 
+	s3Client := newS3Client(config.Region,
+		config.UseIAMProfile,
+		config.AccessKeyID,
+		config.SecretAccessKey,
+		config.Host,
+		logger,
+		config.S3DebugLogLevel,
+		config.Bucket,
+		config.SignatureVersion,
+	)
 	blobstore := &Blobstore{
-		s3Client: newS3Client(config.Region,
-			config.UseIAMProfile,
-			config.AccessKeyID,
-			config.SecretAccessKey,
-			config.Host,
-			logger,
-			config.S3DebugLogLevel,
-			config.Bucket,
-			config.SignatureVersion,
-		),
+		s3Client: s3Client,
+		// Blobs up to PartSize are uploaded with a single PutObject. Larger ones are uploaded as multipart upload,
+		// which the uploader aborts when a part fails, so that no incomplete uploads are left behind.
+		uploader: s3manager.NewUploaderWithClient(s3Client, func(uploader *s3manager.Uploader) {
+			uploader.PartSize = config.PartSizeBytes()
+			uploader.Concurrency = config.UploadConcurrencyOrDefault()
+		}),
 		bucket: config.Bucket,
 		signer: s3Signer,
 	}
@@ -120,6 +129,53 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return true, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	output, e := blobstore.s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &blobstore.bucket,
+		Key:    &path,
+	})
+	if e != nil {
+		if isS3NotFoundError(e) {
+			return nil, bitsgo.NewNotFoundErrorWithKey(path)
+		}
+		return nil, errors.Wrapf(e, "Failed to stat %v/%v", blobstore.bucket, path)
+	}
+	return &bitsgo.BlobInfo{
+		Path:         path,
+		Size:         aws.Int64Value(output.ContentLength),
+		LastModified: aws.TimeValue(output.LastModified),
+		ETag:         strings.Trim(aws.StringValue(output.ETag), `"`),
+	}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	input := &s3.ListObjectsInput{
+		Bucket: &blobstore.bucket,
+		Prefix: &prefix,
+	}
+	if pageToken != "" {
+		input.Marker = &pageToken
+	}
+	output, e := blobstore.s3Client.ListObjects(input)
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list %v/%v", blobstore.bucket, prefix)
+	}
+	blobs := make([]bitsgo.BlobInfo, len(output.Contents))
+	for i, object := range output.Contents {
+		blobs[i] = bitsgo.BlobInfo{
+			Path:         aws.StringValue(object.Key),
+			Size:         aws.Int64Value(object.Size),
+			LastModified: aws.TimeValue(object.LastModified),
+			ETag:         strings.Trim(aws.StringValue(object.ETag), `"`),
+		}
+	}
+	// NextMarker is only set when using a delimiter. Otherwise the last key is the marker for the next page.
+	if aws.BoolValue(output.IsTruncated) && len(blobs) > 0 {
+		return blobs, blobs[len(blobs)-1].Path, nil
+	}
+	return blobs, "", nil
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	logger.Log.Debugw("Get from S3", "bucket", blobstore.bucket, "path", path)
 	output, e := blobstore.s3Client.GetObject(&s3.GetObjectInput{
@@ -135,6 +191,22 @@ func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	return output.Body, nil
 }
 
+func (blobstore *Blobstore) GetRange(path string, offset int64, length int64) (body io.ReadCloser, err error) {
+	logger.Log.Debugw("GetRange from S3", "bucket", blobstore.bucket, "path", path, "offset", offset, "length", length)
+	output, e := blobstore.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: &blobstore.bucket,
+		Key:    &path,
+		Range:  aws.String(bitsgo.HTTPByteRange(offset, length)),
+	})
+	if e != nil {
+		if isS3NotFoundError(e) {
+			return nil, bitsgo.NewNotFoundErrorWithKey(path)
+		}
+		return nil, errors.Wrapf(e, "Path %v", path)
+	}
+	return output.Body, nil
+}
+
 func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redirectLocation string, err error) {
 	request, _ := blobstore.s3Client.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: &blobstore.bucket,
@@ -146,7 +218,7 @@ func (blobstore *Blobstore) GetOrRedirect(path string) (body io.ReadCloser, redi
 
 func (blobstore *Blobstore) Put(path string, src io.ReadSeeker) error {
 	logger.Log.Debugw("Put to S3", "bucket", blobstore.bucket, "path", path)
-	_, e := blobstore.s3Client.PutObject(&s3.PutObjectInput{
+	_, e := blobstore.uploader.Upload(&s3manager.UploadInput{
 		Bucket:               &blobstore.bucket,
 		Key:                  &path,
 		Body:                 src,
@@ -0,0 +1,91 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/pkg/errors"
+)
+
+type multistatus struct {
+	Responses []propfindResponse `xml:"response"`
+}
+
+type propfindResponse struct {
+	Href      string `xml:"href"`
+	Propstats []struct {
+		Prop struct {
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ETag          string `xml:"getetag"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+// walk calls fn for every blob below dir. It uses PROPFIND with depth 1 and descends into collections itself,
+// because most WebDAV servers do not allow depth infinity.
+func (blobstore *Blobstore) walk(dir string, fn func(blob bitsgo.BlobInfo)) error {
+	endpoint, e := url.Parse(blobstore.WebdavPrivateEndpoint)
+	if e != nil {
+		return errors.Wrapf(e, "Invalid private endpoint %v", blobstore.WebdavPrivateEndpoint)
+	}
+	basePath := strings.TrimSuffix(endpoint.Path, "/") + "/admin/"
+
+	request := blobstore.newRequestWithBasicAuth("PROPFIND", blobstore.WebdavPrivateEndpoint+"/admin/"+dir, nil)
+	request.Header.Set("Depth", "1")
+	response, e := blobstore.HttpClient.Do(request)
+	if e != nil {
+		return errors.Wrapf(e, "PROPFIND failed. dir=%v", dir)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if response.StatusCode != http.StatusMultiStatus {
+		return errors.Errorf("Expected HTTP status code 207, but got status code: %v", response.Status)
+	}
+	var result multistatus
+	e = xml.NewDecoder(response.Body).Decode(&result)
+	if e != nil {
+		return errors.Wrapf(e, "Could not decode PROPFIND response. dir=%v", dir)
+	}
+
+	for _, r := range result.Responses {
+		href, e := url.Parse(r.Href)
+		if e != nil {
+			return errors.Wrapf(e, "Invalid href in PROPFIND response: %v", r.Href)
+		}
+		path := strings.TrimPrefix(href.Path, basePath)
+		if path == dir || path+"/" == dir {
+			continue
+		}
+		for _, propstat := range r.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			if propstat.Prop.ResourceType.Collection != nil {
+				e = blobstore.walk(AppendsSuffixIfNeeded(path), fn)
+				if e != nil {
+					return e
+				}
+				break
+			}
+			lastModified, _ := http.ParseTime(propstat.Prop.LastModified)
+			fn(bitsgo.BlobInfo{
+				Path:         path,
+				Size:         propstat.Prop.ContentLength,
+				LastModified: lastModified,
+				ETag:         strings.Trim(propstat.Prop.ETag, `"`),
+			})
+			break
+		}
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	pathpkg "path"
+	"sort"
 	"strings"
 
 	"bytes"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/pagination"
 	"github.com/cloudfoundry-incubator/bits-service/config"
 	"github.com/cloudfoundry-incubator/bits-service/httputil"
 	"github.com/cloudfoundry-incubator/bits-service/logger"
@@ -51,6 +54,51 @@ func (blobstore *Blobstore) Exists(path string) (bool, error) {
 	return false, nil
 }
 
+func (blobstore *Blobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	url := blobstore.WebdavPrivateEndpoint + "/" + path
+	response, e := blobstore.HttpClient.Do(blobstore.newRequestWithBasicAuth("HEAD", url, nil))
+	if e != nil {
+		return nil, errors.Wrapf(e, "Error in Stat, path=%v", path)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, bitsgo.NewNotFoundErrorWithKey(path)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected status code %v. Expected status OK", response.Status)
+	}
+	// A missing or malformed Last-Modified header results in a zero time, which callers treat as unknown.
+	lastModified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+	return &bitsgo.BlobInfo{
+		Path:         path,
+		Size:         response.ContentLength,
+		LastModified: lastModified,
+		ETag:         strings.Trim(response.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+func (blobstore *Blobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = pathpkg.Dir(prefix) + "/"
+		if dir == "./" {
+			dir = ""
+		}
+	}
+	blobs := []bitsgo.BlobInfo{}
+	e := blobstore.walk(dir, func(blob bitsgo.BlobInfo) {
+		if strings.HasPrefix(blob.Path, prefix) {
+			blobs = append(blobs, blob)
+		}
+	})
+	if e != nil {
+		return nil, "", errors.Wrapf(e, "Failed to list prefix=%v", prefix)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Path < blobs[j].Path })
+	blobs, nextPageToken := pagination.Page(blobs, pageToken, pagination.DefaultPageSize)
+	return blobs, nextPageToken, nil
+}
+
 func (blobstore *Blobstore) Get(path string) (body io.ReadCloser, err error) {
 	exists, e := blobstore.Exists(path)
 	if e != nil {
@@ -48,3 +48,65 @@ var _ = Describe("webdav DeleteDirectory", func() {
 		})
 	})
 })
+
+var _ = Describe("webdav List", func() {
+	var (
+		webdavBlobstore *Blobstore
+		testServer      *httptest.Server
+	)
+
+	BeforeEach(func() {
+		testServer = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			Expect(req.Method).To(Equal("PROPFIND"))
+			Expect(req.Header.Get("Depth")).To(Equal("1"))
+			switch req.URL.Path {
+			case "/admin/ab/":
+				res.WriteHeader(http.StatusMultiStatus)
+				res.Write([]byte(`<?xml version="1.0" encoding="utf-8" ?>
+<D:multistatus xmlns:D="DAV:">
+<D:response><D:href>/admin/ab/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/admin/ab/cd/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/admin/ab/other</D:href><D:propstat><D:prop><D:getcontentlength>3</D:getcontentlength><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+</D:multistatus>`))
+			case "/admin/ab/cd/":
+				res.WriteHeader(http.StatusMultiStatus)
+				res.Write([]byte(`<?xml version="1.0" encoding="utf-8" ?>
+<D:multistatus xmlns:D="DAV:">
+<D:response><D:href>/admin/ab/cd/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/admin/ab/cd/abcdef</D:href><D:propstat><D:prop><D:getcontentlength>5</D:getcontentlength><D:getlastmodified>Thu, 01 Mar 2018 12:00:00 GMT</D:getlastmodified><D:getetag>"the-etag"</D:getetag><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+</D:multistatus>`))
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		webdavBlobstore = &Blobstore{
+			WebdavPrivateEndpoint: testServer.URL,
+			WebdavPublicEndpoint:  testServer.URL,
+			HttpClient:            &http.Client{},
+			WebdavUsername:        "foo",
+			WebdavPassword:        "bar",
+		}
+	})
+
+	AfterEach(func() { testServer.Close() })
+
+	It("descends into collections and only returns blobs with the prefix", func() {
+		blobs, nextPageToken, e := webdavBlobstore.List("ab/c", "")
+
+		Expect(e).NotTo(HaveOccurred())
+		Expect(nextPageToken).To(BeEmpty())
+		Expect(blobs).To(HaveLen(1))
+		Expect(blobs[0].Path).To(Equal("ab/cd/abcdef"))
+		Expect(blobs[0].Size).To(BeEquivalentTo(5))
+		Expect(blobs[0].ETag).To(Equal("the-etag"))
+		Expect(blobs[0].LastModified.Year()).To(Equal(2018))
+	})
+
+	It("returns no blobs when the directory does not exist", func() {
+		blobs, _, e := webdavBlobstore.List("does-not-exist/", "")
+
+		Expect(e).NotTo(HaveOccurred())
+		Expect(blobs).To(BeEmpty())
+	})
+})
@@ -2,6 +2,7 @@ package ccupdater
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -15,12 +16,17 @@ import (
 	"github.com/cloudfoundry-incubator/bits-service"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CCUpdater struct {
 	httpClient HttpClient
 	endpoint   string
 	method     string
+	ctx        context.Context
 }
 
 type processingUploadPayload struct {
@@ -66,9 +72,18 @@ func NewCCUpdaterWithHttpClient(endpoint string, method string, httpClient HttpC
 		httpClient: httpClient,
 		endpoint:   endpoint,
 		method:     method,
+		ctx:        context.Background(),
 	}
 }
 
+// WithContext returns an updater whose notifications are recorded in the trace of ctx
+// and which propagates that trace to CC.
+func (updater *CCUpdater) WithContext(ctx context.Context) bitsgo.Updater {
+	updaterWithContext := *updater
+	updaterWithContext.ctx = ctx
+	return &updaterWithContext
+}
+
 func loadTLSConfig(clientCertFile string, clientKeyFile string, caCertFile string) *tls.Config {
 	cert, e := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
 	if e != nil {
@@ -91,11 +106,11 @@ func loadTLSConfig(clientCertFile string, clientKeyFile string, caCertFile strin
 }
 
 func (updater *CCUpdater) NotifyProcessingUpload(guid string) error {
-	return updater.update(guid, processingUploadPayload{"PROCESSING_UPLOAD"})
+	return updater.update("notify_processing_upload", guid, processingUploadPayload{"PROCESSING_UPLOAD"})
 }
 
 func (updater *CCUpdater) NotifyUploadSucceeded(guid string, sha1 string, sha256 string) error {
-	return updater.update(guid, successPayload{
+	return updater.update("notify_upload_succeeded", guid, successPayload{
 		"READY",
 		[]checksum{
 			checksum{Type: "sha1", Value: sha1},
@@ -105,10 +120,15 @@ func (updater *CCUpdater) NotifyUploadSucceeded(guid string, sha1 string, sha256
 }
 
 func (updater *CCUpdater) NotifyUploadFailed(guid string, e error) error {
-	return updater.update(guid, failurePayload{"FAILED", e.Error()})
+	return updater.update("notify_upload_failed", guid, failurePayload{"FAILED", e.Error()})
 }
 
-func (updater *CCUpdater) update(guid string, p interface{}) error {
+func (updater *CCUpdater) update(notification string, guid string, p interface{}) (err error) {
+	ctx, span := bitsgo.Tracer().Start(updater.ctx, "cc_updater."+notification,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("bits.guid", guid)))
+	defer func() { bitsgo.EndSpan(span, err) }()
+
 	payload, e := json.Marshal(p)
 	if e != nil {
 		logger.Log.Fatalw("Unexpected error in CC Updater update when marshalling payload",
@@ -120,10 +140,13 @@ func (updater *CCUpdater) update(guid string, p interface{}) error {
 		logger.Log.Fatalw("Unexpected error in CC Updater update when creating new request",
 			"error", e, "guid", guid, "payload", p)
 	}
+	r = r.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
 	resp, e := updater.httpClient.Do(r)
 	if e != nil {
 		return errors.Wrapf(e, "Could not make request against CC (GUID: \"%v\")", guid)
 	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 	if resp.StatusCode == http.StatusNotFound {
 		return bitsgo.NewNotFoundError()
 	}
@@ -1,6 +1,7 @@
 package ccupdater_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +11,10 @@ import (
 	. "github.com/cloudfoundry-incubator/bits-service/ccupdater"
 	. "github.com/cloudfoundry-incubator/bits-service/ccupdater/matchers"
 	. "github.com/petergtz/pegomock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var _ = Describe("CCUpdater", func() {
@@ -106,4 +111,29 @@ var _ = Describe("CCUpdater", func() {
 			  }`))
 		})
 	})
+
+	Describe("WithContext", func() {
+		It("records the notification as child of the context's span and propagates the trace to CC", func() {
+			spanRecorder := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+			defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+			ctx, parentSpan := otel.Tracer("test").Start(context.Background(), "parent")
+			defer parentSpan.End()
+			updater = updater.WithContext(ctx).(*CCUpdater)
+			When(httpClient.Do(AnyPtrToHttpRequest())).ThenReturn(&http.Response{}, nil)
+
+			e := updater.NotifyProcessingUpload("abc")
+
+			Expect(e).NotTo(HaveOccurred())
+			Expect(spanRecorder.Ended()).To(HaveLen(1))
+			span := spanRecorder.Ended()[0]
+			Expect(span.Name()).To(Equal("cc_updater.notify_processing_upload"))
+			Expect(span.Parent().TraceID()).To(Equal(parentSpan.SpanContext().TraceID()))
+
+			request := httpClient.VerifyWasCalledOnce().Do(AnyPtrToHttpRequest()).GetCapturedArguments()
+			Expect(request.Header.Get("traceparent")).To(Equal(
+				"00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"))
+		})
+	})
 })
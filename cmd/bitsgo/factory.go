@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/benbjohnson/clock"
 	bitsgo "github.com/cloudfoundry-incubator/bits-service"
@@ -12,17 +17,49 @@ import (
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/gcp"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/local"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/openstack"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/replicated"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/s3"
 	"github.com/cloudfoundry-incubator/bits-service/blobstores/webdav"
 	"github.com/cloudfoundry-incubator/bits-service/ccupdater"
 	"github.com/cloudfoundry-incubator/bits-service/config"
 	log "github.com/cloudfoundry-incubator/bits-service/logger"
 	"github.com/cloudfoundry-incubator/bits-service/pathsigner"
+	"github.com/cloudfoundry-incubator/bits-service/prometheus"
+	"github.com/cloudfoundry-incubator/bits-service/quota"
+	"github.com/cloudfoundry-incubator/bits-service/statsd"
+	"github.com/cloudfoundry-incubator/bits-service/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 )
 
-func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, publicEndpoint *url.URL, port int, secret string, signingKeys map[string]string, activeKeyID string, resourceType string, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService) (bitsgo.Blobstore, *bitsgo.SignResourceHandler) {
+// keyring is nil, if encryption is not enabled.
+func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, publicEndpoint *url.URL, port int, secret string, signingKeys map[string]string, activeKeyID string, resourceType string, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService, keyring *decorator.Keyring) (bitsgo.Blobstore, *bitsgo.SignResourceHandler) {
 	localResourceSigner := createLocalResourceSigner(publicEndpoint, port, secret, signingKeys, activeKeyID, resourceType)
+	blobstore, getResourceSigner := createBlobstoreAndGetResourceSigner(blobstoreConfig, localResourceSigner, resourceType, logger, metricsService)
+	if blobstoreConfig.Cache.Enabled() {
+		blobstore = withCache(blobstore, blobstoreConfig.Cache, resourceType, metricsService)
+	}
+	if keyring != nil {
+		blobstore = decorator.ForBlobstoreWithEncryption(blobstore, keyring, resourceType)
+		// Signed URLs pointing directly into the blobstore would deliver ciphertext
+		getResourceSigner = localResourceSigner
+	}
+	if blobstoreConfig.Compression.Enabled {
+		blobstore, getResourceSigner = withCompression(blobstore, getResourceSigner, localResourceSigner, blobstoreConfig.Compression, resourceType, metricsService)
+	}
+	if blobstoreConfig.ContentAddressable {
+		log.Log.Infow("Enabling content addressable storage", "resource-type", resourceType)
+		contentAddressableBlobstore := decorator.ForBlobstoreWithContentAddressing(blobstore)
+		blobstore = contentAddressableBlobstore
+		if getResourceSigner != localResourceSigner {
+			getResourceSigner = decorator.ForResourceSignerWithContentAddressing(getResourceSigner, contentAddressableBlobstore)
+		}
+	}
+	return blobstore, bitsgo.NewSignResourceHandler(getResourceSigner, localResourceSigner)
+}
+
+// createBlobstoreAndGetResourceSigner returns localResourceSigner as signer, when the blobstore cannot be accessed directly.
+func createBlobstoreAndGetResourceSigner(blobstoreConfig config.BlobstoreConfig, localResourceSigner bitsgo.ResourceSigner, resourceType string, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService) (bitsgo.Blobstore, bitsgo.ResourceSigner) {
 	switch blobstoreConfig.BlobstoreType {
 	case config.Local:
 		log.Log.Infow("Creating local blobstore", "path-prefix", blobstoreConfig.LocalConfig.PathPrefix)
@@ -31,7 +68,7 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					local.NewBlobstore(*blobstoreConfig.LocalConfig),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(localResourceSigner, localResourceSigner)
+			localResourceSigner
 	case config.AWS:
 		log.Log.Infow("Creating S3 blobstore", "bucket", blobstoreConfig.S3Config.Bucket)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -39,10 +76,8 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger)),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger))
 	case config.Google:
 		log.Log.Infow("Creating GCP blobstore", "bucket", blobstoreConfig.GCPConfig.Bucket)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -50,10 +85,8 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					gcp.NewBlobstore(*blobstoreConfig.GCPConfig),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					gcp.NewBlobstore(*blobstoreConfig.GCPConfig)),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				gcp.NewBlobstore(*blobstoreConfig.GCPConfig))
 	case config.Azure:
 		log.Log.Infow("Creating Azure blobstore", "container", blobstoreConfig.AzureConfig.ContainerName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -61,10 +94,8 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService)),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService))
 	case config.OpenStack:
 		log.Log.Infow("Creating Openstack blobstore", "container", blobstoreConfig.OpenstackConfig.ContainerName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -72,10 +103,8 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig)),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig))
 	case config.WebDAV:
 		log.Log.Infow("Creating Webdav blobstore",
 			"public-endpoint", blobstoreConfig.WebdavConfig.PublicEndpoint,
@@ -87,12 +116,10 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 						metricsService,
 						resourceType),
 					blobstoreConfig.WebdavConfig.DirectoryKey+"/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						webdav.NewBlobstore(*blobstoreConfig.WebdavConfig),
-						blobstoreConfig.WebdavConfig.DirectoryKey+"/")),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					webdav.NewBlobstore(*blobstoreConfig.WebdavConfig),
+					blobstoreConfig.WebdavConfig.DirectoryKey+"/"))
 	case config.Alibaba:
 		log.Log.Infow("Creating Alibaba blobstore", "bucket", blobstoreConfig.AlibabaConfig.BucketName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -100,18 +127,41 @@ func createBlobstoreAndSignURLHandler(blobstoreConfig config.BlobstoreConfig, pu
 					alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig),
 					metricsService,
 					resourceType)),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig)),
-				localResourceSigner)
+			decorator.ForResourceSignerWithPathPartitioning(
+				alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig))
+	case config.Replicated:
+		// Signed URLs point to the bits-service, so that downloads fall back to secondaries as well.
+		return createReplicatedBlobstore(blobstoreConfig.ReplicatedConfig, resourceType, metricsService, func(backendConfig config.BlobstoreConfig) bitsgo.Blobstore {
+				blobstore, _ := createBlobstoreAndGetResourceSigner(backendConfig, localResourceSigner, resourceType, logger, metricsService)
+				return blobstore
+			}),
+			localResourceSigner
 	default:
 		log.Log.Fatalw("blobstoreConfig is invalid.", "blobstore-type", blobstoreConfig.BlobstoreType)
 		return nil, nil // satisfy compiler
 	}
 }
 
-func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig, publicEndpoint *url.URL, port int, secret string, signingKeys map[string]string, activeKeyID string, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService) (bitsgo.Blobstore, *bitsgo.SignResourceHandler) {
+// The buildpack cache lives in the droplet blobstore, so compression and cache come from buildpackCacheConfig.
+func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig, buildpackCacheConfig config.BlobstoreConfig, publicEndpoint *url.URL, port int, secret string, signingKeys map[string]string, activeKeyID string, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService, keyring *decorator.Keyring) (bitsgo.Blobstore, *bitsgo.SignResourceHandler) {
 	localResourceSigner := createLocalResourceSigner(publicEndpoint, port, secret, signingKeys, activeKeyID, "buildpack_cache/entries")
+	blobstore, getResourceSigner := createPrefixedBlobstoreAndGetResourceSigner(blobstoreConfig, "buildpack_cache", localResourceSigner, logger, metricsService)
+	if buildpackCacheConfig.Cache.Enabled() {
+		blobstore = withCache(blobstore, buildpackCacheConfig.Cache, "buildpack_cache", metricsService)
+	}
+	if keyring != nil {
+		blobstore = decorator.ForBlobstoreWithEncryption(blobstore, keyring, "buildpack_cache")
+		getResourceSigner = localResourceSigner
+	}
+	if buildpackCacheConfig.Compression.Enabled {
+		blobstore, getResourceSigner = withCompression(blobstore, getResourceSigner, localResourceSigner, buildpackCacheConfig.Compression, "buildpack_cache", metricsService)
+	}
+	return blobstore, bitsgo.NewSignResourceHandler(getResourceSigner, localResourceSigner)
+}
+
+// createPrefixedBlobstoreAndGetResourceSigner keeps blobs under prefix/ in the bucket of blobstoreConfig.
+// The partitioned paths of other blobstores in the same bucket never start with it.
+func createPrefixedBlobstoreAndGetResourceSigner(blobstoreConfig config.BlobstoreConfig, prefix string, localResourceSigner bitsgo.ResourceSigner, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService) (bitsgo.Blobstore, bitsgo.ResourceSigner) {
 	switch blobstoreConfig.BlobstoreType {
 	case config.Local:
 		log.Log.Infow("Creating local blobstore", "path-prefix", blobstoreConfig.LocalConfig.PathPrefix)
@@ -120,9 +170,9 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						local.NewBlobstore(*blobstoreConfig.LocalConfig),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(localResourceSigner, localResourceSigner)
+						prefix),
+					prefix+"/")),
+			localResourceSigner
 	case config.AWS:
 		log.Log.Infow("Creating S3 blobstore", "bucket", blobstoreConfig.S3Config.Bucket)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -130,14 +180,12 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger),
-						"buildpack_cache")),
-				localResourceSigner)
+						prefix),
+					prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					s3.NewBlobstoreWithLogger(*blobstoreConfig.S3Config, logger),
+					prefix))
 	case config.Google:
 		log.Log.Infow("Creating GCP blobstore", "bucket", blobstoreConfig.GCPConfig.Bucket)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -145,14 +193,12 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						gcp.NewBlobstore(*blobstoreConfig.GCPConfig),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						gcp.NewBlobstore(*blobstoreConfig.GCPConfig),
-						"buildpack_cache")),
-				localResourceSigner)
+						prefix),
+					prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					gcp.NewBlobstore(*blobstoreConfig.GCPConfig),
+					prefix))
 	case config.Azure:
 		log.Log.Infow("Creating Azure blobstore", "container", blobstoreConfig.AzureConfig.ContainerName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -160,14 +206,12 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService),
-						"buildpack_cache")),
-				localResourceSigner)
+						prefix),
+					prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					azure.NewBlobstore(*blobstoreConfig.AzureConfig, metricsService),
+					prefix))
 	case config.OpenStack:
 		log.Log.Infow("Creating Openstack blobstore", "container", blobstoreConfig.OpenstackConfig.ContainerName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -175,14 +219,12 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig),
-						"buildpack_cache")),
-				localResourceSigner)
+						prefix),
+					prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					openstack.NewBlobstore(*blobstoreConfig.OpenstackConfig),
+					prefix))
 	case config.WebDAV:
 		log.Log.Infow("Creating Webdav blobstore",
 			"public-endpoint", blobstoreConfig.WebdavConfig.PublicEndpoint,
@@ -192,14 +234,12 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						webdav.NewBlobstore(*blobstoreConfig.WebdavConfig),
 						metricsService,
-						"buildpack_cache"),
-					blobstoreConfig.WebdavConfig.DirectoryKey+"/buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						webdav.NewBlobstore(*blobstoreConfig.WebdavConfig),
-						blobstoreConfig.WebdavConfig.DirectoryKey+"/buildpack_cache/")),
-				localResourceSigner)
+						prefix),
+					blobstoreConfig.WebdavConfig.DirectoryKey+"/"+prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					webdav.NewBlobstore(*blobstoreConfig.WebdavConfig),
+					blobstoreConfig.WebdavConfig.DirectoryKey+"/"+prefix+"/"))
 	case config.Alibaba:
 		log.Log.Infow("Creating Alibaba blobstore", "bucket", blobstoreConfig.AlibabaConfig.BucketName)
 		return decorator.ForBlobstoreWithPathPartitioning(
@@ -207,20 +247,32 @@ func createBuildpackCacheSignURLHandler(blobstoreConfig config.BlobstoreConfig,
 					decorator.ForBlobstoreWithMetricsEmitter(
 						alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig),
 						metricsService,
-						"buildpack_cache"),
-					"buildpack_cache/")),
-			bitsgo.NewSignResourceHandler(
-				decorator.ForResourceSignerWithPathPartitioning(
-					decorator.ForResourceSignerWithPathPrefixing(
-						alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig),
-						"buildpack_cache")),
-				localResourceSigner)
+						prefix),
+					prefix+"/")),
+			decorator.ForResourceSignerWithPathPartitioning(
+				decorator.ForResourceSignerWithPathPrefixing(
+					alibaba.NewBlobstore(*blobstoreConfig.AlibabaConfig),
+					prefix))
+	case config.Replicated:
+		return createReplicatedBlobstore(blobstoreConfig.ReplicatedConfig, prefix, metricsService, func(backendConfig config.BlobstoreConfig) bitsgo.Blobstore {
+				blobstore, _ := createPrefixedBlobstoreAndGetResourceSigner(backendConfig, prefix, localResourceSigner, logger, metricsService)
+				return blobstore
+			}),
+			localResourceSigner
 	default:
 		log.Log.Fatalw("blobstoreConfig is invalid.", "blobstore-type", blobstoreConfig.BlobstoreType)
 		return nil, nil // satisfy compiler
 	}
 }
 
+// createInternalBlobstore returns the blobstore for the state all instances share, i.e. the quota ledger, upload sessions
+// and the app stash access log. It lives in the droplet bucket, but outside of the paths droplet routes can reach.
+// It has none of the droplet decorators, since its blobs are small and many, and never served as they are.
+func createInternalBlobstore(dropletConfig config.BlobstoreConfig, logger *zap.SugaredLogger, metricsService bitsgo.MetricsService) bitsgo.Blobstore {
+	blobstore, _ := createPrefixedBlobstoreAndGetResourceSigner(dropletConfig, "bits_internal", nil, logger, metricsService)
+	return blobstore
+}
+
 func createLocalResourceSigner(publicEndpoint *url.URL, port int, secret string, signingKeys map[string]string, activeKeyID string, resourceType string) bitsgo.ResourceSigner {
 	return &local.LocalResourceSigner{
 		DelegateEndpoint: fmt.Sprintf("%v://%v:%v", publicEndpoint.Scheme, publicEndpoint.Host, port),
@@ -321,12 +373,42 @@ func createAppStashBlobstore(blobstoreConfig config.BlobstoreConfig, publicEndpo
 						"app_stash"),
 					"app_bits_cache/")),
 			signAppStashMatchesHandler
+	case config.Replicated:
+		return createReplicatedBlobstore(blobstoreConfig.ReplicatedConfig, "app_stash", metricsService, func(backendConfig config.BlobstoreConfig) bitsgo.Blobstore {
+				blobstore, _ := createAppStashBlobstore(backendConfig, publicEndpoint, port, secret, signingKeys, activeKeyID, logger, metricsService)
+				return blobstore
+			}),
+			signAppStashMatchesHandler
 	default:
 		log.Log.Fatalw("blobstoreConfig is invalid.", "blobstore-type", blobstoreConfig.BlobstoreType)
 		return nil, nil // satisfy compiler
 	}
 }
 
+func createReplicatedBlobstore(replicatedConfig *config.ReplicatedBlobstoreConfig, resourceType string, metricsService bitsgo.MetricsService, createBackend func(config.BlobstoreConfig) bitsgo.Blobstore) bitsgo.Blobstore {
+	log.Log.Infow("Creating replicated blobstore",
+		"resource-type", resourceType,
+		"secondaries", len(replicatedConfig.Secondaries),
+		"write-mode", replicatedConfig.WriteModeOrDefault(),
+		"repair-interval", replicatedConfig.RepairInterval(),
+		"run-repair", replicatedConfig.RunRepair,
+		"max-orphan-removals", replicatedConfig.MaxOrphanRemovalsOrDefault())
+	primary := createBackend(replicatedConfig.Primary)
+	var secondaries []bitsgo.Blobstore
+	for _, secondaryConfig := range replicatedConfig.Secondaries {
+		secondaries = append(secondaries, createBackend(secondaryConfig))
+	}
+	queueSize := 0
+	if replicatedConfig.WriteModeOrDefault() == config.AsyncReplication {
+		queueSize = replicatedConfig.QueueSizeOrDefault()
+	}
+	blobstore := replicated.NewBlobstoreWithOrphanRemoval(primary, secondaries, queueSize, replicatedConfig.MaxOrphanRemovalsOrDefault(), metricsService, resourceType)
+	if interval := replicatedConfig.RepairInterval(); interval > 0 && replicatedConfig.RunRepair {
+		go blobstore.RepairPeriodically(interval)
+	}
+	return blobstore
+}
+
 func createRootFSBlobstore(blobstoreConfig config.BlobstoreConfig) bitsgo.Blobstore {
 	if blobstoreConfig.BlobstoreType != config.Local {
 		log.Log.Fatalw("RootFS blobstore currently only allows local blobstores", "blobstore-type", blobstoreConfig.BlobstoreType)
@@ -346,3 +428,173 @@ func createUpdater(ccUpdaterConfig *config.CCUpdaterConfig) bitsgo.Updater {
 		ccUpdaterConfig.ClientKeyFile,
 		ccUpdaterConfig.CACertFile)
 }
+
+func createUploadJobStore(asyncUploadsConfig config.AsyncUploadsConfig) *bitsgo.UploadJobStore {
+	if asyncUploadsConfig.JobsDirectory == "" {
+		return nil
+	}
+	uploadJobs, e := bitsgo.NewUploadJobStore(asyncUploadsConfig.JobsDirectory, asyncUploadsConfig.StatusRetention())
+	if e != nil {
+		log.Log.Fatalw("Could not create upload job store", "error", e)
+	}
+	return uploadJobs
+}
+
+// Expired upload sessions are removed at most this long after they expired.
+const uploadSessionSweepInterval = time.Hour
+
+// createUploadSessionHandler returns nil, if resumable uploads are not configured.
+// Sessions are kept in the internal blobstore, which all instances share.
+func createUploadSessionHandler(resumableUploadsConfig config.ResumableUploadsConfig, resourceHandler *bitsgo.ResourceHandler, resourceType string, internalBlobstore bitsgo.Blobstore) *bitsgo.UploadSessionHandler {
+	if !resumableUploadsConfig.Enabled() {
+		return nil
+	}
+	sessions, e := bitsgo.NewUploadSessionStore(
+		decorator.ForBlobstoreWithPathPrefixing(internalBlobstore, "upload-sessions/"+resourceType+"/"),
+		filepath.Join(resumableUploadsConfig.StagingDirectory, resourceType),
+		resumableUploadsConfig.SessionTTL())
+	if e != nil {
+		log.Log.Fatalw("Could not create upload session store", "resource-type", resourceType, "error", e)
+	}
+	go sessions.RemoveExpiredPeriodically(uploadSessionSweepInterval)
+	return bitsgo.NewUploadSessionHandler(resourceHandler, sessions)
+}
+
+// createRegistryUploadStore keeps blob uploads to the registry in the internal blobstore, like resumable uploads.
+// It buffers them next to resumable uploads, if those are configured.
+func createRegistryUploadStore(resumableUploadsConfig config.ResumableUploadsConfig, internalBlobstore bitsgo.Blobstore) *bitsgo.UploadSessionStore {
+	dir := filepath.Join(os.TempDir(), "bits-registry-uploads")
+	if resumableUploadsConfig.Enabled() {
+		dir = filepath.Join(resumableUploadsConfig.StagingDirectory, "registry")
+	}
+	uploads, e := bitsgo.NewUploadSessionStore(
+		decorator.ForBlobstoreWithPathPrefixing(internalBlobstore, "upload-sessions/registry/"),
+		dir,
+		resumableUploadsConfig.SessionTTL())
+	if e != nil {
+		log.Log.Fatalw("Could not create registry upload store", "error", e)
+	}
+	go uploads.RemoveExpiredPeriodically(uploadSessionSweepInterval)
+	return uploads
+}
+
+// createQuotaLedger keeps the ledger in the internal blobstore, which all instances share.
+func createQuotaLedger(quotasConfig config.QuotasConfig, internalBlobstore bitsgo.Blobstore) *quota.Ledger {
+	if !quotasConfig.Enabled {
+		return nil
+	}
+	tenantLimits := make(map[string]int64)
+	for tenant, maxSize := range quotasConfig.TenantMaxSizesMap() {
+		tenantLimits[tenant] = int64(maxSize)
+	}
+	log.Log.Infow("Enforcing quotas", "default-max-size", quotasConfig.DefaultMaxSizeBytes())
+	return quota.NewLedger(
+		decorator.ForBlobstoreWithPathPrefixing(internalBlobstore, "quota-ledger/"),
+		int64(quotasConfig.DefaultMaxSizeBytes()),
+		tenantLimits)
+}
+
+// withQuotas returns blobstore as it is, when quotaLedger is nil.
+func withQuotas(blobstore bitsgo.Blobstore, quotaLedger *quota.Ledger, resourceType string) bitsgo.Blobstore {
+	if quotaLedger == nil {
+		return blobstore
+	}
+	return decorator.ForBlobstoreWithQuotas(blobstore, quotaLedger, resourceType)
+}
+
+// createMetricsService returns a nil http.Handler if Prometheus is not configured.
+func createMetricsService(metricsConfig config.MetricsConfig) (bitsgo.MetricsService, http.Handler) {
+	var (
+		metricsServices bitsgo.MetricsServices
+		metricsHandler  http.Handler
+	)
+	for _, backend := range metricsConfig.BackendsOrDefault() {
+		switch backend {
+		case config.Statsd:
+			log.Log.Infow("Sending metrics to statsd")
+			metricsServices = append(metricsServices, statsd.NewMetricsService())
+		case config.Prometheus:
+			log.Log.Infow("Exposing metrics for Prometheus on /metrics")
+			prometheusMetricsService := prometheus.NewMetricsService()
+			metricsServices = append(metricsServices, prometheusMetricsService)
+			metricsHandler = prometheusMetricsService.Handler()
+		}
+	}
+	if len(metricsServices) == 1 {
+		return metricsServices[0], metricsHandler
+	}
+	return metricsServices, metricsHandler
+}
+
+// setUpTracing returns a function which flushes outstanding spans. Without exporter, spans are not recorded at all.
+func setUpTracing(tracingConfig config.TracingConfig) (shutdown func(context.Context) error) {
+	var (
+		exporter sdktrace.SpanExporter
+		e        error
+	)
+	switch tracingConfig.ExporterOrDefault() {
+	case config.NoTracing:
+		return func(context.Context) error { return nil }
+	case config.OTLPExporter:
+		log.Log.Infow("Exporting traces via OTLP", "endpoint", tracingConfig.OTLPEndpointOrDefault())
+		exporter, e = tracing.NewOTLPExporter(tracingConfig.OTLPEndpointOrDefault(), tracingConfig.OTLPInsecure)
+	case config.StdoutExporter:
+		output := os.Stdout
+		if tracingConfig.StdoutFile != "" {
+			output, e = os.OpenFile(tracingConfig.StdoutFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if e != nil {
+				log.Log.Fatalw("Could not open trace file", "error", e, "file", tracingConfig.StdoutFile)
+			}
+		}
+		log.Log.Infow("Writing traces to file", "file", output.Name())
+		exporter, e = tracing.NewWriterExporter(output)
+	}
+	if e != nil {
+		log.Log.Fatalw("Could not create trace exporter", "error", e)
+	}
+	return tracing.Register(exporter, tracingConfig.ServiceNameOrDefault(), tracingConfig.SamplingRatioOrDefault())
+}
+
+// withCache must be wrapped by encryption and compression, so that the cache only holds what the blobstore holds.
+func withCache(blobstore bitsgo.Blobstore, cacheConfig config.CacheConfig, resourceType string, metricsService bitsgo.MetricsService) bitsgo.Blobstore {
+	log.Log.Infow("Enabling cache",
+		"resource-type", resourceType,
+		"directory", cacheConfig.Directory,
+		"max-size", cacheConfig.MaxSizeBytes())
+	cachingBlobstore, e := decorator.ForBlobstoreWithCaching(blobstore, cacheConfig.Directory, int64(cacheConfig.MaxSizeBytes()), metricsService, resourceType)
+	if e != nil {
+		log.Log.Fatalw("Could not create cache", "resource-type", resourceType, "error", e)
+	}
+	return cachingBlobstore
+}
+
+// withCompression must wrap encryption, since ciphertext cannot be compressed.
+func withCompression(blobstore bitsgo.Blobstore, getResourceSigner bitsgo.ResourceSigner, localResourceSigner bitsgo.ResourceSigner, compressionConfig config.CompressionConfig, resourceType string, metricsService bitsgo.MetricsService) (bitsgo.Blobstore, bitsgo.ResourceSigner) {
+	log.Log.Infow("Enabling compression",
+		"resource-type", resourceType,
+		"algorithm", compressionConfig.AlgorithmOrDefault(),
+		"min-size", compressionConfig.MinSizeBytes())
+	compressingBlobstore := decorator.ForBlobstoreWithCompression(
+		blobstore,
+		decorator.CompressionAlgorithm(compressionConfig.AlgorithmOrDefault()),
+		int64(compressionConfig.MinSizeBytes()),
+		metricsService,
+		resourceType)
+	if getResourceSigner != localResourceSigner {
+		getResourceSigner = decorator.ForResourceSignerWithCompression(getResourceSigner, localResourceSigner, compressingBlobstore)
+	}
+	return compressingBlobstore, getResourceSigner
+}
+
+// createKeyring returns nil, if encryption is not enabled.
+func createKeyring(encryptionConfig config.EncryptionConfig) *decorator.Keyring {
+	if !encryptionConfig.Enabled() {
+		return nil
+	}
+	keyring, e := decorator.NewKeyring(encryptionConfig.KeysMap(), encryptionConfig.ActiveKeyID)
+	if e != nil {
+		log.Log.Fatalw("Could not create encryption keyring", "error", e)
+	}
+	log.Log.Infow("Encrypting blobs", "active-key-id", encryptionConfig.ActiveKeyID)
+	return keyring
+}
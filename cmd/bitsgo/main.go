@@ -1,24 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cloudfoundry-incubator/bits-service/oci_registry"
 
 	"github.com/benbjohnson/clock"
 	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/blobstores/decorator"
 	"github.com/cloudfoundry-incubator/bits-service/config"
+	"github.com/cloudfoundry-incubator/bits-service/gc"
 	log "github.com/cloudfoundry-incubator/bits-service/logger"
 	"github.com/cloudfoundry-incubator/bits-service/middlewares"
 	"github.com/cloudfoundry-incubator/bits-service/pathsigner"
 	"github.com/cloudfoundry-incubator/bits-service/routes"
-	"github.com/cloudfoundry-incubator/bits-service/statsd"
+	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 	"go.uber.org/zap"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -44,24 +50,96 @@ func main() {
 		log.Log.Infow("Config file uses deprecated \"secret\" property. Please consider using \"signing_keys\" instead.")
 	}
 
-	metricsService := statsd.NewMetricsService()
+	metricsService, metricsHandler := createMetricsService(config.Metrics)
+	keyring := createKeyring(config.Encryption)
+	shutdownTracing := setUpTracing(config.Tracing)
 
 	appStashBlobstore, signAppStashURLHandler := createAppStashBlobstore(config.AppStash, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, log.Log, metricsService)
-	packageBlobstore, signPackageURLHandler := createBlobstoreAndSignURLHandler(config.Packages, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "packages", log.Log, metricsService)
-	dropletBlobstore, signDropletURLHandler := createBlobstoreAndSignURLHandler(config.Droplets, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "droplets", log.Log, metricsService)
-	buildpackBlobstore, signBuildpackURLHandler := createBlobstoreAndSignURLHandler(config.Buildpacks, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "buildpacks", log.Log, metricsService)
-	buildpackCacheBlobstore, signBuildpackCacheURLHandler := createBuildpackCacheSignURLHandler(config.Droplets, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, log.Log, metricsService)
+	if keyring != nil {
+		appStashBlobstore = decorator.ForBlobstoreWithEncryption(appStashBlobstore, keyring, "app_stash")
+	}
+	if config.AppStash.Compression.Enabled {
+		appStashBlobstore, _ = withCompression(appStashBlobstore, nil, nil, config.AppStash.Compression, "app_stash", metricsService)
+	}
+	packageBlobstore, signPackageURLHandler := createBlobstoreAndSignURLHandler(config.Packages, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "packages", log.Log, metricsService, keyring)
+	dropletBlobstore, signDropletURLHandler := createBlobstoreAndSignURLHandler(config.Droplets, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "droplets", log.Log, metricsService, keyring)
+	buildpackBlobstore, signBuildpackURLHandler := createBlobstoreAndSignURLHandler(config.Buildpacks, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, "buildpacks", log.Log, metricsService, keyring)
+	buildpackCacheBlobstore, signBuildpackCacheURLHandler := createBuildpackCacheSignURLHandler(config.Droplets, config.BuildpackCache, config.PublicEndpointUrl(), config.Port, config.Secret, config.SigningKeysMap(), config.ActiveKeyID, log.Log, metricsService, keyring)
+	internalBlobstore := createInternalBlobstore(config.Droplets, log.Log, metricsService)
+	quotaLedger := createQuotaLedger(config.Quotas, internalBlobstore)
+	// The access log is kept in the internal blobstore, so that all instances share it.
+	appStashAccessLog := gc.NewAccessLog(decorator.ForBlobstoreWithPathPrefixing(internalBlobstore, "app-stash-access-log/"), clock.New())
+	go appStashAccessLog.FlushPeriodically(time.Minute)
+	// Readiness probes do not use the app stash, so they must not be recorded.
+	probedAppStashBlobstore := appStashBlobstore
+	appStashBlobstore = decorator.ForBlobstoreWithAccessRecording(appStashBlobstore, appStashAccessLog)
 
 	go regularlyEmitGoRoutines(metricsService)
 
+	if gcConfig := config.AppStashConfig.GarbageCollection; gcConfig != nil {
+		log.Log.Infow("Starting app stash garbage collection",
+			"max-age", gcConfig.MaxAge(),
+			"max-total-size", gcConfig.MaxTotalSizeBytes(),
+			"interval", gcConfig.Interval(),
+			"dry-run", gcConfig.DryRun)
+		go gc.NewAppStashGarbageCollector(
+			appStashBlobstore,
+			appStashAccessLog,
+			metricsService,
+			gcConfig.MaxAge(),
+			gcConfig.MaxTotalSizeBytes(),
+			gcConfig.DryRun,
+			clock.New(),
+		).RunPeriodically(gcConfig.Interval())
+	}
+
+	if reaperConfig := config.BuildpackReaper; reaperConfig != nil {
+		log.Log.Infow("Starting buildpack reaper",
+			"uncommitted-ttl", reaperConfig.UncommittedTTL(),
+			"interval", reaperConfig.Interval())
+		go gc.NewBuildpackReaper(buildpackBlobstore, metricsService, reaperConfig.UncommittedTTL(), clock.New()).
+			RunPeriodically(reaperConfig.Interval())
+	}
+
+	// Only request handlers are traced, so that background jobs do not record a trace per blobstore call.
+	tracedAppStashBlobstore := decorator.ForBlobstoreWithTracing(appStashBlobstore, "app_stash")
+	packageHandler := bitsgo.NewResourceHandlerWithUploadJobs(
+		decorator.ForBlobstoreWithTracing(withQuotas(packageBlobstore, quotaLedger, "packages"), "packages"),
+		tracedAppStashBlobstore,
+		createUpdater(config.CCUpdater),
+		"package",
+		metricsService,
+		config.Packages.MaxBodySizeBytes(),
+		config.AppStashConfig.MinimumSizeBytes(),
+		config.AppStashConfig.MaximumSizeBytes(),
+		config.ShouldProxyGetRequests,
+		createUploadJobStore(config.AsyncUploads),
+		config.AsyncUploads.MaxConcurrentUploadsOrDefault(),
+	)
+	e = packageHandler.ResumeUploadJobs()
+	if e != nil {
+		log.Log.Fatalw("Could not resume upload jobs", "error", e)
+	}
+
+	probedBlobstores := map[string]bitsgo.Blobstore{
+		"packages":   packageBlobstore,
+		"droplets":   dropletBlobstore,
+		"buildpacks": buildpackBlobstore,
+		"app_stash":  probedAppStashBlobstore,
+	}
+
+	basicAuthMiddleware := middlewares.NewBasicAuthMiddleWare(basicAuthCredentialsFrom(config.SigningUsers)...)
+
 	var (
 		ociImageHandler      *oci_registry.ImageHandler
 		registryEndpointHost = ""
 	)
 	if config.EnableRegistry {
+		rootFSBlobstore := createRootFSBlobstore(config.RootFS)
+		probedBlobstores["rootfs"] = rootFSBlobstore
 		ociImageHandler = &oci_registry.ImageHandler{
 			ImageManager: oci_registry.NewBitsImageManager(
-				createRootFSBlobstore(config.RootFS),
+				rootFSBlobstore,
 				dropletBlobstore,
 				// TODO: We should use a differently decorated blobstore for digestLookupStore:
 				// We want one with a non-partitioned prefix, so real droplets and
@@ -70,20 +148,54 @@ func main() {
 				dropletBlobstore,
 			),
 		}
+		if config.RegistryPush.Enabled {
+			ociImageHandler.Uploads = createRegistryUploadStore(config.ResumableUploads, internalBlobstore)
+			ociImageHandler.MaxBlobSize = int64(config.RegistryPush.MaxBlobSizeBytes())
+		}
 		registryEndpointHost = config.RegistryEndpointUrl().Host
+		if config.RegistryAuth.Enabled {
+			ociImageHandler.Auth = &oci_registry.TokenAuth{
+				Realm:       strings.TrimSuffix(config.RegistryEndpointUrl().String(), "/") + "/v2/token",
+				Service:     registryEndpointHost,
+				Secret:      config.Secret,
+				SigningKeys: config.SigningKeysMap(),
+				ActiveKeyID: config.ActiveKeyID,
+				TokenTTL:    config.RegistryAuth.TokenTTL(),
+				Clock:       clock.New(),
+				BasicAuth:   basicAuthMiddleware,
+			}
+		}
 		log.Log.Infow("Starting with OCI image registry",
 			"registry-host", registryEndpointHost,
+			"auth-enabled", config.RegistryAuth.Enabled,
+			"push-enabled", config.RegistryPush.Enabled,
 			"http-enabled", config.HttpEnabled,
 			"http-port", config.HttpPort,
 			"https-port", config.Port,
 		)
 	}
 
+	buildpackHandler := bitsgo.NewResourceHandler(decorator.ForBlobstoreWithTracing(buildpackBlobstore, "buildpacks"), tracedAppStashBlobstore, "buildpack", metricsService, config.Buildpacks.MaxBodySizeBytes(), config.ShouldProxyGetRequests)
+	dropletHandler := bitsgo.NewResourceHandler(decorator.ForBlobstoreWithTracing(withQuotas(dropletBlobstore, quotaLedger, "droplets"), "droplets"), tracedAppStashBlobstore, "droplet", metricsService, config.Droplets.MaxBodySizeBytes(), config.ShouldProxyGetRequests)
+	if ociImageHandler != nil {
+		dropletHandler.NotifyOnDelete(ociImageHandler.ImageManager.ForgetDroplet)
+	}
+	buildpackCacheHandler := bitsgo.NewResourceHandler(decorator.ForBlobstoreWithTracing(withQuotas(buildpackCacheBlobstore, quotaLedger, "buildpack_cache"), "buildpack_cache"), tracedAppStashBlobstore, "buildpack_cache", metricsService, config.BuildpackCache.MaxBodySizeBytes(), config.ShouldProxyGetRequests)
+	readinessHandler := bitsgo.NewReadinessHandlerWithBlobstoreProbes(
+		probedBlobstores,
+		config.ReadinessProbes.Timeout(),
+		config.ReadinessProbes.CacheDuration(),
+		clock.New())
+
+	routedMetricsHandler := metricsHandler
+	if config.Metrics.PrometheusPort != 0 {
+		routedMetricsHandler = nil
+	}
 	handler := routes.SetUpAllRoutes(
 		config.PrivateEndpointUrl().Host,
 		config.PublicEndpointUrl().Host,
 		registryEndpointHost,
-		middlewares.NewBasicAuthMiddleWare(basicAuthCredentialsFrom(config.SigningUsers)...),
+		basicAuthMiddleware,
 		&middlewares.SignatureVerificationMiddleware{pathsigner.Validate(&pathsigner.PathSignerValidator{
 			config.Secret,
 			clock.New(),
@@ -95,22 +207,16 @@ func main() {
 		signBuildpackURLHandler,
 		signBuildpackCacheURLHandler,
 		signAppStashURLHandler,
-		bitsgo.NewAppStashHandlerWithSizeThresholds(appStashBlobstore, config.AppStash.MaxBodySizeBytes(), config.AppStashConfig.MinimumSizeBytes(), config.AppStashConfig.MaximumSizeBytes(), metricsService),
-		bitsgo.NewResourceHandlerWithUpdaterAndSizeThresholds(
-			packageBlobstore,
-			appStashBlobstore,
-			createUpdater(config.CCUpdater),
-			"package",
-			metricsService,
-			config.Packages.MaxBodySizeBytes(),
-			config.AppStashConfig.MinimumSizeBytes(),
-			config.AppStashConfig.MaximumSizeBytes(),
-			config.ShouldProxyGetRequests,
-		),
-		bitsgo.NewResourceHandler(buildpackBlobstore, appStashBlobstore, "buildpack", metricsService, config.Buildpacks.MaxBodySizeBytes(), config.ShouldProxyGetRequests),
-		bitsgo.NewResourceHandler(dropletBlobstore, appStashBlobstore, "droplet", metricsService, config.Droplets.MaxBodySizeBytes(), config.ShouldProxyGetRequests),
-		bitsgo.NewResourceHandler(buildpackCacheBlobstore, appStashBlobstore, "buildpack_cache", metricsService, config.BuildpackCache.MaxBodySizeBytes(), config.ShouldProxyGetRequests),
+		bitsgo.NewAppStashHandlerWithSizeThresholds(tracedAppStashBlobstore, config.AppStash.MaxBodySizeBytes(), config.AppStashConfig.MinimumSizeBytes(), config.AppStashConfig.MaximumSizeBytes(), metricsService),
+		packageHandler,
+		buildpackHandler,
+		dropletHandler,
+		buildpackCacheHandler,
+		createUploadSessionHandler(config.ResumableUploads, packageHandler, "packages", internalBlobstore),
+		createUploadSessionHandler(config.ResumableUploads, dropletHandler, "droplets", internalBlobstore),
 		ociImageHandler,
+		readinessHandler,
+		routedMetricsHandler,
 	)
 
 	address := os.Getenv("BITS_LISTEN_ADDR")
@@ -118,21 +224,85 @@ func main() {
 		address = "0.0.0.0"
 	}
 
-	httpServer := &http.Server{
-		Handler: negroni.New(
-			middlewares.NewMetricsMiddleware(metricsService),
-			middlewares.NewZapLoggerMiddleware(log.Log),
-			&middlewares.PanicMiddleware{},
-			&middlewares.MultipartMiddleware{},
-			negroni.Wrap(handler)),
+	serverHandler := negroni.New(
+		middlewares.NewMetricsMiddleware(metricsService),
+		middlewares.NewTracingMiddleware(),
+		middlewares.NewZapLoggerMiddleware(log.Log),
+		&middlewares.TenantMiddleware{},
+		&middlewares.PanicMiddleware{},
+		&middlewares.MultipartMiddleware{},
+		negroni.Wrap(handler))
+	// Every listener needs its own server, as servers hold their address and TLS config.
+	httpsServer := newHttpServer(serverHandler, logger)
+	httpServers := []*http.Server{httpsServer}
+	if config.HttpEnabled {
+		httpServer := newHttpServer(serverHandler, logger)
+		httpServers = append(httpServers, httpServer)
+		go listenAndServe(httpServer, address, config)
+	}
+	go listenAndServeTLS(httpsServer, address, config)
+	if metricsHandler != nil && config.Metrics.PrometheusPort != 0 {
+		metricsRouter := mux.NewRouter()
+		routes.SetUpMetricsRoute(metricsRouter, metricsHandler)
+		metricsServer := newHttpServer(metricsRouter, logger)
+		httpServers = append(httpServers, metricsServer)
+		go listenAndServeMetrics(metricsServer, address, config.Metrics.PrometheusPort)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	log.Log.Infow("Received signal. Draining.", "signal", (<-signals).String())
+
+	drain(httpServers, readinessHandler, config.Drain,
+		packageHandler, buildpackHandler, dropletHandler, buildpackCacheHandler)
+	e = appStashAccessLog.Flush()
+	if e != nil {
+		log.Log.Errorw("Could not flush app stash access log", "error", e)
+	}
+	e = shutdownTracing(context.Background())
+	if e != nil {
+		log.Log.Errorw("Could not flush traces", "error", e)
+	}
+	logger.Sync()
+}
+
+func newHttpServer(handler http.Handler, logger *zap.Logger) *http.Server {
+	return &http.Server{
+		Handler:      handler,
 		WriteTimeout: 60 * time.Minute,
 		ReadTimeout:  60 * time.Minute,
 		ErrorLog:     log.NewStdLog(logger),
 	}
-	if config.HttpEnabled {
-		go listenAndServe(httpServer, address, config)
+}
+
+// drain first reports not-ready, then stops accepting new connections and waits for in-flight requests and async uploads.
+func drain(httpServers []*http.Server, readinessHandler *bitsgo.ReadinessHandler, drainConfig config.DrainConfig, resourceHandlers ...*bitsgo.ResourceHandler) {
+	readinessHandler.StartDraining()
+	time.Sleep(drainConfig.NotReadyDelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainConfig.Timeout())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, httpServer := range httpServers {
+		wg.Add(1)
+		go func(httpServer *http.Server) {
+			defer wg.Done()
+			e := httpServer.Shutdown(ctx)
+			if e != nil {
+				log.Log.Errorw("Not all in-flight requests finished before drain timeout", "error", e)
+			}
+		}(httpServer)
+	}
+	wg.Wait()
+	for _, resourceHandler := range resourceHandlers {
+		e := resourceHandler.WaitForAsyncUploads(ctx)
+		if e != nil {
+			log.Log.Errorw("Not all async uploads finished before drain timeout", "error", e)
+			return
+		}
 	}
-	listenAndServeTLS(httpServer, address, config)
+	log.Log.Infow("Drained")
 }
 
 func listenAndServe(httpServer *http.Server, address string, c config.Config) {
@@ -143,7 +313,18 @@ func listenAndServe(httpServer *http.Server, address string, c config.Config) {
 		"public-endpoint", c.PublicEndpointUrl().Host,
 		"private-endpoint", c.PrivateEndpointUrl().Host)
 	e := httpServer.ListenAndServe()
-	log.Log.Fatalw("HTTP server crashed", "error", e)
+	if e != http.ErrServerClosed {
+		log.Log.Fatalw("HTTP server crashed", "error", e)
+	}
+}
+
+func listenAndServeMetrics(httpServer *http.Server, address string, port int) {
+	httpServer.Addr = fmt.Sprintf("%v:%v", address, port)
+	log.Log.Infow("Starting metrics server", "ip-address", address, "port", port)
+	e := httpServer.ListenAndServe()
+	if e != http.ErrServerClosed {
+		log.Log.Fatalw("Metrics server crashed", "error", e)
+	}
 }
 
 func listenAndServeTLS(httpServer *http.Server, address string, c config.Config) {
@@ -183,7 +364,9 @@ func listenAndServeTLS(httpServer *http.Server, address string, c config.Config)
 		"public-endpoint", c.PublicEndpointUrl().Host,
 		"private-endpoint", c.PrivateEndpointUrl().Host)
 	e := httpServer.ListenAndServeTLS(c.CertFile, c.KeyFile)
-	log.Log.Fatalw("HTTPS server crashed", "error", e)
+	if e != http.ErrServerClosed {
+		log.Log.Fatalw("HTTPS server crashed", "error", e)
+	}
 }
 
 func createLoggerWith(logLevel string) *zap.Logger {
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
 	"io/ioutil"
 	"math"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -48,8 +51,30 @@ type Config struct {
 
 	AppStashConfig AppStashConfig `yaml:"app_stash_config"`
 
+	BuildpackReaper *BuildpackReaperConfig `yaml:"buildpack_reaper"`
+
+	AsyncUploads AsyncUploadsConfig `yaml:"async_uploads"`
+
+	ResumableUploads ResumableUploadsConfig `yaml:"resumable_uploads"`
+
+	Drain DrainConfig `yaml:"drain"`
+
+	ReadinessProbes ReadinessProbesConfig `yaml:"readiness_probes"`
+
+	Metrics MetricsConfig
+
+	Tracing TracingConfig
+
+	Encryption EncryptionConfig
+
+	Quotas QuotasConfig
+
 	EnableRegistry bool `yaml:"enable_registry"`
 
+	RegistryAuth RegistryAuthConfig `yaml:"registry_auth"`
+
+	RegistryPush RegistryPushConfig `yaml:"registry_push"`
+
 	ShouldProxyGetRequests bool `yaml:"proxy_get_requests"`
 }
 
@@ -85,16 +110,64 @@ func (config *Config) SigningKeysMap() map[string]string {
 }
 
 type BlobstoreConfig struct {
-	BlobstoreType     BlobstoreType             `yaml:"blobstore_type"`
-	LocalConfig       *LocalBlobstoreConfig     `yaml:"local_config"`
-	S3Config          *S3BlobstoreConfig        `yaml:"s3_config"`
-	GCPConfig         *GCPBlobstoreConfig       `yaml:"gcp_config"`
-	AzureConfig       *AzureBlobstoreConfig     `yaml:"azure_config"`
-	OpenstackConfig   *OpenstackBlobstoreConfig `yaml:"openstack_config"`
-	WebdavConfig      *WebdavBlobstoreConfig    `yaml:"webdav_config"`
-	AlibabaConfig     *AlibabaBlobstoreConfig   `yaml:"alibaba_config"`
-	MaxBodySize       string                    `yaml:"max_body_size"`
-	GlobalMaxBodySize string                    // Not to be set by yaml
+	BlobstoreType     BlobstoreType              `yaml:"blobstore_type"`
+	LocalConfig       *LocalBlobstoreConfig      `yaml:"local_config"`
+	S3Config          *S3BlobstoreConfig         `yaml:"s3_config"`
+	GCPConfig         *GCPBlobstoreConfig        `yaml:"gcp_config"`
+	AzureConfig       *AzureBlobstoreConfig      `yaml:"azure_config"`
+	OpenstackConfig   *OpenstackBlobstoreConfig  `yaml:"openstack_config"`
+	WebdavConfig      *WebdavBlobstoreConfig     `yaml:"webdav_config"`
+	AlibabaConfig     *AlibabaBlobstoreConfig    `yaml:"alibaba_config"`
+	ReplicatedConfig  *ReplicatedBlobstoreConfig `yaml:"replicated_config"`
+	MaxBodySize       string                     `yaml:"max_body_size"`
+	GlobalMaxBodySize string                     // Not to be set by yaml
+	// Stores identical bits only once. Only supported for packages and droplets.
+	ContentAddressable bool `yaml:"content_addressable"`
+	// Compresses blobs before storing them. Not supported for rootfs.
+	Compression CompressionConfig `yaml:"compression"`
+	// Caches blobs read from the blobstore on local disk. Not supported for app_stash and rootfs.
+	Cache CacheConfig `yaml:"cache"`
+}
+
+type CacheConfig struct {
+	// Must be different for each resource type. Caching is disabled when empty.
+	Directory string `yaml:"directory"`
+	// Defaults to 1G.
+	MaxSize string `yaml:"max_size"`
+}
+
+func (config *CacheConfig) Enabled() bool {
+	return config.Directory != ""
+}
+
+func (config *CacheConfig) MaxSizeBytes() uint64 {
+	return parseSizeProperty(config.MaxSize, 1024*1024*1024)
+}
+
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// One of gzip and zstd. Defaults to gzip.
+	Algorithm CompressionAlgorithm `yaml:"algorithm"`
+	// Blobs smaller than this are stored uncompressed. Defaults to 1K.
+	MinSize string `yaml:"min_size"`
+}
+
+type CompressionAlgorithm string
+
+const (
+	Gzip CompressionAlgorithm = "gzip"
+	Zstd CompressionAlgorithm = "zstd"
+)
+
+func (config *CompressionConfig) AlgorithmOrDefault() CompressionAlgorithm {
+	if config.Algorithm == "" {
+		return Gzip
+	}
+	return config.Algorithm
+}
+
+func (config *CompressionConfig) MinSizeBytes() uint64 {
+	return parseSizeProperty(config.MinSize, 1024)
 }
 
 type BlobstoreType string
@@ -107,16 +180,19 @@ const (
 	OpenStack BlobstoreType = "openstack"
 	WebDAV    BlobstoreType = "webdav"
 	Alibaba   BlobstoreType = "alibaba"
+	// Mirrors writes to one or more secondary blobstores. See ReplicatedBlobstoreConfig.
+	Replicated BlobstoreType = "replicated"
 )
 
 var BlobstoreTypes = map[BlobstoreType]bool{
-	Local:     true,
-	AWS:       true,
-	Google:    true,
-	Azure:     true,
-	OpenStack: true,
-	WebDAV:    true,
-	Alibaba:   true,
+	Local:      true,
+	AWS:        true,
+	Google:     true,
+	Azure:      true,
+	OpenStack:  true,
+	WebDAV:     true,
+	Alibaba:    true,
+	Replicated: true,
 }
 
 func (config *BlobstoreConfig) MaxBodySizeBytes() uint64 {
@@ -152,6 +228,18 @@ type S3BlobstoreConfig struct {
 	SSEKMSKeyID          string `yaml:"server_side_encryption_aws_kms_key_id"`
 	UseIAMProfile        bool   `yaml:"use_iam_profile"`
 	SignatureVersion     int    `yaml:"signature_version"`
+	// Blobs larger than this are uploaded as multipart upload in parts of this size. Defaults to 64M. Must be at least 5M.
+	PartSize string `yaml:"part_size"`
+	// Number of parts uploaded in parallel. Defaults to 4.
+	UploadConcurrency int `yaml:"upload_concurrency"`
+}
+
+func (config *S3BlobstoreConfig) PartSizeBytes() int64 {
+	return int64(parseSizeProperty(config.PartSize, 64*1024*1024))
+}
+
+func (config *S3BlobstoreConfig) UploadConcurrencyOrDefault() int {
+	return uploadConcurrencyOrDefault(config.UploadConcurrency)
 }
 
 type GCPBlobstoreConfig struct {
@@ -207,11 +295,87 @@ type WebdavBlobstoreConfig struct {
 	Password        string
 }
 
+// ReplicatedBlobstoreConfig wraps a primary and secondary blobstores, which are configured
+// like any other blobstore, but must not be replicated themselves.
+type ReplicatedBlobstoreConfig struct {
+	Primary     BlobstoreConfig   `yaml:"primary"`
+	Secondaries []BlobstoreConfig `yaml:"secondaries"`
+	// One of sync and async. Defaults to sync.
+	WriteMode ReplicationWriteMode `yaml:"write_mode"`
+	// Number of writes waiting for replication in async mode. Further writes are only replicated by repair. Defaults to 1000.
+	QueueSize int `yaml:"queue_size"`
+	// Interval of making the secondaries hold the same blobs as the primary. 0 disables repair.
+	RepairIntervalMinutes int `yaml:"repair_interval_minutes"`
+	// Repair only runs on instances which set this, so that instances do not repair at the same time.
+	// It must be set on exactly one instance.
+	RunRepair bool `yaml:"run_repair"`
+	// Makes repair remove blobs which only exist in a secondary. Off by default, so that secondaries keep their
+	// blobs when the primary loses them.
+	RemoveOrphans bool `yaml:"remove_orphans"`
+	// Repair removes nothing from a secondary when more blobs than this only exist in it. Defaults to 100.
+	MaxOrphanRemovals int `yaml:"max_orphan_removals"`
+}
+
+type ReplicationWriteMode string
+
+const (
+	SyncReplication  ReplicationWriteMode = "sync"
+	AsyncReplication ReplicationWriteMode = "async"
+)
+
+func (config *ReplicatedBlobstoreConfig) WriteModeOrDefault() ReplicationWriteMode {
+	if config.WriteMode == "" {
+		return SyncReplication
+	}
+	return config.WriteMode
+}
+
+func (config *ReplicatedBlobstoreConfig) QueueSizeOrDefault() int {
+	if config.QueueSize == 0 {
+		return 1000
+	}
+	return config.QueueSize
+}
+
+// MaxOrphanRemovalsOrDefault returns 0 when orphans must not be removed.
+func (config *ReplicatedBlobstoreConfig) MaxOrphanRemovalsOrDefault() int {
+	if !config.RemoveOrphans {
+		return 0
+	}
+	if config.MaxOrphanRemovals == 0 {
+		return 100
+	}
+	return config.MaxOrphanRemovals
+}
+
+func (config *ReplicatedBlobstoreConfig) RepairInterval() time.Duration {
+	return time.Duration(config.RepairIntervalMinutes) * time.Minute
+}
+
 type AlibabaBlobstoreConfig struct {
 	BucketName string `yaml:"bucket_name"`
 	ApiKey     string `yaml:"access_key_id"`
 	ApiSecret  string `yaml:"access_key_secret"`
 	Endpoint   string
+	// Blobs larger than this are uploaded as multipart upload in parts of this size. Defaults to 64M. Must be at least 100K.
+	PartSize string `yaml:"part_size"`
+	// Number of parts uploaded in parallel. Defaults to 4.
+	UploadConcurrency int `yaml:"upload_concurrency"`
+}
+
+func (config *AlibabaBlobstoreConfig) PartSizeBytes() int64 {
+	return int64(parseSizeProperty(config.PartSize, 64*1024*1024))
+}
+
+func (config *AlibabaBlobstoreConfig) UploadConcurrencyOrDefault() int {
+	return uploadConcurrencyOrDefault(config.UploadConcurrency)
+}
+
+func uploadConcurrencyOrDefault(uploadConcurrency int) int {
+	if uploadConcurrency == 0 {
+		return 4
+	}
+	return uploadConcurrency
 }
 
 func (config WebdavBlobstoreConfig) CACert() string {
@@ -240,8 +404,307 @@ type CCUpdaterConfig struct {
 }
 
 type AppStashConfig struct {
-	MinimumSize string `yaml:"minimum_size"`
-	MaximumSize string `yaml:"maximum_size"`
+	MinimumSize       string                           `yaml:"minimum_size"`
+	MaximumSize       string                           `yaml:"maximum_size"`
+	GarbageCollection *AppStashGarbageCollectionConfig `yaml:"garbage_collection"`
+}
+
+// AppStashGarbageCollectionConfig configures the removal of app stash entries.
+// An entry counts as used when it was uploaded, checked or read by any bits-service instance. Every instance records
+// the uses in the droplet bucket under bits_internal/ once a minute. Entries without any recorded use, e.g. the ones
+// uploaded by older versions, count as used when garbage collection first sees them.
+type AppStashGarbageCollectionConfig struct {
+	// Entries not used within this time are removed. 0 means entries are never removed because of their age.
+	MaxAgeHours int `yaml:"max_age_hours"`
+	// Least recently used entries are removed until the app stash is smaller than this. Empty means no limit.
+	MaxTotalSize    string `yaml:"max_total_size"`
+	IntervalMinutes int    `yaml:"interval_minutes"`
+	// Only logs and reports metrics about what would be removed.
+	DryRun bool `yaml:"dry_run"`
+}
+
+func (config *AppStashGarbageCollectionConfig) MaxAge() time.Duration {
+	return time.Duration(config.MaxAgeHours) * time.Hour
+}
+
+func (config *AppStashGarbageCollectionConfig) MaxTotalSizeBytes() uint64 {
+	return parseSizeProperty(config.MaxTotalSize, 0)
+}
+
+func (config *AppStashGarbageCollectionConfig) Interval() time.Duration {
+	if config.IntervalMinutes == 0 {
+		return time.Hour
+	}
+	return time.Duration(config.IntervalMinutes) * time.Minute
+}
+
+// BuildpackReaperConfig configures the removal of buildpacks which were uploaded, but never committed by Cloud Controller.
+// The reaper is disabled unless configured. Only configure it once Cloud Controller calls POST /buildpacks/:guid/commit
+// for every buildpack it accepts. Otherwise every buildpack uploaded from then on is removed after the TTL.
+type BuildpackReaperConfig struct {
+	// Uncommitted buildpacks older than this are removed. Defaults to 24 hours.
+	UncommittedTTLHours int `yaml:"uncommitted_ttl_hours"`
+	IntervalMinutes     int `yaml:"interval_minutes"`
+}
+
+func (config *BuildpackReaperConfig) UncommittedTTL() time.Duration {
+	if config.UncommittedTTLHours == 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(config.UncommittedTTLHours) * time.Hour
+}
+
+func (config *BuildpackReaperConfig) Interval() time.Duration {
+	if config.IntervalMinutes == 0 {
+		return time.Hour
+	}
+	return time.Duration(config.IntervalMinutes) * time.Minute
+}
+
+// AsyncUploadsConfig configures package uploads with ?async=true.
+type AsyncUploadsConfig struct {
+	// Upload jobs are persisted here, so that they are resumed after a restart and their status can be queried.
+	// Empty means upload jobs only live in memory. Must not be a tmpfs.
+	JobsDirectory string `yaml:"jobs_directory"`
+	// Status of finished upload jobs can be queried for this long. Defaults to 24 hours.
+	StatusRetentionHours int `yaml:"status_retention_hours"`
+	// Defaults to 10.
+	MaxConcurrentUploads int `yaml:"max_concurrent_uploads"`
+}
+
+func (config *AsyncUploadsConfig) StatusRetention() time.Duration {
+	if config.StatusRetentionHours == 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(config.StatusRetentionHours) * time.Hour
+}
+
+func (config *AsyncUploadsConfig) MaxConcurrentUploadsOrDefault() int {
+	if config.MaxConcurrentUploads == 0 {
+		return 10
+	}
+	return config.MaxConcurrentUploads
+}
+
+// ResumableUploadsConfig configures chunked package and droplet uploads via /packages/{guid}/uploads and /droplets/{guid}/uploads.
+type ResumableUploadsConfig struct {
+	// Chunks are buffered here while they are received and assembled here when the upload is finalized.
+	// The sessions themselves are kept in the droplet bucket under bits_internal/. Empty disables resumable uploads.
+	StagingDirectory string `yaml:"staging_directory"`
+	// Sessions without new chunks for this long are removed. Defaults to 24 hours.
+	SessionTTLHours int `yaml:"session_ttl_hours"`
+}
+
+func (config *ResumableUploadsConfig) Enabled() bool {
+	return config.StagingDirectory != ""
+}
+
+func (config *ResumableUploadsConfig) SessionTTL() time.Duration {
+	if config.SessionTTLHours == 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(config.SessionTTLHours) * time.Hour
+}
+
+// RegistryAuthConfig protects the registry with the Docker token auth flow. Tokens are issued to signing_users and
+// signed with signing_keys, or secret when there are none.
+type RegistryAuthConfig struct {
+	// Without authentication, everyone who can reach the registry can pull every droplet.
+	Enabled bool `yaml:"enabled"`
+	// Defaults to 5 minutes.
+	TokenTTLSeconds int `yaml:"token_ttl_seconds"`
+}
+
+func (config *RegistryAuthConfig) TokenTTL() time.Duration {
+	if config.TokenTTLSeconds == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(config.TokenTTLSeconds) * time.Second
+}
+
+// RegistryPushConfig allows to push images to the registry. Pushing requires registry_auth, because pushed images are
+// stored in the droplet blobstore.
+type RegistryPushConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Pushed blobs larger than this are rejected. Defaults to 1G.
+	MaxBlobSize string `yaml:"max_blob_size"`
+}
+
+func (config *RegistryPushConfig) MaxBlobSizeBytes() uint64 {
+	return parseSizeProperty(config.MaxBlobSize, 1<<30)
+}
+
+// DrainConfig configures what happens on SIGTERM or SIGINT.
+type DrainConfig struct {
+	// In-flight requests and async uploads still running after this time are aborted. Defaults to 60 seconds.
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// Time between reporting not-ready and closing the listeners, so that load balancers can notice. Defaults to 0.
+	NotReadyDelaySeconds int `yaml:"not_ready_delay_seconds"`
+}
+
+func (config *DrainConfig) Timeout() time.Duration {
+	if config.TimeoutSeconds == 0 {
+		return time.Minute
+	}
+	return time.Duration(config.TimeoutSeconds) * time.Second
+}
+
+func (config *DrainConfig) NotReadyDelay() time.Duration {
+	return time.Duration(config.NotReadyDelaySeconds) * time.Second
+}
+
+// ReadinessProbesConfig configures the blobstore probes behind /readyz.
+type ReadinessProbesConfig struct {
+	// Defaults to 10 seconds.
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// Probe results are reused for this long. Defaults to 30 seconds.
+	CacheSeconds int `yaml:"cache_seconds"`
+}
+
+func (config *ReadinessProbesConfig) Timeout() time.Duration {
+	if config.TimeoutSeconds == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(config.TimeoutSeconds) * time.Second
+}
+
+func (config *ReadinessProbesConfig) CacheDuration() time.Duration {
+	if config.CacheSeconds == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(config.CacheSeconds) * time.Second
+}
+
+type MetricsBackend string
+
+const (
+	Statsd     MetricsBackend = "statsd"
+	Prometheus MetricsBackend = "prometheus"
+)
+
+type MetricsConfig struct {
+	// Any combination of statsd and prometheus. Defaults to statsd.
+	Backends []MetricsBackend
+	// When set, Prometheus metrics are served via plain HTTP on this port only, e.g. to scrape them by IP address.
+	// Otherwise they are served via the private host.
+	PrometheusPort int `yaml:"prometheus_port"`
+}
+
+func (config *MetricsConfig) BackendsOrDefault() []MetricsBackend {
+	if len(config.Backends) == 0 {
+		return []MetricsBackend{Statsd}
+	}
+	return config.Backends
+}
+
+// EncryptionConfig enables encrypting all blobs except rootfs ones, when ActiveKeyID is set.
+type EncryptionConfig struct {
+	// New blobs are encrypted with this key. Keys used before a rotation must stay configured to read older blobs.
+	ActiveKeyID string `yaml:"active_key_id"`
+	Keys        []EncryptionKey
+}
+
+type EncryptionKey struct {
+	// At most 32 bytes
+	KeyID string `yaml:"key_id"`
+	// Base64 encoded 32 bytes
+	Key string
+}
+
+func (config *EncryptionConfig) Enabled() bool {
+	return config.ActiveKeyID != ""
+}
+
+// KeysMap must only be called on validated configs.
+func (config *EncryptionConfig) KeysMap() map[string][]byte {
+	result := make(map[string][]byte, len(config.Keys))
+	for _, key := range config.Keys {
+		decodedKey, e := base64.StdEncoding.DecodeString(key.Key)
+		if e != nil {
+			panic("Unexpected error: " + e.Error())
+		}
+		result[key.KeyID] = decodedKey
+	}
+	return result
+}
+
+// QuotasConfig limits the total size of packages, droplets and buildpack cache entries per tenant.
+type QuotasConfig struct {
+	// The usage ledger is kept in the droplet bucket under bits_internal/, so that all instances share it.
+	Enabled bool `yaml:"enabled"`
+	// Applies to tenants without their own max_size. Empty means unlimited.
+	DefaultMaxSize string `yaml:"default_max_size"`
+	Tenants        []TenantQuota
+}
+
+type TenantQuota struct {
+	Tenant string
+	// Empty means unlimited.
+	MaxSize string `yaml:"max_size"`
+}
+
+// DefaultMaxSizeBytes returns 0 for unlimited.
+func (config *QuotasConfig) DefaultMaxSizeBytes() uint64 {
+	return parseSizeProperty(config.DefaultMaxSize, 0)
+}
+
+// TenantMaxSizesMap returns 0 for unlimited tenants.
+func (config *QuotasConfig) TenantMaxSizesMap() map[string]uint64 {
+	result := make(map[string]uint64, len(config.Tenants))
+	for _, tenantQuota := range config.Tenants {
+		result[tenantQuota.Tenant] = parseSizeProperty(tenantQuota.MaxSize, 0)
+	}
+	return result
+}
+
+type TracingExporter string
+
+const (
+	NoTracing      TracingExporter = "none"
+	OTLPExporter   TracingExporter = "otlp"
+	StdoutExporter TracingExporter = "stdout"
+)
+
+type TracingConfig struct {
+	// One of none, otlp and stdout. Defaults to none.
+	Exporter TracingExporter
+	// host:port of an OTLP/HTTP collector. Defaults to localhost:4318.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	OTLPInsecure bool   `yaml:"otlp_insecure"`
+	// The stdout exporter writes spans to this file instead of stdout, if configured.
+	StdoutFile string `yaml:"stdout_file"`
+	// Fraction of new traces to record. Traces started by a caller follow the caller's sampling decision. Defaults to 1.
+	SamplingRatio *float64 `yaml:"sampling_ratio"`
+	// Defaults to bits-service.
+	ServiceName string `yaml:"service_name"`
+}
+
+func (config *TracingConfig) ExporterOrDefault() TracingExporter {
+	if config.Exporter == "" {
+		return NoTracing
+	}
+	return config.Exporter
+}
+
+func (config *TracingConfig) OTLPEndpointOrDefault() string {
+	if config.OTLPEndpoint == "" {
+		return "localhost:4318"
+	}
+	return config.OTLPEndpoint
+}
+
+func (config *TracingConfig) SamplingRatioOrDefault() float64 {
+	if config.SamplingRatio == nil {
+		return 1
+	}
+	return *config.SamplingRatio
+}
+
+func (config *TracingConfig) ServiceNameOrDefault() string {
+	if config.ServiceName == "" {
+		return "bits-service"
+	}
+	return config.ServiceName
 }
 
 func (config *AppStashConfig) MinimumSizeBytes() uint64 {
@@ -282,10 +745,10 @@ func LoadConfig(filename string) (config Config, err error) {
 	config.Buildpacks.GlobalMaxBodySize = config.MaxBodySize
 	config.BuildpackCache.GlobalMaxBodySize = config.MaxBodySize
 
-	config.Droplets.BlobstoreType = BlobstoreType(strings.ToLower(string(config.Droplets.BlobstoreType)))
-	config.Packages.BlobstoreType = BlobstoreType(strings.ToLower(string(config.Packages.BlobstoreType)))
-	config.AppStash.BlobstoreType = BlobstoreType(strings.ToLower(string(config.AppStash.BlobstoreType)))
-	config.Buildpacks.BlobstoreType = BlobstoreType(strings.ToLower(string(config.Buildpacks.BlobstoreType)))
+	normalizeBlobstoreType(&config.Droplets)
+	normalizeBlobstoreType(&config.Packages)
+	normalizeBlobstoreType(&config.AppStash)
+	normalizeBlobstoreType(&config.Buildpacks)
 
 	setSignatureVersionDefault(&config.AppStash)
 	setSignatureVersionDefault(&config.Buildpacks)
@@ -370,6 +833,39 @@ func LoadConfig(filename string) (config Config, err error) {
 	verifyBlobstoreConfig(config.Buildpacks, "buildpacks", &errs)
 	verifyBlobstoreConfig(config.AppStash, "app_stash", &errs)
 
+	verifyCompressionConfig(config.Droplets.Compression, "droplets", &errs)
+	verifyCompressionConfig(config.Packages.Compression, "packages", &errs)
+	verifyCompressionConfig(config.Buildpacks.Compression, "buildpacks", &errs)
+	verifyCompressionConfig(config.AppStash.Compression, "app_stash", &errs)
+	verifyCompressionConfig(config.BuildpackCache.Compression, "buildpack_cache", &errs)
+	if config.RootFS.Compression.Enabled {
+		errs = append(errs, "compression is not supported for rootfs")
+	}
+
+	if config.AppStash.Cache.Enabled() || config.RootFS.Cache.Enabled() {
+		errs = append(errs, "cache is not supported for app_stash and rootfs")
+	}
+	cacheDirectories := make(map[string]bool)
+	for resourceType, cacheConfig := range map[string]CacheConfig{
+		"droplets":        config.Droplets.Cache,
+		"packages":        config.Packages.Cache,
+		"buildpacks":      config.Buildpacks.Cache,
+		"buildpack_cache": config.BuildpackCache.Cache,
+	} {
+		if !cacheConfig.Enabled() {
+			continue
+		}
+		if cacheDirectories[cacheConfig.Directory] {
+			errs = append(errs, "cache directory "+cacheConfig.Directory+" is used by more than one resource type")
+		}
+		cacheDirectories[cacheConfig.Directory] = true
+		if cacheConfig.MaxSize != "" {
+			if _, e := bytefmt.ToBytes(cacheConfig.MaxSize); e != nil {
+				errs = append(errs, resourceType+".cache.max_size is invalid. Caused by: "+e.Error())
+			}
+		}
+	}
+
 	if len(errs) > 0 {
 		// returning here already, because follow-up checks are difficult if not even basic checks succeed
 		return Config{}, errors.New("error in config values: " + strings.Join(errs, "; "))
@@ -381,11 +877,16 @@ func LoadConfig(filename string) (config Config, err error) {
 		config.BuildpackCache.OpenstackConfig != nil ||
 		config.BuildpackCache.S3Config != nil ||
 		config.BuildpackCache.AlibabaConfig != nil ||
+		config.BuildpackCache.ReplicatedConfig != nil ||
 		config.BuildpackCache.WebdavConfig != nil {
 		errs = append(errs, "buildpack_cache must not have a blobstore configured, as it only exists to allow to configure max_body_size. "+
 			"As blobstore, the droplet blobstore is used.")
 	}
 
+	if config.Buildpacks.ContentAddressable || config.AppStash.ContentAddressable || config.BuildpackCache.ContentAddressable || config.RootFS.ContentAddressable {
+		errs = append(errs, "content_addressable is only supported for packages and droplets")
+	}
+
 	if config.Packages.BlobstoreType == WebDAV && config.Packages.WebdavConfig.DirectoryKey == "" {
 		errs = append(errs, "Packages WebDAV blobstore must have a directory_key configured.")
 	}
@@ -403,10 +904,120 @@ func LoadConfig(filename string) (config Config, err error) {
 		errs = append(errs, "app_stash_config.maximum_size must be greater than app_stash_config.minimum_size")
 	}
 
+	if gcConfig := config.AppStashConfig.GarbageCollection; gcConfig != nil {
+		if gcConfig.MaxAgeHours < 0 || gcConfig.IntervalMinutes < 0 {
+			errs = append(errs, "app_stash_config.garbage_collection.max_age_hours and interval_minutes must not be negative")
+		}
+		if gcConfig.MaxTotalSize != "" {
+			if _, e := bytefmt.ToBytes(gcConfig.MaxTotalSize); e != nil {
+				errs = append(errs, "app_stash_config.garbage_collection.max_total_size is invalid. Caused by: "+e.Error())
+			}
+		}
+		if gcConfig.MaxAgeHours == 0 && gcConfig.MaxTotalSize == "" {
+			errs = append(errs, "app_stash_config.garbage_collection must have max_age_hours or max_total_size configured")
+		}
+	}
+
+	if config.AsyncUploads.StatusRetentionHours < 0 || config.AsyncUploads.MaxConcurrentUploads < 0 {
+		errs = append(errs, "async_uploads.status_retention_hours and max_concurrent_uploads must not be negative")
+	}
+
+	if config.ResumableUploads.SessionTTLHours < 0 {
+		errs = append(errs, "resumable_uploads.session_ttl_hours must not be negative")
+	}
+
+	if config.RegistryAuth.TokenTTLSeconds < 0 {
+		errs = append(errs, "registry_auth.token_ttl_seconds must not be negative")
+	}
+	if config.RegistryAuth.Enabled && len(config.SigningUsers) == 0 {
+		errs = append(errs, "registry_auth requires signing_users, because nobody could get a token otherwise")
+	}
+
+	if config.RegistryPush.Enabled && (!config.EnableRegistry || !config.RegistryAuth.Enabled) {
+		errs = append(errs, "registry_push requires enable_registry and registry_auth, because everyone could push images otherwise")
+	}
+	if config.RegistryPush.MaxBlobSize != "" {
+		if _, e := bytefmt.ToBytes(config.RegistryPush.MaxBlobSize); e != nil {
+			errs = append(errs, "registry_push.max_blob_size is invalid. Caused by: "+e.Error())
+		}
+	}
+
+	if config.Drain.TimeoutSeconds < 0 || config.Drain.NotReadyDelaySeconds < 0 {
+		errs = append(errs, "drain.timeout_seconds and not_ready_delay_seconds must not be negative")
+	}
+
+	if config.ReadinessProbes.TimeoutSeconds < 0 || config.ReadinessProbes.CacheSeconds < 0 {
+		errs = append(errs, "readiness_probes.timeout_seconds and cache_seconds must not be negative")
+	}
+
+	for _, backend := range config.Metrics.Backends {
+		if backend != Statsd && backend != Prometheus {
+			errs = append(errs, "metrics.backends contains invalid backend '"+string(backend)+"'. Valid backends are: statsd, prometheus")
+		}
+	}
+
+	switch config.Tracing.ExporterOrDefault() {
+	case NoTracing, OTLPExporter, StdoutExporter:
+	default:
+		errs = append(errs, "tracing.exporter '"+string(config.Tracing.Exporter)+"' is invalid. Valid exporters are: none, otlp, stdout")
+	}
+	if ratio := config.Tracing.SamplingRatioOrDefault(); ratio < 0 || ratio > 1 {
+		errs = append(errs, "tracing.sampling_ratio must be between 0 and 1")
+	}
+
+	if reaperConfig := config.BuildpackReaper; reaperConfig != nil {
+		if reaperConfig.UncommittedTTLHours < 0 || reaperConfig.IntervalMinutes < 0 {
+			errs = append(errs, "buildpack_reaper.uncommitted_ttl_hours and interval_minutes must not be negative")
+		}
+	}
+
 	if config.Secret == "" && len(config.SigningKeys) == 0 {
 		errs = append(errs, "Must provide either \"secret\" or \"signing_keys\" with at least one element.")
 	}
 
+	if config.Encryption.Enabled() || len(config.Encryption.Keys) > 0 {
+		activeKeyFound := false
+		for _, key := range config.Encryption.Keys {
+			if len(key.KeyID) == 0 || len(key.KeyID) > 32 {
+				errs = append(errs, "encryption.keys key_id '"+key.KeyID+"' must have between 1 and 32 characters")
+			}
+			decodedKey, e := base64.StdEncoding.DecodeString(key.Key)
+			if e != nil || len(decodedKey) != 32 {
+				errs = append(errs, "encryption.keys key for '"+key.KeyID+"' must be 32 bytes encoded as base64")
+			}
+			if key.KeyID == config.Encryption.ActiveKeyID {
+				activeKeyFound = true
+			}
+		}
+		if !activeKeyFound {
+			errs = append(errs, "encryption.active_key_id must be one of encryption.keys")
+		}
+	}
+
+	if !config.Quotas.Enabled && (config.Quotas.DefaultMaxSize != "" || len(config.Quotas.Tenants) > 0) {
+		errs = append(errs, "quotas.enabled must be set when quotas are configured")
+	}
+	if config.Quotas.DefaultMaxSize != "" {
+		if _, e := bytefmt.ToBytes(config.Quotas.DefaultMaxSize); e != nil {
+			errs = append(errs, "quotas.default_max_size is invalid. Caused by: "+e.Error())
+		}
+	}
+	quotaTenants := make(map[string]bool, len(config.Quotas.Tenants))
+	for _, tenantQuota := range config.Quotas.Tenants {
+		if tenantQuota.Tenant == "" {
+			errs = append(errs, "quotas.tenants must not contain an empty tenant")
+		}
+		if quotaTenants[tenantQuota.Tenant] {
+			errs = append(errs, "quotas.tenants contains tenant '"+tenantQuota.Tenant+"' more than once")
+		}
+		quotaTenants[tenantQuota.Tenant] = true
+		if tenantQuota.MaxSize != "" {
+			if _, e := bytefmt.ToBytes(tenantQuota.MaxSize); e != nil {
+				errs = append(errs, "quotas.tenants max_size for '"+tenantQuota.Tenant+"' is invalid. Caused by: "+e.Error())
+			}
+		}
+	}
+
 	if len(config.SigningKeys) > 0 && config.ActiveKeyID == "" {
 		errs = append(errs, "When providing signing_keys, you must also provide active_key_id.")
 	}
@@ -428,12 +1039,74 @@ func verifyBlobstoreType(blobstoreType BlobstoreType, resourceType string, errs
 	}
 }
 
+func verifyCompressionConfig(compressionConfig CompressionConfig, resourceType string, errs *[]string) {
+	if compressionConfig.Algorithm != "" && compressionConfig.Algorithm != Gzip && compressionConfig.Algorithm != Zstd {
+		*errs = append(*errs, "Compression algorithm '"+string(compressionConfig.Algorithm)+"' for "+resourceType+" is invalid. Valid algorithms are: gzip, zstd")
+	}
+	if compressionConfig.MinSize != "" {
+		if _, e := bytefmt.ToBytes(compressionConfig.MinSize); e != nil {
+			*errs = append(*errs, resourceType+".compression.min_size is invalid. Caused by: "+e.Error())
+		}
+	}
+}
+
 func verifyBlobstoreConfig(blobstoreConfig BlobstoreConfig, resourceType string, errs *[]string) {
 	if blobstoreConfig.BlobstoreType == "" { // Already handled
 		return
 	}
 	if blobstoreConfigIsNil(blobstoreConfig) {
 		*errs = append(*errs, resourceType+" blobstore config is missing "+string(blobstoreConfig.BlobstoreType)+" config")
+		return
+	}
+	switch blobstoreConfig.BlobstoreType {
+	case Replicated:
+		verifyReplicatedBlobstoreConfig(*blobstoreConfig.ReplicatedConfig, resourceType, errs)
+	case AWS:
+		verifyMultipartUploadConfig(blobstoreConfig.S3Config.PartSize, 5*1024*1024, blobstoreConfig.S3Config.UploadConcurrency, resourceType, errs)
+	case Alibaba:
+		verifyMultipartUploadConfig(blobstoreConfig.AlibabaConfig.PartSize, 100*1024, blobstoreConfig.AlibabaConfig.UploadConcurrency, resourceType, errs)
+	}
+}
+
+func verifyMultipartUploadConfig(partSize string, minPartSize uint64, uploadConcurrency int, resourceType string, errs *[]string) {
+	if partSize != "" {
+		bytes, e := bytefmt.ToBytes(partSize)
+		if e != nil {
+			*errs = append(*errs, resourceType+" blobstore part_size is invalid. Caused by: "+e.Error())
+		} else if bytes < minPartSize {
+			*errs = append(*errs, resourceType+" blobstore part_size must be at least "+bytefmt.ByteSize(minPartSize))
+		}
+	}
+	if uploadConcurrency < 0 {
+		*errs = append(*errs, resourceType+" blobstore upload_concurrency must not be negative")
+	}
+}
+
+func verifyReplicatedBlobstoreConfig(replicatedConfig ReplicatedBlobstoreConfig, resourceType string, errs *[]string) {
+	if len(replicatedConfig.Secondaries) == 0 {
+		*errs = append(*errs, resourceType+" replicated blobstore must have at least one secondary")
+	}
+	backends := append([]BlobstoreConfig{replicatedConfig.Primary}, replicatedConfig.Secondaries...)
+	for i, backend := range backends {
+		name := resourceType + " primary"
+		if i > 0 {
+			name = resourceType + " secondary " + strconv.Itoa(i)
+		}
+		if backend.BlobstoreType == Replicated {
+			*errs = append(*errs, name+" blobstore must not be replicated")
+			continue
+		}
+		verifyBlobstoreType(backend.BlobstoreType, name, errs)
+		verifyBlobstoreConfig(backend, name, errs)
+	}
+	if writeMode := replicatedConfig.WriteModeOrDefault(); writeMode != SyncReplication && writeMode != AsyncReplication {
+		*errs = append(*errs, resourceType+" replicated blobstore write_mode '"+string(writeMode)+"' is invalid. Valid write modes are: sync, async")
+	}
+	if replicatedConfig.QueueSize < 0 {
+		*errs = append(*errs, resourceType+" replicated blobstore queue_size must not be negative")
+	}
+	if replicatedConfig.MaxOrphanRemovals < 0 {
+		*errs = append(*errs, resourceType+" replicated blobstore max_orphan_removals must not be negative")
 	}
 }
 
@@ -453,13 +1126,31 @@ func blobstoreConfigIsNil(blobstoreConfig BlobstoreConfig) bool {
 		return blobstoreConfig.WebdavConfig == nil || *blobstoreConfig.WebdavConfig == (WebdavBlobstoreConfig{})
 	case Alibaba:
 		return blobstoreConfig.AlibabaConfig == nil || *blobstoreConfig.AlibabaConfig == (AlibabaBlobstoreConfig{})
+	case Replicated:
+		return blobstoreConfig.ReplicatedConfig == nil
 	default:
 		return true
 	}
 }
 
+func normalizeBlobstoreType(c *BlobstoreConfig) {
+	c.BlobstoreType = BlobstoreType(strings.ToLower(string(c.BlobstoreType)))
+	if c.BlobstoreType == Replicated && c.ReplicatedConfig != nil {
+		normalizeBlobstoreType(&c.ReplicatedConfig.Primary)
+		for i := range c.ReplicatedConfig.Secondaries {
+			normalizeBlobstoreType(&c.ReplicatedConfig.Secondaries[i])
+		}
+	}
+}
+
 func setSignatureVersionDefault(c *BlobstoreConfig) {
 	if c.BlobstoreType == AWS && c.S3Config.SignatureVersion == 0 {
 		c.S3Config.SignatureVersion = 4
 	}
+	if c.BlobstoreType == Replicated && c.ReplicatedConfig != nil {
+		setSignatureVersionDefault(&c.ReplicatedConfig.Primary)
+		for i := range c.ReplicatedConfig.Secondaries {
+			setSignatureVersionDefault(&c.ReplicatedConfig.Secondaries[i])
+		}
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	. "github.com/cloudfoundry-incubator/bits-service/config"
 	"github.com/onsi/ginkgo"
@@ -201,6 +202,892 @@ app_stash_config:
 		})
 	})
 
+	Context("app_stash_config.garbage_collection", func() {
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+app_stash_config:
+  garbage_collection:
+    max_age_hours: 720
+    max_total_size: 10G
+    dry_run: true
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.AppStashConfig.GarbageCollection.MaxAge()).To(Equal(720 * time.Hour))
+			Expect(config.AppStashConfig.GarbageCollection.MaxTotalSizeBytes()).To(Equal(uint64(10737418240)))
+			Expect(config.AppStashConfig.GarbageCollection.Interval()).To(Equal(time.Hour))
+			Expect(config.AppStashConfig.GarbageCollection.DryRun).To(BeTrue())
+		})
+
+		It("returns an error when neither max_age_hours nor max_total_size is configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+app_stash_config:
+  garbage_collection:
+    dry_run: true
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("app_stash_config.garbage_collection must have max_age_hours or max_total_size configured")))
+		})
+	})
+
+	Context("buildpack_reaper", func() {
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+buildpack_reaper:
+  uncommitted_ttl_hours: 6
+  interval_minutes: 10
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.BuildpackReaper.UncommittedTTL()).To(Equal(6 * time.Hour))
+			Expect(config.BuildpackReaper.Interval()).To(Equal(10 * time.Minute))
+		})
+
+		It("returns an error when the TTL is negative", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+buildpack_reaper:
+  uncommitted_ttl_hours: -1
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("buildpack_reaper.uncommitted_ttl_hours and interval_minutes must not be negative")))
+		})
+	})
+
+	Context("drain", func() {
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+drain:
+  timeout_seconds: 120
+  not_ready_delay_seconds: 15
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Drain.Timeout()).To(Equal(2 * time.Minute))
+			Expect(config.Drain.NotReadyDelay()).To(Equal(15 * time.Second))
+		})
+	})
+
+	Context("readiness_probes", func() {
+		It("uses defaults when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.ReadinessProbes.Timeout()).To(Equal(10 * time.Second))
+			Expect(config.ReadinessProbes.CacheDuration()).To(Equal(30 * time.Second))
+		})
+	})
+
+	Context("metrics", func() {
+		It("defaults to statsd", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Metrics.BackendsOrDefault()).To(Equal([]MetricsBackend{Statsd}))
+		})
+
+		It("reads the port for Prometheus", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+metrics:
+  backends: [prometheus]
+  prometheus_port: 9100
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Metrics.PrometheusPort).To(Equal(9100))
+		})
+
+		It("returns an error for invalid backends", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+metrics:
+  backends: [prometheus, graphite]
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("metrics.backends contains invalid backend 'graphite'")))
+		})
+	})
+
+	Context("content_addressable", func() {
+		It("can be enabled for packages and droplets", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: local
+  content_addressable: true
+  local_config:
+    path_prefix: dummy
+droplets:
+  blobstore_type: google
+  content_addressable: true
+  gcp_config:
+    bucket: dummy
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Packages.ContentAddressable).To(BeTrue())
+			Expect(config.Droplets.ContentAddressable).To(BeTrue())
+			Expect(config.Buildpacks.ContentAddressable).To(BeFalse())
+		})
+
+		It("returns an error when enabled for other resources", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: local
+  local_config:
+    path_prefix: dummy
+droplets:
+  blobstore_type: google
+  gcp_config:
+    bucket: dummy
+buildpacks:
+  blobstore_type: aws
+  content_addressable: true
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("content_addressable is only supported for packages and droplets")))
+		})
+	})
+
+	Context("compression", func() {
+		It("can be enabled per resource type", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: local
+  local_config:
+    path_prefix: dummy
+droplets:
+  blobstore_type: google
+  gcp_config:
+    bucket: dummy
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  compression:
+    enabled: true
+    algorithm: zstd
+    min_size: 4K
+  webdav_config:
+    directory_key: dummy
+buildpack_cache:
+  compression:
+    enabled: true
+`)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Packages.Compression.Enabled).To(BeFalse())
+			Expect(config.AppStash.Compression.Enabled).To(BeTrue())
+			Expect(config.AppStash.Compression.AlgorithmOrDefault()).To(Equal(Zstd))
+			Expect(config.AppStash.Compression.MinSizeBytes()).To(BeEquivalentTo(4096))
+			Expect(config.BuildpackCache.Compression.Enabled).To(BeTrue())
+			Expect(config.BuildpackCache.Compression.AlgorithmOrDefault()).To(Equal(Gzip))
+			Expect(config.BuildpackCache.Compression.MinSizeBytes()).To(BeEquivalentTo(1024))
+		})
+
+		It("returns an error for unknown algorithms", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: local
+  compression:
+    enabled: true
+    algorithm: lzma
+  local_config:
+    path_prefix: dummy
+droplets:
+  blobstore_type: google
+  gcp_config:
+    bucket: dummy
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("Compression algorithm 'lzma' for packages is invalid")))
+		})
+	})
+
+	Context("replicated", func() {
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: replicated
+  replicated_config:
+    write_mode: async
+    repair_interval_minutes: 60
+    run_repair: true
+    remove_orphans: true
+    primary:
+      blobstore_type: AWS
+      s3_config:
+        bucket: dummy
+    secondaries:
+    - blobstore_type: Google
+      gcp_config:
+        bucket: dummy
+droplets:
+  blobstore_type: google
+  gcp_config:
+    bucket: dummy
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Packages.BlobstoreType).To(Equal(Replicated))
+			replicatedConfig := config.Packages.ReplicatedConfig
+			Expect(replicatedConfig.WriteModeOrDefault()).To(Equal(AsyncReplication))
+			Expect(replicatedConfig.QueueSizeOrDefault()).To(Equal(1000))
+			Expect(replicatedConfig.RepairInterval()).To(Equal(time.Hour))
+			Expect(replicatedConfig.RunRepair).To(BeTrue())
+			Expect(replicatedConfig.MaxOrphanRemovalsOrDefault()).To(Equal(100))
+			Expect(replicatedConfig.Primary.BlobstoreType).To(Equal(AWS))
+			Expect(replicatedConfig.Primary.S3Config.Bucket).To(Equal("dummy"))
+			Expect(replicatedConfig.Primary.S3Config.SignatureVersion).To(Equal(4))
+			Expect(replicatedConfig.Secondaries).To(HaveLen(1))
+			Expect(replicatedConfig.Secondaries[0].BlobstoreType).To(Equal(Google))
+			Expect(replicatedConfig.Secondaries[0].GCPConfig.Bucket).To(Equal("dummy"))
+		})
+
+		It("returns an error when secondaries are missing or replicated themselves", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: replicated
+  replicated_config:
+    primary:
+      blobstore_type: aws
+      s3_config:
+        bucket: dummy
+droplets:
+  blobstore_type: replicated
+  replicated_config:
+    write_mode: eventually
+    primary:
+      blobstore_type: aws
+      s3_config:
+        bucket: dummy
+    secondaries:
+    - blobstore_type: replicated
+      replicated_config: {}
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(SatisfyAll(
+				ContainSubstring("packages replicated blobstore must have at least one secondary"),
+				ContainSubstring("droplets secondary 1 blobstore must not be replicated"),
+				ContainSubstring("droplets replicated blobstore write_mode 'eventually' is invalid"))))
+		})
+	})
+
+	Context("multipart uploads", func() {
+		It("uses defaults when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Buildpacks.S3Config.PartSizeBytes()).To(BeEquivalentTo(64 * 1024 * 1024))
+			Expect(config.Buildpacks.S3Config.UploadConcurrencyOrDefault()).To(Equal(4))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: alibaba
+  alibaba_config:
+    bucket_name: dummy
+    part_size: 1M
+    upload_concurrency: 2
+droplets:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+    part_size: 128M
+    upload_concurrency: 16
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Packages.AlibabaConfig.PartSizeBytes()).To(BeEquivalentTo(1024 * 1024))
+			Expect(config.Packages.AlibabaConfig.UploadConcurrencyOrDefault()).To(Equal(2))
+			Expect(config.Droplets.S3Config.PartSizeBytes()).To(BeEquivalentTo(128 * 1024 * 1024))
+			Expect(config.Droplets.S3Config.UploadConcurrencyOrDefault()).To(Equal(16))
+		})
+
+		It("returns an error when the part size is too small or the concurrency negative", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+packages:
+  blobstore_type: local
+  local_config:
+    path_prefix: dummy
+droplets:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+    part_size: 1M
+buildpacks:
+  blobstore_type: aws
+  s3_config:
+    bucket: dummy
+    upload_concurrency: -1
+app_stash:
+  blobstore_type: webdav
+  webdav_config:
+    directory_key: dummy
+`)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(SatisfyAll(
+				ContainSubstring("droplets blobstore part_size must be at least 5M"),
+				ContainSubstring("buildpacks blobstore upload_concurrency must not be negative"))))
+		})
+	})
+
+	Context("encryption", func() {
+		It("is disabled when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Encryption.Enabled()).To(BeFalse())
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+encryption:
+  active_key_id: key-2
+  keys:
+  - key_id: key-1
+    key: MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=
+  - key_id: key-2
+    key: ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Encryption.Enabled()).To(BeTrue())
+			Expect(config.Encryption.KeysMap()).To(Equal(map[string][]byte{
+				"key-1": []byte("0123456789abcdef0123456789abcdef"),
+				"key-2": []byte("fedcba9876543210fedcba9876543210"),
+			}))
+		})
+
+		It("returns an error for invalid keys", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+encryption:
+  active_key_id: key-3
+  keys:
+  - key_id: key-1
+    key: dG9vIHNob3J0
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(SatisfyAll(
+				ContainSubstring("encryption.keys key for 'key-1' must be 32 bytes encoded as base64"),
+				ContainSubstring("encryption.active_key_id must be one of encryption.keys"))))
+		})
+	})
+
+	Context("quotas", func() {
+		It("is disabled when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Quotas.Enabled).To(BeFalse())
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+quotas:
+  enabled: true
+  default_max_size: 10G
+  tenants:
+  - tenant: big-space
+    max_size: 50G
+  - tenant: unlimited-space
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Quotas.Enabled).To(BeTrue())
+			Expect(config.Quotas.DefaultMaxSizeBytes()).To(BeEquivalentTo(10 * 1024 * 1024 * 1024))
+			Expect(config.Quotas.TenantMaxSizesMap()).To(Equal(map[string]uint64{
+				"big-space":       50 * 1024 * 1024 * 1024,
+				"unlimited-space": 0,
+			}))
+		})
+
+		It("returns an error for invalid quotas", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+quotas:
+  default_max_size: lots
+  tenants:
+  - tenant: space
+  - tenant: space
+    max_size: 1G
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(SatisfyAll(
+				ContainSubstring("quotas.enabled must be set when quotas are configured"),
+				ContainSubstring("quotas.default_max_size is invalid"),
+				ContainSubstring("quotas.tenants contains tenant 'space' more than once"))))
+		})
+	})
+
+	Context("tracing", func() {
+		It("uses defaults when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Tracing.ExporterOrDefault()).To(Equal(NoTracing))
+			Expect(config.Tracing.OTLPEndpointOrDefault()).To(Equal("localhost:4318"))
+			Expect(config.Tracing.SamplingRatioOrDefault()).To(Equal(1.0))
+			Expect(config.Tracing.ServiceNameOrDefault()).To(Equal("bits-service"))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+tracing:
+  exporter: otlp
+  otlp_endpoint: collector:4318
+  otlp_insecure: true
+  sampling_ratio: 0
+  service_name: bits-service-z1
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.Tracing.ExporterOrDefault()).To(Equal(OTLPExporter))
+			Expect(config.Tracing.OTLPEndpointOrDefault()).To(Equal("collector:4318"))
+			Expect(config.Tracing.OTLPInsecure).To(BeTrue())
+			Expect(config.Tracing.SamplingRatioOrDefault()).To(Equal(0.0))
+			Expect(config.Tracing.ServiceNameOrDefault()).To(Equal("bits-service-z1"))
+		})
+
+		It("returns an error for invalid exporters and sampling ratios", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+tracing:
+  exporter: jaeger
+  sampling_ratio: 1.5
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(SatisfyAll(
+				ContainSubstring("tracing.exporter 'jaeger' is invalid"),
+				ContainSubstring("tracing.sampling_ratio must be between 0 and 1"))))
+		})
+	})
+
+	Context("async_uploads", func() {
+		It("uses defaults when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.AsyncUploads.JobsDirectory).To(BeEmpty())
+			Expect(config.AsyncUploads.StatusRetention()).To(Equal(24 * time.Hour))
+			Expect(config.AsyncUploads.MaxConcurrentUploadsOrDefault()).To(Equal(10))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+async_uploads:
+  jobs_directory: /var/vcap/data/bits-service/upload_jobs
+  status_retention_hours: 2
+  max_concurrent_uploads: 3
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.AsyncUploads.JobsDirectory).To(Equal("/var/vcap/data/bits-service/upload_jobs"))
+			Expect(config.AsyncUploads.StatusRetention()).To(Equal(2 * time.Hour))
+			Expect(config.AsyncUploads.MaxConcurrentUploadsOrDefault()).To(Equal(3))
+		})
+	})
+
+	Context("resumable_uploads", func() {
+		It("is disabled when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.ResumableUploads.Enabled()).To(BeFalse())
+			Expect(config.ResumableUploads.SessionTTL()).To(Equal(24 * time.Hour))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+resumable_uploads:
+  staging_directory: /var/vcap/data/bits-service/upload_sessions
+  session_ttl_hours: 6
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.ResumableUploads.Enabled()).To(BeTrue())
+			Expect(config.ResumableUploads.StagingDirectory).To(Equal("/var/vcap/data/bits-service/upload_sessions"))
+			Expect(config.ResumableUploads.SessionTTL()).To(Equal(6 * time.Hour))
+		})
+	})
+
+	Context("registry_auth", func() {
+		It("is disabled when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.RegistryAuth.Enabled).To(BeFalse())
+			Expect(config.RegistryAuth.TokenTTL()).To(Equal(5 * time.Minute))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+signing_users:
+  - username: the-username
+    password: the-password
+registry_auth:
+  enabled: true
+  token_ttl_seconds: 60
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.RegistryAuth.Enabled).To(BeTrue())
+			Expect(config.RegistryAuth.TokenTTL()).To(Equal(time.Minute))
+		})
+
+		It("returns an error when there are no signing_users", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+registry_auth:
+  enabled: true
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("registry_auth requires signing_users")))
+		})
+	})
+
+	Context("registry_push", func() {
+		It("is disabled when not configured", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.RegistryPush.Enabled).To(BeFalse())
+			Expect(config.RegistryPush.MaxBlobSizeBytes()).To(BeEquivalentTo(1 << 30))
+		})
+
+		It("can be read", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+signing_users:
+  - username: the-username
+    password: the-password
+enable_registry: true
+registry_auth:
+  enabled: true
+registry_push:
+  enabled: true
+  max_blob_size: 2M
+`+
+				dummyBlobstoreConfigs)
+			config, e := LoadConfig(configFile.Name())
+			Expect(e).NotTo(HaveOccurred())
+			Expect(config.RegistryPush.Enabled).To(BeTrue())
+			Expect(config.RegistryPush.MaxBlobSizeBytes()).To(BeEquivalentTo(2 << 20))
+		})
+
+		It("returns an error when registry_auth is not enabled", func() {
+			fmt.Fprintf(configFile, "%s", `
+public_endpoint: https://public.127.0.0.1.nip.io
+private_endpoint: https://internal.127.0.0.1.nip.io
+port: 8000
+secret: geheim
+key_file: /some/path
+cert_file: /some/path
+enable_registry: true
+registry_push:
+  enabled: true
+`+
+				dummyBlobstoreConfigs)
+			_, e := LoadConfig(configFile.Name())
+			Expect(e).To(MatchError(ContainSubstring("registry_push requires enable_registry and registry_auth")))
+		})
+	})
+
 	It("returns an error when blobstores are not configured", func() {
 		fmt.Fprintf(configFile, "%s", `
 privatebuildpacks:
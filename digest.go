@@ -0,0 +1,128 @@
+package bitsgo
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ExpectedDigest is a single instance-digest from a Digest header as described in RFC 3230.
+type ExpectedDigest struct {
+	Algorithm string
+	Value     []byte
+}
+
+var supportedDigestAlgorithms = map[string]struct {
+	name    string
+	newHash func() hash.Hash
+	size    int
+}{
+	"sha256":  {"sha-256", sha256.New, sha256.Size},
+	"sha-256": {"sha-256", sha256.New, sha256.Size},
+	"sha512":  {"sha-512", sha512.New, sha512.Size},
+	"sha-512": {"sha-512", sha512.New, sha512.Size},
+}
+
+// ParseDigestHeader parses a Digest header of the form "sha-256=<value>, sha-512=<value>".
+// Values can be hex encoded (which is what Cloud Controller sends) or base64 encoded (as RFC 3230 suggests).
+// Algorithms we don't know are ignored, but at least one supported algorithm must be present.
+func ParseDigestHeader(digestHeader string) ([]ExpectedDigest, error) {
+	var digests []ExpectedDigest
+	for _, instanceDigest := range strings.Split(digestHeader, ",") {
+		parts := strings.SplitN(strings.TrimSpace(instanceDigest), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Digest must have format <algorithm>=<value>, but is '%v'", digestHeader)
+		}
+		algorithm, found := supportedDigestAlgorithms[strings.ToLower(parts[0])]
+		if !found {
+			continue
+		}
+		if parts[1] == "" {
+			return nil, fmt.Errorf("Digest must have format <algorithm>=<value>. Value for %v cannot be empty", parts[0])
+		}
+		value, e := decodeDigestValue(parts[1], algorithm.size)
+		if e != nil {
+			return nil, fmt.Errorf("Digest value for %v is neither a hex nor a base64 encoded checksum: '%v'", parts[0], parts[1])
+		}
+		digests = append(digests, ExpectedDigest{Algorithm: algorithm.name, Value: value})
+	}
+	if len(digests) == 0 {
+		return nil, fmt.Errorf("Digest must contain at least one of sha256, sha-256, sha512, sha-512, but is '%v'", digestHeader)
+	}
+	return digests, nil
+}
+
+func decodeDigestValue(value string, size int) ([]byte, error) {
+	if len(value) == hex.EncodedLen(size) {
+		if decoded, e := hex.DecodeString(value); e == nil {
+			return decoded, nil
+		}
+	}
+	decoded, e := base64.StdEncoding.DecodeString(value)
+	if e != nil {
+		return nil, e
+	}
+	if len(decoded) != size {
+		return nil, fmt.Errorf("Decoded value has wrong length %v", len(decoded))
+	}
+	return decoded, nil
+}
+
+type DigestMismatchError struct {
+	error
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func NewDigestMismatchError(algorithm string, expected, actual []byte) *DigestMismatchError {
+	return &DigestMismatchError{
+		error:     fmt.Errorf("Digest header does not match content. Digest header has %v=%x, but content has %v=%x", algorithm, expected, algorithm, actual),
+		Algorithm: algorithm,
+		Expected:  hex.EncodeToString(expected),
+		Actual:    hex.EncodeToString(actual),
+	}
+}
+
+// digestWriter computes all checksums needed to verify a set of expected digests while content is streamed through it.
+// It always computes sha-256, because that's what we use to address the content in the blobstore.
+type digestWriter struct {
+	hashes map[string]hash.Hash
+}
+
+func newDigestWriter(expectedDigests []ExpectedDigest) *digestWriter {
+	hashes := map[string]hash.Hash{"sha-256": sha256.New()}
+	for _, expectedDigest := range expectedDigests {
+		if _, exists := hashes[expectedDigest.Algorithm]; !exists {
+			hashes[expectedDigest.Algorithm] = supportedDigestAlgorithms[expectedDigest.Algorithm].newHash()
+		}
+	}
+	return &digestWriter{hashes: hashes}
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (w *digestWriter) Sha256() string {
+	return hex.EncodeToString(w.hashes["sha-256"].Sum(nil))
+}
+
+// returns *DigestMismatchError for the first digest that does not match
+func (w *digestWriter) Verify(expectedDigests []ExpectedDigest) error {
+	for _, expectedDigest := range expectedDigests {
+		actual := w.hashes[expectedDigest.Algorithm].Sum(nil)
+		if subtle.ConstantTimeCompare(actual, expectedDigest.Value) != 1 {
+			return NewDigestMismatchError(expectedDigest.Algorithm, expectedDigest.Value, actual)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package bitsgo_test
+
+import (
+	"encoding/hex"
+
+	. "github.com/cloudfoundry-incubator/bits-service"
+)
+
+var _ = Describe("ParseDigestHeader", func() {
+	It("parses a single hex encoded sha256 as sent by Cloud Controller", func() {
+		digests, e := ParseDigestHeader("sha256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76")
+
+		Expect(e).NotTo(HaveOccurred())
+		Expect(digests).To(HaveLen(1))
+		Expect(digests[0].Algorithm).To(Equal("sha-256"))
+		Expect(hex.EncodeToString(digests[0].Value)).To(Equal("5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"))
+	})
+
+	It("parses RFC 3230 multi-value headers with base64 values and ignores unknown algorithms", func() {
+		digests, e := ParseDigestHeader("MD5=HUXZLQLMuI/KZ5KDcJPcOA==, SHA-256=U1jDeUKwEmCEuxb31gJ4jQBBbgG8P9ATL0RY3TVdjnY=")
+
+		Expect(e).NotTo(HaveOccurred())
+		Expect(digests).To(HaveLen(1))
+		Expect(digests[0].Algorithm).To(Equal("sha-256"))
+		Expect(hex.EncodeToString(digests[0].Value)).To(Equal("5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"))
+	})
+
+	It("fails when no supported algorithm is present", func() {
+		_, e := ParseDigestHeader("md5=HUXZLQLMuI/KZ5KDcJPcOA==")
+
+		Expect(e).To(HaveOccurred())
+	})
+
+	It("fails when a value is empty", func() {
+		_, e := ParseDigestHeader("sha256=")
+
+		Expect(e).To(HaveOccurred())
+	})
+
+	It("fails when a value has the wrong length", func() {
+		_, e := ParseDigestHeader("sha-512=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76")
+
+		Expect(e).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,180 @@
+package gc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// Accesses within this period after the last recorded one are not recorded again,
+// so that matching resources against the app stash does not write a marker for every request.
+const accessRecordingInterval = 10 * time.Minute
+
+// AccessLog remembers when blobs were last used. It keeps a marker <path>/<unix nano time> per blob in a blobstore
+// which all bits-service instances share, so that the access times survive restarts and every instance sees
+// the accesses of all others. Reading the times only needs to list the markers.
+//
+// Accesses are only collected in memory while requests are served. Flush writes them to the blobstore.
+type AccessLog struct {
+	store bitsgo.Blobstore
+	clock clock.Clock
+
+	mutex sync.Mutex
+	// recorded holds when this instance last recorded an access of a path, so that it can throttle recording.
+	recorded map[string]time.Time
+	// pending holds the accesses which are not flushed yet.
+	pending map[string]time.Time
+
+	flushMutex sync.Mutex
+}
+
+func NewAccessLog(store bitsgo.Blobstore, clock clock.Clock) *AccessLog {
+	return &AccessLog{
+		store:    store,
+		clock:    clock,
+		recorded: make(map[string]time.Time),
+		pending:  make(map[string]time.Time),
+	}
+}
+
+func (accessLog *AccessLog) RecordAccess(path string) {
+	accessLog.mutex.Lock()
+	defer accessLog.mutex.Unlock()
+
+	now := accessLog.clock.Now()
+	if previous, recorded := accessLog.recorded[path]; recorded && now.Sub(previous) < accessRecordingInterval {
+		return
+	}
+	accessLog.recorded[path] = now
+	accessLog.pending[path] = now
+}
+
+func (accessLog *AccessLog) FlushPeriodically(interval time.Duration) {
+	runPeriodically(accessLog.clock, interval, "Flushing access log", accessLog.Flush)
+}
+
+// Flush writes the accesses recorded since the last flush. For every path, it replaces all older markers, including
+// the ones other instances wrote, so that only the latest marker of a path remains.
+func (accessLog *AccessLog) Flush() error {
+	accessLog.flushMutex.Lock()
+	defer accessLog.flushMutex.Unlock()
+
+	accessLog.mutex.Lock()
+	pending := accessLog.pending
+	accessLog.pending = make(map[string]time.Time)
+	for path, lastRecorded := range accessLog.recorded {
+		if accessLog.clock.Since(lastRecorded) >= accessRecordingInterval {
+			delete(accessLog.recorded, path)
+		}
+	}
+	accessLog.mutex.Unlock()
+
+	var errs []error
+	for path, accessTime := range pending {
+		e := accessLog.writeMarker(path, accessTime)
+		if e != nil {
+			errs = append(errs, e)
+			accessLog.mutex.Lock()
+			// Without it, the next access records the path again.
+			delete(accessLog.recorded, path)
+			accessLog.mutex.Unlock()
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Errorf("Could not record %v of %v accesses: %v", len(errs), len(pending), errs)
+	}
+	return nil
+}
+
+func (accessLog *AccessLog) writeMarker(path string, accessTime time.Time) error {
+	var previousAccesses []time.Time
+	e := accessLog.forEachMarker(path+"/", func(markedPath string, lastAccess time.Time) {
+		if markedPath == path {
+			previousAccesses = append(previousAccesses, lastAccess)
+		}
+	})
+	if e != nil {
+		return e
+	}
+	latestAccess := accessTime
+	for _, previousAccess := range previousAccesses {
+		if previousAccess.After(latestAccess) {
+			latestAccess = previousAccess
+		}
+	}
+	if latestAccess.Equal(accessTime) {
+		e = accessLog.store.Put(markerPathFor(path, accessTime), bytes.NewReader([]byte(accessTime.UTC().Format(time.RFC3339Nano))))
+		if e != nil {
+			return errors.Wrapf(e, "Could not record access of %v", path)
+		}
+	}
+	for _, previousAccess := range previousAccesses {
+		if previousAccess.Before(latestAccess) {
+			e = accessLog.store.Delete(markerPathFor(path, previousAccess))
+			if e != nil && !bitsgo.IsNotFoundError(e) {
+				return errors.Wrapf(e, "Could not remove superseded access marker of %v", path)
+			}
+		}
+	}
+	return nil
+}
+
+// LastAccesses returns the last recorded access of every path. Paths which were never accessed are missing.
+// Accesses which are not flushed yet are missing, too.
+func (accessLog *AccessLog) LastAccesses() (map[string]time.Time, error) {
+	lastAccesses := make(map[string]time.Time)
+	e := accessLog.forEachMarker("", func(path string, lastAccess time.Time) {
+		if lastAccess.After(lastAccesses[path]) {
+			lastAccesses[path] = lastAccess
+		}
+	})
+	if e != nil {
+		return nil, e
+	}
+	return lastAccesses, nil
+}
+
+func (accessLog *AccessLog) forEachMarker(prefix string, f func(path string, lastAccess time.Time)) error {
+	pageToken := ""
+	for {
+		markers, nextPageToken, e := accessLog.store.List(prefix, pageToken)
+		if e != nil {
+			return errors.Wrap(e, "Could not list access markers")
+		}
+		for _, marker := range markers {
+			separatorIndex := strings.LastIndex(marker.Path, "/")
+			if separatorIndex == -1 {
+				continue
+			}
+			nanos, e := strconv.ParseInt(marker.Path[separatorIndex+1:], 10, 64)
+			if e != nil {
+				logger.Log.Debugw("Skipping invalid access marker", "marker", marker.Path)
+				continue
+			}
+			f(marker.Path[:separatorIndex], time.Unix(0, nanos))
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+func (accessLog *AccessLog) Forget(path string) error {
+	accessLog.mutex.Lock()
+	delete(accessLog.recorded, path)
+	delete(accessLog.pending, path)
+	accessLog.mutex.Unlock()
+	return errors.Wrapf(accessLog.store.DeleteDir(path+"/"), "Could not remove access markers of %v", path)
+}
+
+func markerPathFor(path string, t time.Time) string {
+	return path + "/" + strconv.FormatInt(t.UnixNano(), 10)
+}
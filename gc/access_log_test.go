@@ -0,0 +1,89 @@
+package gc_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	. "github.com/cloudfoundry-incubator/bits-service/gc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLog", func() {
+	var (
+		store     *inmemory.Blobstore
+		accessLog *AccessLog
+		mockClock *clock.Mock
+	)
+
+	BeforeEach(func() {
+		mockClock = clock.NewMock()
+		store = inmemory.NewBlobstore()
+		accessLog = NewAccessLog(store, mockClock)
+	})
+
+	markersOf := func(path string) []string {
+		var markers []string
+		for key := range store.Entries {
+			if strings.HasPrefix(key, path+"/") {
+				markers = append(markers, key)
+			}
+		}
+		return markers
+	}
+
+	It("writes recorded accesses only when flushed", func() {
+		accessLog.RecordAccess("some-sha")
+		accessLog.RecordAccess("other-sha")
+		Expect(store.Entries).To(BeEmpty())
+
+		Expect(accessLog.Flush()).To(Succeed())
+
+		Expect(accessLog.LastAccesses()).To(Equal(map[string]time.Time{
+			"some-sha":  time.Unix(0, mockClock.Now().UnixNano()),
+			"other-sha": time.Unix(0, mockClock.Now().UnixNano()),
+		}))
+	})
+
+	It("records accesses of the same path only once per interval", func() {
+		accessLog.RecordAccess("some-sha")
+		Expect(accessLog.Flush()).To(Succeed())
+		mockClock.Add(time.Minute)
+		accessLog.RecordAccess("some-sha")
+		Expect(accessLog.Flush()).To(Succeed())
+
+		Expect(accessLog.LastAccesses()).To(HaveKeyWithValue("some-sha", time.Unix(0, 0)))
+	})
+
+	It("replaces the markers of a path, including the ones other instances or earlier runs wrote", func() {
+		otherAccessLog := NewAccessLog(store, mockClock)
+		otherAccessLog.RecordAccess("some-sha")
+		Expect(otherAccessLog.Flush()).To(Succeed())
+		mockClock.Add(time.Minute)
+		accessLog.RecordAccess("some-sha")
+		Expect(accessLog.Flush()).To(Succeed())
+		Expect(markersOf("some-sha")).To(HaveLen(1))
+
+		mockClock.Add(time.Hour)
+		restartedAccessLog := NewAccessLog(store, mockClock)
+		restartedAccessLog.RecordAccess("some-sha")
+		Expect(restartedAccessLog.Flush()).To(Succeed())
+
+		Expect(markersOf("some-sha")).To(HaveLen(1))
+		Expect(accessLog.LastAccesses()).To(HaveKeyWithValue("some-sha", time.Unix(0, mockClock.Now().UnixNano())))
+	})
+
+	It("forgets paths together with their accesses which are not flushed yet", func() {
+		accessLog.RecordAccess("some-sha")
+		Expect(accessLog.Flush()).To(Succeed())
+		mockClock.Add(time.Hour)
+		accessLog.RecordAccess("some-sha")
+
+		Expect(accessLog.Forget("some-sha")).To(Succeed())
+		Expect(accessLog.Flush()).To(Succeed())
+
+		Expect(store.Entries).To(BeEmpty())
+	})
+})
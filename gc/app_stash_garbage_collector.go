@@ -0,0 +1,169 @@
+package gc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// Entries used within this period are never removed because of max total size.
+// Cloud Controller usually uploads a package right after matching its resources against the app stash,
+// so removing entries which were just matched would break package uploads.
+const minimumAgeForSizeBasedRemoval = time.Hour
+
+type AppStashGarbageCollector struct {
+	blobstore      bitsgo.Blobstore
+	accessLog      *AccessLog
+	metricsService bitsgo.MetricsService
+	maxAge         time.Duration
+	maxTotalSize   uint64
+	dryRun         bool
+	clock          clock.Clock
+}
+
+// NewAppStashGarbageCollector expects the same blobstore the app stash uses, i.e. one which lists entries by their sha1.
+// A maxAge or maxTotalSize of 0 means no limit.
+func NewAppStashGarbageCollector(blobstore bitsgo.Blobstore, accessLog *AccessLog, metricsService bitsgo.MetricsService, maxAge time.Duration, maxTotalSize uint64, dryRun bool, clock clock.Clock) *AppStashGarbageCollector {
+	return &AppStashGarbageCollector{
+		blobstore:      blobstore,
+		accessLog:      accessLog,
+		metricsService: metricsService,
+		maxAge:         maxAge,
+		maxTotalSize:   maxTotalSize,
+		dryRun:         dryRun,
+		clock:          clock,
+	}
+}
+
+func (gc *AppStashGarbageCollector) RunPeriodically(interval time.Duration) {
+	runPeriodically(gc.clock, interval, "App stash garbage collection", gc.Run)
+}
+
+type entry struct {
+	bitsgo.BlobInfo
+	lastUsed time.Time
+}
+
+// Run removes all entries that were not used within maxAge and, starting with the least recently used one,
+// as many further entries as necessary to get the app stash below maxTotalSize.
+// It also removes the access markers of entries which do not exist anymore.
+func (gc *AppStashGarbageCollector) Run() error {
+	startTime := gc.clock.Now()
+	e := gc.accessLog.Flush()
+	if e != nil {
+		logger.Log.Errorw("Could not flush app stash access log", "error", e)
+	}
+	entries, totalSize, unknownPaths, e := gc.entriesByLastUse()
+	if e != nil {
+		return e
+	}
+
+	var (
+		removedEntries int64
+		removedBytes   int64
+		errs           []error
+	)
+	for _, entry := range entries {
+		expired := gc.maxAge != 0 && startTime.Sub(entry.lastUsed) > gc.maxAge
+		tooBig := gc.maxTotalSize != 0 && uint64(totalSize) > gc.maxTotalSize &&
+			startTime.Sub(entry.lastUsed) > minimumAgeForSizeBasedRemoval
+		if !expired && !tooBig {
+			// entries are sorted by last use, so none of the remaining ones can be expired either
+			break
+		}
+		logger.Log.Debugw("Removing app stash entry", "sha1", entry.Path, "size", entry.Size, "last-used", entry.lastUsed, "dry-run", gc.dryRun)
+		if !gc.dryRun {
+			e = gc.blobstore.Delete(entry.Path)
+			if e != nil && !bitsgo.IsNotFoundError(e) {
+				errs = append(errs, e)
+				continue
+			}
+			e = gc.accessLog.Forget(entry.Path)
+			if e != nil {
+				errs = append(errs, e)
+			}
+		}
+		removedEntries++
+		removedBytes += entry.Size
+		totalSize -= entry.Size
+	}
+	if !gc.dryRun {
+		// e.g. markers of entries removed via another instance's blobstore or of readiness probes
+		for _, path := range unknownPaths {
+			logger.Log.Debugw("Removing access markers of missing app stash entry", "sha1", path)
+			e = gc.accessLog.Forget(path)
+			if e != nil {
+				errs = append(errs, e)
+			}
+		}
+	}
+
+	metricPrefix := "app_stash-gc-"
+	if gc.dryRun {
+		metricPrefix = "app_stash-gc-dry_run-"
+	}
+	gc.metricsService.SendCounterMetric(metricPrefix+"removed_entries", removedEntries)
+	gc.metricsService.SendCounterMetric(metricPrefix+"removed_bytes", removedBytes)
+	gc.metricsService.SendGaugeMetric(metricPrefix+"total_size", totalSize)
+	gc.metricsService.SendTimingMetric(metricPrefix+"time", gc.clock.Now().Sub(startTime))
+	logger.Log.Infow("App stash garbage collection done",
+		"removed-entries", removedEntries,
+		"removed-bytes", removedBytes,
+		"total-size", totalSize,
+		"dry-run", gc.dryRun)
+
+	if len(errs) != 0 {
+		return errors.Errorf("Errors from removing app stash entries: %v", errs)
+	}
+	return nil
+}
+
+// entriesByLastUse fails closed: entries without recorded access, e.g. the ones which existed before access was
+// recorded, count as used just now. Their access is recorded, so that they expire eventually.
+// It also returns the paths with recorded access which are no entries.
+func (gc *AppStashGarbageCollector) entriesByLastUse() (entries []entry, totalSize int64, unknownPaths []string, err error) {
+	// Accesses are listed before entries, so that entries uploaded in between are not taken for unknown paths.
+	lastAccesses, e := gc.accessLog.LastAccesses()
+	if e != nil {
+		return nil, 0, nil, e
+	}
+	listed := make(map[string]bool)
+	pageToken := ""
+	for {
+		blobs, nextPageToken, e := gc.blobstore.List("", pageToken)
+		if e != nil {
+			return nil, 0, nil, errors.Wrap(e, "Could not list app stash entries")
+		}
+		for _, blob := range blobs {
+			listed[blob.Path] = true
+			lastAccess, recorded := lastAccesses[blob.Path]
+			if !recorded {
+				lastAccess = gc.clock.Now()
+				if !gc.dryRun {
+					gc.accessLog.RecordAccess(blob.Path)
+				}
+			}
+			lastUsed := blob.LastModified
+			if lastAccess.After(lastUsed) {
+				lastUsed = lastAccess
+			}
+			entries = append(entries, entry{BlobInfo: blob, lastUsed: lastUsed})
+			totalSize += blob.Size
+		}
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+	for path := range lastAccesses {
+		if !listed[path] {
+			unknownPaths = append(unknownPaths, path)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+	return entries, totalSize, unknownPaths, nil
+}
@@ -0,0 +1,128 @@
+package gc_test
+
+import (
+	"time"
+
+	"github.com/benbjohnson/clock"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	. "github.com/cloudfoundry-incubator/bits-service/gc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/petergtz/pegomock"
+)
+
+var _ = Describe("AppStashGarbageCollector", func() {
+	var (
+		blobstore      *inmemory.Blobstore
+		accessLog      *AccessLog
+		metricsService *MockMetricsService
+		mockClock      *clock.Mock
+	)
+
+	BeforeEach(func() {
+		mockClock = clock.NewMock()
+		mockClock.Add(1000 * time.Hour)
+		blobstore = inmemory.NewBlobstoreWithEntries(map[string][]byte{
+			"never-used":       []byte("12345"),
+			"used-3-hours-ago": []byte("1234"),
+			"used-2-hours-ago": []byte("123"),
+			"used-30-min-ago":  []byte("12"),
+			"used-just-now":    []byte("1"),
+		})
+		accessLog = NewAccessLog(inmemory.NewBlobstore(), mockClock)
+		accessLog.RecordAccess("used-3-hours-ago")
+		mockClock.Add(time.Hour)
+		accessLog.RecordAccess("used-2-hours-ago")
+		mockClock.Add(90 * time.Minute)
+		accessLog.RecordAccess("used-30-min-ago")
+		mockClock.Add(30 * time.Minute)
+		accessLog.RecordAccess("used-just-now")
+		metricsService = NewMockMetricsService()
+	})
+
+	remainingEntries := func() []string {
+		var entries []string
+		for key := range blobstore.Entries {
+			entries = append(entries, key)
+		}
+		return entries
+	}
+
+	It("removes entries which were not used within max age", func() {
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf("never-used", "used-2-hours-ago", "used-30-min-ago", "used-just-now"))
+		metricsService.VerifyWasCalledOnce().SendCounterMetric("app_stash-gc-removed_entries", 1)
+		metricsService.VerifyWasCalledOnce().SendCounterMetric("app_stash-gc-removed_bytes", 4)
+		metricsService.VerifyWasCalledOnce().SendGaugeMetric("app_stash-gc-total_size", 11)
+	})
+
+	It("removes least recently used entries until the app stash is smaller than max total size", func() {
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 0, 11, false, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf("never-used", "used-2-hours-ago", "used-30-min-ago", "used-just-now"))
+	})
+
+	It("does not remove recently used entries to get below max total size", func() {
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 0, 1, false, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf("never-used", "used-30-min-ago", "used-just-now"))
+	})
+
+	It("never removes entries without recorded access, but starts recording them", func() {
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+		Expect(remainingEntries()).To(ContainElement("never-used"))
+
+		mockClock.Add(151 * time.Minute)
+
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+		Expect(remainingEntries()).NotTo(ContainElement("never-used"))
+	})
+
+	It("considers entries as used when they were recorded as accessed after the last run", func() {
+		mockClock.Add(151 * time.Minute)
+		accessLog.RecordAccess("used-30-min-ago")
+
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf("never-used", "used-30-min-ago"))
+	})
+
+	It("considers accesses recorded by other instances", func() {
+		accessLogStore := inmemory.NewBlobstore()
+		accessLog = NewAccessLog(accessLogStore, mockClock)
+		for path := range blobstore.Entries {
+			accessLog.RecordAccess(path)
+		}
+		mockClock.Add(151 * time.Minute)
+		otherAccessLog := NewAccessLog(accessLogStore, mockClock)
+		otherAccessLog.RecordAccess("used-2-hours-ago")
+		Expect(otherAccessLog.Flush()).To(Succeed())
+
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf("used-2-hours-ago"))
+	})
+
+	It("removes the access markers of entries which do not exist anymore", func() {
+		accessLogStore := inmemory.NewBlobstore()
+		accessLog = NewAccessLog(accessLogStore, mockClock)
+		accessLog.RecordAccess("used-just-now")
+		accessLog.RecordAccess("readiness-probe-guid")
+
+		Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, false, mockClock).Run()).To(Succeed())
+
+		Expect(accessLog.LastAccesses()).To(HaveKey("used-just-now"))
+		Expect(accessLog.LastAccesses()).NotTo(HaveKey("readiness-probe-guid"))
+	})
+
+	Context("dry run", func() {
+		It("does not remove anything, but reports what it would remove", func() {
+			Expect(NewAppStashGarbageCollector(blobstore, accessLog, metricsService, 150*time.Minute, 0, true, mockClock).Run()).To(Succeed())
+
+			Expect(remainingEntries()).To(HaveLen(5))
+			metricsService.VerifyWasCalledOnce().SendCounterMetric("app_stash-gc-dry_run-removed_entries", 1)
+			metricsService.VerifyWasCalled(pegomock.Never()).SendCounterMetric("app_stash-gc-removed_entries", 1)
+		})
+	})
+})
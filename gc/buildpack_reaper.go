@@ -0,0 +1,110 @@
+package gc
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+// BuildpackReaper removes buildpacks which Cloud Controller never committed, e.g. because the upload
+// was abandoned after the bits were already stored.
+type BuildpackReaper struct {
+	blobstore      bitsgo.Blobstore
+	metricsService bitsgo.MetricsService
+	ttl            time.Duration
+	clock          clock.Clock
+}
+
+func NewBuildpackReaper(blobstore bitsgo.Blobstore, metricsService bitsgo.MetricsService, ttl time.Duration, clock clock.Clock) *BuildpackReaper {
+	return &BuildpackReaper{blobstore: blobstore, metricsService: metricsService, ttl: ttl, clock: clock}
+}
+
+func (reaper *BuildpackReaper) RunPeriodically(interval time.Duration) {
+	runPeriodically(reaper.clock, interval, "Buildpack reaper", reaper.Run)
+}
+
+// Run removes buildpack, metadata and marker of every buildpack whose uncommitted marker is older than ttl.
+func (reaper *BuildpackReaper) Run() error {
+	startTime := reaper.clock.Now()
+	var (
+		removed   int64
+		errs      []error
+		pageToken string
+	)
+	for {
+		markers, nextPageToken, e := reaper.blobstore.List(bitsgo.UncommittedBuildpackMarkerPrefix, pageToken)
+		if e != nil {
+			return errors.Wrap(e, "Could not list uncommitted buildpacks")
+		}
+		for _, marker := range markers {
+			uploadTime, known, e := reaper.uploadTimeOf(marker)
+			if e != nil {
+				errs = append(errs, e)
+				continue
+			}
+			if !known || startTime.Sub(uploadTime) <= reaper.ttl {
+				continue
+			}
+			identifier := strings.TrimPrefix(marker.Path, bitsgo.UncommittedBuildpackMarkerPrefix)
+			logger.Log.Infow("Removing uncommitted buildpack", "identifier", identifier, "uploaded-at", uploadTime)
+			// The marker must be deleted last. Otherwise a failure would leave the buildpack behind without marker.
+			e = reaper.deleteAll(identifier, identifier+"-metadata", marker.Path)
+			if e != nil {
+				errs = append(errs, e)
+				continue
+			}
+			removed++
+		}
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+	reaper.metricsService.SendCounterMetric("buildpacks-reaper-removed_buildpacks", removed)
+	reaper.metricsService.SendTimingMetric("buildpacks-reaper-time", reaper.clock.Now().Sub(startTime))
+
+	if len(errs) != 0 {
+		return errors.Errorf("Errors from removing uncommitted buildpacks: %v", errs)
+	}
+	return nil
+}
+
+// uploadTimeOf returns known == false for markers which were committed in the meantime and for markers which
+// were not written in RFC 3339 format. Older versions wrote time.Time.String() into every marker and never removed it,
+// because Cloud Controller did not commit buildpacks back then. Such markers do not tell whether a buildpack is in use,
+// so they must never lead to its removal.
+func (reaper *BuildpackReaper) uploadTimeOf(marker bitsgo.BlobInfo) (uploadTime time.Time, known bool, e error) {
+	body, e := reaper.blobstore.Get(marker.Path)
+	if bitsgo.IsNotFoundError(e) {
+		return time.Time{}, false, nil
+	}
+	if e != nil {
+		return time.Time{}, false, errors.Wrapf(e, "Could not read %v", marker.Path)
+	}
+	defer body.Close()
+	content, e := ioutil.ReadAll(body)
+	if e != nil {
+		return time.Time{}, false, errors.Wrapf(e, "Could not read %v", marker.Path)
+	}
+	uploadTime, e = time.Parse(time.RFC3339Nano, string(content))
+	if e != nil {
+		logger.Log.Debugw("Skipping uncommitted marker in legacy format", "marker", marker.Path)
+		return time.Time{}, false, nil
+	}
+	return uploadTime, true, nil
+}
+
+func (reaper *BuildpackReaper) deleteAll(paths ...string) error {
+	for _, path := range paths {
+		e := reaper.blobstore.Delete(path)
+		if e != nil && !bitsgo.IsNotFoundError(e) {
+			return errors.Wrapf(e, "Could not delete %v", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package gc_test
+
+import (
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	. "github.com/cloudfoundry-incubator/bits-service/gc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildpackReaper", func() {
+	var (
+		blobstore      *inmemory.Blobstore
+		metricsService *MockMetricsService
+		mockClock      *clock.Mock
+	)
+
+	BeforeEach(func() {
+		mockClock = clock.NewMock()
+		mockClock.Add(1000 * time.Hour)
+		blobstore = inmemory.NewBlobstoreWithEntries(map[string][]byte{
+			"old":          []byte("old buildpack"),
+			"old-metadata": []byte("{}"),
+			bitsgo.UncommittedBuildpackMarkerPrefix + "old": []byte(
+				mockClock.Now().Add(-25 * time.Hour).UTC().Format(time.RFC3339Nano)),
+			"legacy": []byte("legacy buildpack"),
+			bitsgo.UncommittedBuildpackMarkerPrefix + "legacy": []byte(
+				mockClock.Now().Add(-25*time.Hour).String() + " m=+0.000000001"),
+			"new":          []byte("new buildpack"),
+			"new-metadata": []byte("{}"),
+			bitsgo.UncommittedBuildpackMarkerPrefix + "new": []byte(
+				mockClock.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)),
+			"committed":          []byte("committed buildpack"),
+			"committed-metadata": []byte("{}"),
+		})
+		metricsService = NewMockMetricsService()
+	})
+
+	remainingEntries := func() []string {
+		var entries []string
+		for key := range blobstore.Entries {
+			entries = append(entries, key)
+		}
+		return entries
+	}
+
+	It("removes buildpacks, their metadata and markers when the markers are older than the TTL", func() {
+		Expect(NewBuildpackReaper(blobstore, metricsService, 24*time.Hour, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(ConsistOf(
+			"legacy", bitsgo.UncommittedBuildpackMarkerPrefix+"legacy",
+			"new", "new-metadata", bitsgo.UncommittedBuildpackMarkerPrefix+"new",
+			"committed", "committed-metadata"))
+		metricsService.VerifyWasCalledOnce().SendCounterMetric("buildpacks-reaper-removed_buildpacks", 1)
+	})
+
+	It("never removes buildpacks whose markers were written by older versions", func() {
+		Expect(NewBuildpackReaper(blobstore, metricsService, time.Nanosecond, mockClock).Run()).To(Succeed())
+
+		Expect(blobstore.Entries).To(HaveKey("legacy"))
+		Expect(blobstore.Entries).To(HaveKey(bitsgo.UncommittedBuildpackMarkerPrefix + "legacy"))
+	})
+
+	It("keeps everything when no marker is older than the TTL", func() {
+		Expect(NewBuildpackReaper(blobstore, metricsService, 48*time.Hour, mockClock).Run()).To(Succeed())
+
+		Expect(remainingEntries()).To(HaveLen(10))
+		metricsService.VerifyWasCalledOnce().SendCounterMetric("buildpacks-reaper-removed_buildpacks", 0)
+	})
+})
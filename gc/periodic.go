@@ -0,0 +1,17 @@
+package gc
+
+import (
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+)
+
+func runPeriodically(clock clock.Clock, interval time.Duration, name string, run func() error) {
+	for range clock.Ticker(interval).C {
+		e := run()
+		if e != nil {
+			logger.Log.Errorw(name+" failed", "error", e)
+		}
+	}
+}
@@ -1,9 +1,97 @@
 package bitsgo
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 type MetricsService interface {
 	SendTimingMetric(name string, duration time.Duration)
 	SendGaugeMetric(name string, value int64)
 	SendCounterMetric(name string, value int64)
 }
+
+// RequestMetricsService is implemented by MetricsServices which support labels, e.g. Prometheus.
+type RequestMetricsService interface {
+	ObserveRequest(method string, resourceType string, status int, duration time.Duration, requestSize int64, responseSize int64)
+}
+
+// BlobstoreMetricsService is implemented by MetricsServices which support labels, e.g. Prometheus.
+type BlobstoreMetricsService interface {
+	ObserveBlobstoreOperation(resourceType string, operation string, duration time.Duration)
+}
+
+// ObserveRequest sends the metrics of a finished HTTP request. MetricsServices without label support
+// get them as individual metrics with method, resource type and status concatenated into their names.
+// resourceType is empty for requests not targeting a resource.
+func ObserveRequest(metricsService MetricsService, method string, resourceType string, status int, duration time.Duration, requestSize int64, responseSize int64) {
+	if requestMetricsService, ok := metricsService.(RequestMetricsService); ok {
+		requestMetricsService.ObserveRequest(method, resourceType, status, duration, requestSize, responseSize)
+		return
+	}
+	responseStatus := strconv.Itoa(status)
+	metricsService.SendCounterMetric("status-"+responseStatus, 1)
+	if resourceType != "" {
+		metricsService.SendTimingMetric(method+"-"+resourceType+"-time", duration)
+		metricsService.SendTimingMetric(method+"-"+resourceType+"-"+responseStatus+"-time", duration)
+		metricsService.SendGaugeMetric(method+"-"+resourceType+"-size", responseSize)
+		metricsService.SendGaugeMetric(method+"-"+resourceType+"-request-size", requestSize)
+	}
+}
+
+var legacyBlobstoreOperationNames = map[string]string{
+	"exists":     "exists_in_blobstore",
+	"stat":       "stat_in_blobstore",
+	"list":       "list_in_blobstore",
+	"put":        "cp_to_blobstore",
+	"copy":       "copy_in_blobstore",
+	"delete":     "delete_from_blobstore",
+	"delete_dir": "delete_dir_from_blobstore",
+}
+
+// ObserveBlobstoreOperation sends the duration of a blobstore operation. MetricsServices without label support
+// get it as timing metric with resource type and operation concatenated into its name.
+func ObserveBlobstoreOperation(metricsService MetricsService, resourceType string, operation string, duration time.Duration) {
+	if blobstoreMetricsService, ok := metricsService.(BlobstoreMetricsService); ok {
+		blobstoreMetricsService.ObserveBlobstoreOperation(resourceType, operation, duration)
+		return
+	}
+	legacyName, ok := legacyBlobstoreOperationNames[operation]
+	if !ok {
+		legacyName = operation + "_in_blobstore"
+	}
+	metricsService.SendTimingMetric(resourceType+"-"+legacyName+"-time", duration)
+}
+
+// MetricsServices sends all metrics to each of its MetricsServices.
+type MetricsServices []MetricsService
+
+func (services MetricsServices) SendTimingMetric(name string, duration time.Duration) {
+	for _, service := range services {
+		service.SendTimingMetric(name, duration)
+	}
+}
+
+func (services MetricsServices) SendGaugeMetric(name string, value int64) {
+	for _, service := range services {
+		service.SendGaugeMetric(name, value)
+	}
+}
+
+func (services MetricsServices) SendCounterMetric(name string, value int64) {
+	for _, service := range services {
+		service.SendCounterMetric(name, value)
+	}
+}
+
+func (services MetricsServices) ObserveRequest(method string, resourceType string, status int, duration time.Duration, requestSize int64, responseSize int64) {
+	for _, service := range services {
+		ObserveRequest(service, method, resourceType, status, duration, requestSize, responseSize)
+	}
+}
+
+func (services MetricsServices) ObserveBlobstoreOperation(resourceType string, operation string, duration time.Duration) {
+	for _, service := range services {
+		ObserveBlobstoreOperation(service, resourceType, operation, duration)
+	}
+}
@@ -40,7 +40,7 @@ func (middleware *BasicAuthMiddleware) ServeHTTP(responseWriter http.ResponseWri
 		return
 	}
 
-	if !middleware.authorized(username, password) {
+	if !middleware.Authorized(username, password) {
 		if middleware.unauthorizedHandler == nil {
 			responseWriter.WriteHeader(http.StatusUnauthorized)
 			return
@@ -50,7 +50,8 @@ func (middleware *BasicAuthMiddleware) ServeHTTP(responseWriter http.ResponseWri
 	next(responseWriter, request)
 }
 
-func (middleware *BasicAuthMiddleware) authorized(username, password string) bool {
+// Authorized checks username and password against the configured credentials in constant time.
+func (middleware *BasicAuthMiddleware) Authorized(username, password string) bool {
 	for _, credential := range middleware.credentials {
 		if subtle.ConstantTimeCompare([]byte(username), []byte(credential.Username)) == 1 &&
 			subtle.ConstantTimeCompare([]byte(password), []byte(credential.Password)) == 1 {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/cloudfoundry-incubator/bits-service/util"
 	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -27,6 +28,9 @@ func (middleware *ZapLoggerMiddleware) ServeHTTP(responseWriter http.ResponseWri
 	requestLogger := middleware.logger.With(
 		"request-id", requestId,
 		"vcap-request-id", request.Header.Get("X-Vcap-Request-Id"))
+	if spanContext := trace.SpanContextFromContext(request.Context()); spanContext.HasTraceID() {
+		requestLogger = requestLogger.With("trace-id", spanContext.TraceID().String())
+	}
 
 	requestLogger.Infow(
 		"HTTP Request started",
@@ -2,7 +2,6 @@ package middlewares
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/urfave/negroni"
 
@@ -30,16 +29,13 @@ func (middleware *MetricsMiddleware) ServeHTTP(responseWriter http.ResponseWrite
 
 	next(negroniResponseWriter, request)
 
-	responseStatus := strconv.Itoa(negroniResponseWriter.Status())
-	middleware.metricsService.SendCounterMetric("status-"+responseStatus, 1)
-	resourceType := ResourceTypeFrom(request.URL.Path)
-	if resourceType != "" {
-		duration := time.Since(startTime)
-		middleware.metricsService.SendTimingMetric(request.Method+"-"+resourceType+"-time", duration)
-		middleware.metricsService.SendTimingMetric(request.Method+"-"+resourceType+"-"+responseStatus+"-time", duration)
-		middleware.metricsService.SendGaugeMetric(request.Method+"-"+resourceType+"-size", int64(negroniResponseWriter.Size()))
-		middleware.metricsService.SendGaugeMetric(request.Method+"-"+resourceType+"-request-size", int64(request.ContentLength))
-	}
+	bitsgo.ObserveRequest(middleware.metricsService,
+		request.Method,
+		ResourceTypeFrom(request.URL.Path),
+		negroniResponseWriter.Status(),
+		time.Since(startTime),
+		request.ContentLength,
+		int64(negroniResponseWriter.Size()))
 }
 
 var resourceURLPathPattern = regexp.MustCompile(`^/(packages|droplets|app_stash|buildpacks|buildpack_cache)/`)
@@ -3,6 +3,7 @@ package middlewares
 import (
 	"net/http"
 
+	"github.com/cloudfoundry-incubator/bits-service"
 	"github.com/cloudfoundry-incubator/bits-service/pathsigner"
 )
 
@@ -19,5 +20,6 @@ func (middleware *SignatureVerificationMiddleware) ServeHTTP(responseWriter http
 		responseWriter.WriteHeader(403)
 		return
 	}
-	next(responseWriter, request)
+	// Only the signed claim counts. Clients must not be able to charge uploads to other tenants via the header.
+	next(responseWriter, request.WithContext(bitsgo.ContextWithTenant(request.Context(), request.URL.Query().Get("tenant"))))
 }
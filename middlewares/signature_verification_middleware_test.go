@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
 	"github.com/cloudfoundry-incubator/bits-service/pathsigner"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
@@ -67,6 +68,24 @@ var _ = Describe("Signing URLs", func() {
 		Expect(responseWriter.Code).To(Equal(http.StatusOK))
 	})
 
+	It("only passes on the tenant claim of signed URLs", func() {
+		signedURL := handler.SignForTenant("path", "PUT", mockClock.Now().Add(1*time.Hour), "space-guid")
+
+		var tenant string
+		r := mux.NewRouter()
+		r.Path("/my/path").Methods("PUT").Handler(negroni.New(
+			&SignatureVerificationMiddleware{pathSignerValidator},
+			negroni.WrapFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+				tenant = bitsgo.TenantFrom(request.Context())
+			}),
+		))
+		request := httptest.NewRequest("PUT", signedURL, nil)
+		request.Header.Set(bitsgo.TenantHeader, "other-space-guid")
+		r.ServeHTTP(httptest.NewRecorder(), request)
+
+		Expect(tenant).To(Equal("space-guid"))
+	})
+
 	It("signs and returns an error when URL has expired", func() {
 		// signing
 		responseBody := handler.Sign("path", "get", mockClock.Now().Add(1*time.Hour))
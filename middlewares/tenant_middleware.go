@@ -0,0 +1,15 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+)
+
+// TenantMiddleware makes the tenant of bitsgo.TenantHeader available to handlers via bitsgo.TenantFrom.
+// For requests with signed URLs, SignatureVerificationMiddleware replaces it by the tenant claim.
+type TenantMiddleware struct{}
+
+func (middleware *TenantMiddleware) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	next(responseWriter, request.WithContext(bitsgo.ContextWithTenant(request.Context(), request.Header.Get(bitsgo.TenantHeader))))
+}
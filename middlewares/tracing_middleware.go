@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware continues the trace of an incoming W3C traceparent header, or starts a new one,
+// and records the request as server span. Handlers find the span in the request's context.
+type TracingMiddleware struct{}
+
+func NewTracingMiddleware() *TracingMiddleware {
+	return &TracingMiddleware{}
+}
+
+func (middleware *TracingMiddleware) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	resourceType := ResourceTypeFrom(request.URL.Path)
+	spanName := request.Method
+	if resourceType != "" {
+		spanName += " " + resourceType
+	}
+	ctx, span := bitsgo.Tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", request.Method),
+			attribute.String("http.target", request.URL.Path),
+			attribute.String("http.host", request.Host),
+			attribute.String("bits.resource_type", resourceType),
+			attribute.String("bits.vcap_request_id", request.Header.Get("X-Vcap-Request-Id")),
+		))
+	defer span.End()
+
+	negroniResponseWriter, ok := responseWriter.(negroni.ResponseWriter)
+	if !ok {
+		negroniResponseWriter = negroni.NewResponseWriter(responseWriter)
+	}
+
+	next(negroniResponseWriter, request.WithContext(ctx))
+
+	span.SetAttributes(attribute.Int("http.status_code", negroniResponseWriter.Status()))
+	if negroniResponseWriter.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(negroniResponseWriter.Status()))
+	}
+}
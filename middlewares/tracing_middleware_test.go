@@ -0,0 +1,65 @@
+package middlewares_test
+
+import (
+	http "net/http"
+	"net/http/httptest"
+
+	"github.com/cloudfoundry-incubator/bits-service/middlewares"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("TracingMiddleware", func() {
+	setUpTracing := func() *tracetest.SpanRecorder {
+		spanRecorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		return spanRecorder
+	}
+
+	It("continues the trace from the traceparent header", func() {
+		spanRecorder := setUpTracing()
+		defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+		middleware := middlewares.NewTracingMiddleware()
+		req, e := http.NewRequest("PUT", "http://example.com/packages/someguid", nil)
+		Expect(e).NotTo(HaveOccurred())
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		var spanContextInHandler trace.SpanContext
+		middleware.ServeHTTP(httptest.NewRecorder(), req, func(rw http.ResponseWriter, r *http.Request) {
+			spanContextInHandler = trace.SpanContextFromContext(r.Context())
+			rw.WriteHeader(http.StatusCreated)
+		})
+
+		Expect(spanRecorder.Ended()).To(HaveLen(1))
+		span := spanRecorder.Ended()[0]
+		Expect(span.Name()).To(Equal("PUT packages"))
+		Expect(span.SpanKind()).To(Equal(trace.SpanKindServer))
+		Expect(span.SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(span.Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		Expect(span.Status().Code).To(Equal(codes.Unset))
+		Expect(spanContextInHandler).To(Equal(span.SpanContext()))
+	})
+
+	It("starts a new trace without traceparent header and marks server errors", func() {
+		spanRecorder := setUpTracing()
+		defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+		middleware := middlewares.NewTracingMiddleware()
+		req, e := http.NewRequest("GET", "http://example.com/droplets/someguid", nil)
+		Expect(e).NotTo(HaveOccurred())
+
+		middleware.ServeHTTP(httptest.NewRecorder(), req, func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusInternalServerError)
+		})
+
+		Expect(spanRecorder.Ended()).To(HaveLen(1))
+		span := spanRecorder.Ended()[0]
+		Expect(span.SpanContext().TraceID().IsValid()).To(BeTrue())
+		Expect(span.Parent().IsValid()).To(BeFalse())
+		Expect(span.Status().Code).To(Equal(codes.Error))
+	})
+})
@@ -4,6 +4,7 @@
 package bitsgo_test
 
 import (
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
 	pegomock "github.com/petergtz/pegomock"
 	io "io"
 	"reflect"
@@ -36,6 +37,48 @@ func (mock *MockBlobstore) Exists(path string) (bool, error) {
 	return ret0, ret1
 }
 
+func (mock *MockBlobstore) Stat(path string) (*bitsgo.BlobInfo, error) {
+	if mock == nil {
+		panic("mock must not be nil. Use myMock := NewMockBlobstore().")
+	}
+	params := []pegomock.Param{path}
+	result := pegomock.GetGenericMockFrom(mock).Invoke("Stat", params, []reflect.Type{reflect.TypeOf((**bitsgo.BlobInfo)(nil)).Elem(), reflect.TypeOf((*error)(nil)).Elem()})
+	var ret0 *bitsgo.BlobInfo
+	var ret1 error
+	if len(result) != 0 {
+		if result[0] != nil {
+			ret0 = result[0].(*bitsgo.BlobInfo)
+		}
+		if result[1] != nil {
+			ret1 = result[1].(error)
+		}
+	}
+	return ret0, ret1
+}
+
+func (mock *MockBlobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	if mock == nil {
+		panic("mock must not be nil. Use myMock := NewMockBlobstore().")
+	}
+	params := []pegomock.Param{prefix, pageToken}
+	result := pegomock.GetGenericMockFrom(mock).Invoke("List", params, []reflect.Type{reflect.TypeOf((*[]bitsgo.BlobInfo)(nil)).Elem(), reflect.TypeOf((*string)(nil)).Elem(), reflect.TypeOf((*error)(nil)).Elem()})
+	var ret0 []bitsgo.BlobInfo
+	var ret1 string
+	var ret2 error
+	if len(result) != 0 {
+		if result[0] != nil {
+			ret0 = result[0].([]bitsgo.BlobInfo)
+		}
+		if result[1] != nil {
+			ret1 = result[1].(string)
+		}
+		if result[2] != nil {
+			ret2 = result[2].(error)
+		}
+	}
+	return ret0, ret1, ret2
+}
+
 func (mock *MockBlobstore) HeadOrRedirectAsGet(path string) (string, error) {
 	if mock == nil {
 		panic("mock must not be nil. Use myMock := NewMockBlobstore().")
@@ -202,6 +245,64 @@ func (c *Blobstore_Exists_OngoingVerification) GetAllCapturedArguments() (_param
 	return
 }
 
+func (verifier *VerifierBlobstore) Stat(path string) *Blobstore_Stat_OngoingVerification {
+	params := []pegomock.Param{path}
+	methodInvocations := pegomock.GetGenericMockFrom(verifier.mock).Verify(verifier.inOrderContext, verifier.invocationCountMatcher, "Stat", params)
+	return &Blobstore_Stat_OngoingVerification{mock: verifier.mock, methodInvocations: methodInvocations}
+}
+
+type Blobstore_Stat_OngoingVerification struct {
+	mock              *MockBlobstore
+	methodInvocations []pegomock.MethodInvocation
+}
+
+func (c *Blobstore_Stat_OngoingVerification) GetCapturedArguments() string {
+	path := c.GetAllCapturedArguments()
+	return path[len(path)-1]
+}
+
+func (c *Blobstore_Stat_OngoingVerification) GetAllCapturedArguments() (_param0 []string) {
+	params := pegomock.GetGenericMockFrom(c.mock).GetInvocationParams(c.methodInvocations)
+	if len(params) > 0 {
+		_param0 = make([]string, len(params[0]))
+		for u, param := range params[0] {
+			_param0[u] = param.(string)
+		}
+	}
+	return
+}
+
+func (verifier *VerifierBlobstore) List(prefix string, pageToken string) *Blobstore_List_OngoingVerification {
+	params := []pegomock.Param{prefix, pageToken}
+	methodInvocations := pegomock.GetGenericMockFrom(verifier.mock).Verify(verifier.inOrderContext, verifier.invocationCountMatcher, "List", params)
+	return &Blobstore_List_OngoingVerification{mock: verifier.mock, methodInvocations: methodInvocations}
+}
+
+type Blobstore_List_OngoingVerification struct {
+	mock              *MockBlobstore
+	methodInvocations []pegomock.MethodInvocation
+}
+
+func (c *Blobstore_List_OngoingVerification) GetCapturedArguments() (string, string) {
+	prefix, pageToken := c.GetAllCapturedArguments()
+	return prefix[len(prefix)-1], pageToken[len(pageToken)-1]
+}
+
+func (c *Blobstore_List_OngoingVerification) GetAllCapturedArguments() (_param0 []string, _param1 []string) {
+	params := pegomock.GetGenericMockFrom(c.mock).GetInvocationParams(c.methodInvocations)
+	if len(params) > 0 {
+		_param0 = make([]string, len(params[0]))
+		for u, param := range params[0] {
+			_param0[u] = param.(string)
+		}
+		_param1 = make([]string, len(params[1]))
+		for u, param := range params[1] {
+			_param1[u] = param.(string)
+		}
+	}
+	return
+}
+
 func (verifier *VerifierBlobstore) HeadOrRedirectAsGet(path string) *Blobstore_HeadOrRedirectAsGet_OngoingVerification {
 	params := []pegomock.Param{path}
 	methodInvocations := pegomock.GetGenericMockFrom(verifier.mock).Verify(verifier.inOrderContext, verifier.invocationCountMatcher, "HeadOrRedirectAsGet", params)
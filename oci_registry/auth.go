@@ -0,0 +1,278 @@
+package oci_registry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/cloudfoundry-incubator/bits-service/middlewares"
+	"github.com/cloudfoundry-incubator/bits-service/util"
+)
+
+// TokenAuth protects the registry with the token authentication flow of the Docker registry: Unauthenticated requests
+// get a Bearer challenge pointing to the token endpoint, which issues short-lived JWTs to clients with valid
+// Basic credentials. Clients which cannot follow the flow can send their Basic credentials with every request instead.
+//
+// Tokens are signed with HS256, using the active signing key or, without signing keys, the secret. Tokens signed
+// with any other configured signing key remain valid, so that keys can be rotated.
+type TokenAuth struct {
+	// Realm is the URL of the token endpoint.
+	Realm       string
+	Service     string
+	Secret      string
+	SigningKeys map[string]string
+	ActiveKeyID string
+	TokenTTL    time.Duration
+	Clock       clock.Clock
+	BasicAuth   *middlewares.BasicAuthMiddleware
+}
+
+type tokenClaims struct {
+	Issuer    string        `json:"iss"`
+	Subject   string        `json:"sub"`
+	Audience  string        `json:"aud"`
+	ExpiresAt int64         `json:"exp"`
+	NotBefore int64         `json:"nbf"`
+	IssuedAt  int64         `json:"iat"`
+	Access    []accessEntry `json:"access"`
+}
+
+type accessEntry struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// ServeToken implements GET /v2/token?service=<service>&scope=<scope>. Signing users get every requested pull and push
+// action on repositories and access to the catalog.
+func (auth *TokenAuth) ServeToken(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || !auth.BasicAuth.Authorized(username, password) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, auth.Service))
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Valid Basic credentials are required to get a token")
+		return
+	}
+	if service := r.URL.Query().Get("service"); service != "" && service != auth.Service {
+		writeRegistryError(w, http.StatusBadRequest, "UNSUPPORTED", fmt.Sprintf("Tokens are only issued for service '%v'", auth.Service))
+		return
+	}
+
+	access := []accessEntry{}
+	for _, scopes := range r.URL.Query()["scope"] {
+		for _, scope := range strings.Fields(scopes) {
+			if entry, ok := grantedAccess(scope); ok {
+				access = append(access, entry)
+			}
+		}
+	}
+
+	now := auth.Clock.Now()
+	token := auth.sign(tokenClaims{
+		Issuer:    auth.Service,
+		Subject:   username,
+		Audience:  auth.Service,
+		ExpiresAt: now.Add(auth.TokenTTL).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		Access:    access,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   int(auth.TokenTTL.Seconds()),
+		"issued_at":    now.UTC().Format(time.RFC3339),
+	})
+}
+
+// grantedAccess parses a scope of the form <type>:<name>:<actions> and returns the actions it grants.
+func grantedAccess(scope string) (accessEntry, bool) {
+	parts := strings.Split(scope, ":")
+	if len(parts) < 3 {
+		return accessEntry{}, false
+	}
+	entry := accessEntry{Type: parts[0], Name: strings.Join(parts[1:len(parts)-1], ":")}
+	for _, action := range strings.Split(parts[len(parts)-1], ",") {
+		switch {
+		case entry.Type == "repository" && (action == "pull" || action == "push"),
+			entry.Type == "registry" && entry.Name == "catalog" && action == "*":
+			entry.Actions = append(entry.Actions, action)
+		}
+	}
+	if entry.Type == "repository" {
+		entry.Name = strings.TrimPrefix(entry.Name, "cloudfoundry/")
+	}
+	return entry, len(entry.Actions) > 0
+}
+
+// ServeHTTP makes TokenAuth a negroni middleware. It must run after the route matched, since the required scope
+// depends on the route's name variable.
+func (auth *TokenAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	required := requiredAccess(r)
+	scopes := make([]string, len(required))
+	for i, entry := range required {
+		scopes[i] = fmt.Sprintf("%v:%v:%v", entry.Type, entry.Name, entry.Actions[0])
+	}
+	challengeScope := strings.Join(scopes, " ")
+
+	if username, password, ok := r.BasicAuth(); ok {
+		if !auth.BasicAuth.Authorized(username, password) {
+			auth.challenge(w, challengeScope, "")
+			writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid Basic credentials")
+			return
+		}
+		next(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == r.Header.Get("Authorization") || token == "" {
+		auth.challenge(w, challengeScope, "")
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Authentication required")
+		return
+	}
+	claims, e := auth.verify(token)
+	if e != nil {
+		auth.challenge(w, challengeScope, "invalid_token")
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", e.Error())
+		return
+	}
+	for i, entry := range required {
+		if !claims.grants(entry) {
+			auth.challenge(w, challengeScope, "insufficient_scope")
+			writeRegistryError(w, http.StatusUnauthorized, "DENIED", fmt.Sprintf("Token does not grant scope '%v'", scopes[i]))
+			return
+		}
+	}
+	next(w, r)
+}
+
+// requiredAccess returns the access a request needs, one entry per required action. The API version
+// endpoint only requires authentication. Mounting a blob from another repository requires pulling from that one, too.
+func requiredAccess(r *http.Request) []accessEntry {
+	if r.URL.Path == "/v2/_catalog" {
+		return []accessEntry{{Type: "registry", Name: "catalog", Actions: []string{"*"}}}
+	}
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		return nil
+	}
+	action := "push"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		action = "pull"
+	}
+	required := []accessEntry{{Type: "repository", Name: strings.TrimPrefix(name, "cloudfoundry/"), Actions: []string{action}}}
+	if from := r.URL.Query().Get("from"); r.Method == http.MethodPost && r.URL.Query().Get("mount") != "" && from != "" {
+		required = append(required, accessEntry{Type: "repository", Name: strings.TrimPrefix(from, "cloudfoundry/"), Actions: []string{"pull"}})
+	}
+	return required
+}
+
+func (claims *tokenClaims) grants(required accessEntry) bool {
+	for _, entry := range claims.Access {
+		if entry.Type != required.Type || entry.Name != required.Name {
+			continue
+		}
+		for _, action := range entry.Actions {
+			if action == required.Actions[0] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (auth *TokenAuth) challenge(w http.ResponseWriter, scope string, errorCode string) {
+	challenge := fmt.Sprintf(`Bearer realm=%q,service=%q`, auth.Realm, auth.Service)
+	if scope != "" {
+		challenge += fmt.Sprintf(`,scope=%q`, scope)
+	}
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`,error=%q`, errorCode)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+func (auth *TokenAuth) sign(claims tokenClaims) string {
+	header := tokenHeader{Algorithm: "HS256", Type: "JWT"}
+	key := auth.Secret
+	if len(auth.SigningKeys) > 0 {
+		header.KeyID = auth.ActiveKeyID
+		key = auth.SigningKeys[auth.ActiveKeyID]
+	}
+	signingInput := encodeTokenSegment(header) + "." + encodeTokenSegment(claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(hmacSHA256(key, signingInput))
+}
+
+func (auth *TokenAuth) verify(token string) (*tokenClaims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, errors.New("Token is malformed")
+	}
+	var header tokenHeader
+	if e := decodeTokenSegment(segments[0], &header); e != nil || header.Algorithm != "HS256" {
+		return nil, errors.New("Token header is invalid")
+	}
+	key := auth.Secret
+	if header.KeyID != "" {
+		var exists bool
+		if key, exists = auth.SigningKeys[header.KeyID]; !exists {
+			return nil, errors.Errorf("Token is signed with unknown key '%v'", header.KeyID)
+		}
+	}
+	if key == "" {
+		return nil, errors.New("Token header is invalid")
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(segments[2])
+	if e != nil || !hmac.Equal(signature, hmacSHA256(key, segments[0]+"."+segments[1])) {
+		return nil, errors.New("Token signature is invalid")
+	}
+
+	var claims tokenClaims
+	if e := decodeTokenSegment(segments[1], &claims); e != nil {
+		return nil, errors.New("Token claims are invalid")
+	}
+	now := auth.Clock.Now().Unix()
+	if now >= claims.ExpiresAt || now < claims.NotBefore {
+		return nil, errors.New("Token is expired or not valid yet")
+	}
+	if claims.Audience != auth.Service {
+		return nil, errors.Errorf("Token is not issued for service '%v'", auth.Service)
+	}
+	return &claims, nil
+}
+
+func encodeTokenSegment(value interface{}) string {
+	content, e := json.Marshal(value)
+	util.PanicOnError(errors.WithStack(e))
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func decodeTokenSegment(segment string, value interface{}) error {
+	content, e := base64.RawURLEncoding.DecodeString(segment)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(content, value)
+}
+
+func hmacSHA256(key string, content string) []byte {
+	hash := hmac.New(sha256.New, []byte(key))
+	hash.Write([]byte(content))
+	return hash.Sum(nil)
+}
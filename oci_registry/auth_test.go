@@ -0,0 +1,188 @@
+package oci_registry_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	inmemory_blobstore "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	"github.com/cloudfoundry-incubator/bits-service/middlewares"
+	"github.com/cloudfoundry-incubator/bits-service/oci_registry"
+	"github.com/cloudfoundry-incubator/bits-service/routes"
+)
+
+var _ = Describe("TokenAuth", func() {
+	blobDigest := "sha256:" + strings.Repeat("a", 64)
+
+	var (
+		server    *httptest.Server
+		mockClock *clock.Mock
+		auth      *oci_registry.TokenAuth
+	)
+
+	BeforeEach(func() {
+		dropletBlobstore := inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{
+			"app-a/hash-1": []byte("droplet"),
+			"app-b/hash-2": []byte("droplet"),
+			blobDigest:     []byte("blob"),
+		})
+		mockClock = clock.NewMock()
+		auth = &oci_registry.TokenAuth{
+			Service:     "registry.example.com",
+			SigningKeys: map[string]string{"key1": "secret1", "key2": "secret2"},
+			ActiveKeyID: "key1",
+			TokenTTL:    5 * time.Minute,
+			Clock:       mockClock,
+			BasicAuth:   middlewares.NewBasicAuthMiddleWare(middlewares.Credential{Username: "the-username", Password: "the-password"}),
+		}
+		uploads, e := bitsgo.NewUploadSessionStore(inmemory_blobstore.NewBlobstore(), filepath.Join(os.TempDir(), "auth-test-uploads"), time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+		router := mux.NewRouter()
+		routes.AddImageHandler(router, &oci_registry.ImageHandler{
+			ImageManager: oci_registry.NewBitsImageManager(
+				inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{"assets/eirinifs.tar": []byte("the-rootfs-blob")}),
+				dropletBlobstore,
+				dropletBlobstore),
+			Uploads: uploads,
+			Auth:    auth,
+		})
+		server = httptest.NewServer(router)
+		auth.Realm = server.URL + "/v2/token"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(path string, authorization string) *http.Response {
+		request, e := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		Expect(e).NotTo(HaveOccurred())
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		res, e := http.DefaultClient.Do(request)
+		Expect(e).NotTo(HaveOccurred())
+		return res
+	}
+
+	fetchToken := func(scope string) string {
+		request, e := http.NewRequest(http.MethodGet, server.URL+"/v2/token?service=registry.example.com&scope="+url.QueryEscape(scope), nil)
+		Expect(e).NotTo(HaveOccurred())
+		request.SetBasicAuth("the-username", "the-password")
+		res, e := http.DefaultClient.Do(request)
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+
+		var body struct {
+			Token     string `json:"token"`
+			ExpiresIn int    `json:"expires_in"`
+		}
+		Expect(json.NewDecoder(res.Body).Decode(&body)).To(Succeed())
+		Expect(body.ExpiresIn).To(Equal(300))
+		return body.Token
+	}
+
+	It("challenges unauthenticated clients to get a token", func() {
+		res := get("/v2/", "")
+
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(res.Header.Get("WWW-Authenticate")).To(Equal(`Bearer realm="` + server.URL + `/v2/token",service="registry.example.com"`))
+		Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("UNAUTHORIZED"))
+
+		res = get("/v2/cloudfoundry/app-a/tags/list", "")
+
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(res.Header.Get("WWW-Authenticate")).To(HaveSuffix(`,scope="repository:app-a:pull"`))
+	})
+
+	It("lets clients pull with a token scoped to the repository", func() {
+		token := fetchToken("repository:cloudfoundry/app-a:pull")
+
+		Expect(get("/v2/", "Bearer "+token).StatusCode).To(Equal(http.StatusOK))
+		Expect(get("/v2/cloudfoundry/app-a/tags/list", "Bearer "+token).StatusCode).To(Equal(http.StatusOK))
+
+		res := get("/v2/cloudfoundry/app-b/tags/list", "Bearer "+token)
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(res.Header.Get("WWW-Authenticate")).To(ContainSubstring(`scope="repository:app-b:pull",error="insufficient_scope"`))
+		Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("DENIED"))
+
+		Expect(get("/v2/_catalog", "Bearer "+token).StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(get("/v2/_catalog", "Bearer "+fetchToken("registry:catalog:*")).StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("requires pulling from the repository a blob is mounted from", func() {
+		mount := func(token string) *http.Response {
+			request, e := http.NewRequest(http.MethodPost, server.URL+"/v2/other/image/blobs/uploads/?mount="+blobDigest+"&from=my/image", nil)
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "Bearer "+token)
+			res, e := http.DefaultClient.Do(request)
+			Expect(e).NotTo(HaveOccurred())
+			return res
+		}
+
+		res := mount(fetchToken("repository:other/image:push"))
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(res.Header.Get("WWW-Authenticate")).To(ContainSubstring(`scope="repository:other/image:push repository:my/image:pull",error="insufficient_scope"`))
+
+		res = mount(fetchToken("repository:other/image:push repository:my/image:pull"))
+		Expect(res.StatusCode).To(Equal(http.StatusCreated))
+		Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(blobDigest))
+	})
+
+	It("rejects expired tokens", func() {
+		token := fetchToken("repository:app-a:pull")
+
+		mockClock.Add(5 * time.Minute)
+
+		res := get("/v2/app-a/tags/list", "Bearer "+token)
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(res.Header.Get("WWW-Authenticate")).To(ContainSubstring(`error="invalid_token"`))
+	})
+
+	It("rejects tampered tokens", func() {
+		token := fetchToken("repository:app-a:pull")
+
+		Expect(get("/v2/app-a/tags/list", "Bearer "+token+"x").StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("accepts tokens signed with a key which is no longer active", func() {
+		token := fetchToken("repository:app-a:pull")
+
+		auth.ActiveKeyID = "key2"
+
+		Expect(get("/v2/app-a/tags/list", "Bearer "+token).StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("accepts Basic credentials instead of tokens", func() {
+		request, e := http.NewRequest(http.MethodGet, server.URL+"/v2/app-b/tags/list", nil)
+		Expect(e).NotTo(HaveOccurred())
+		request.SetBasicAuth("the-username", "the-password")
+		res, e := http.DefaultClient.Do(request)
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+
+		request.SetBasicAuth("the-username", "wrong-password")
+		res, e = http.DefaultClient.Do(request)
+		Expect(res.StatusCode, e).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("issues tokens only for valid Basic credentials", func() {
+		Expect(get("/v2/token?service=registry.example.com&scope=repository:app-a:pull", "").StatusCode).To(Equal(http.StatusUnauthorized))
+
+		request, e := http.NewRequest(http.MethodGet, server.URL+"/v2/token?service=registry.example.com", nil)
+		Expect(e).NotTo(HaveOccurred())
+		request.SetBasicAuth("the-username", "wrong-password")
+		res, e := http.DefaultClient.Do(request)
+		Expect(res.StatusCode, e).To(Equal(http.StatusUnauthorized))
+	})
+})
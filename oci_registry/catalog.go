@@ -0,0 +1,197 @@
+package oci_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/util"
+)
+
+// dropletPathRegex matches paths of droplets, i.e. <app-guid>/<droplet-hash>. Blobs and records of the registry do not
+// match, so that they are skipped when the droplet blobstore is the digest lookup store as well.
+var dropletPathRegex = regexp.MustCompile(`^([a-z0-9\-]+)/([a-zA-Z0-9_.\-]+)$`)
+
+// dropletRepositoryInitials are the characters the names of droplet repositories can start with, in ascending order.
+const dropletRepositoryInitials = "-0123456789abcdefghijklmnopqrstuvwxyz"
+
+// ServeCatalog implements GET /v2/_catalog. Every app with droplets and every pushed repository is a repository.
+func (m *ImageHandler) ServeCatalog(w http.ResponseWriter, r *http.Request) {
+	n, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+	repositories, e := m.ImageManager.Repositories(r.URL.Query().Get("last"), n)
+	util.PanicOnError(e)
+	writeJSON(w, map[string]interface{}{"repositories": paginate(w, r, repositories, n)})
+}
+
+// ServeTags implements GET /v2/<name>/tags/list. The tags of an app's repository are the hashes of its droplets.
+func (m *ImageHandler) ServeTags(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	n, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+	tags, e := m.ImageManager.Tags(name)
+	util.PanicOnError(e)
+	if len(tags) == 0 {
+		writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "Unknown repository "+name)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"name": name, "tags": paginate(w, r, tags, n)})
+}
+
+// pageSize returns the query parameter n, or -1 without one.
+func pageSize(w http.ResponseWriter, r *http.Request) (n int, ok bool) {
+	if r.URL.Query().Get("n") == "" {
+		return -1, true
+	}
+	n, e := strconv.Atoi(r.URL.Query().Get("n"))
+	if e != nil || n < 0 {
+		writeRegistryError(w, http.StatusBadRequest, "PAGINATION_NUMBER_INVALID", "n must be a non-negative integer")
+		return 0, false
+	}
+	return n, true
+}
+
+// paginate returns the entries after the one given by query parameter last, but at most n. If there are more, it links the next page.
+// entries must be sorted. A negative n returns all of them.
+func paginate(w http.ResponseWriter, r *http.Request, entries []string, n int) (page []string) {
+	last := r.URL.Query().Get("last")
+	page = entries[sort.Search(len(entries), func(i int) bool { return entries[i] > last }):]
+	if n >= 0 && n < len(page) {
+		page = page[:n]
+		if n > 0 {
+			next := url.Values{"n": {strconv.Itoa(n)}, "last": {page[n-1]}}
+			w.Header().Set("Link", fmt.Sprintf(`<%v?%v>; rel="next"`, r.URL.Path, next.Encode()))
+		}
+	}
+	if page == nil {
+		page = []string{}
+	}
+	return page
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	content, e := json.Marshal(body)
+	util.PanicOnError(errors.WithStack(e))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// Repositories returns the sorted repositories after last, i.e. the GUIDs of all apps with droplets and the names of all pushed
+// repositories. When there are more than n, it returns at least n+1 of them, so that callers can tell that there is a next page.
+// A negative n returns all of them.
+//
+// Droplets are listed by the initial of their app GUID, starting at the one of last, so that only the part of the droplet blobstore
+// the page falls into is listed.
+func (b *BitsImageManager) Repositories(last string, n int) ([]string, error) {
+	repositories := map[string]bool{}
+	e := b.forEachTag("", func(name string, _ string) {
+		if name > last {
+			repositories[name] = true
+		}
+	})
+	if e != nil {
+		return nil, e
+	}
+	for _, initial := range dropletRepositoryInitials {
+		if last != "" && string(initial) < last[:1] {
+			continue
+		}
+		e = b.forEachDroplet(string(initial), func(appGUID string, _ string) {
+			if appGUID > last {
+				repositories[appGUID] = true
+			}
+		})
+		if e != nil {
+			return nil, e
+		}
+		// All repositories up to this initial are known now.
+		if n >= 0 && countWithInitialUpTo(repositories, byte(initial)) > n {
+			break
+		}
+	}
+	result := make([]string, 0, len(repositories))
+	for repository := range repositories {
+		result = append(result, repository)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Tags returns the sorted hashes of the app's droplets for repositories backed by droplets, and the pushed tags otherwise.
+func (b *BitsImageManager) Tags(name string) ([]string, error) {
+	var tags []string
+	var e error
+	if isDropletRepository(name) {
+		appGUID := strings.TrimPrefix(name, "cloudfoundry/")
+		e = b.forEachDroplet(appGUID+"/", func(_ string, dropletHash string) { tags = append(tags, dropletHash) })
+	} else {
+		e = b.forEachTag(name+"/", func(tagName string, tag string) {
+			if tagName == name {
+				tags = append(tags, tag)
+			}
+		})
+	}
+	if e != nil {
+		return nil, e
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (b *BitsImageManager) forEachDroplet(prefix string, f func(appGUID string, dropletHash string)) error {
+	return forEachBlob(b.dropletBlobstore, prefix, func(path string) {
+		if match := dropletPathRegex.FindStringSubmatch(path); match != nil {
+			f(match[1], match[2])
+		}
+	})
+}
+
+// forEachTag calls f for every tag pushed to a repository whose name starts with namePrefix.
+func (b *BitsImageManager) forEachTag(namePrefix string, f func(name string, tag string)) error {
+	return forEachBlob(b.digestLookupStore, "tags/"+namePrefix, func(path string) {
+		separatorIndex := strings.LastIndex(path, "/")
+		if name := strings.TrimPrefix(path[:separatorIndex], "tags/"); name != "" && !isDropletRepository(name) {
+			f(name, path[separatorIndex+1:])
+		}
+	})
+}
+
+func forEachBlob(blobstore bitsgo.Blobstore, prefix string, f func(path string)) error {
+	pageToken := ""
+	for {
+		blobs, nextPageToken, e := blobstore.List(prefix, pageToken)
+		if e != nil {
+			return errors.Wrapf(e, "Could not list %v", prefix)
+		}
+		for _, blob := range blobs {
+			f(blob.Path)
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+func countWithInitialUpTo(repositories map[string]bool, initial byte) int {
+	count := 0
+	for repository := range repositories {
+		if repository[0] <= initial {
+			count++
+		}
+	}
+	return count
+}
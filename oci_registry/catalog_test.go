@@ -0,0 +1,133 @@
+package oci_registry_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	inmemory_blobstore "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	"github.com/cloudfoundry-incubator/bits-service/oci_registry"
+	"github.com/cloudfoundry-incubator/bits-service/routes"
+)
+
+var _ = Describe("Catalog", func() {
+	var (
+		server         *httptest.Server
+		listedPrefixes []string
+	)
+
+	BeforeEach(func() {
+		listedPrefixes = nil
+		// As in production, the droplet blobstore also holds the registry's blobs and records, which must not show up.
+		dropletBlobstore := inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{
+			"app-a/hash-2":                 []byte("droplet"),
+			"app-a/hash-1":                 []byte("droplet"),
+			"app-b/hash-3":                 []byte("droplet"),
+			"sha256:abc":                   []byte("blob"),
+			"manifests/sha256:abc":         []byte("record"),
+			"droplet-images/app-c/hash-4":  []byte("record"),
+			"tags/pushed/image/latest":     []byte("record"),
+			"tags/pushed/image/v1":         []byte("record"),
+			"tags/pushed/image/nested/v2":  []byte("record"),
+			"buildpack_cache/app-d/hash-5": []byte("entry"),
+		})
+		router := mux.NewRouter()
+		routes.AddImageHandler(router, &oci_registry.ImageHandler{
+			ImageManager: oci_registry.NewBitsImageManager(
+				inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{"assets/eirinifs.tar": []byte("the-rootfs-blob")}),
+				&listRecordingBlobstore{dropletBlobstore, &listedPrefixes},
+				dropletBlobstore),
+		})
+		server = httptest.NewServer(router)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists all apps with droplets and all pushed repositories", func() {
+		res, e := http.Get(server.URL + "/v2/_catalog")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"repositories":["app-a","app-b","pushed/image","pushed/image/nested"]}`))
+	})
+
+	It("paginates repositories", func() {
+		res, e := http.Get(server.URL + "/v2/_catalog?n=1")
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"repositories":["app-a"]}`))
+		Expect(res.Header.Get("Link")).To(Equal(`</v2/_catalog?last=app-a&n=1>; rel="next"`))
+
+		res, e = http.Get(server.URL + "/v2/_catalog?last=app-a&n=1")
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"repositories":["app-b"]}`))
+		Expect(res.Header.Get("Link")).To(Equal(`</v2/_catalog?last=app-b&n=1>; rel="next"`))
+
+		res, e = http.Get(server.URL + "/v2/_catalog?last=pushed/image&n=5")
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"repositories":["pushed/image/nested"]}`))
+		Expect(res.Header.Get("Link")).To(BeEmpty())
+	})
+
+	It("only lists the droplets of the initials a page reaches", func() {
+		res, e := http.Get(server.URL + "/v2/_catalog?n=1")
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(listedPrefixes).To(Equal([]string{"-", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a"}))
+
+		listedPrefixes = nil
+		res, e = http.Get(server.URL + "/v2/_catalog?last=app-b&n=1")
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"repositories":["pushed/image"]}`))
+		Expect(listedPrefixes).To(Equal([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"}))
+	})
+
+	It("lists the tags of pushed repositories", func() {
+		res, e := http.Get(server.URL + "/v2/pushed/image/tags/list")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"name":"pushed/image","tags":["latest","v1"]}`))
+	})
+
+	It("lists the droplet hashes of an app as tags", func() {
+		res, e := http.Get(server.URL + "/v2/cloudfoundry/app-a/tags/list")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"name":"cloudfoundry/app-a","tags":["hash-1","hash-2"]}`))
+	})
+
+	It("paginates tags", func() {
+		res, e := http.Get(server.URL + "/v2/app-a/tags/list?n=1&last=hash-1")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+		Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"name":"app-a","tags":["hash-2"]}`))
+	})
+
+	It("returns StatusNotFound for apps without droplets", func() {
+		res, e := http.Get(server.URL + "/v2/app-c/tags/list")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusNotFound))
+		Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("NAME_UNKNOWN"))
+	})
+
+	It("returns StatusBadRequest for invalid page sizes", func() {
+		res, e := http.Get(server.URL + "/v2/_catalog?n=-1")
+
+		Expect(res.StatusCode, e).To(Equal(http.StatusBadRequest))
+		Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("PAGINATION_NUMBER_INVALID"))
+	})
+})
+
+type listRecordingBlobstore struct {
+	*inmemory_blobstore.Blobstore
+	listedPrefixes *[]string
+}
+
+func (blobstore *listRecordingBlobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	*blobstore.listedPrefixes = append(*blobstore.listedPrefixes, prefix)
+	return blobstore.Blobstore.List(prefix, pageToken)
+}
@@ -0,0 +1,72 @@
+package oci_registry
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bits-service/oci_registry/models/docker/mediatype"
+)
+
+// generatedManifestMediaTypes are the media types in which images generated from droplets are served, in order of preference.
+// The Docker manifest list comes first, because it is what clients got before OCI media types were supported.
+var generatedManifestMediaTypes = []string{
+	mediatype.DistributionManifestListV2Json,
+	mediatype.OCIImageIndexV1Json,
+	mediatype.DistributionManifestV2Json,
+	mediatype.OCIImageManifestV1Json,
+}
+
+// negotiateManifestMediaType picks the generated manifest media type with the highest quality in the Accept headers.
+// Ties are broken by the order of generatedManifestMediaTypes. Without Accept header, every media type is acceptable.
+func negotiateManifestMediaType(r *http.Request) (mediaType string, acceptable bool) {
+	// Clients like docker send one Accept header per media type
+	accept := strings.Join(r.Header["Accept"], ",")
+	if strings.TrimSpace(accept) == "" {
+		return generatedManifestMediaTypes[0], true
+	}
+	var bestQuality float64
+	for _, candidate := range generatedManifestMediaTypes {
+		if quality := acceptQuality(accept, candidate); quality > bestQuality {
+			mediaType, bestQuality = candidate, quality
+		}
+	}
+	return mediaType, bestQuality > 0
+}
+
+// acceptQuality returns the q value accept assigns to mediaType. As in RFC 7231, the most specific media range
+// matching mediaType counts, so that e.g. "*/*, application/vnd.oci.image.index.v1+json;q=0" excludes OCI image indexes.
+func acceptQuality(accept string, mediaType string) float64 {
+	var quality float64
+	specificity := -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		var rangeSpecificity int
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "*/*":
+			rangeSpecificity = 0
+		case "application/*":
+			rangeSpecificity = 1
+		case mediaType:
+			rangeSpecificity = 2
+		default:
+			continue
+		}
+		if rangeSpecificity < specificity {
+			continue
+		}
+		rangeQuality := 1.0
+		for _, param := range params[1:] {
+			keyAndValue := strings.SplitN(param, "=", 2)
+			if len(keyAndValue) == 2 && strings.TrimSpace(keyAndValue[0]) == "q" {
+				if q, e := strconv.ParseFloat(strings.TrimSpace(keyAndValue[1]), 64); e == nil {
+					rangeQuality = q
+				}
+			}
+		}
+		if rangeSpecificity > specificity || rangeQuality > quality {
+			quality, specificity = rangeQuality, rangeSpecificity
+		}
+	}
+	return quality
+}
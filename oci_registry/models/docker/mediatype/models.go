@@ -8,3 +8,12 @@ const (
 	ImageRootfsTar                 = "application/vnd.docker.image.rootfs.diff.tar"
 	ImageRootfsTarGzip             = "application/vnd.docker.image.rootfs.diff.tar.gzip"
 )
+
+// Media types of the OCI image spec. Their documents have the same structure as their Docker counterparts.
+const (
+	OCIImageIndexV1Json    = "application/vnd.oci.image.index.v1+json"
+	OCIImageManifestV1Json = "application/vnd.oci.image.manifest.v1+json"
+	OCIImageConfigV1Json   = "application/vnd.oci.image.config.v1+json"
+	OCIImageLayerV1Tar     = "application/vnd.oci.image.layer.v1.tar"
+	OCIImageLayerV1TarGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
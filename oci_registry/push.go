@@ -0,0 +1,378 @@
+package oci_registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+
+	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/cloudfoundry-incubator/bits-service/util"
+)
+
+// maxManifestSize is what the distribution spec recommends registries to accept at least.
+const maxManifestSize = 4 << 20
+
+var (
+	digestRegex       = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	tagRegex          = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	contentRangeRegex = regexp.MustCompile(`^(\d+)-(\d+)$`)
+)
+
+// StartBlobUpload implements POST /v2/<name>/blobs/uploads/. With ?mount=<digest>&from=<name>, it mounts an existing blob
+// instead. Because all repositories share the same blobs, every existing blob can be mounted, as long as the client may pull
+// from the repository it names. Without from, a regular upload is started. With ?digest=<digest>, the request body is the whole blob.
+func (m *ImageHandler) StartBlobUpload(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if mount := r.URL.Query().Get("mount"); mount != "" && r.URL.Query().Get("from") != "" && digestRegex.MatchString(mount) && m.ImageManager.HasBlob(mount) {
+		w.Header().Set("Location", "/v2/"+name+"/blobs/"+mount)
+		w.Header().Set("Docker-Content-Digest", mount)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	uploadID := uuid.NewV4().String()
+	e := m.Uploads.Create(uploadSessionKey(name, uploadID))
+	util.PanicOnError(e)
+
+	if digest := r.URL.Query().Get("digest"); digest != "" {
+		_, e = m.Uploads.Append(uploadSessionKey(name, uploadID), 0, r.Body, m.MaxBlobSize)
+		if handleBlobUploadError(e, w, name, uploadID) {
+			m.Uploads.Delete(uploadSessionKey(name, uploadID))
+			return
+		}
+		m.completeBlobUpload(w, name, uploadID, digest)
+		return
+	}
+	writeBlobUploadProgress(w, name, uploadID, 0)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// PatchBlobUpload appends a chunk at the offset given by the Content-Range header or, without one, at the current offset.
+func (m *ImageHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request) {
+	name, uploadID := mux.Vars(r)["name"], mux.Vars(r)["uuid"]
+	var offset int64
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+		match := contentRangeRegex.FindStringSubmatch(contentRange)
+		if match == nil {
+			writeRegistryError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", "Content-Range must have format <start>-<end>")
+			return
+		}
+		// The regex guarantees that both are numbers
+		start, _ := strconv.ParseInt(match[1], 10, 64)
+		end, _ := strconv.ParseInt(match[2], 10, 64)
+		if end < start || (r.ContentLength != -1 && end-start+1 != r.ContentLength) {
+			writeRegistryError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", "Content-Range does not match Content-Length")
+			return
+		}
+		offset = start
+	} else {
+		var e error
+		offset, e = m.Uploads.Offset(uploadSessionKey(name, uploadID))
+		if handleBlobUploadError(e, w, name, uploadID) {
+			return
+		}
+	}
+	newOffset, e := m.Uploads.Append(uploadSessionKey(name, uploadID), offset, r.Body, m.MaxBlobSize)
+	if handleBlobUploadError(e, w, name, uploadID) {
+		return
+	}
+	writeBlobUploadProgress(w, name, uploadID, newOffset)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *ImageHandler) GetBlobUploadStatus(w http.ResponseWriter, r *http.Request) {
+	name, uploadID := mux.Vars(r)["name"], mux.Vars(r)["uuid"]
+	offset, e := m.Uploads.Offset(uploadSessionKey(name, uploadID))
+	if handleBlobUploadError(e, w, name, uploadID) {
+		return
+	}
+	writeBlobUploadProgress(w, name, uploadID, offset)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CompleteBlobUpload implements PUT /v2/<name>/blobs/uploads/<uuid>?digest=<digest>. A non-empty body is appended as last chunk first.
+func (m *ImageHandler) CompleteBlobUpload(w http.ResponseWriter, r *http.Request) {
+	name, uploadID := mux.Vars(r)["name"], mux.Vars(r)["uuid"]
+	if r.ContentLength != 0 {
+		offset, e := m.Uploads.Offset(uploadSessionKey(name, uploadID))
+		if handleBlobUploadError(e, w, name, uploadID) {
+			return
+		}
+		_, e = m.Uploads.Append(uploadSessionKey(name, uploadID), offset, r.Body, m.MaxBlobSize)
+		if handleBlobUploadError(e, w, name, uploadID) {
+			return
+		}
+	}
+	m.completeBlobUpload(w, name, uploadID, r.URL.Query().Get("digest"))
+}
+
+func (m *ImageHandler) completeBlobUpload(w http.ResponseWriter, name string, uploadID string, digest string) {
+	if !digestRegex.MatchString(digest) {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest must have format sha256:<hex>")
+		return
+	}
+	e := m.Uploads.Finalize(uploadSessionKey(name, uploadID), func(bitsFilename string) bool {
+		file, e := os.Open(bitsFilename)
+		util.PanicOnError(errors.WithStack(e))
+		defer file.Close()
+
+		// A blob with the wrong digest cannot be fixed by uploading more chunks, so the upload is removed in any case.
+		if actualDigest, _ := shaAndSize(file); actualDigest != digest {
+			writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("Blob has digest %v, but %v was given", actualDigest, digest))
+			return true
+		}
+		_, e = file.Seek(0, io.SeekStart)
+		util.PanicOnError(errors.WithStack(e))
+
+		e = m.ImageManager.PutBlob(digest, file)
+		util.PanicOnError(e)
+
+		w.Header().Set("Location", "/v2/"+name+"/blobs/"+digest)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusCreated)
+		return true
+	})
+	handleBlobUploadError(e, w, name, uploadID)
+}
+
+func (m *ImageHandler) CancelBlobUpload(w http.ResponseWriter, r *http.Request) {
+	name, uploadID := mux.Vars(r)["name"], mux.Vars(r)["uuid"]
+	e := m.Uploads.Delete(uploadSessionKey(name, uploadID))
+	if handleBlobUploadError(e, w, name, uploadID) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PutManifest implements PUT /v2/<name>/manifests/<reference>. All blobs and manifests the manifest refers to must have been pushed before.
+// Repositories backed by droplets cannot be pushed to, so that their images always are the ones generated from the droplets.
+func (m *ImageHandler) PutManifest(w http.ResponseWriter, r *http.Request) {
+	name, reference := mux.Vars(r)["name"], mux.Vars(r)["tag"]
+	if isDropletRepository(name) {
+		writeRegistryError(w, http.StatusForbidden, "DENIED", fmt.Sprintf("Repository %v is backed by droplets and cannot be pushed to", name))
+		return
+	}
+	if !digestRegex.MatchString(reference) && !tagRegex.MatchString(reference) {
+		writeRegistryError(w, http.StatusBadRequest, "TAG_INVALID", "reference must be a tag or a sha256 digest")
+		return
+	}
+	content, e := ioutil.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	util.PanicOnError(errors.WithStack(e))
+	if len(content) > maxManifestSize {
+		writeRegistryError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", fmt.Sprintf("Manifest must not be larger than %v bytes", maxManifestSize))
+		return
+	}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Config    *struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	e = json.Unmarshal(content, &manifest)
+	if e != nil {
+		writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", "Manifest is not valid JSON: "+e.Error())
+		return
+	}
+	mediaType := r.Header.Get("Content-Type")
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+
+	var blobDigests []string
+	if manifest.Config != nil {
+		blobDigests = append(blobDigests, manifest.Config.Digest)
+	}
+	for _, layer := range manifest.Layers {
+		blobDigests = append(blobDigests, layer.Digest)
+	}
+	for _, blobDigest := range blobDigests {
+		if !digestRegex.MatchString(blobDigest) || !m.ImageManager.HasBlob(blobDigest) {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "Unknown blob "+blobDigest)
+			return
+		}
+	}
+	for _, childManifest := range manifest.Manifests {
+		if !digestRegex.MatchString(childManifest.Digest) || !m.ImageManager.HasBlob(childManifest.Digest) {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_UNKNOWN", "Unknown manifest "+childManifest.Digest)
+			return
+		}
+	}
+
+	digest, _ := shaAndSize(bytes.NewReader(content))
+	if digestRegex.MatchString(reference) && reference != digest {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("Manifest has digest %v, but is pushed as %v", digest, reference))
+		return
+	}
+	e = m.ImageManager.PutManifest(name, reference, mediaType, content)
+	util.PanicOnError(e)
+
+	w.Header().Set("Location", "/v2/"+name+"/manifests/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveStoredManifest returns false, if there is no pushed or generated manifest for reference.
+func (m *ImageHandler) serveStoredManifest(w http.ResponseWriter, r *http.Request, name string, reference string) bool {
+	content, mediaType, digest := m.ImageManager.GetStoredManifest(name, reference)
+	if content == nil {
+		return false
+	}
+	writeManifest(w, r, mediaType, digest, content)
+	return true
+}
+
+// uploadSessionKey binds blob uploads to the repository they were started for, so that they cannot be continued,
+// completed or cancelled via another repository. Names with and without cloudfoundry/ prefix are the same repository.
+func uploadSessionKey(name string, uploadID string) string {
+	return strings.TrimPrefix(name, "cloudfoundry/") + "/" + uploadID
+}
+
+func writeBlobUploadProgress(w http.ResponseWriter, name string, uploadID string, offset int64) {
+	w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+uploadID)
+	w.Header().Set("Docker-Upload-UUID", uploadID)
+	end := offset - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Range", fmt.Sprintf("0-%v", end))
+}
+
+func handleBlobUploadError(e error, w http.ResponseWriter, name string, uploadID string) (wasError bool) {
+	switch e := e.(type) {
+	case nil:
+		return false
+	case *bitsgo.NotFoundError:
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "Unknown blob upload "+uploadID)
+	case *bitsgo.UploadOffsetMismatchError:
+		writeBlobUploadProgress(w, name, uploadID, e.Offset)
+		writeRegistryError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", e.Error())
+	case *bitsgo.UploadSessionBusyError:
+		writeRegistryError(w, http.StatusConflict, "BLOB_UPLOAD_INVALID", e.Error())
+	case *bitsgo.UploadTooLargeError:
+		writeRegistryError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", e.Error())
+	default:
+		panic(e)
+	}
+	return true
+}
+
+// writeRegistryError writes the error format of the distribution spec.
+func writeRegistryError(w http.ResponseWriter, statusCode int, code string, message string) {
+	logger.Log.Infow("Rejecting registry request", "code", code, "message", message)
+	body, e := json.Marshal(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+	util.PanicOnError(errors.WithStack(e))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+type manifestRecord struct {
+	MediaType string `json:"mediaType"`
+}
+
+type tagRecord struct {
+	Digest string `json:"digest"`
+}
+
+// HasBlob also returns true for manifests, since they are stored as blobs, too.
+func (b *BitsImageManager) HasBlob(digest string) bool {
+	if digest == b.rootfsDigest {
+		return true
+	}
+	exists, e := b.digestLookupStore.Exists(digest)
+	util.PanicOnError(errors.WithStack(e))
+	return exists
+}
+
+func (b *BitsImageManager) PutBlob(digest string, content io.ReadSeeker) error {
+	return errors.WithStack(b.digestLookupStore.Put(digest, content))
+}
+
+// PutManifest stores content as blob and records its media type. If reference is a tag, it is pointed to the manifest.
+func (b *BitsImageManager) PutManifest(name string, reference string, mediaType string, content []byte) error {
+	digest, _ := shaAndSize(bytes.NewReader(content))
+	e := b.digestLookupStore.Put(digest, bytes.NewReader(content))
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	e = b.putRecord(manifestRecordKey(digest), manifestRecord{MediaType: mediaType})
+	if e != nil {
+		return e
+	}
+	if digestRegex.MatchString(reference) {
+		return nil
+	}
+	return b.putRecord(tagRecordKey(name, reference), tagRecord{Digest: digest})
+}
+
+// GetStoredManifest returns a nil content, if no manifest was pushed or generated for reference.
+func (b *BitsImageManager) GetStoredManifest(name string, reference string) (content []byte, mediaType string, digest string) {
+	digest = reference
+	if !digestRegex.MatchString(reference) {
+		var tag tagRecord
+		if !b.getRecord(tagRecordKey(name, reference), &tag) {
+			return nil, "", ""
+		}
+		digest = tag.Digest
+	}
+	var manifest manifestRecord
+	if !b.getRecord(manifestRecordKey(digest), &manifest) {
+		return nil, "", ""
+	}
+	reader, e := b.digestLookupStore.Get(digest)
+	if bitsgo.IsNotFoundError(e) {
+		return nil, "", ""
+	}
+	util.PanicOnError(errors.WithStack(e))
+	defer reader.Close()
+	content, e = ioutil.ReadAll(reader)
+	util.PanicOnError(errors.WithStack(e))
+	return content, manifest.MediaType, digest
+}
+
+func manifestRecordKey(digest string) string { return "manifests/" + digest }
+
+func tagRecordKey(name string, tag string) string { return "tags/" + name + "/" + tag }
+
+func (b *BitsImageManager) putRecord(key string, record interface{}) error {
+	content, e := json.Marshal(record)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	return errors.WithStack(b.digestLookupStore.Put(key, bytes.NewReader(content)))
+}
+
+// getRecord returns false, if there is no record at key.
+func (b *BitsImageManager) getRecord(key string, record interface{}) bool {
+	reader, e := b.digestLookupStore.Get(key)
+	if bitsgo.IsNotFoundError(e) {
+		return false
+	}
+	util.PanicOnError(errors.WithStack(e))
+	defer reader.Close()
+	util.PanicOnError(errors.WithStack(json.NewDecoder(reader).Decode(record)))
+	return true
+}
@@ -7,82 +7,120 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	bitsgo "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/cloudfoundry-incubator/bits-service/logger"
 
 	"github.com/cloudfoundry-incubator/bits-service/oci_registry/models/docker"
 	"github.com/cloudfoundry-incubator/bits-service/oci_registry/models/docker/mediatype"
 	"github.com/cloudfoundry-incubator/bits-service/util"
 
 	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
 )
 
 type ImageHandler struct {
 	ImageManager *BitsImageManager
+	// Holds blobs while they are pushed. Pushing is disabled when nil.
+	Uploads *bitsgo.UploadSessionStore
+	// Pushed blobs larger than this are rejected. 0 means no limit.
+	MaxBlobSize int64
+	// Protects all endpoints. The registry is open to everyone when nil.
+	Auth *TokenAuth
 }
 
 func (m *ImageHandler) ServeAPIVersion(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Pong"))
 }
 
+// ServeManifest serves the image generated from the droplet for repositories backed by droplets, and pushed manifests otherwise.
+// The generated image always comes first, so that the image Cloud Foundry runs cannot be replaced by pushing.
 func (m *ImageHandler) ServeManifest(w http.ResponseWriter, r *http.Request) {
+	name, reference := mux.Vars(r)["name"], mux.Vars(r)["tag"]
+	if isDropletRepository(name) && !digestRegex.MatchString(reference) {
+		m.serveDropletImage(w, r, strings.TrimPrefix(name, "cloudfoundry/"), reference)
+		return
+	}
+
+	if m.serveStoredManifest(w, r, name, reference) {
+		return
+	}
+
 	// TODO (pego): this is a hack to address to quickly find out if this should serve a manifest or manifest list. Should be improved.
-	if m.ImageManager.GetBlob("not used", mux.Vars(r)["tag"]) != nil {
-		mux.Vars(r)["digest"] = mux.Vars(r)["tag"]
+	if m.ImageManager.HasBlob(reference) {
+		mux.Vars(r)["digest"] = reference
 		m.ServeBlob(w, r)
 		return
 	}
+	http.NotFound(w, r)
+}
+
+func (m *ImageHandler) serveDropletImage(w http.ResponseWriter, r *http.Request, dropletGUID string, dropletHash string) {
+	w.Header().Set("Vary", "Accept")
+	mediaType, acceptable := negotiateManifestMediaType(r)
+	if !acceptable {
+		writeRegistryError(w, http.StatusNotAcceptable, "UNSUPPORTED", "Images can only be served as "+strings.Join(generatedManifestMediaTypes, ", "))
+		return
+	}
 
-	manifestList := m.ImageManager.GetManifestList(strings.TrimPrefix(mux.Vars(r)["name"], "cloudfoundry/"), mux.Vars(r)["tag"])
+	content, digest := m.ImageManager.GetImageManifest(dropletGUID, dropletHash, mediaType)
 
-	if manifestList == nil {
+	if content == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	manifestListJson, e := json.Marshal(manifestList)
-	util.PanicOnError(errors.WithStack(e))
-
-	manifestListDigest, manifestListSize := shaAndSize(bytes.NewReader(manifestListJson))
-	e = m.ImageManager.digestLookupStore.Put(manifestListDigest, bytes.NewReader(manifestListJson))
-	util.PanicOnError(errors.WithStack(e))
+	writeManifest(w, r, mediaType, digest, content)
+}
 
-	w.Header().Add("Content-Type", mediatype.DistributionManifestListV2Json)
-	w.Header().Add("Docker-Content-Digest", manifestListDigest)
-	w.Header().Add("Content-Length", fmt.Sprintf("%d", manifestListSize))
+// isDropletRepository returns true for the names images generated from droplets are served under, i.e.
+// cloudfoundry/<app-guid> and <app-guid>.
+func isDropletRepository(name string) bool {
+	return strings.HasPrefix(name, "cloudfoundry/") || !strings.Contains(name, "/")
+}
 
-	w.Write(manifestListJson)
+// writeManifest omits the body for HEAD requests, but still reports its size.
+func writeManifest(w http.ResponseWriter, r *http.Request, mediaType string, digest string, content []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(content)
 }
 
+// ServeBlob also answers HEAD and Range requests. Blobs are only fetched from the blobstore when content needs to be sent.
 func (m *ImageHandler) ServeBlob(w http.ResponseWriter, r *http.Request) {
 	digest := mux.Vars(r)["digest"]
-	layer := m.ImageManager.GetBlob(mux.Vars(r)["name"], digest)
+	blobstore, path, size, found := m.ImageManager.statBlob(digest)
 
-	if layer == nil {
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
 
 	// TODO (pego): this is a hack to find out if we should serve a layer or a manifest blob. Should be improved.
 	if mux.Vars(r)["digest"] == mux.Vars(r)["tag"] {
-		w.Header().Add("Content-Type", mediatype.DistributionManifestV2Json)
+		w.Header().Set("Content-Type", mediatype.DistributionManifestV2Json)
 	} else {
-		w.Header().Add("Content-Type", mediatype.ImageRootfsTarGzip)
+		w.Header().Set("Content-Type", mediatype.ImageRootfsTarGzip)
 	}
 
-	w.Header().Add("Docker-Content-Digest", digest)
-	_, e := io.Copy(w, layer)
-
-	util.PanicOnError(errors.WithStack(e))
+	w.Header().Set("Docker-Content-Digest", digest)
+	// Blobs are addressed by their digest, so their content never changes.
+	w.Header().Set("ETag", `"`+digest+`"`)
+	bitsgo.ServeBlobContent(w, r, blobstore, path, size, time.Time{})
 }
 
 type BitsImageManager struct {
@@ -115,29 +153,46 @@ func NewBitsImageManager(
 	}
 }
 
-func (b *BitsImageManager) GetManifestList(dropletGUID string, dropletHash string) *docker.ManifestList {
-	manifest := b.GetManifest(dropletGUID, dropletHash)
-	if manifest == nil {
-		return nil
-	}
-	manifestJson, e := json.Marshal(manifest)
-	util.PanicOnError(errors.WithStack(e))
-
-	manifestDigest, manifestSize := shaAndSize(bytes.NewReader(manifestJson))
+// dropletImageRecord remembers the image generated for a droplet, so that pulling it again does not need to
+// download and convert the droplet again. Manifests in all media types can be derived from it.
+type dropletImageRecord struct {
+	// The image depends on the root FS, too. A record for a different root FS is stale.
+	RootfsDigest string `json:"rootfsDigest"`
+	ConfigDigest string `json:"configDigest"`
+	ConfigSize   int64  `json:"configSize"`
+	LayerDigest  string `json:"layerDigest"`
+	LayerSize    int64  `json:"layerSize"`
+}
 
-	e = b.digestLookupStore.Put(manifestDigest, bytes.NewReader(manifestJson))
-	util.PanicOnError(errors.WithStack(e))
+// GetImageManifest returns the manifest or index of the image generated from the droplet in mediaType,
+// which must be one of generatedManifestMediaTypes. content is nil, if the droplet does not exist.
+func (b *BitsImageManager) GetImageManifest(dropletGUID string, dropletHash string, mediaType string) (content []byte, digest string) {
+	image := b.dropletImage(dropletGUID, dropletHash)
+	if image == nil {
+		return nil, ""
+	}
+	switch mediaType {
+	case mediatype.DistributionManifestListV2Json:
+		return b.storeGeneratedManifest(b.manifestList(image, mediaType, mediatype.DistributionManifestV2Json), mediaType)
+	case mediatype.OCIImageIndexV1Json:
+		return b.storeGeneratedManifest(b.manifestList(image, mediaType, mediatype.OCIImageManifestV1Json), mediaType)
+	default:
+		return b.storeGeneratedManifest(b.manifest(image, mediaType), mediaType)
+	}
+}
 
+func (b *BitsImageManager) manifestList(image *dropletImageRecord, mediaType string, manifestMediaType string) *docker.ManifestList {
+	manifest, manifestDigest := b.storeGeneratedManifest(b.manifest(image, manifestMediaType), manifestMediaType)
 	return &docker.ManifestList{
 		Versioned: docker.Versioned{
-			MediaType:     mediatype.DistributionManifestListV2Json,
+			MediaType:     mediaType,
 			SchemaVersion: 2,
 		},
 		Manifests: []docker.ManifestDescriptor{
 			docker.ManifestDescriptor{
 				Content: docker.Content{
-					MediaType: mediatype.DistributionManifestV2Json,
-					Size:      manifestSize,
+					MediaType: manifestMediaType,
+					Size:      int64(len(manifest)),
 					Digest:    manifestDigest,
 				},
 				Platform: docker.PlatformSpec{
@@ -149,7 +204,83 @@ func (b *BitsImageManager) GetManifestList(dropletGUID string, dropletHash strin
 	}
 }
 
-func (b *BitsImageManager) GetManifest(dropletGUID string, dropletHash string) *docker.Manifest {
+func (b *BitsImageManager) manifest(image *dropletImageRecord, mediaType string) *docker.Manifest {
+	configMediaType, rootfsMediaType, layerMediaType := mediatype.ContainerImageV1Json, mediatype.ImageRootfsTarGzip, mediatype.ImageRootfsTar
+	if mediaType == mediatype.OCIImageManifestV1Json {
+		configMediaType, rootfsMediaType, layerMediaType = mediatype.OCIImageConfigV1Json, mediatype.OCIImageLayerV1TarGzip, mediatype.OCIImageLayerV1Tar
+	}
+	return &docker.Manifest{
+		Versioned: docker.Versioned{
+			MediaType:     mediaType,
+			SchemaVersion: 2,
+		},
+		Config: docker.Content{
+			MediaType: configMediaType,
+			Digest:    image.ConfigDigest,
+			Size:      image.ConfigSize,
+		},
+		Layers: []docker.Content{
+			docker.Content{
+				MediaType: rootfsMediaType,
+				Digest:    b.rootfsDigest,
+				Size:      b.rootfsSize,
+			},
+			docker.Content{
+				MediaType: layerMediaType,
+				Digest:    image.LayerDigest,
+				Size:      image.LayerSize,
+			},
+		},
+	}
+}
+
+// storeGeneratedManifest stores manifest, so that it can be pulled by digest, unless it is stored already.
+func (b *BitsImageManager) storeGeneratedManifest(manifest interface{}, mediaType string) (content []byte, digest string) {
+	content, e := json.Marshal(manifest)
+	util.PanicOnError(errors.WithStack(e))
+
+	digest, _ = shaAndSize(bytes.NewReader(content))
+	// The record is written last, so that an existing record implies an existing blob.
+	recorded, e := b.digestLookupStore.Exists(manifestRecordKey(digest))
+	util.PanicOnError(errors.WithStack(e))
+	if recorded {
+		return content, digest
+	}
+	e = b.digestLookupStore.Put(digest, bytes.NewReader(content))
+	util.PanicOnError(errors.WithStack(e))
+	e = b.putRecord(manifestRecordKey(digest), manifestRecord{MediaType: mediaType})
+	util.PanicOnError(e)
+	return content, digest
+}
+
+// dropletImage returns nil, if the droplet does not exist.
+func (b *BitsImageManager) dropletImage(dropletGUID string, dropletHash string) *dropletImageRecord {
+	var record dropletImageRecord
+	if b.getRecord(dropletImageRecordKey(dropletGUID+"/"+dropletHash), &record) && record.RootfsDigest == b.rootfsDigest {
+		return &record
+	}
+	image := b.generateDropletImage(dropletGUID, dropletHash)
+	if image == nil {
+		return nil
+	}
+	e := b.putRecord(dropletImageRecordKey(dropletGUID+"/"+dropletHash), image)
+	util.PanicOnError(e)
+	return image
+}
+
+// ForgetDroplet removes what GetImageManifest remembers about the droplet at dropletPath, i.e. <droplet-guid>/<droplet-hash>.
+// Blobs are left alone, since other images can refer to them as well.
+func (b *BitsImageManager) ForgetDroplet(dropletPath string) {
+	e := b.digestLookupStore.Delete(dropletImageRecordKey(dropletPath))
+	if e != nil && !bitsgo.IsNotFoundError(e) {
+		logger.Log.Errorw("Could not remove image record of deleted droplet", "droplet", dropletPath, "error", e)
+	}
+}
+
+func dropletImageRecordKey(dropletPath string) string { return "droplet-images/" + dropletPath }
+
+// generateDropletImage converts the droplet into a layer and stores it with the image's config. It returns nil, if the droplet does not exist.
+func (b *BitsImageManager) generateDropletImage(dropletGUID string, dropletHash string) *dropletImageRecord {
 	dropletReader, e := b.dropletBlobstore.Get(dropletGUID + "/" + dropletHash)
 
 	if bitsgo.IsNotFoundError(e) {
@@ -164,7 +295,7 @@ func (b *BitsImageManager) GetManifest(dropletGUID string, dropletHash string) *
 	defer os.Remove(ociDropletFile.Name())
 	defer ociDropletFile.Close()
 
-	preFixDroplet(dropletReader, ociDropletFile)
+	stagingInfo := preFixDroplet(dropletReader, ociDropletFile)
 
 	_, e = ociDropletFile.Seek(0, 0)
 	util.PanicOnError(errors.WithStack(e))
@@ -177,38 +308,29 @@ func (b *BitsImageManager) GetManifest(dropletGUID string, dropletHash string) *
 	e = b.digestLookupStore.Put(dropletDigest, ociDropletFile)
 	util.PanicOnError(errors.WithStack(e))
 
-	configJSON := b.configMetadata(b.rootfsDigest, dropletDigest)
+	configJSON := b.configMetadata(b.rootfsDigest, dropletDigest, stagingInfo)
 	configDigest, configSize := shaAndSize(bytes.NewReader(configJSON))
 
 	e = b.digestLookupStore.Put(configDigest, bytes.NewReader(configJSON))
 	util.PanicOnError(errors.WithStack(e))
 
-	return &docker.Manifest{
-		Versioned: docker.Versioned{
-			MediaType:     mediatype.DistributionManifestV2Json,
-			SchemaVersion: 2,
-		},
-		Config: docker.Content{
-			MediaType: mediatype.ContainerImageV1Json,
-			Digest:    configDigest,
-			Size:      configSize,
-		},
-		Layers: []docker.Content{
-			docker.Content{
-				MediaType: mediatype.ImageRootfsTarGzip,
-				Digest:    b.rootfsDigest,
-				Size:      b.rootfsSize,
-			},
-			docker.Content{
-				MediaType: mediatype.ImageRootfsTar,
-				Digest:    dropletDigest,
-				Size:      dropletSize,
-			},
-		},
+	return &dropletImageRecord{
+		RootfsDigest: b.rootfsDigest,
+		ConfigDigest: configDigest,
+		ConfigSize:   configSize,
+		LayerDigest:  dropletDigest,
+		LayerSize:    dropletSize,
 	}
 }
 
-func preFixDroplet(cfDroplet io.Reader, ociDroplet io.Writer) {
+// stagingInfo is what the buildpack app lifecycle writes to staging_info.yml in the droplet.
+type stagingInfo struct {
+	DetectedBuildpack string `yaml:"detected_buildpack"`
+	StartCommand      string `yaml:"start_command"`
+}
+
+// preFixDroplet also returns the droplet's staging_info.yml, which is empty when the droplet does not have one or it cannot be parsed.
+func preFixDroplet(cfDroplet io.Reader, ociDroplet io.Writer) (info stagingInfo) {
 	layer := tar.NewWriter(ociDroplet)
 
 	gz, e := gzip.NewReader(cfDroplet)
@@ -222,12 +344,27 @@ func preFixDroplet(cfDroplet io.Reader, ociDroplet io.Writer) {
 		}
 		util.PanicOnError(errors.WithStack(e))
 
+		content := io.Reader(t)
+		var stagingInfoYAML bytes.Buffer
+		if filepath.Clean(hdr.Name) == "staging_info.yml" {
+			content = io.TeeReader(t, &stagingInfoYAML)
+		}
+
 		hdr.Name = filepath.Join("/home/vcap", hdr.Name)
 		e = layer.WriteHeader(hdr)
 		util.PanicOnError(errors.WithStack(e))
-		_, e = io.Copy(layer, t)
+		_, e = io.Copy(layer, content)
 		util.PanicOnError(errors.WithStack(e))
+
+		if stagingInfoYAML.Len() > 0 {
+			e = yaml.Unmarshal(stagingInfoYAML.Bytes(), &info)
+			if e != nil {
+				logger.Log.Infow("Ignoring invalid staging_info.yml in droplet", "error", e)
+				info = stagingInfo{}
+			}
+		}
 	}
+	return
 }
 
 func shaAndSize(reader io.Reader) (sha string, size int64) {
@@ -237,6 +374,20 @@ func shaAndSize(reader io.Reader) (sha string, size int64) {
 	return "sha256:" + hex.EncodeToString(sha256Hash.Sum([]byte{})), configSize
 }
 
+// statBlob returns where the blob with digest is stored. found is false, if there is no such blob.
+func (b *BitsImageManager) statBlob(digest string) (blobstore bitsgo.Blobstore, path string, size int64, found bool) {
+	if digest == b.rootfsDigest {
+		return b.rootFSBlobstore, "assets/eirinifs.tar", b.rootfsSize, true
+	}
+
+	info, e := b.digestLookupStore.Stat(digest)
+	if bitsgo.IsNotFoundError(e) {
+		return nil, "", 0, false
+	}
+	util.PanicOnError(errors.WithStack(e))
+	return b.digestLookupStore, digest, info.Size, true
+}
+
 // NOTE: name is currently not used.
 func (b *BitsImageManager) GetBlob(name string, digest string) io.ReadCloser {
 	if digest == b.rootfsDigest {
@@ -254,11 +405,24 @@ func (b *BitsImageManager) GetBlob(name string, digest string) io.ReadCloser {
 	return r
 }
 
-func (b *BitsImageManager) configMetadata(rootfsDigest string, dropletDigest string) []byte {
+// configMetadata returns the OCI image config. It starts the app like Diego does, i.e. via the launcher of
+// the buildpack app lifecycle, which is part of the root FS and sources the app's .profile.d scripts.
+func (b *BitsImageManager) configMetadata(rootfsDigest string, dropletDigest string, stagingInfo stagingInfo) []byte {
+	containerConfig := map[string]interface{}{
+		"User":       "vcap",
+		"Env":        []string{"HOME=/home/vcap/app", "LANG=en_US.UTF-8", "PATH=/usr/local/bin:/usr/bin:/bin"},
+		"WorkingDir": "/home/vcap/app",
+	}
+	if stagingInfo.StartCommand != "" {
+		containerConfig["Cmd"] = []string{"/lifecycle/launcher", "app", stagingInfo.StartCommand, ""}
+	}
+	if stagingInfo.DetectedBuildpack != "" {
+		containerConfig["Labels"] = map[string]string{"org.cloudfoundry.detected_buildpack": stagingInfo.DetectedBuildpack}
+	}
 	config, e := json.Marshal(map[string]interface{}{
-		"config": map[string]interface{}{
-			"user": "vcap",
-		},
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       containerConfig,
 		"rootfs": map[string]interface{}{
 			"type": "layers",
 			"diff_ids": []string{
@@ -3,13 +3,21 @@ package oci_registry_test
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
 
 	inmemory_blobstore "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
 	"github.com/cloudfoundry-incubator/bits-service/oci_registry"
+	"github.com/cloudfoundry-incubator/bits-service/oci_registry/models/docker"
 
 	"github.com/gorilla/mux"
 	. "github.com/onsi/ginkgo"
@@ -28,6 +36,8 @@ var _ = Describe("Registry", func() {
 		rootFSBlobstore   bitsgo.Blobstore
 		dropletBlobstore  bitsgo.Blobstore
 		digestLookupStore bitsgo.Blobstore
+		imageManager      *oci_registry.BitsImageManager
+		uploadsDir        string
 		droplet           []byte
 	)
 	BeforeSuite(func() {
@@ -37,11 +47,17 @@ var _ = Describe("Registry", func() {
 		rootFSBlobstore = inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{"assets/eirinifs.tar": []byte("the-rootfs-blob")})
 		dropletBlobstore = inmemory_blobstore.NewBlobstoreWithEntries(map[string][]byte{"the-droplet-guid/the-droplet-hash": droplet})
 		digestLookupStore = inmemory_blobstore.NewBlobstore()
-		imageManager := oci_registry.NewBitsImageManager(rootFSBlobstore, dropletBlobstore, digestLookupStore)
+		imageManager = oci_registry.NewBitsImageManager(rootFSBlobstore, dropletBlobstore, digestLookupStore)
+		uploadsDir, e = ioutil.TempDir("", "registry-uploads")
+		Expect(e).NotTo(HaveOccurred())
+		uploads, e := bitsgo.NewUploadSessionStore(inmemory_blobstore.NewBlobstore(), uploadsDir, time.Hour)
+		Expect(e).NotTo(HaveOccurred())
 		router := mux.NewRouter()
 
 		routes.AddImageHandler(router, &oci_registry.ImageHandler{
 			ImageManager: imageManager,
+			Uploads:      uploads,
+			MaxBlobSize:  16,
 		})
 		fakeServer = httptest.NewServer(negroni.New(
 			// middlewares.NewZapLoggerMiddleware(logger.Log),
@@ -52,6 +68,7 @@ var _ = Describe("Registry", func() {
 
 	AfterSuite(func() {
 		fakeServer.Close()
+		os.RemoveAll(uploadsDir)
 	})
 
 	It("Serves the /v2 endpoint so that the client skips authentication", func() {
@@ -64,31 +81,47 @@ var _ = Describe("Registry", func() {
 		It("should serve the GET image manifest endpoint", func() {
 			res, e := http.Get(serverURL + "/v2/cloudfoundry/the-droplet-guid/manifests/the-droplet-hash")
 			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
-			Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.docker.distribution.manifest.list.v2+json"))
+			manifestListJSON, e := ioutil.ReadAll(res.Body)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(sha256Digest(string(manifestListJSON))))
+			var manifestList docker.ManifestList
+			Expect(json.Unmarshal(manifestListJSON, &manifestList)).To(Succeed())
+			Expect(manifestList.Manifests).To(HaveLen(1))
+			manifestDigest := manifestList.Manifests[0].Digest
+			Expect(manifestListJSON).To(MatchJSON(fmt.Sprintf(`{
 				"schemaVersion": 2,
 				"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
 				"manifests": [
 				  {
 					"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-					"digest": "sha256:42706af61c60ec7eb377b9d79e56ebbbef8ccb5637ef12df070363ea0103e570",
-					"size": 582,
+					"digest": "%v",
+					"size": %v,
 					"platform": {
 					  "architecture": "amd64",
 					  "os": "linux"
 					}
 				  }
 				]
-			  }`))
+			  }`, manifestDigest, manifestList.Manifests[0].Size)))
 
-			res, e = http.Get(serverURL + "/v2/irrelevant-image-name/manifests/sha256:42706af61c60ec7eb377b9d79e56ebbbef8ccb5637ef12df070363ea0103e570")
+			res, e = http.Get(serverURL + "/v2/irrelevant-image-name/manifests/" + manifestDigest)
 			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
-			Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+			manifestJSON, e := ioutil.ReadAll(res.Body)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(sha256Digest(string(manifestJSON))).To(Equal(manifestDigest))
+			Expect(manifestJSON).To(HaveLen(int(manifestList.Manifests[0].Size)))
+			var manifest docker.Manifest
+			Expect(json.Unmarshal(manifestJSON, &manifest)).To(Succeed())
+			configDigest := manifest.Config.Digest
+			Expect(manifestJSON).To(MatchJSON(fmt.Sprintf(`{
 				"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
 				"schemaVersion": 2,
 				"config": {
 					"mediaType": "application/vnd.docker.container.image.v1+json",
-					"digest": "sha256:e45cc3b91fb3e12c17e8a9a3a9d476f516f480756795a2b446f0a129f6bcae3d",
-					"size": 214
+					"digest": "%v",
+					"size": %v
 				},
 				"layers": [
 					{
@@ -102,22 +135,26 @@ var _ = Describe("Registry", func() {
 						"size": 86134392
 					}
 				]
-			}`))
+			}`, configDigest, manifest.Config.Size)))
 
-			res, e = http.Get(serverURL + "/v2/irrelevant-image-name/blobs/sha256:e45cc3b91fb3e12c17e8a9a3a9d476f516f480756795a2b446f0a129f6bcae3d")
+			res, e = http.Get(serverURL + "/v2/irrelevant-image-name/blobs/" + configDigest)
 			Expect(e).NotTo(HaveOccurred())
-			Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{
-				"config": {
-				  "user": "vcap"
-				},
-				"rootfs": {
-				  "diff_ids": [
+			configJSON, e := ioutil.ReadAll(res.Body)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(sha256Digest(string(configJSON))).To(Equal(configDigest))
+			var config map[string]interface{}
+			Expect(json.Unmarshal(configJSON, &config)).To(Succeed())
+			Expect(config).To(HaveKeyWithValue("architecture", "amd64"))
+			Expect(config).To(HaveKeyWithValue("os", "linux"))
+			Expect(config["config"]).To(HaveKeyWithValue("User", "vcap"))
+			Expect(config["config"]).To(HaveKeyWithValue("WorkingDir", "/home/vcap/app"))
+			Expect(json.Marshal(config["rootfs"])).To(MatchJSON(`{
+				"diff_ids": [
 					"sha256:56ca430559f451494a0e97ff4989ebe28b5d61041f1d7cf8f244acc76974df20",
 					"sha256:ccba5ce536c29da80ff2da1c81fc7b9e4d07ab679b6bfb03964432f116d61dd7"
-				  ],
-				  "type": "layers"
-				}
-			  }`))
+				],
+				"type": "layers"
+			}`))
 
 			res, e = http.Get(serverURL + "/v2/irrelevant-image-name/blobs/sha256:56ca430559f451494a0e97ff4989ebe28b5d61041f1d7cf8f244acc76974df20")
 			Expect(e).NotTo(HaveOccurred())
@@ -141,6 +178,24 @@ var _ = Describe("Registry", func() {
 			}
 		})
 
+		It("remembers generated images until the droplet is forgotten", func() {
+			Expect(dropletBlobstore.Put("other-droplet-guid/other-droplet-hash", bytes.NewReader(droplet))).To(Succeed())
+			res, e := http.Get(serverURL + "/v2/cloudfoundry/other-droplet-guid/manifests/other-droplet-hash")
+			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+			digest := res.Header.Get("Docker-Content-Digest")
+
+			// Without the record, the droplet would be needed to generate the image again.
+			Expect(dropletBlobstore.Delete("other-droplet-guid/other-droplet-hash")).To(Succeed())
+			res, e = http.Get(serverURL + "/v2/cloudfoundry/other-droplet-guid/manifests/other-droplet-hash")
+			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(digest))
+
+			imageManager.ForgetDroplet("other-droplet-guid/other-droplet-hash")
+
+			res, e = http.Get(serverURL + "/v2/cloudfoundry/other-droplet-guid/manifests/other-droplet-hash")
+			Expect(res.StatusCode, e).To(Equal(http.StatusNotFound))
+		})
+
 		It("returns StatusNotFound when droplet does not exist", func() {
 			res, e := http.Get(serverURL + "/v2/image/name/manifests/non-existing-droplet-guid")
 
@@ -167,4 +222,307 @@ var _ = Describe("Registry", func() {
 			})
 		})
 	})
+
+	Describe("media types", func() {
+		BeforeEach(func() {
+			Expect(dropletBlobstore.Put("staged-droplet-guid/staged-droplet-hash", bytes.NewReader(gzippedTar(map[string]string{
+				"./staging_info.yml": `{"detected_buildpack":"ruby","start_command":"bundle exec rackup"}`,
+				"./app/config.ru":    "run App",
+			})))).To(Succeed())
+		})
+
+		get := func(path string, accept ...string) *http.Response {
+			request, e := http.NewRequest("GET", serverURL+path, nil)
+			Expect(e).NotTo(HaveOccurred())
+			for _, mediaType := range accept {
+				request.Header.Add("Accept", mediaType)
+			}
+			res, e := http.DefaultClient.Do(request)
+			Expect(e).NotTo(HaveOccurred())
+			return res
+		}
+
+		It("serves an OCI image index and manifest when the client accepts them", func() {
+			res := get("/v2/cloudfoundry/staged-droplet-guid/manifests/staged-droplet-hash",
+				"application/vnd.oci.image.index.v1+json", "application/vnd.oci.image.manifest.v1+json")
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.oci.image.index.v1+json"))
+			var index docker.ManifestList
+			Expect(json.NewDecoder(res.Body).Decode(&index)).To(Succeed())
+			Expect(index.MediaType).To(Equal("application/vnd.oci.image.index.v1+json"))
+			Expect(index.Manifests).To(HaveLen(1))
+			Expect(index.Manifests[0].MediaType).To(Equal("application/vnd.oci.image.manifest.v1+json"))
+
+			res = get("/v2/irrelevant-image-name/manifests/" + index.Manifests[0].Digest)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.oci.image.manifest.v1+json"))
+			var manifest docker.Manifest
+			Expect(json.NewDecoder(res.Body).Decode(&manifest)).To(Succeed())
+			Expect(manifest.Config.MediaType).To(Equal("application/vnd.oci.image.config.v1+json"))
+			Expect(manifest.Layers).To(HaveLen(2))
+			Expect(manifest.Layers[0].MediaType).To(Equal("application/vnd.oci.image.layer.v1.tar+gzip"))
+			Expect(manifest.Layers[1].MediaType).To(Equal("application/vnd.oci.image.layer.v1.tar"))
+
+			res = get("/v2/irrelevant-image-name/blobs/" + manifest.Config.Digest)
+			Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{
+				"architecture": "amd64",
+				"os": "linux",
+				"config": {
+					"User": "vcap",
+					"Env": ["HOME=/home/vcap/app", "LANG=en_US.UTF-8", "PATH=/usr/local/bin:/usr/bin:/bin"],
+					"WorkingDir": "/home/vcap/app",
+					"Cmd": ["/lifecycle/launcher", "app", "bundle exec rackup", ""],
+					"Labels": {"org.cloudfoundry.detected_buildpack": "ruby"}
+				},
+				"rootfs": {
+					"type": "layers",
+					"diff_ids": ["` + sha256Digest("the-rootfs-blob") + `", "` + manifest.Layers[1].Digest + `"]
+				}
+			}`))
+		})
+
+		It("serves the manifest directly when the client does not accept manifest lists", func() {
+			res := get("/v2/cloudfoundry/staged-droplet-guid/manifests/staged-droplet-hash", "application/vnd.docker.distribution.manifest.v2+json")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+			var manifest docker.Manifest
+			Expect(json.NewDecoder(res.Body).Decode(&manifest)).To(Succeed())
+			Expect(manifest.Config.MediaType).To(Equal("application/vnd.docker.container.image.v1+json"))
+		})
+
+		It("honours the quality of the accepted media types", func() {
+			res := get("/v2/cloudfoundry/staged-droplet-guid/manifests/staged-droplet-hash",
+				"application/vnd.docker.distribution.manifest.list.v2+json;q=0.5, application/vnd.oci.image.index.v1+json")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.oci.image.index.v1+json"))
+		})
+
+		It("returns StatusNotAcceptable when the client accepts none of the media types", func() {
+			res := get("/v2/cloudfoundry/staged-droplet-guid/manifests/staged-droplet-hash", "application/json", "*/*;q=0")
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotAcceptable))
+			Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("UNSUPPORTED"))
+		})
+	})
+
+	Describe("blobs", func() {
+		var rootfsDigest string
+
+		BeforeEach(func() {
+			rootfsDigest = sha256Digest("the-rootfs-blob")
+		})
+
+		It("answers HEAD requests without body", func() {
+			res, e := http.Head(serverURL + "/v2/irrelevant-image-name/blobs/" + rootfsDigest)
+
+			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+			Expect(res.ContentLength).To(BeEquivalentTo(len("the-rootfs-blob")))
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(rootfsDigest))
+			Expect(res.Header.Get("Accept-Ranges")).To(Equal("bytes"))
+			Expect(ioutil.ReadAll(res.Body)).To(BeEmpty())
+		})
+
+		It("serves the requested range only", func() {
+			request, e := http.NewRequest("GET", serverURL+"/v2/irrelevant-image-name/blobs/"+rootfsDigest, nil)
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Range", "bytes=4-9")
+
+			res, e := http.DefaultClient.Do(request)
+
+			Expect(res.StatusCode, e).To(Equal(http.StatusPartialContent))
+			Expect(res.Header.Get("Content-Range")).To(Equal("bytes 4-9/15"))
+			Expect(ioutil.ReadAll(res.Body)).To(Equal([]byte("rootfs")))
+		})
+
+		It("returns StatusNotFound for unknown blobs", func() {
+			res, e := http.Head(serverURL + "/v2/irrelevant-image-name/blobs/sha256:" + strings.Repeat("3", 64))
+
+			Expect(res.StatusCode, e).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("push image", func() {
+		const layer = "the-layer"
+
+		var actualLayerDigest string
+
+		BeforeEach(func() {
+			actualLayerDigest = sha256Digest(layer)
+		})
+
+		do := func(method string, url string, body string, headers ...string) *http.Response {
+			request, e := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(e).NotTo(HaveOccurred())
+			for i := 0; i < len(headers); i += 2 {
+				request.Header.Set(headers[i], headers[i+1])
+			}
+			response, e := http.DefaultClient.Do(request)
+			Expect(e).NotTo(HaveOccurred())
+			return response
+		}
+
+		pushLayer := func() {
+			res := do("POST", serverURL+"/v2/my/image/blobs/uploads/", "")
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			location := res.Header.Get("Location")
+			Expect(location).To(HavePrefix("/v2/my/image/blobs/uploads/"))
+			Expect(res.Header.Get("Docker-Upload-UUID")).NotTo(BeEmpty())
+
+			res = do("PATCH", serverURL+location, layer[:4], "Content-Range", "0-3")
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(res.Header.Get("Range")).To(Equal("0-3"))
+
+			res = do("PUT", serverURL+location+"?digest="+actualLayerDigest, layer[4:])
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(actualLayerDigest))
+		}
+
+		It("uploads blobs in chunks and serves them", func() {
+			pushLayer()
+
+			res, e := http.Get(serverURL + "/v2/my/image/blobs/" + actualLayerDigest)
+			Expect(e).NotTo(HaveOccurred())
+			Expect(ioutil.ReadAll(res.Body)).To(Equal([]byte(layer)))
+		})
+
+		It("rejects blobs whose digest does not match", func() {
+			res := do("POST", serverURL+"/v2/my/image/blobs/uploads/?digest=sha256:"+strings.Repeat("0", 64), layer)
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(ioutil.ReadAll(res.Body)).To(MatchJSON(`{"errors":[{"code":"DIGEST_INVALID","message":"Blob has digest ` + actualLayerDigest + `, but sha256:` + strings.Repeat("0", 64) + ` was given"}]}`))
+		})
+
+		It("rejects blobs larger than the maximum blob size", func() {
+			tooLarge := strings.Repeat("x", 17)
+
+			res := do("POST", serverURL+"/v2/my/image/blobs/uploads/?digest="+sha256Digest(tooLarge), tooLarge)
+			Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("SIZE_INVALID"))
+
+			res = do("POST", serverURL+"/v2/my/image/blobs/uploads/", "")
+			location := res.Header.Get("Location")
+			Expect(do("PATCH", serverURL+location, tooLarge[:10]).StatusCode).To(Equal(http.StatusAccepted))
+			Expect(do("PATCH", serverURL+location, tooLarge[10:]).StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+
+		It("tells the client where to resume when a chunk starts at the wrong offset", func() {
+			res := do("POST", serverURL+"/v2/my/image/blobs/uploads/", "")
+			location := res.Header.Get("Location")
+			do("PATCH", serverURL+location, layer[:4])
+
+			res = do("PATCH", serverURL+location, layer[2:], "Content-Range", fmt.Sprintf("2-%v", len(layer)-1))
+
+			Expect(res.StatusCode).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+			Expect(res.Header.Get("Range")).To(Equal("0-3"))
+		})
+
+		It("only continues uploads via the repository they were started for", func() {
+			res := do("POST", serverURL+"/v2/my/image/blobs/uploads/", "")
+			location := res.Header.Get("Location")
+			otherLocation := strings.Replace(location, "/v2/my/image/", "/v2/other/image/", 1)
+
+			for _, method := range []string{"PATCH", "GET", "PUT", "DELETE"} {
+				res = do(method, serverURL+otherLocation+"?digest="+actualLayerDigest, layer)
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound), method)
+				Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("BLOB_UPLOAD_UNKNOWN"))
+			}
+			Expect(do("GET", serverURL+location, "").StatusCode).To(Equal(http.StatusNoContent))
+		})
+
+		It("does not mount blobs without the repository they are mounted from", func() {
+			pushLayer()
+
+			res := do("POST", serverURL+"/v2/other/image/blobs/uploads/?mount="+actualLayerDigest, "")
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+		})
+
+		It("mounts existing blobs from other repositories", func() {
+			pushLayer()
+
+			res := do("POST", serverURL+"/v2/other/image/blobs/uploads/?mount="+actualLayerDigest+"&from=my/image", "")
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(res.Header.Get("Location")).To(Equal("/v2/other/image/blobs/" + actualLayerDigest))
+		})
+
+		It("starts a regular upload when the blob to mount does not exist", func() {
+			res := do("POST", serverURL+"/v2/other/image/blobs/uploads/?mount=sha256:"+strings.Repeat("1", 64)+"&from=my/image", "")
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+		})
+
+		It("stores manifests by tag and serves them with their media type", func() {
+			pushLayer()
+			manifest := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+				`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + actualLayerDigest + `","size":9},` +
+				`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","digest":"` + actualLayerDigest + `","size":9}]}`
+
+			res := do("PUT", serverURL+"/v2/my/image/manifests/v1", manifest, "Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(sha256Digest(manifest)))
+
+			for _, reference := range []string{"v1", sha256Digest(manifest)} {
+				res, e := http.Get(serverURL + "/v2/my/image/manifests/" + reference)
+				Expect(e).NotTo(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.oci.image.manifest.v1+json"))
+				Expect(ioutil.ReadAll(res.Body)).To(Equal([]byte(manifest)))
+			}
+
+			res, e := http.Head(serverURL + "/v2/my/image/manifests/v1")
+			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+			Expect(res.ContentLength).To(BeEquivalentTo(len(manifest)))
+			Expect(res.Header.Get("Docker-Content-Digest")).To(Equal(sha256Digest(manifest)))
+			Expect(ioutil.ReadAll(res.Body)).To(BeEmpty())
+		})
+
+		It("does not let pushes replace images generated from droplets", func() {
+			pushLayer()
+			manifest := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+				`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + actualLayerDigest + `","size":9},` +
+				`"layers":[]}`
+
+			for _, name := range []string{"cloudfoundry/the-droplet-guid", "the-droplet-guid"} {
+				res := do("PUT", serverURL+"/v2/"+name+"/manifests/the-droplet-hash", manifest, "Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("DENIED"))
+			}
+
+			// Tags stored in a droplet's repository by other means must not shadow the generated image either.
+			Expect(imageManager.PutManifest("cloudfoundry/the-droplet-guid", "the-droplet-hash", "application/vnd.oci.image.manifest.v1+json", []byte(manifest))).To(Succeed())
+			res, e := http.Get(serverURL + "/v2/cloudfoundry/the-droplet-guid/manifests/the-droplet-hash")
+			Expect(res.StatusCode, e).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/vnd.docker.distribution.manifest.list.v2+json"))
+		})
+
+		It("rejects manifests referring to unknown blobs", func() {
+			manifest := `{"schemaVersion":2,"config":{"digest":"sha256:` + strings.Repeat("2", 64) + `"},"layers":[]}`
+
+			res := do("PUT", serverURL+"/v2/my/image/manifests/v2", manifest, "Content-Type", "application/vnd.oci.image.manifest.v1+json")
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(ioutil.ReadAll(res.Body)).To(ContainSubstring("MANIFEST_BLOB_UNKNOWN"))
+		})
+	})
 })
+
+func sha256Digest(content string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+}
+
+func gzippedTar(files map[string]string) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	t := tar.NewWriter(gz)
+	for name, content := range files {
+		Expect(t.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(Succeed())
+		_, e := t.Write([]byte(content))
+		Expect(e).NotTo(HaveOccurred())
+	}
+	Expect(t.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buffer.Bytes()
+}
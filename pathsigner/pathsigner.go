@@ -19,6 +19,11 @@ type PathSigner interface {
 	Sign(method string, path string, expires time.Time) string
 }
 
+// TenantPathSigner signs paths which carry the tenant whose quota uploads to them are charged to.
+type TenantPathSigner interface {
+	SignForTenant(method string, path string, expires time.Time, tenant string) string
+}
+
 type PathSignatureValidator interface {
 	SignatureValid(method string, u *url.URL) bool
 }
@@ -41,11 +46,21 @@ func Validate(signer *PathSignerValidator) *PathSignerValidator {
 }
 
 func (signer *PathSignerValidator) Sign(method string, path string, expires time.Time) string {
+	return signer.SignForTenant(method, path, expires, "")
+}
+
+// SignForTenant adds tenant as claim to the signed path. The signature covers the claim, so it cannot be changed.
+// An empty tenant results in the same signed path as Sign.
+func (signer *PathSignerValidator) SignForTenant(method string, path string, expires time.Time, tenant string) string {
 	method = strings.ToUpper(method)
+	var tenantClaim string
+	if tenant != "" {
+		tenantClaim = "&tenant=" + url.QueryEscape(tenant)
+	}
 	if len(signer.SigningKeys) > 0 {
-		return fmt.Sprintf("%s?signature=%x&expires=%v&AccessKeyId=%v", path, signatureWithHMACFor(method, path, signer.SigningKeys[signer.ActiveKeyID], expires), expires.Unix(), signer.ActiveKeyID)
+		return fmt.Sprintf("%s?signature=%x&expires=%v&AccessKeyId=%v%s", path, signatureWithHMACFor(method, path, signer.SigningKeys[signer.ActiveKeyID], expires, tenant), expires.Unix(), signer.ActiveKeyID, tenantClaim)
 	}
-	return fmt.Sprintf("%s?signature=%x&expires=%v", path, signatureWithHMACFor(method, path, signer.Secret, expires), expires.Unix())
+	return fmt.Sprintf("%s?signature=%x&expires=%v%s", path, signatureWithHMACFor(method, path, signer.Secret, expires, tenant), expires.Unix(), tenantClaim)
 }
 
 func (signer *PathSignerValidator) SignatureValid(method string, u *url.URL) bool {
@@ -68,19 +83,23 @@ func (signer *PathSignerValidator) SignatureValid(method string, u *url.URL) boo
 		if _, exist := signer.SigningKeys[accessKeyID]; !exist {
 			return false
 		}
-		if subtle.ConstantTimeCompare(querySignature, signatureWithHMACFor(method, u.Path, signer.SigningKeys[accessKeyID], time.Unix(expires, 0))) == 0 {
+		if subtle.ConstantTimeCompare(querySignature, signatureWithHMACFor(method, u.Path, signer.SigningKeys[accessKeyID], time.Unix(expires, 0), u.Query().Get("tenant"))) == 0 {
 			return false
 		}
 	} else {
-		if subtle.ConstantTimeCompare(querySignature, signatureWithHMACFor(method, u.Path, signer.Secret, time.Unix(expires, 0))) == 0 {
+		if subtle.ConstantTimeCompare(querySignature, signatureWithHMACFor(method, u.Path, signer.Secret, time.Unix(expires, 0), u.Query().Get("tenant"))) == 0 {
 			return false
 		}
 	}
 	return true
 }
 
-func signatureWithHMACFor(method string, path string, secret string, expires time.Time) []byte {
+// signatureWithHMACFor only covers tenant when it is set, so that signatures without tenant claim stay the same.
+func signatureWithHMACFor(method string, path string, secret string, expires time.Time, tenant string) []byte {
 	hash := hmac.New(sha256.New, []byte(secret))
 	hash.Write([]byte(fmt.Sprintf("%v %v %v %v", method, path, secret, expires.Unix())))
+	if tenant != "" {
+		hash.Write([]byte(" tenant=" + tenant))
+	}
 	return hash.Sum(nil)
 }
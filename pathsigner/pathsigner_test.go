@@ -97,6 +97,26 @@ var _ = Describe("PathSigner", func() {
 			Expect(signer.SignatureValid("GET", u)).To(BeFalse())
 		})
 
+		It("can sign a path for a tenant and will not allow to tamper with the tenant", func() {
+			signedPath := signer.SignForTenant("PUT", "/some/path", time.Unix(200, 0), "space-guid")
+			Expect(signedPath).To(ContainSubstring("tenant=space-guid"))
+			Expect(signer.SignatureValid("PUT", httputil.MustParse(signedPath))).To(BeTrue())
+
+			for _, tenant := range []string{"other-space-guid", ""} {
+				u := httputil.MustParse(signedPath)
+				q := u.Query()
+				q.Set("tenant", tenant)
+				u.RawQuery = q.Encode()
+				Expect(signer.SignatureValid("PUT", u)).To(BeFalse())
+			}
+
+			u := httputil.MustParse(signer.Sign("PUT", "/some/path", time.Unix(200, 0)))
+			q := u.Query()
+			q.Set("tenant", "space-guid")
+			u.RawQuery = q.Encode()
+			Expect(signer.SignatureValid("PUT", u)).To(BeFalse())
+		})
+
 		It("can sign a path and will not allow to tamper with the AccessKeyId", func() {
 			signedPath := signer.Sign("GET", "/some/path", time.Unix(200, 0))
 
@@ -0,0 +1,169 @@
+package prometheus
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bits"
+
+// MetricsService collects metrics for Prometheus to scrape from Handler.
+// Request and blobstore metrics use labels. All other metrics are created on first use,
+// with their names sanitized to be valid Prometheus metric names.
+type MetricsService struct {
+	registry *prometheus.Registry
+
+	requests          *prometheus.CounterVec
+	requestDurations  *prometheus.HistogramVec
+	requestSizes      *prometheus.HistogramVec
+	responseSizes     *prometheus.HistogramVec
+	blobstoreDuration *prometheus.HistogramVec
+
+	mutex    sync.Mutex
+	timings  map[string]prometheus.Histogram
+	gauges   map[string]prometheus.Gauge
+	counters map[string]prometheus.Counter
+}
+
+func NewMetricsService() *MetricsService {
+	sizeBuckets := prometheus.ExponentialBuckets(1024, 4, 10) // 1KB to 256GB
+	service := &MetricsService{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests.",
+		}, []string{"method", "resource_type", "status"}),
+		requestDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16), // 5ms to ~3min
+		}, []string{"method", "resource_type", "status"}),
+		requestSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_size_bytes",
+			Help:      "Size of HTTP request bodies.",
+			Buckets:   sizeBuckets,
+		}, []string{"method", "resource_type"}),
+		responseSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_response_size_bytes",
+			Help:      "Size of HTTP response bodies.",
+			Buckets:   sizeBuckets,
+		}, []string{"method", "resource_type"}),
+		blobstoreDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "blobstore_operation_duration_seconds",
+			Help:      "Duration of blobstore operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+		}, []string{"resource_type", "operation"}),
+		timings:  make(map[string]prometheus.Histogram),
+		gauges:   make(map[string]prometheus.Gauge),
+		counters: make(map[string]prometheus.Counter),
+	}
+	service.registry.MustRegister(
+		service.requests,
+		service.requestDurations,
+		service.requestSizes,
+		service.responseSizes,
+		service.blobstoreDuration,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return service
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (service *MetricsService) Handler() http.Handler {
+	return promhttp.HandlerFor(service.registry, promhttp.HandlerOpts{})
+}
+
+func (service *MetricsService) ObserveRequest(method string, resourceType string, status int, duration time.Duration, requestSize int64, responseSize int64) {
+	statusLabel := strconv.Itoa(status)
+	service.requests.WithLabelValues(method, resourceType, statusLabel).Inc()
+	service.requestDurations.WithLabelValues(method, resourceType, statusLabel).Observe(duration.Seconds())
+	if requestSize >= 0 {
+		service.requestSizes.WithLabelValues(method, resourceType).Observe(float64(requestSize))
+	}
+	service.responseSizes.WithLabelValues(method, resourceType).Observe(float64(responseSize))
+}
+
+func (service *MetricsService) ObserveBlobstoreOperation(resourceType string, operation string, duration time.Duration) {
+	service.blobstoreDuration.WithLabelValues(resourceType, operation).Observe(duration.Seconds())
+}
+
+func (service *MetricsService) SendTimingMetric(name string, duration time.Duration) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	histogram, exists := service.timings[name]
+	if !exists {
+		histogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      metricNameFrom(strings.TrimSuffix(name, "-time")) + "_seconds",
+			Help:      name,
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+		})
+		if !service.register(histogram) {
+			return
+		}
+		service.timings[name] = histogram
+	}
+	histogram.Observe(duration.Seconds())
+}
+
+func (service *MetricsService) SendGaugeMetric(name string, value int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	gauge, exists := service.gauges[name]
+	if !exists {
+		gauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      metricNameFrom(name),
+			Help:      name,
+		})
+		if !service.register(gauge) {
+			return
+		}
+		service.gauges[name] = gauge
+	}
+	gauge.Set(float64(value))
+}
+
+func (service *MetricsService) SendCounterMetric(name string, value int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	counter, exists := service.counters[name]
+	if !exists {
+		counter = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricNameFrom(name) + "_total",
+			Help:      name,
+		})
+		if !service.register(counter) {
+			return
+		}
+		service.counters[name] = counter
+	}
+	if value > 0 {
+		counter.Add(float64(value))
+	}
+}
+
+// register drops metrics whose sanitized names clash with already registered ones instead of failing.
+func (service *MetricsService) register(collector prometheus.Collector) bool {
+	return service.registry.Register(collector) == nil
+}
+
+var invalidMetricNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+func metricNameFrom(name string) string {
+	return strings.Trim(invalidMetricNameCharacters.ReplaceAllString(name, "_"), "_")
+}
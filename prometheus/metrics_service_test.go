@@ -0,0 +1,54 @@
+package prometheus_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service"
+	. "github.com/cloudfoundry-incubator/bits-service/prometheus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MetricsService", func() {
+	var metricsService *MetricsService
+
+	BeforeEach(func() {
+		metricsService = NewMetricsService()
+	})
+
+	scrape := func() string {
+		responseWriter := httptest.NewRecorder()
+		metricsService.Handler().ServeHTTP(responseWriter, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(responseWriter.Code).To(Equal(http.StatusOK))
+		return responseWriter.Body.String()
+	}
+
+	It("records requests in histograms labeled with method, resource type and status", func() {
+		bitsgo.ObserveRequest(metricsService, "PUT", "packages", 201, 300*time.Millisecond, 2048, 100)
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bits_http_requests_total{method="PUT",resource_type="packages",status="201"} 1`))
+		Expect(metrics).To(ContainSubstring(`bits_http_request_duration_seconds_count{method="PUT",resource_type="packages",status="201"} 1`))
+		Expect(metrics).To(ContainSubstring(`bits_http_request_size_bytes_sum{method="PUT",resource_type="packages"} 2048`))
+		Expect(metrics).NotTo(ContainSubstring("PUT_packages"))
+	})
+
+	It("records blobstore operations labeled with resource type and operation", func() {
+		bitsgo.ObserveBlobstoreOperation(metricsService, "droplets", "put", time.Second)
+
+		Expect(scrape()).To(ContainSubstring(`bits_blobstore_operation_duration_seconds_sum{operation="put",resource_type="droplets"} 1`))
+	})
+
+	It("exposes other metrics with sanitized names", func() {
+		metricsService.SendCounterMetric("app_stash-gc-removed_entries", 3)
+		metricsService.SendGaugeMetric("numGoRoutines", 42)
+		metricsService.SendTimingMetric("buildpacks-reaper-time", 2*time.Second)
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring("bits_app_stash_gc_removed_entries_total 3"))
+		Expect(metrics).To(ContainSubstring("bits_numGoRoutines 42"))
+		Expect(metrics).To(ContainSubstring("bits_buildpacks_reaper_seconds_sum 2"))
+	})
+})
@@ -0,0 +1,43 @@
+package bitsgo
+
+import (
+	"context"
+	"fmt"
+)
+
+// TenantHeader identifies the tenant, e.g. the space, whose quota requests to the private endpoint are charged to.
+// Requests to the public endpoint cannot set it. They carry the tenant as claim of their signed URL instead.
+const TenantHeader = "X-Bits-Tenant"
+
+type tenantContextKey struct{}
+
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFrom returns an empty string, if ctx belongs to a request without tenant.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+type QuotaExceededError struct {
+	error
+	Tenant string
+	Usage  int64
+	Limit  int64
+}
+
+func NewQuotaExceededError(tenant string, usage int64, size int64, limit int64) *QuotaExceededError {
+	return &QuotaExceededError{
+		error:  fmt.Errorf("Quota of tenant %v exceeded: %v bytes in use, %v bytes to add, limit is %v bytes", tenant, usage, size, limit),
+		Tenant: tenant,
+		Usage:  usage,
+		Limit:  limit,
+	}
+}
+
+func IsQuotaExceededError(e error) bool {
+	_, quotaExceeded := e.(*QuotaExceededError)
+	return quotaExceeded
+}
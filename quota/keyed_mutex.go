@@ -0,0 +1,41 @@
+package quota
+
+import "sync"
+
+// keyedMutex locks keys independently of each other. It only keeps the locks of keys which are locked or waited for.
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	holders int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyLock)}
+}
+
+func (m *keyedMutex) Lock(key string) {
+	m.mutex.Lock()
+	lock, exists := m.locks[key]
+	if !exists {
+		lock = &keyLock{}
+		m.locks[key] = lock
+	}
+	lock.holders++
+	m.mutex.Unlock()
+	lock.Lock()
+}
+
+func (m *keyedMutex) Unlock(key string) {
+	m.mutex.Lock()
+	lock := m.locks[key]
+	lock.holders--
+	if lock.holders == 0 {
+		delete(m.locks, key)
+	}
+	m.mutex.Unlock()
+	lock.Unlock()
+}
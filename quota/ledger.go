@@ -0,0 +1,295 @@
+package quota
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	"github.com/pkg/errors"
+)
+
+const (
+	entriesPrefix = "entries/"
+	usagePrefix   = "usage/"
+)
+
+// Entry is what the ledger knows about a blob: the tenant it is charged to and its size.
+type Entry struct {
+	Tenant string `json:"tenant"`
+	Size   int64  `json:"size"`
+}
+
+// Ledger tracks the storage used by every tenant and enforces their limits. It keeps its records in a blobstore
+// which all bits-service instances share, so that they all see the same usage:
+//
+// entries/<key> holds the Entry for key, and usage/<tenant>/<size>/<key> charges size to tenant. A tenant's usage is
+// the sum of the sizes listed under its usage prefix, so it needs no shared counter which instances could overwrite.
+//
+// Listing the usage records of a tenant takes a request per page, so the ledger caches the sum for usageTTL and
+// adds its own charges to it. Charges are serialized per tenant and instance only. Uploads via different instances
+// can exceed a tenant's limit together by what they charged within usageTTL.
+type Ledger struct {
+	store        bitsgo.Blobstore
+	defaultLimit int64
+	tenantLimits map[string]int64
+	usageTTL     time.Duration
+	clock        clock.Clock
+
+	keyLocks    *keyedMutex
+	tenantLocks *keyedMutex
+
+	mutex  sync.Mutex
+	usages map[string]*cachedUsage
+}
+
+type cachedUsage struct {
+	usage    int64
+	loadedAt time.Time
+}
+
+const DefaultUsageTTL = 10 * time.Second
+
+// NewLedger keeps the ledger in store. A limit of 0 means unlimited.
+// tenantLimits overrides defaultLimit for individual tenants.
+func NewLedger(store bitsgo.Blobstore, defaultLimit int64, tenantLimits map[string]int64) *Ledger {
+	return NewLedgerWithUsageTTL(store, defaultLimit, tenantLimits, DefaultUsageTTL, clock.New())
+}
+
+func NewLedgerWithUsageTTL(store bitsgo.Blobstore, defaultLimit int64, tenantLimits map[string]int64, usageTTL time.Duration, clock clock.Clock) *Ledger {
+	return &Ledger{
+		store:        store,
+		defaultLimit: defaultLimit,
+		tenantLimits: tenantLimits,
+		usageTTL:     usageTTL,
+		clock:        clock,
+		keyLocks:     newKeyedMutex(),
+		tenantLocks:  newKeyedMutex(),
+		usages:       make(map[string]*cachedUsage),
+	}
+}
+
+// Charge records size bytes at key for tenant, replacing what was recorded at key before. It returns the replaced entry,
+// so that callers can Restore it when the write fails, or a *bitsgo.QuotaExceededError when tenant has not enough quota left.
+// An empty tenant charges nobody, i.e. it only removes the previous entry.
+func (ledger *Ledger) Charge(key string, tenant string, size int64) (previous *Entry, err error) {
+	ledger.keyLocks.Lock(key)
+	defer ledger.keyLocks.Unlock(key)
+
+	previous, e := ledger.lookup(key)
+	if e != nil {
+		return nil, e
+	}
+	if tenant == "" {
+		return previous, ledger.set(key, previous, nil)
+	}
+	if limit := ledger.LimitFor(tenant); limit > 0 {
+		ledger.tenantLocks.Lock(tenant)
+		defer ledger.tenantLocks.Unlock(tenant)
+
+		usage, e := ledger.usage(tenant)
+		if e != nil {
+			return nil, e
+		}
+		if previous != nil && previous.Tenant == tenant {
+			usage -= previous.Size
+		}
+		if usage+size > limit {
+			return nil, bitsgo.NewQuotaExceededError(tenant, usage, size, limit)
+		}
+	}
+	return previous, ledger.set(key, previous, &Entry{Tenant: tenant, Size: size})
+}
+
+// Restore puts back an entry returned by Charge. A nil previous removes key.
+func (ledger *Ledger) Restore(key string, previous *Entry) error {
+	ledger.keyLocks.Lock(key)
+	defer ledger.keyLocks.Unlock(key)
+	current, e := ledger.lookup(key)
+	if e != nil {
+		return e
+	}
+	return ledger.set(key, current, previous)
+}
+
+func (ledger *Ledger) Release(key string) error {
+	ledger.keyLocks.Lock(key)
+	defer ledger.keyLocks.Unlock(key)
+	current, e := ledger.lookup(key)
+	if e != nil || current == nil {
+		return e
+	}
+	return ledger.set(key, current, nil)
+}
+
+func (ledger *Ledger) ReleasePrefix(prefix string) error {
+	var keys []string
+	e := ledger.forEach(entriesPrefix+prefix, func(path string) {
+		keys = append(keys, strings.TrimPrefix(path, entriesPrefix))
+	})
+	if e != nil {
+		return e
+	}
+	for _, key := range keys {
+		e = ledger.Release(key)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Lookup returns nil, if nothing is recorded at key.
+func (ledger *Ledger) Lookup(key string) (*Entry, error) {
+	return ledger.lookup(key)
+}
+
+func (ledger *Ledger) Usage(tenant string) (int64, error) {
+	return ledger.usage(tenant)
+}
+
+// LimitFor returns 0, if tenant is unlimited.
+func (ledger *Ledger) LimitFor(tenant string) int64 {
+	if limit, exists := ledger.tenantLimits[tenant]; exists {
+		return limit
+	}
+	return ledger.defaultLimit
+}
+
+func (ledger *Ledger) lookup(key string) (*Entry, error) {
+	body, e := ledger.store.Get(entriesPrefix + key)
+	if bitsgo.IsNotFoundError(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not read quota ledger entry for %v", key)
+	}
+	defer body.Close()
+	var entry Entry
+	e = json.NewDecoder(body).Decode(&entry)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not read quota ledger entry for %v", key)
+	}
+	return &entry, nil
+}
+
+// usage returns the cached usage of tenant, unless it is older than usageTTL.
+func (ledger *Ledger) usage(tenant string) (int64, error) {
+	ledger.mutex.Lock()
+	cached, exists := ledger.usages[tenant]
+	if exists && ledger.clock.Since(cached.loadedAt) < ledger.usageTTL {
+		ledger.mutex.Unlock()
+		return cached.usage, nil
+	}
+	ledger.mutex.Unlock()
+
+	loadedAt := ledger.clock.Now()
+	usage, e := ledger.listUsage(tenant)
+	if e != nil {
+		return 0, e
+	}
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	// Another listing might have finished meanwhile. Only newer listings replace it.
+	if cached, exists := ledger.usages[tenant]; !exists || cached.loadedAt.Before(loadedAt) {
+		ledger.usages[tenant] = &cachedUsage{usage: usage, loadedAt: loadedAt}
+	}
+	return usage, nil
+}
+
+func (ledger *Ledger) listUsage(tenant string) (int64, error) {
+	prefix := usageTenantPrefix(tenant)
+	var (
+		usage     int64
+		formatErr error
+	)
+	e := ledger.forEach(prefix, func(path string) {
+		size, e := strconv.ParseInt(strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)[0], 10, 64)
+		if e != nil {
+			formatErr = errors.Errorf("Invalid quota ledger record %v", path)
+			return
+		}
+		usage += size
+	})
+	if e != nil {
+		return 0, e
+	}
+	return usage, formatErr
+}
+
+// adjustUsage adds delta to the cached usage of tenant, if there is one.
+func (ledger *Ledger) adjustUsage(tenant string, delta int64) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	if cached, exists := ledger.usages[tenant]; exists {
+		cached.usage += delta
+	}
+}
+
+// set replaces current by next. Either can be nil. The usage record of next is written before the entry and the
+// usage record of current is removed last, so that a failure in between can only overcharge, but never undercharge.
+// It must be called with the lock of key held.
+func (ledger *Ledger) set(key string, current *Entry, next *Entry) error {
+	if next != nil {
+		content, e := json.Marshal(next)
+		if e != nil {
+			return errors.WithStack(e)
+		}
+		e = ledger.store.Put(usagePathFor(key, *next), bytes.NewReader(content))
+		if e != nil {
+			return errors.Wrapf(e, "Could not write quota ledger for %v", key)
+		}
+		if current == nil || usagePathFor(key, *current) != usagePathFor(key, *next) {
+			ledger.adjustUsage(next.Tenant, next.Size)
+		}
+		e = ledger.store.Put(entriesPrefix+key, bytes.NewReader(content))
+		if e != nil {
+			return errors.Wrapf(e, "Could not write quota ledger for %v", key)
+		}
+	} else if current != nil {
+		e := ledger.store.Delete(entriesPrefix + key)
+		if e != nil && !bitsgo.IsNotFoundError(e) {
+			return errors.Wrapf(e, "Could not write quota ledger for %v", key)
+		}
+	}
+	if current != nil && (next == nil || usagePathFor(key, *current) != usagePathFor(key, *next)) {
+		e := ledger.store.Delete(usagePathFor(key, *current))
+		if e != nil && !bitsgo.IsNotFoundError(e) {
+			return errors.Wrapf(e, "Could not write quota ledger for %v", key)
+		}
+		ledger.adjustUsage(current.Tenant, -current.Size)
+	}
+	return nil
+}
+
+func (ledger *Ledger) forEach(prefix string, f func(path string)) error {
+	pageToken := ""
+	for {
+		blobs, nextPageToken, e := ledger.store.List(prefix, pageToken)
+		if e != nil {
+			return errors.Wrapf(e, "Could not list quota ledger records under %v", prefix)
+		}
+		for _, blob := range blobs {
+			f(blob.Path)
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// usageTenantPrefix escapes tenant, so that a tenant cannot list the usage of another one whose name it is a prefix of.
+func usageTenantPrefix(tenant string) string {
+	return usagePrefix + url.PathEscape(tenant) + "/"
+}
+
+func usagePathFor(key string, entry Entry) string {
+	return usageTenantPrefix(entry.Tenant) + fmt.Sprintf("%d/%s", entry.Size, key)
+}
@@ -0,0 +1,123 @@
+package quota_test
+
+import (
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	"github.com/cloudfoundry-incubator/bits-service/quota"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ledger", func() {
+	var (
+		store  *inmemory.Blobstore
+		ledger *quota.Ledger
+	)
+
+	BeforeEach(func() {
+		store = inmemory.NewBlobstore()
+		ledger = quota.NewLedger(store, 100, map[string]int64{"big-space": 1000, "unlimited-space": 0})
+	})
+
+	It("charges tenants up to their limit", func() {
+		Expect(ledger.Charge("packages:a", "space", 60)).To(BeNil())
+		_, e := ledger.Charge("packages:b", "space", 41)
+		Expect(e).To(BeAssignableToTypeOf(&bitsgo.QuotaExceededError{}))
+		Expect(ledger.Lookup("packages:b")).To(BeNil())
+
+		Expect(ledger.Charge("packages:b", "space", 40)).To(BeNil())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(100))
+
+		Expect(ledger.Charge("packages:c", "big-space", 1000)).To(BeNil())
+		Expect(ledger.Charge("packages:d", "unlimited-space", 5000)).To(BeNil())
+	})
+
+	It("replaces what was charged for the same key before", func() {
+		Expect(ledger.Charge("droplets:a", "space", 60)).To(BeNil())
+
+		previous, e := ledger.Charge("droplets:a", "space", 90)
+		Expect(e).NotTo(HaveOccurred())
+		Expect(previous).To(Equal(&quota.Entry{Tenant: "space", Size: 60}))
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(90))
+
+		previous, e = ledger.Charge("droplets:a", "other-space", 10)
+		Expect(e).NotTo(HaveOccurred())
+		Expect(ledger.Usage("space")).To(BeZero())
+		Expect(ledger.Usage("other-space")).To(BeEquivalentTo(10))
+
+		Expect(ledger.Restore("droplets:a", previous)).To(Succeed())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(90))
+		Expect(ledger.Usage("other-space")).To(BeZero())
+	})
+
+	It("releases single keys and prefixes", func() {
+		Expect(ledger.Charge("buildpack_cache:app/stack/a", "space", 10)).To(BeNil())
+		Expect(ledger.Charge("buildpack_cache:app/stack/b", "space", 20)).To(BeNil())
+		Expect(ledger.Charge("buildpack_cache:other-app/stack/a", "space", 30)).To(BeNil())
+
+		Expect(ledger.Release("buildpack_cache:app/stack/a")).To(Succeed())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(50))
+		Expect(ledger.ReleasePrefix("buildpack_cache:app/")).To(Succeed())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(30))
+		Expect(ledger.Release("buildpack_cache:does-not-exist")).To(Succeed())
+	})
+
+	It("does not mix up tenants whose names are prefixes of each other", func() {
+		Expect(ledger.Charge("packages:a", "space", 60)).To(BeNil())
+		Expect(ledger.Charge("packages:b", "space-2", 30)).To(BeNil())
+
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(60))
+		Expect(ledger.Usage("space-2")).To(BeEquivalentTo(30))
+	})
+
+	It("shares the ledger between all instances using the same store", func() {
+		fakeClock := clock.NewMock()
+		ledger = quota.NewLedgerWithUsageTTL(store, 100, nil, time.Minute, fakeClock)
+		otherLedger := quota.NewLedgerWithUsageTTL(store, 100, nil, time.Minute, fakeClock)
+
+		Expect(ledger.Charge("packages:a", "space", 60)).To(BeNil())
+		Expect(otherLedger.Usage("space")).To(BeEquivalentTo(60))
+		Expect(otherLedger.Lookup("packages:a")).To(Equal(&quota.Entry{Tenant: "space", Size: 60}))
+
+		_, e := otherLedger.Charge("packages:b", "space", 41)
+		Expect(e).To(BeAssignableToTypeOf(&bitsgo.QuotaExceededError{}))
+
+		Expect(otherLedger.Release("packages:a")).To(Succeed())
+		Expect(otherLedger.Usage("space")).To(BeZero())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(60))
+
+		fakeClock.Add(time.Minute)
+		Expect(ledger.Usage("space")).To(BeZero())
+		Expect(ledger.Charge("packages:b", "space", 100)).To(BeNil())
+	})
+
+	It("lists the usage records of a tenant only once per usage TTL", func() {
+		fakeClock := clock.NewMock()
+		listCountingStore := &listCountingBlobstore{Blobstore: store}
+		ledger = quota.NewLedgerWithUsageTTL(listCountingStore, 100, nil, time.Minute, fakeClock)
+
+		Expect(ledger.Charge("packages:a", "space", 10)).To(BeNil())
+		Expect(ledger.Charge("packages:b", "space", 20)).To(BeNil())
+		Expect(ledger.Charge("packages:a", "space", 30)).To(Equal(&quota.Entry{Tenant: "space", Size: 10}))
+		Expect(ledger.Release("packages:b")).To(Succeed())
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(30))
+		Expect(listCountingStore.lists).To(Equal(1))
+
+		fakeClock.Add(time.Minute)
+		Expect(ledger.Usage("space")).To(BeEquivalentTo(30))
+		Expect(listCountingStore.lists).To(Equal(2))
+	})
+})
+
+type listCountingBlobstore struct {
+	*inmemory.Blobstore
+	lists int
+}
+
+func (blobstore *listCountingBlobstore) List(prefix string, pageToken string) ([]bitsgo.BlobInfo, string, error) {
+	blobstore.lists++
+	return blobstore.Blobstore.List(prefix, pageToken)
+}
@@ -0,0 +1,182 @@
+package bitsgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/cloudfoundry-incubator/bits-service/util"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Healthz reports liveness. As long as bits-service can serve requests, it is alive.
+func Healthz(responseWriter http.ResponseWriter, request *http.Request) {
+	responseWriter.WriteHeader(http.StatusOK)
+	util.FprintDescriptionAsJSON(responseWriter, "OK")
+}
+
+// ReadinessHandler tells load balancers whether they should route requests to this instance.
+// The instance is ready when it is not draining and all blobstores pass a write/read/delete probe.
+type ReadinessHandler struct {
+	draining     int32
+	probes       []*blobstoreProbe
+	probeTimeout time.Duration
+	cacheFor     time.Duration
+	clock        clock.Clock
+
+	mutex           sync.Mutex
+	lastProbeTime   time.Time
+	lastProbeResult map[string]ProbeResult
+}
+
+type ProbeResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ReadinessResponseBody struct {
+	Status     string                 `json:"status"`
+	Blobstores map[string]ProbeResult `json:"blobstores,omitempty"`
+}
+
+type blobstoreProbe struct {
+	name      string
+	blobstore Blobstore
+	path      string
+	// set while a probe call to the blobstore is outstanding, so that a hanging blobstore does not pile up goroutines
+	running int32
+}
+
+func NewReadinessHandler() *ReadinessHandler {
+	return NewReadinessHandlerWithBlobstoreProbes(nil, 0, 0, clock.New())
+}
+
+// NewReadinessHandlerWithBlobstoreProbes probes each of blobstores by name. A probe taking longer than probeTimeout counts
+// as failed. Probe results are reused for cacheFor, so that frequent readiness checks do not put load on the blobstores.
+func NewReadinessHandlerWithBlobstoreProbes(blobstores map[string]Blobstore, probeTimeout time.Duration, cacheFor time.Duration, clock clock.Clock) *ReadinessHandler {
+	handler := &ReadinessHandler{probeTimeout: probeTimeout, cacheFor: cacheFor, clock: clock}
+	// Every instance uses its own path, so that concurrent probes of several instances do not interfere.
+	path := "readiness-probe-" + uuid.NewV4().String()
+	for name, blobstore := range blobstores {
+		handler.probes = append(handler.probes, &blobstoreProbe{name: name, blobstore: blobstore, path: path})
+	}
+	sort.Slice(handler.probes, func(i, j int) bool { return handler.probes[i].name < handler.probes[j].name })
+	return handler
+}
+
+// StartDraining makes the instance report not-ready from now on.
+func (handler *ReadinessHandler) StartDraining() {
+	atomic.StoreInt32(&handler.draining, 1)
+}
+
+func (handler *ReadinessHandler) IsDraining() bool {
+	return atomic.LoadInt32(&handler.draining) == 1
+}
+
+func (handler *ReadinessHandler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	if handler.IsDraining() {
+		writeReadinessResponse(responseWriter, http.StatusServiceUnavailable, &ReadinessResponseBody{Status: "draining"})
+		return
+	}
+	results := handler.probeResults()
+	for _, result := range results {
+		if result.Status != "ok" {
+			writeReadinessResponse(responseWriter, http.StatusServiceUnavailable, &ReadinessResponseBody{Status: "not_ready", Blobstores: results})
+			return
+		}
+	}
+	writeReadinessResponse(responseWriter, http.StatusOK, &ReadinessResponseBody{Status: "ready", Blobstores: results})
+}
+
+func writeReadinessResponse(responseWriter http.ResponseWriter, statusCode int, body *ReadinessResponseBody) {
+	respBody, e := json.Marshal(body)
+	util.PanicOnError(e)
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(statusCode)
+	responseWriter.Write(respBody)
+}
+
+func (handler *ReadinessHandler) probeResults() map[string]ProbeResult {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	if handler.lastProbeResult != nil && handler.clock.Now().Sub(handler.lastProbeTime) < handler.cacheFor {
+		return handler.lastProbeResult
+	}
+
+	results := make(map[string]ProbeResult, len(handler.probes))
+	var (
+		wg          sync.WaitGroup
+		resultMutex sync.Mutex
+	)
+	for _, probe := range handler.probes {
+		wg.Add(1)
+		go func(probe *blobstoreProbe) {
+			defer wg.Done()
+			result := ProbeResult{Status: "ok"}
+			e := probe.runWithTimeout(handler.probeTimeout, handler.clock)
+			if e != nil {
+				result = ProbeResult{Status: "failed", Error: e.Error()}
+			}
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			results[probe.name] = result
+		}(probe)
+	}
+	wg.Wait()
+
+	handler.lastProbeTime = handler.clock.Now()
+	handler.lastProbeResult = results
+	return results
+}
+
+func (probe *blobstoreProbe) runWithTimeout(timeout time.Duration, clock clock.Clock) error {
+	if !atomic.CompareAndSwapInt32(&probe.running, 0, 1) {
+		return errors.New("Previous probe has not finished yet")
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&probe.running, 0)
+		done <- probe.run()
+	}()
+	select {
+	case e := <-done:
+		return e
+	case <-clock.After(timeout):
+		return errors.Errorf("Probe timed out after %v", timeout)
+	}
+}
+
+var probeContent = []byte("bits-service readiness probe")
+
+func (probe *blobstoreProbe) run() error {
+	e := probe.blobstore.Put(probe.path, bytes.NewReader(probeContent))
+	if e != nil {
+		return errors.Wrap(e, "Write failed")
+	}
+	body, e := probe.blobstore.Get(probe.path)
+	if e != nil {
+		return errors.Wrap(e, "Read failed")
+	}
+	content, e := ioutil.ReadAll(body)
+	body.Close()
+	if e != nil {
+		return errors.Wrap(e, "Read failed")
+	}
+	if !bytes.Equal(content, probeContent) {
+		return errors.Errorf("Read returned unexpected content: %v", strings.TrimSpace(string(content)))
+	}
+	e = probe.blobstore.Delete(probe.path)
+	if e != nil {
+		return errors.Wrap(e, "Delete failed")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package bitsgo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/cloudfoundry-incubator/bits-service"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	. "github.com/petergtz/pegomock"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("ReadinessHandler", func() {
+	var (
+		healthyBlobstore *inmemory.Blobstore
+		failingBlobstore *MockBlobstore
+		mockClock        *clock.Mock
+	)
+
+	BeforeEach(func() {
+		healthyBlobstore = inmemory.NewBlobstore()
+		failingBlobstore = NewMockBlobstore()
+		When(failingBlobstore.Put(AnyString(), anyReadSeeker())).ThenReturn(errors.New("Access denied"))
+		mockClock = clock.NewMock()
+	})
+
+	serve := func(handler *ReadinessHandler) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		handler.ServeHTTP(responseWriter, httptest.NewRequest("GET", "/readyz", nil))
+		return responseWriter
+	}
+
+	It("reports ready with per-blobstore status when all probes pass and leaves no probe blobs behind", func() {
+		handler := NewReadinessHandlerWithBlobstoreProbes(map[string]Blobstore{"packages": healthyBlobstore}, time.Second, time.Minute, mockClock)
+
+		responseWriter := serve(handler)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusOK))
+		Expect(responseWriter.Body.String()).To(MatchJSON(`{"status":"ready","blobstores":{"packages":{"status":"ok"}}}`))
+		Expect(healthyBlobstore.Entries).To(BeEmpty())
+	})
+
+	It("reports not ready with the error of the failing blobstore", func() {
+		handler := NewReadinessHandlerWithBlobstoreProbes(map[string]Blobstore{"packages": healthyBlobstore, "droplets": failingBlobstore}, time.Second, time.Minute, mockClock)
+
+		responseWriter := serve(handler)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(responseWriter.Body.String()).To(MatchJSON(`{"status":"not_ready","blobstores":{
+			"packages":{"status":"ok"},
+			"droplets":{"status":"failed","error":"Write failed: Access denied"}}}`))
+	})
+
+	It("reuses probe results until they expire", func() {
+		handler := NewReadinessHandlerWithBlobstoreProbes(map[string]Blobstore{"droplets": failingBlobstore}, time.Second, time.Minute, mockClock)
+
+		serve(handler)
+		serve(handler)
+		failingBlobstore.VerifyWasCalledOnce().Put(AnyString(), anyReadSeeker())
+
+		mockClock.Add(time.Minute)
+		serve(handler)
+		failingBlobstore.VerifyWasCalled(Times(2)).Put(AnyString(), anyReadSeeker())
+	})
+
+	It("fails probes which take longer than the timeout", func() {
+		unblock := make(chan bool)
+		defer close(unblock)
+		hangingBlobstore := NewMockBlobstore()
+		When(hangingBlobstore.Put(AnyString(), anyReadSeeker())).Then(func(params []Param) ReturnValues {
+			<-unblock
+			return []ReturnValue{errors.New("unblocked")}
+		})
+		handler := NewReadinessHandlerWithBlobstoreProbes(map[string]Blobstore{"droplets": hangingBlobstore}, 10*time.Millisecond, time.Minute, clock.New())
+
+		responseWriter := serve(handler)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(responseWriter.Body.String()).To(ContainSubstring("Probe timed out after 10ms"))
+	})
+
+	It("reports not ready once draining starts", func() {
+		handler := NewReadinessHandlerWithBlobstoreProbes(map[string]Blobstore{"packages": healthyBlobstore}, time.Second, time.Minute, mockClock)
+		Expect(serve(handler).Code).To(Equal(http.StatusOK))
+
+		handler.StartDraining()
+
+		responseWriter := serve(handler)
+		Expect(responseWriter.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(responseWriter.Body.String()).To(MatchJSON(`{"status":"draining"}`))
+	})
+})
+
+var _ = Describe("Healthz", func() {
+	It("always reports OK", func() {
+		responseWriter := httptest.NewRecorder()
+		Healthz(responseWriter, httptest.NewRequest("GET", "/healthz", nil))
+		Expect(responseWriter.Code).To(Equal(http.StatusOK))
+	})
+})
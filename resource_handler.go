@@ -3,6 +3,7 @@ package bitsgo
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
@@ -15,6 +16,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -58,6 +60,12 @@ type ResourceHandler struct {
 	minimumSize            uint64
 	maximumSize            uint64
 	shouldProxyGetRequests bool
+	uploadJobs             *UploadJobStore
+	// Bounds the number of concurrent async uploads. nil means unbounded.
+	uploadSlots  chan struct{}
+	asyncUploads sync.WaitGroup
+	// Called with the identifier of every resource removed by Delete. nil means nobody is interested.
+	onDelete func(identifier string)
 }
 
 type ResponseBody struct {
@@ -95,7 +103,23 @@ func NewResourceHandlerWithUpdater(blobstore Blobstore, appStashBlobstore Blobst
 }
 
 func NewResourceHandlerWithUpdaterAndSizeThresholds(blobstore Blobstore, appStashBlobstore Blobstore, updater Updater, resourceType string, metricsService MetricsService, maxBodySizeLimit uint64, minimumSize, maximumSize uint64, shouldProxyGetRequests bool) *ResourceHandler {
-	return &ResourceHandler{
+	return NewResourceHandlerWithUploadJobs(
+		blobstore,
+		appStashBlobstore,
+		updater,
+		resourceType,
+		metricsService,
+		maxBodySizeLimit,
+		minimumSize, maximumSize,
+		shouldProxyGetRequests,
+		nil, 0,
+	)
+}
+
+// NewResourceHandlerWithUploadJobs records async uploads in uploadJobs, so that they survive restarts and their status
+// can be queried. uploadJobs may be nil. A maxConcurrentUploads of 0 means no limit.
+func NewResourceHandlerWithUploadJobs(blobstore Blobstore, appStashBlobstore Blobstore, updater Updater, resourceType string, metricsService MetricsService, maxBodySizeLimit uint64, minimumSize, maximumSize uint64, shouldProxyGetRequests bool, uploadJobs *UploadJobStore, maxConcurrentUploads int) *ResourceHandler {
+	handler := &ResourceHandler{
 		blobstore:              blobstore,
 		appStashBlobstore:      appStashBlobstore,
 		resourceType:           resourceType,
@@ -105,7 +129,12 @@ func NewResourceHandlerWithUpdaterAndSizeThresholds(blobstore Blobstore, appStas
 		maximumSize:            maximumSize,
 		minimumSize:            minimumSize,
 		shouldProxyGetRequests: shouldProxyGetRequests,
+		uploadJobs:             uploadJobs,
 	}
+	if maxConcurrentUploads > 0 {
+		handler.uploadSlots = make(chan struct{}, maxConcurrentUploads)
+	}
+	return handler
 }
 
 // TODO: instead of params, we could use `identifier string` to make the interface more type-safe.
@@ -121,41 +150,32 @@ func (handler *ResourceHandler) AddOrReplaceWithDigestInHeader(responseWriter ht
 		badRequest(responseWriter, request, "No Digest header")
 		return
 	}
-	parts := strings.Split(digest, "=")
-	if len(parts) != 2 {
-		badRequest(responseWriter, request, "Digest must have format sha256=value, but is "+digest)
-		return
-	}
-	alg, value := strings.ToLower(parts[0]), parts[1]
-	if alg != "sha256" {
-		badRequest(responseWriter, request, "Digest must have format sha256=value, but is "+digest)
-		return
-	}
-	if value == "" {
-		badRequest(responseWriter, request, "Digest must have format sha256=value. Value cannot be empty")
+	expectedDigests, e := ParseDigestHeader(digest)
+	if e != nil {
+		badRequest(responseWriter, request, "%v", e.Error())
 		return
 	}
 
-	// TODO this can cause an out of memory panic. Should be smart about writing big files to disk instead.
-	content, e := ioutil.ReadAll(request.Body)
+	// Droplets can be several GBs, so we stream them to disk and compute the checksums on the way,
+	// instead of buffering them in memory.
+	digestWriter := newDigestWriter(expectedDigests)
+	tempFilename, e := CreateTempFileWithContent(io.TeeReader(request.Body, digestWriter))
 	util.PanicOnError(e)
+	defer os.Remove(tempFilename)
 
-	e = backoff.RetryNotify(func() error {
-		e := handler.blobstore.Put(params["identifier"]+"/"+value, bytes.NewReader(content))
-		if e != nil {
-			if _, noSpaceLeft := e.(*NoSpaceLeftError); noSpaceLeft {
-				return backoff.Permanent(e)
-			}
-			return errors.Wrap(e, "Could not upload bits to blobstore")
-		}
-		return nil
+	e = digestWriter.Verify(expectedDigests)
+	if e != nil {
+		logger.From(request).Infow("Rejecting upload", "error", e)
+		responseWriter.WriteHeader(http.StatusUnprocessableEntity)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 290010, "%v", e.Error())
+		return
+	}
+	actualSha256 := digestWriter.Sha256()
 
-	}, retryPolicy(), func(e error, delay time.Duration) {
-		handler.metricsService.SendCounterMetric("upload"+handler.resourceType, 1)
-	})
+	e = handler.uploadFileWithRetries(request.Context(), tempFilename, params["identifier"]+"/"+actualSha256, logger.From(request))
 
 	// TODO use Clock instead:
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, nil, &ResponseBody{Guid: params["identifier"], State: "READY", Type: "bits", CreatedAt: time.Now()}, "")
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, &ResponseBody{Guid: params["identifier"], State: "READY", Type: "bits", CreatedAt: time.Now(), Sha256: actualSha256})
 }
 
 // TODO: instead of params, we could use `identifier string` to make the interface more type-safe.
@@ -175,20 +195,29 @@ func (handler *ResourceHandler) AddOrReplace(responseWriter http.ResponseWriter,
 	util.PanicOnError(e)
 	defer file.Close()
 
-	var tempFilename string
+	handler.addOrReplaceFrom(responseWriter, request, params, file, fileInfo.Size)
+}
+
+// addOrReplaceFrom stores file, which holds fileSize bytes, as the resource identified by params.
+// It returns whether the bits were stored or their asynchronous upload was started.
+func (handler *ResourceHandler) addOrReplaceFrom(responseWriter http.ResponseWriter, request *http.Request, params map[string]string, file multipart.File, fileSize int64) (stored bool) {
+	var (
+		tempFilename string
+		e            error
+	)
 	// TODO: this if-block maybe not be necessary at all.
 	//       The reason it's necessary right now is that we need zip handling only for packages. We treat other resources opaque.
 	if handler.resourceType == "package" {
-		tempFilename, e = handler.completePackageWithResources(request.FormValue("resources"), file, fileInfo.Size, logger.From(request))
+		tempFilename, e = handler.completePackageWithResources(request.Context(), request.FormValue("resources"), file, fileSize, logger.From(request))
 		switch e.(type) {
 		case *inputError:
 			logger.From(request).Infow(e.Error())
 			responseWriter.WriteHeader(http.StatusUnprocessableEntity)
 			util.FprintDescriptionAsJSON(responseWriter, e.Error())
-			return
+			return false
 		case *NoSpaceLeftError:
 			http.Error(responseWriter, util.DescriptionAndCodeAsJSON(500000, "Request Entity Too Large"), http.StatusInsufficientStorage)
-			return
+			return false
 		case error:
 			panic(e)
 		}
@@ -200,36 +229,47 @@ func (handler *ResourceHandler) AddOrReplace(responseWriter http.ResponseWriter,
 	sha1, sha256, e := ShaSums(tempFilename)
 	util.PanicOnError(e)
 
-	e = handler.updater.NotifyProcessingUpload(params["identifier"])
+	e = UpdaterWithContext(handler.updater, request.Context()).NotifyProcessingUpload(params["identifier"])
 	if handleNotificationError(e, responseWriter, request) {
-		return
+		return false
 	}
 
 	if request.URL.Query().Get("async") == "true" {
-		go handler.uploadResource(tempFilename, request, params["identifier"], true, sha1, sha256)
-		writeResponseBasedOn("", nil, responseWriter, request, http.StatusAccepted, nil, &ResponseBody{
-			Guid:      params["identifier"],
-			State:     "PROCESSING_UPLOAD",
-			Type:      "bits",
-			CreatedAt: time.Now(),
-			Sha1:      hex.EncodeToString(sha1),
-			Sha256:    hex.EncodeToString(sha256),
-		}, "")
-	} else {
-		e = handler.uploadResource(tempFilename, request, params["identifier"], false, sha1, sha256)
-		if IsNotFoundError(e) {
-			writeResponseBasedOn("", nil, responseWriter, request, http.StatusConflict, nil, nil, "")
-			return
+		if handler.uploadJobs != nil {
+			job, e := handler.uploadJobs.Add(params["identifier"], tempFilename, hex.EncodeToString(sha1), hex.EncodeToString(sha256), TenantFrom(request.Context()))
+			util.PanicOnError(e)
+			handler.startAsyncUpload(func() { handler.processUploadJob(DetachedContext(request.Context()), job, logger.From(request)) })
+		} else {
+			handler.startAsyncUpload(func() {
+				handler.acquireUploadSlot()
+				defer handler.releaseUploadSlot()
+				handler.uploadResource(DetachedContext(request.Context()), tempFilename, logger.From(request), params["identifier"], true, sha1, sha256)
+			})
 		}
-		writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, nil, &ResponseBody{
+		writeResponseBasedOn("", nil, responseWriter, request, http.StatusAccepted, &ResponseBody{
 			Guid:      params["identifier"],
-			State:     "READY",
+			State:     "PROCESSING_UPLOAD",
 			Type:      "bits",
 			CreatedAt: time.Now(),
 			Sha1:      hex.EncodeToString(sha1),
 			Sha256:    hex.EncodeToString(sha256),
-		}, "")
+		})
+		return true
+	}
+	e = handler.uploadResource(request.Context(), tempFilename, logger.From(request), params["identifier"], false, sha1, sha256)
+	if IsNotFoundError(e) {
+		writeResponseBasedOn("", nil, responseWriter, request, http.StatusConflict, nil)
+		return false
 	}
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, &ResponseBody{
+		Guid:      params["identifier"],
+		State:     "READY",
+		Type:      "bits",
+		CreatedAt: time.Now(),
+		Sha1:      hex.EncodeToString(sha1),
+		Sha256:    hex.EncodeToString(sha256),
+	})
+	return e == nil
 }
 
 type BuildpackMetadata struct {
@@ -240,6 +280,10 @@ type BuildpackMetadata struct {
 	Key      string `json:"key"`
 }
 
+// UncommittedBuildpackMarkerPrefix is where AddBuildpack stores a marker for every new buildpack.
+// The marker stays until the buildpack gets committed. It contains the upload time in RFC 3339 format.
+const UncommittedBuildpackMarkerPrefix = "uncommitted/"
+
 func (handler *ResourceHandler) AddBuildpack(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
 	if !HandleBodySizeLimits(responseWriter, request, handler.maxBodySizeLimit) {
 		return
@@ -271,7 +315,7 @@ func (handler *ResourceHandler) AddBuildpack(responseWriter http.ResponseWriter,
 
 	identifier := uuid.NewV4().String()
 
-	e = handler.uploadResource(tempFilename, request, identifier, false, sha1, sha256)
+	e = handler.uploadResource(request.Context(), tempFilename, logger.From(request), identifier, false, sha1, sha256)
 	util.PanicOnError(e)
 
 	buildpackMetadata := BuildpackMetadata{
@@ -284,18 +328,33 @@ func (handler *ResourceHandler) AddBuildpack(responseWriter http.ResponseWriter,
 
 	bpMetadataJson, e := json.Marshal(buildpackMetadata)
 	util.PanicOnError(e)
-	e = handler.blobstore.Put(identifier+"-metadata", bytes.NewReader(bpMetadataJson))
+	e = handler.blobstoreFor(request).Put(identifier+"-metadata", bytes.NewReader(bpMetadataJson))
 	util.PanicOnError(e)
-	e = handler.blobstore.Put("uncommitted/"+identifier, strings.NewReader(time.Now().String()))
+	e = handler.blobstoreFor(request).Put(UncommittedBuildpackMarkerPrefix+identifier, strings.NewReader(time.Now().UTC().Format(time.RFC3339Nano)))
 	util.PanicOnError(e)
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, nil, &ResponseBody{
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, &ResponseBody{
 		Guid:      buildpackMetadata.Key,
 		State:     "READY",
 		Type:      "bits",
 		CreatedAt: time.Now(),
 		Sha1:      buildpackMetadata.Sha1,
 		Sha256:    buildpackMetadata.Sha256,
-	}, "")
+	})
+}
+
+// CommitBuildpack is called once Cloud Controller has accepted a buildpack. It's idempotent.
+func (handler *ResourceHandler) CommitBuildpack(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	exists, e := handler.blobstoreFor(request).Exists(params["identifier"])
+	util.PanicOnError(e)
+	if !exists {
+		responseWriter.WriteHeader(http.StatusNotFound)
+		return
+	}
+	e = handler.blobstoreFor(request).Delete(UncommittedBuildpackMarkerPrefix + params["identifier"])
+	if IsNotFoundError(e) {
+		e = nil
+	}
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusNoContent, nil)
 }
 
 func extractStackFromZipFile(tempFilename string) (string, error) {
@@ -337,7 +396,7 @@ type inputError struct {
 }
 
 // returns inputError or NoSpaceLeftError in case of error
-func (handler *ResourceHandler) completePackageWithResources(resources string, file multipart.File, fileSize int64, logger *zap.SugaredLogger) (tempfileName string, err error) {
+func (handler *ResourceHandler) completePackageWithResources(ctx context.Context, resources string, file multipart.File, fileSize int64, logger *zap.SugaredLogger) (tempfileName string, err error) {
 	var bundlesPayload []Fingerprint
 	if resources != "" {
 		e := json.Unmarshal([]byte(resources), &bundlesPayload)
@@ -354,7 +413,9 @@ func (handler *ResourceHandler) completePackageWithResources(resources string, f
 	}
 	util.PanicOnError(e)
 
-	tempFilename, e := CreateTempZipFileFrom(bundlesPayload, zipReader, handler.minimumSize, handler.maximumSize, handler.appStashBlobstore, handler.metricsService, logger)
+	ctx, span := Tracer().Start(ctx, "app_stash.assemble_package")
+	tempFilename, e := CreateTempZipFileFrom(bundlesPayload, zipReader, handler.minimumSize, handler.maximumSize, BlobstoreWithContext(handler.appStashBlobstore, ctx), handler.metricsService, logger)
+	EndSpan(span, e)
 	if _, noSpaceLeft := e.(*NoSpaceLeftError); noSpaceLeft {
 		return "", e
 	}
@@ -380,23 +441,140 @@ func CreateTempFileWithContent(reader io.Reader) (string, error) {
 	return uploadedFile.Name(), nil
 }
 
-func (handler *ResourceHandler) uploadResource(tempFilename string, request *http.Request, identifier string, async bool, sha1Sum []byte, sha256Sum []byte) error {
+func (handler *ResourceHandler) uploadResource(ctx context.Context, tempFilename string, logger *zap.SugaredLogger, identifier string, async bool, sha1Sum []byte, sha256Sum []byte) error {
 	defer os.Remove(tempFilename)
-	e := backoff.RetryNotify(func() error {
+	e := handler.uploadAndNotify(ctx, tempFilename, identifier, hex.EncodeToString(sha1Sum), hex.EncodeToString(sha256Sum), logger)
+	if e != nil && !IsNotFoundError(e) {
+		return handle(e, async, logger)
+	}
+	return e
+}
+
+// uploadAndNotify returns a *NotFoundError when Cloud Controller does not know identifier (anymore).
+func (handler *ResourceHandler) uploadAndNotify(ctx context.Context, filename string, identifier string, sha1 string, sha256 string, logger *zap.SugaredLogger) error {
+	e := handler.uploadFileWithRetries(ctx, filename, identifier, logger)
+	if e != nil {
+		handler.notifyUploadFailed(ctx, identifier, e, logger)
+		return e
+	}
+	e = UpdaterWithContext(handler.updater, ctx).NotifyUploadSucceeded(identifier, sha1, sha256)
+	if IsNotFoundError(e) {
+		return e
+	}
+	if e != nil {
+		return errors.Wrapf(e, "Could not notify Cloud Controller about successful upload")
+	}
+	return nil
+}
+
+func (handler *ResourceHandler) processUploadJob(ctx context.Context, job *UploadJob, logger *zap.SugaredLogger) {
+	handler.acquireUploadSlot()
+	defer handler.releaseUploadSlot()
+
+	e := handler.uploadAndNotify(ctx, handler.uploadJobs.BitsPath(job), job.Guid, job.Sha1, job.Sha256, logger)
+	if e != nil {
+		logger.Errorw("Failure during upload", "identifier", job.Guid, "error", e)
+	}
+	e = handler.uploadJobs.Finish(job, e)
+	if e != nil {
+		logger.Errorw("Could not record upload job status", "identifier", job.Guid, "error", e)
+	}
+}
+
+// ResumeUploadJobs restarts async uploads which did not finish, e.g. because bits-service was restarted.
+func (handler *ResourceHandler) ResumeUploadJobs() error {
+	if handler.uploadJobs == nil {
+		return nil
+	}
+	jobs, e := handler.uploadJobs.Pending()
+	if e != nil {
+		return e
+	}
+	for _, job := range jobs {
+		logger.Log.Infow("Resuming upload", "identifier", job.Guid)
+		job := job
+		handler.startAsyncUpload(func() { handler.processUploadJob(ContextWithTenant(context.Background(), job.Tenant), job, logger.Log) })
+	}
+	return nil
+}
+
+func (handler *ResourceHandler) blobstoreFor(request *http.Request) Blobstore {
+	return BlobstoreWithContext(handler.blobstore, request.Context())
+}
+
+func (handler *ResourceHandler) startAsyncUpload(upload func()) {
+	handler.asyncUploads.Add(1)
+	go func() {
+		defer handler.asyncUploads.Done()
+		upload()
+	}()
+}
+
+// WaitForAsyncUploads blocks until all async uploads are finished or ctx is done.
+func (handler *ResourceHandler) WaitForAsyncUploads(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		handler.asyncUploads.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (handler *ResourceHandler) acquireUploadSlot() {
+	if handler.uploadSlots != nil {
+		handler.uploadSlots <- struct{}{}
+	}
+}
+
+func (handler *ResourceHandler) releaseUploadSlot() {
+	if handler.uploadSlots != nil {
+		<-handler.uploadSlots
+	}
+}
+
+func (handler *ResourceHandler) UploadStatus(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	if handler.uploadJobs == nil {
+		responseWriter.WriteHeader(http.StatusNotFound)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 10010, "Upload status tracking is not enabled")
+		return
+	}
+	job, e := handler.uploadJobs.Get(params["identifier"])
+	if IsNotFoundError(e) {
+		responseWriter.WriteHeader(http.StatusNotFound)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 10010, "No upload found for %v", params["identifier"])
+		return
+	}
+	util.PanicOnError(e)
+	respBody, e := json.Marshal(job)
+	util.PanicOnError(e)
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(respBody)
+}
+
+func (handler *ResourceHandler) uploadFileWithRetries(ctx context.Context, tempFilename string, path string, logger *zap.SugaredLogger) error {
+	return backoff.RetryNotify(func() error {
 		tempFile, e := os.Open(tempFilename)
 		if e != nil {
 			return backoff.Permanent(errors.Wrapf(e, "Could not open temporary file '%v'", tempFilename))
 		}
 		defer tempFile.Close()
 
-		logger.From(request).Debugw("Starting upload to blobstore", "identifier", identifier)
-		e = handler.blobstore.Put(identifier, tempFile)
-		logger.From(request).Debugw("Completed upload to blobstore", "identifier", identifier)
+		logger.Debugw("Starting upload to blobstore", "identifier", path)
+		e = BlobstoreWithContext(handler.blobstore, ctx).Put(path, tempFile)
+		logger.Debugw("Completed upload to blobstore", "identifier", path)
 
 		if e != nil {
 			if _, noSpaceLeft := e.(*NoSpaceLeftError); noSpaceLeft {
 				return backoff.Permanent(e)
 			}
+			if IsQuotaExceededError(e) {
+				return backoff.Permanent(e)
+			}
 
 			return errors.Wrapf(e, "Could not upload temporary file to blobstore %v", tempFilename)
 		}
@@ -404,25 +582,12 @@ func (handler *ResourceHandler) uploadResource(tempFilename string, request *htt
 	}, retryPolicy(), func(e error, delay time.Duration) {
 		handler.metricsService.SendCounterMetric("upload"+handler.resourceType, 1)
 	})
-
-	if e != nil {
-		handler.notifyUploadFailed(identifier, e, request)
-		return handle(e, async, request)
-	}
-	e = handler.updater.NotifyUploadSucceeded(identifier, hex.EncodeToString(sha1Sum), hex.EncodeToString(sha256Sum))
-	if IsNotFoundError(e) {
-		return e
-	}
-	if e != nil {
-		return handle(errors.Wrapf(e, "Could not notify Cloud Controller about successful upload"), async, request)
-	}
-	return nil
 }
 
 // TODO(pego): find better name for this function
-func handle(e error, async bool, request *http.Request) error {
+func handle(e error, async bool, logger *zap.SugaredLogger) error {
 	if async {
-		logger.From(request).Errorw("Failure during upload", "error", e)
+		logger.Errorw("Failure during upload", "error", e)
 	}
 	return e
 }
@@ -433,10 +598,10 @@ func retryPolicy() *backoff.ExponentialBackOff {
 	return retryPolicy
 }
 
-func (handler *ResourceHandler) notifyUploadFailed(identifier string, e error, request *http.Request) {
-	notifyErr := handler.updater.NotifyUploadFailed(identifier, e)
+func (handler *ResourceHandler) notifyUploadFailed(ctx context.Context, identifier string, e error, logger *zap.SugaredLogger) {
+	notifyErr := UpdaterWithContext(handler.updater, ctx).NotifyUploadFailed(identifier, e)
 	if notifyErr != nil {
-		logger.From(request).Errorw("Failed to notifying CC about failed upload.", "error", notifyErr)
+		logger.Errorw("Failed to notifying CC about failed upload.", "error", notifyErr)
 	}
 }
 
@@ -479,9 +644,9 @@ func (handler *ResourceHandler) CopySourceGuid(responseWriter http.ResponseWrite
 	if sourceGuid == "" {
 		return // response is already handled in sourceGuidFrom
 	}
-	e := handler.blobstore.Copy(sourceGuid, params["identifier"])
+	e := handler.blobstoreFor(request).Copy(sourceGuid, params["identifier"])
 	// TODO use Clock instead:
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, nil, &ResponseBody{Guid: params["identifier"], State: "READY", Type: "bits", CreatedAt: time.Now()}, "")
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, &ResponseBody{Guid: params["identifier"], State: "READY", Type: "bits", CreatedAt: time.Now()})
 }
 
 func sourceGuidFrom(request *http.Request, responseWriter http.ResponseWriter) string {
@@ -499,7 +664,7 @@ func sourceGuidFrom(request *http.Request, responseWriter http.ResponseWriter) s
 }
 
 func (handler *ResourceHandler) Head(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
-	exists, e := handler.blobstore.Exists(params["identifier"])
+	exists, e := handler.blobstoreFor(request).Exists(params["identifier"])
 	util.PanicOnError(e)
 	if exists {
 		responseWriter.WriteHeader(http.StatusOK)
@@ -509,53 +674,86 @@ func (handler *ResourceHandler) Head(responseWriter http.ResponseWriter, request
 }
 
 func (handler *ResourceHandler) Get(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
-	var (
-		redirectLocation string
-		e                error
-		body             io.ReadCloser
-	)
-	if handler.shouldProxyGetRequests {
-		body, e = handler.blobstore.Get(params["identifier"])
-	} else {
-		body, redirectLocation, e = handler.blobstore.GetOrRedirect(params["identifier"])
+	var body io.ReadCloser
+	if !handler.shouldProxyGetRequests {
+		var (
+			redirectLocation string
+			e                error
+		)
+		body, redirectLocation, e = handler.blobstoreFor(request).GetOrRedirect(params["identifier"])
+		if redirectLocation != "" || e != nil {
+			writeResponseBasedOn(redirectLocation, e, responseWriter, request, http.StatusOK, nil)
+			return
+		}
 	}
-	writeResponseBasedOn(redirectLocation, e, responseWriter, request, http.StatusOK, body, nil, request.Header.Get("If-None-Modify"))
+	handler.serveBlob(responseWriter, request, params["identifier"], body)
 }
 
 func (handler *ResourceHandler) BuildpackMetadata(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
-	body, e := handler.blobstore.Get(params["identifier"] + "-metadata")
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusOK, body, nil, request.Header.Get("If-None-Modify"))
+	handler.serveBlob(responseWriter, request, params["identifier"]+"-metadata", nil)
+}
+
+// serveBlob takes care of conditional and Range requests using only the blob's metadata. This way,
+// a 304 response does not fetch the blob at all and a 206 response only sends the requested bytes.
+// body can be an already opened reader for path. If it's nil, the blob is only fetched when content needs to be sent.
+func (handler *ResourceHandler) serveBlob(responseWriter http.ResponseWriter, request *http.Request, path string, body io.ReadCloser) {
+	content := newBlobReadSeeker(handler.blobstoreFor(request), path, body)
+	defer content.Close()
+
+	info, e := handler.blobstoreFor(request).Stat(path)
+	if e != nil {
+		writeResponseBasedOn("", e, responseWriter, request, http.StatusOK, nil)
+		return
+	}
+	content.size = info.Size
+	if info.ETag != "" && info.WeakETag {
+		responseWriter.Header().Set("ETag", `W/"`+info.ETag+`"`)
+	} else if info.ETag != "" {
+		responseWriter.Header().Set("ETag", `"`+info.ETag+`"`)
+	}
+	// Setting a Content-Type prevents http.ServeContent from sniffing it, which would mean fetching the blob twice.
+	responseWriter.Header().Set("Content-Type", "application/octet-stream")
+	logger.From(request).Debugw("Serving blob", "path", path, "etag", info.ETag, "last-modified", info.LastModified, "range", request.Header.Get("Range"))
+	http.ServeContent(responseWriter, request, "", info.LastModified, content)
 }
 
 func (handler *ResourceHandler) Delete(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
 	// TODO nothing should be S3 specific here
 	// this check is needed, because S3 does not return a NotFound on a Delete request:
-	exists, e := handler.blobstore.Exists(params["identifier"])
+	exists, e := handler.blobstoreFor(request).Exists(params["identifier"])
 	util.PanicOnError(e)
 	if !exists {
 		responseWriter.WriteHeader(http.StatusNotFound)
 		return
 	}
-	e = handler.blobstore.Delete(params["identifier"])
+	e = handler.blobstoreFor(request).Delete(params["identifier"])
+	if e == nil && handler.onDelete != nil {
+		handler.onDelete(params["identifier"])
+	}
+
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusNoContent, nil)
+}
 
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusNoContent, nil, nil, "")
+// NotifyOnDelete registers listener to be called after Delete removed a resource, e.g. to invalidate data derived from it.
+func (handler *ResourceHandler) NotifyOnDelete(listener func(identifier string)) {
+	handler.onDelete = listener
 }
 
 func (handler *ResourceHandler) DeleteDir(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
-	e := handler.blobstore.DeleteDir(params["identifier"])
+	e := handler.blobstoreFor(request).DeleteDir(params["identifier"])
 
 	switch e.(type) {
 	case *NotFoundError:
 		responseWriter.WriteHeader(http.StatusNoContent)
 		return
 	}
-	writeResponseBasedOn("", e, responseWriter, request, http.StatusNoContent, nil, nil, "")
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusNoContent, nil)
 }
 
 var emptyReader = ioutil.NopCloser(bytes.NewReader(nil))
 
 // TODO: this function probably does too many things and should be refactored
-func writeResponseBasedOn(redirectLocation string, e error, responseWriter http.ResponseWriter, request *http.Request, statusCode int, body io.ReadCloser, jsonBody *ResponseBody, ifNoneModify string) {
+func writeResponseBasedOn(redirectLocation string, e error, responseWriter http.ResponseWriter, request *http.Request, statusCode int, jsonBody *ResponseBody) {
 	switch e.(type) {
 	case *NotFoundError:
 		responseWriter.WriteHeader(http.StatusNotFound)
@@ -563,6 +761,10 @@ func writeResponseBasedOn(redirectLocation string, e error, responseWriter http.
 	case *NoSpaceLeftError:
 		http.Error(responseWriter, util.DescriptionAndCodeAsJSON(500000, "Request Entity Too Large"), http.StatusInsufficientStorage)
 		return
+	case *QuotaExceededError:
+		logger.From(request).Infow("Rejecting upload", "error", e)
+		http.Error(responseWriter, util.DescriptionAndCodeAsJSON(290011, "%v", e.Error()), http.StatusInsufficientStorage)
+		return
 	case error:
 		panic(e)
 	}
@@ -570,18 +772,6 @@ func writeResponseBasedOn(redirectLocation string, e error, responseWriter http.
 		redirect(responseWriter, redirectLocation)
 		return
 	}
-	if body != nil {
-		buffer, eTag := bufferAndEtagFrom(body)
-		logger.From(request).Debugw("Cache check", "if-none-modify", ifNoneModify, "etag", eTag)
-		responseWriter.Header().Set("ETag", eTag)
-		if ifNoneModify == eTag {
-			responseWriter.WriteHeader(http.StatusNotModified)
-			return
-		}
-		responseWriter.WriteHeader(statusCode)
-		io.Copy(responseWriter, buffer)
-		return
-	}
 	if jsonBody != nil {
 		respBody, marshallingErr := json.Marshal(jsonBody)
 		util.PanicOnError(marshallingErr)
@@ -603,14 +793,3 @@ func badRequest(responseWriter http.ResponseWriter, request *http.Request, messa
 	responseWriter.WriteHeader(http.StatusBadRequest)
 	util.FprintDescriptionAndCodeAsJSON(responseWriter, 290003, message, args...)
 }
-
-func bufferAndEtagFrom(body io.ReadCloser) (buffer *bytes.Buffer, eTag string) {
-	defer body.Close()
-	var buf bytes.Buffer
-	sha := sha1.New()
-	_, e := io.Copy(io.MultiWriter(&buf, sha), body)
-	util.PanicOnError(e)
-	eTag = hex.EncodeToString(sha.Sum(nil))
-	buffer = &buf
-	return
-}
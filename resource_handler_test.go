@@ -1,8 +1,11 @@
 package bitsgo_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"os"
 	"reflect"
 
 	"github.com/petergtz/pegomock"
@@ -16,6 +19,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"time"
 
 	"io"
 
@@ -53,6 +57,20 @@ var _ = Describe("ResourceHandler", func() {
 			})
 		})
 
+		Context("tenant's quota exceeded", func() {
+			It("translates QuotaExceededError into StatusInsufficientStorage with an error code", func() {
+				When(blobstore.Put(AnyString(), anyReadSeeker())).ThenReturn(NewQuotaExceededError("space-guid", 90, 20, 100))
+
+				handler.AddOrReplace(responseWriter,
+					newTestRequest("test-resource", "some-filename", CreateZip(map[string]string{"file1": "content1"}).String()),
+					map[string]string{})
+
+				Expect(responseWriter.Code).To(Equal(http.StatusInsufficientStorage))
+				Expect(responseWriter.Body.String()).To(MatchJSON(`{"code":290011,"description":"Quota of tenant space-guid exceeded: 90 bytes in use, 20 bytes to add, limit is 100 bytes"}`))
+				blobstore.VerifyWasCalledOnce().Put(AnyString(), anyReadSeeker())
+			})
+		})
+
 		Context("resource is a package", func() {
 			BeforeEach(func() {
 				handler = NewResourceHandlerWithUpdater(blobstore, appStashBlobstore, updater, "package", NewMockMetricsService(), 0, false)
@@ -115,65 +133,333 @@ var _ = Describe("ResourceHandler", func() {
 
 				Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
 			})
+
+			It("lets callers wait for async uploads", func() {
+				synchronization := make(chan bool)
+				When(blobstore.Put(AnyString(), anyReadSeeker())).Then(func(params []Param) ReturnValues {
+					<-synchronization
+					return nil
+				})
+				req := newTestRequest("test-resource", "some-filename", CreateZip(map[string]string{"file1": "content1"}).String())
+				req.URL.RawQuery = "async=true"
+				handler.AddOrReplace(responseWriter, req, map[string]string{})
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				Expect(handler.WaitForAsyncUploads(ctx)).To(Equal(context.DeadlineExceeded))
+
+				synchronization <- true
+				Expect(handler.WaitForAsyncUploads(context.Background())).To(Succeed())
+				updater.VerifyWasCalledOnce().NotifyUploadSucceeded(AnyString(), AnyString(), AnyString())
+			})
+
+			Context("with upload jobs", func() {
+				var (
+					jobsDir    string
+					uploadJobs *UploadJobStore
+				)
+
+				BeforeEach(func() {
+					var e error
+					jobsDir, e = ioutil.TempDir("", "upload-jobs")
+					Expect(e).NotTo(HaveOccurred())
+					uploadJobs, e = NewUploadJobStore(jobsDir, time.Hour)
+					Expect(e).NotTo(HaveOccurred())
+					handler = NewResourceHandlerWithUploadJobs(blobstore, appStashBlobstore, updater, "test-resource", NewMockMetricsService(), 0, 0, math.MaxUint64, false, uploadJobs, 1)
+				})
+
+				AfterEach(func() {
+					os.RemoveAll(jobsDir)
+				})
+
+				uploadStatus := func() string {
+					statusResponseWriter := httptest.NewRecorder()
+					handler.UploadStatus(statusResponseWriter, httptest.NewRequest("GET", "/packages/theguid/upload_status", nil), map[string]string{"identifier": "theguid"})
+					Expect(statusResponseWriter.Code).To(Equal(http.StatusOK))
+					return statusResponseWriter.Body.String()
+				}
+
+				asyncRequest := func() *http.Request {
+					req := newTestRequest("test-resource", "some-filename", CreateZip(map[string]string{"file1": "content1"}).String())
+					req.URL.RawQuery = "async=true"
+					return req
+				}
+
+				It("reports the upload as processing and then as ready", func() {
+					synchronization := make(chan bool)
+					When(blobstore.Put(AnyString(), anyReadSeeker())).Then(func(params []Param) ReturnValues {
+						<-synchronization
+						return nil
+					})
+
+					handler.AddOrReplace(responseWriter, asyncRequest(), map[string]string{"identifier": "theguid"})
+
+					Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
+					Expect(uploadStatus()).To(ContainSubstring(`"state":"PROCESSING_UPLOAD"`))
+
+					synchronization <- true
+					Eventually(uploadStatus, "2s").Should(ContainSubstring(`"state":"READY"`))
+					updater.VerifyWasCalledOnce().NotifyUploadSucceeded(EqString("theguid"), AnyString(), AnyString())
+				})
+
+				It("reports failed uploads with their error", func() {
+					When(blobstore.Put(AnyString(), anyReadSeeker())).ThenReturn(NewNoSpaceLeftError())
+
+					handler.AddOrReplace(responseWriter, asyncRequest(), map[string]string{"identifier": "theguid"})
+
+					Eventually(uploadStatus, "2s").Should(ContainSubstring(`"state":"FAILED"`))
+					Expect(uploadStatus()).To(ContainSubstring(`"error":"NoSpaceLeftError"`))
+					updater.VerifyWasCalledOnce().NotifyUploadFailed(EqString("theguid"), anyError())
+				})
+
+				It("resumes pending uploads", func() {
+					bitsFile, e := ioutil.TempFile("", "bits")
+					Expect(e).NotTo(HaveOccurred())
+					bitsFile.Close()
+					_, e = uploadJobs.Add("theguid", bitsFile.Name(), "thesha1", "thesha256", "")
+					Expect(e).NotTo(HaveOccurred())
+
+					Expect(handler.ResumeUploadJobs()).To(Succeed())
+
+					Eventually(uploadStatus, "2s").Should(ContainSubstring(`"state":"READY"`))
+					blobstore.VerifyWasCalledOnce().Put(EqString("theguid"), anyReadSeeker())
+					updater.VerifyWasCalledOnce().NotifyUploadSucceeded("theguid", "thesha1", "thesha256")
+				})
+
+				It("responds with 404 when there is no upload", func() {
+					handler.UploadStatus(responseWriter, httptest.NewRequest("GET", "/packages/unknown/upload_status", nil), map[string]string{"identifier": "unknown"})
+
+					Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+				})
+			})
+		})
+	})
+
+	Context("AddOrReplaceWithDigestInHeader", func() {
+		It("streams the content into the blobstore under its sha256 when the digest matches", func() {
+			request, e := http.NewRequest("PUT", "irrelevant", strings.NewReader("My test string"))
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Digest", "sha256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76")
+
+			handler.AddOrReplaceWithDigestInHeader(responseWriter, request, map[string]string{"identifier": "someguid"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusCreated))
+			blobstore.VerifyWasCalledOnce().Put(EqString("someguid/5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"), anyReadSeeker())
+		})
+
+		It("rejects the upload without touching the blobstore when the digest does not match", func() {
+			request, e := http.NewRequest("PUT", "irrelevant", strings.NewReader("My test string"))
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Digest", "sha256=0000000000000000000000000000000000000000000000000000000000000000")
+
+			handler.AddOrReplaceWithDigestInHeader(responseWriter, request, map[string]string{"identifier": "someguid"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(responseWriter.Body.String()).To(MatchJSON(`{
+				"description": "Digest header does not match content. Digest header has sha-256=0000000000000000000000000000000000000000000000000000000000000000, but content has sha-256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76",
+				"code": 290010
+			}`))
+			blobstore.VerifyWasCalled(Never()).Put(AnyString(), anyReadSeeker())
+		})
+
+		It("verifies every supported algorithm of a multi-value Digest header", func() {
+			request, e := http.NewRequest("PUT", "irrelevant", strings.NewReader("My test string"))
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Digest", "unknown-alg=foo, "+
+				"SHA-256=U1jDeUKwEmCEuxb31gJ4jQBBbgG8P9ATL0RY3TVdjnY=, "+
+				"sha-512=eb75278c702e4a04e1aca60a68e141052a63406b40c7be8f8db1c3b89b612b84e09d64b97e8730fdf91d03a2713235d16847743eec35810ecf6b38898b14523c")
+
+			handler.AddOrReplaceWithDigestInHeader(responseWriter, request, map[string]string{"identifier": "someguid"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusCreated))
+			blobstore.VerifyWasCalledOnce().Put(EqString("someguid/5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"), anyReadSeeker())
+		})
+
+		It("rejects the upload when only the sha-512 does not match", func() {
+			request, e := http.NewRequest("PUT", "irrelevant", strings.NewReader("My test string"))
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Digest", "sha-256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76,sha-512="+strings.Repeat("0", 128))
+
+			handler.AddOrReplaceWithDigestInHeader(responseWriter, request, map[string]string{"identifier": "someguid"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusUnprocessableEntity))
+			blobstore.VerifyWasCalled(Never()).Put(AnyString(), anyReadSeeker())
+		})
+
+		It("returns StatusBadRequest when the Digest header has no supported algorithm", func() {
+			request, e := http.NewRequest("PUT", "irrelevant", strings.NewReader("My test string"))
+			Expect(e).NotTo(HaveOccurred())
+			request.Header.Set("Digest", "md5=HUXZLQLMuI/KZ5KDcJPcOA==")
+
+			handler.AddOrReplaceWithDigestInHeader(responseWriter, request, map[string]string{"identifier": "someguid"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusBadRequest))
+			blobstore.VerifyWasCalled(Never()).Put(AnyString(), anyReadSeeker())
 		})
 	})
 
 	Context("Get", func() {
-		Context("No If-None-Modify	 provided in request", func() {
-			It("returns a response with body and StatusOK", func() {
-				When(blobstore.GetOrRedirect(AnyString())).ThenReturn(ioutil.NopCloser(strings.NewReader("hello")), "", nil)
+		var lastModified time.Time
+
+		BeforeEach(func() {
+			lastModified = time.Date(2018, time.March, 1, 12, 0, 0, 0, time.UTC)
+			When(blobstore.GetOrRedirect(AnyString())).ThenReturn(ioutil.NopCloser(strings.NewReader("hello")), "", nil)
+			When(blobstore.Get(AnyString())).ThenReturn(ioutil.NopCloser(strings.NewReader("hello")), nil)
+			When(blobstore.Stat(AnyString())).ThenReturn(&BlobInfo{Size: 5, LastModified: lastModified, ETag: "the-etag"}, nil)
+		})
+
+		It("returns a response with body, ETag, Last-Modified and StatusOK", func() {
+			handler.Get(responseWriter, newGetRequest(nil), nil)
+
+			Expect(responseWriter.Code).To(Equal(http.StatusOK))
+			Expect(responseWriter.Body.String()).To(Equal("hello"))
+			Expect(responseWriter.Header().Get("ETag")).To(Equal(`"the-etag"`))
+			Expect(responseWriter.Header().Get("Last-Modified")).To(Equal("Thu, 01 Mar 2018 12:00:00 GMT"))
+			Expect(responseWriter.Header().Get("Accept-Ranges")).To(Equal("bytes"))
+		})
+
+		It("redirects without asking the blobstore for metadata", func() {
+			When(blobstore.GetOrRedirect(AnyString())).ThenReturn(nil, "http://the-redirect-location", nil)
+
+			handler.Get(responseWriter, newGetRequest(nil), nil)
+
+			Expect(responseWriter.Code).To(Equal(http.StatusFound))
+			Expect(responseWriter.Header().Get("Location")).To(Equal("http://the-redirect-location"))
+			blobstore.VerifyWasCalled(Never()).Stat(AnyString())
+		})
+
+		It("returns StatusNotFound when the blob does not exist", func() {
+			When(blobstore.Stat(AnyString())).ThenReturn(nil, NewNotFoundError())
+
+			handler.Get(responseWriter, newGetRequest(nil), nil)
+
+			Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+		})
 
-				handler.Get(responseWriter, newGetRequestWithOptionalIfNoneModify(""), nil)
+		Context("If-None-Match provided in request", func() {
+			It("returns a response with empty body and StatusNotModified when it matches the ETag", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"If-None-Match": `"the-etag"`}), nil)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusNotModified))
+				Expect(responseWriter.Body.String()).To(BeEmpty())
+			})
+
+			It("returns a response with body and StatusOK when the content of the blob has changed", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"If-None-Match": `"some-old-etag"`}), nil)
 
 				Expect(responseWriter.Code).To(Equal(http.StatusOK))
 				Expect(responseWriter.Body.String()).To(Equal("hello"))
 			})
 		})
 
-		Context("If-None-Modify provided in request", func() {
-			BeforeEach(func() {
-				When(blobstore.GetOrRedirect(AnyString())).ThenReturn(ioutil.NopCloser(strings.NewReader("hello")), "", nil)
+		Context("If-Modified-Since provided in request", func() {
+			It("returns StatusNotModified when the blob has not been modified since", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"If-Modified-Since": "Thu, 01 Mar 2018 12:00:00 GMT"}), nil)
 
-				handler.Get(responseWriter, newGetRequestWithOptionalIfNoneModify(""), nil)
+				Expect(responseWriter.Code).To(Equal(http.StatusNotModified))
+			})
+
+			It("returns a response with body and StatusOK when the blob has been modified since", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"If-Modified-Since": "Wed, 28 Feb 2018 12:00:00 GMT"}), nil)
 
 				Expect(responseWriter.Code).To(Equal(http.StatusOK))
+				Expect(responseWriter.Body.String()).To(Equal("hello"))
+			})
+		})
+
+		Context("Range provided in request", func() {
+			It("returns only the requested bytes and StatusPartialContent", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=1-3"}), nil)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusPartialContent))
+				Expect(responseWriter.Body.String()).To(Equal("ell"))
+				Expect(responseWriter.Header().Get("Content-Range")).To(Equal("bytes 1-3/5"))
 			})
 
-			Context("matches ETag", func() {
-				It("returns a response with empty body and StatusNotModified", func() {
-					When(blobstore.GetOrRedirect(AnyString())).ThenReturn(ioutil.NopCloser(strings.NewReader("hello")), "", nil)
+			It("returns the remaining bytes for an open-ended range", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=2-"}), nil)
 
-					responseWriterFollowUpRequest := httptest.NewRecorder()
+				Expect(responseWriter.Code).To(Equal(http.StatusPartialContent))
+				Expect(responseWriter.Body.String()).To(Equal("llo"))
+			})
 
-					handler.Get(
-						responseWriterFollowUpRequest,
-						newGetRequestWithOptionalIfNoneModify(responseWriter.HeaderMap.Get("ETag")),
-						nil)
+			It("returns StatusRequestedRangeNotSatisfiable when the range is beyond the blob", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=10-20"}), nil)
 
-					Expect(responseWriterFollowUpRequest.Code).To(Equal(http.StatusNotModified))
-					Expect(responseWriterFollowUpRequest.Body.String()).To(BeEmpty())
-				})
+				Expect(responseWriter.Code).To(Equal(http.StatusRequestedRangeNotSatisfiable))
 			})
-			Context("does not match ETag because content of blob has changed", func() {
-				It("returns a response with body and StatusOK", func() {
-					When(blobstore.GetOrRedirect(AnyString())).
-						ThenReturn(ioutil.NopCloser(strings.NewReader("hello - the content has changed")), "", nil)
 
-					r, e := http.NewRequest("GET", "irrelevant", nil)
-					Expect(e).NotTo(HaveOccurred())
-					r.Header.Set("If-None-Modify", responseWriter.HeaderMap.Get("ETag"))
+			It("returns the requested bytes when If-Range matches the ETag", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=1-3", "If-Range": `"the-etag"`}), nil)
 
-					responseWriterFollowUpRequest := httptest.NewRecorder()
+				Expect(responseWriter.Code).To(Equal(http.StatusPartialContent))
+				Expect(responseWriter.Body.String()).To(Equal("ell"))
+			})
 
-					handler.Get(
-						responseWriterFollowUpRequest,
-						newGetRequestWithOptionalIfNoneModify(responseWriter.HeaderMap.Get("ETag")),
-						nil)
+			It("returns the whole blob when If-Range does not match the ETag", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=1-3", "If-Range": `"some-old-etag"`}), nil)
 
-					Expect(responseWriter.Code).To(Equal(http.StatusOK))
-					Expect(responseWriter.Body.String()).To(Equal("hello"))
-				})
+				Expect(responseWriter.Code).To(Equal(http.StatusOK))
+				Expect(responseWriter.Body.String()).To(Equal("hello"))
 			})
+
+			It("returns the whole blob when If-Range is a weak ETag", func() {
+				When(blobstore.Stat(AnyString())).ThenReturn(&BlobInfo{Size: 5, LastModified: lastModified, ETag: "the-etag", WeakETag: true}, nil)
+
+				handler.Get(responseWriter, newGetRequest(map[string]string{"Range": "bytes=1-3", "If-Range": `W/"the-etag"`}), nil)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusOK))
+				Expect(responseWriter.Header().Get("ETag")).To(Equal(`W/"the-etag"`))
+				Expect(responseWriter.Body.String()).To(Equal("hello"))
+			})
+		})
+
+		Context("proxy_get_requests is enabled", func() {
+			BeforeEach(func() {
+				handler = NewResourceHandlerWithUpdater(blobstore, appStashBlobstore, updater, "test-resource", NewMockMetricsService(), 0, true)
+			})
+
+			It("returns a response with body and StatusOK", func() {
+				handler.Get(responseWriter, newGetRequest(nil), nil)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusOK))
+				Expect(responseWriter.Body.String()).To(Equal("hello"))
+				blobstore.VerifyWasCalled(Never()).GetOrRedirect(AnyString())
+			})
+
+			It("does not fetch the blob when the ETag matches", func() {
+				handler.Get(responseWriter, newGetRequest(map[string]string{"If-None-Match": `"the-etag"`}), nil)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusNotModified))
+				blobstore.VerifyWasCalled(Never()).Get(AnyString())
+			})
+		})
+	})
+
+	Context("Delete", func() {
+		var deleted []string
+
+		BeforeEach(func() {
+			deleted = nil
+			handler.NotifyOnDelete(func(identifier string) { deleted = append(deleted, identifier) })
+		})
+
+		It("notifies the listener about the deleted resource", func() {
+			When(blobstore.Exists("someguid/somehash")).ThenReturn(true, nil)
+
+			handler.Delete(responseWriter, httptest.NewRequest("DELETE", "/droplets/someguid/somehash", nil), map[string]string{"identifier": "someguid/somehash"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusNoContent))
+			Expect(deleted).To(ConsistOf("someguid/somehash"))
+		})
+
+		It("does not notify the listener when the resource does not exist", func() {
+			When(blobstore.Exists("someguid/somehash")).ThenReturn(false, nil)
+
+			handler.Delete(responseWriter, httptest.NewRequest("DELETE", "/droplets/someguid/somehash", nil), map[string]string{"identifier": "someguid/somehash"})
+
+			Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+			Expect(deleted).To(BeEmpty())
 		})
 	})
 
@@ -322,11 +608,11 @@ func newTestRequest(resource string, filename string, body string) *http.Request
 	return request
 }
 
-func newGetRequestWithOptionalIfNoneModify(ifNoneModify string) *http.Request {
+func newGetRequest(headers map[string]string) *http.Request {
 	r, e := http.NewRequest("GET", "irrelevant", nil)
 	Expect(e).NotTo(HaveOccurred())
-	if ifNoneModify != "" {
-		r.Header.Set("If-None-Modify", ifNoneModify)
+	for key, value := range headers {
+		r.Header.Set(key, value)
 	}
 	return r
 }
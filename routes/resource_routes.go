@@ -20,17 +20,30 @@ func SetUpAllRoutes(privateHost, publicHost, registryHost string, basicAuthMiddl
 	signAppStashURLHandler *bitsgo.SignResourceHandler,
 	appstashHandler *bitsgo.AppStashHandler,
 	packageHandler, buildpackHandler, dropletHandler, buildpackCacheHandler *bitsgo.ResourceHandler,
+	packageUploadSessionHandler, dropletUploadSessionHandler *bitsgo.UploadSessionHandler,
 	ociImageHandler *registry.ImageHandler,
+	readinessHandler *bitsgo.ReadinessHandler,
+	metricsHandler http.Handler,
 ) *mux.Router {
 
 	rootRouter := mux.NewRouter()
 
 	internalRouter := rootRouter.Host(privateHost).Subrouter()
 
+	// A nil metricsHandler means metrics are not served at all or served by their own listener.
+	SetUpMetricsRoute(internalRouter, metricsHandler)
+
+	internalRouter.Path("/healthz").Methods("GET").HandlerFunc(bitsgo.Healthz)
+	if readinessHandler != nil {
+		internalRouter.Path("/readyz").Methods("GET").Handler(readinessHandler)
+	}
+
 	SetUpSignRoute(internalRouter, basicAuthMiddleware,
 		signPackageURLHandler, signDropletURLHandler, signBuildpackURLHandler, signBuildpackCacheURLHandler, signAppStashURLHandler)
 
 	SetUpAppStashRoutes(internalRouter, appstashHandler)
+	SetUpUploadSessionRoutes(internalRouter, "packages", packageUploadSessionHandler)
+	SetUpUploadSessionRoutes(internalRouter, "droplets", dropletUploadSessionHandler)
 	SetUpPackageRoutes(internalRouter, packageHandler)
 	SetUpBuildpackRoutes(internalRouter, buildpackHandler)
 	SetUpDropletRoutes(internalRouter, dropletHandler)
@@ -40,6 +53,8 @@ func SetUpAllRoutes(privateHost, publicHost, registryHost string, basicAuthMiddl
 	publicRouter.Use(middlewares.GorillaMiddlewareFrom(signatureVerificationMiddleware))
 
 	SetUpAppStashRoutes(publicRouter, appstashHandler)
+	SetUpUploadSessionRoutes(publicRouter, "packages", packageUploadSessionHandler)
+	SetUpUploadSessionRoutes(publicRouter, "droplets", dropletUploadSessionHandler)
 	SetUpPackageRoutes(publicRouter, packageHandler)
 	SetUpBuildpackRoutes(publicRouter, buildpackHandler)
 	SetUpDropletRoutes(publicRouter, dropletHandler)
@@ -57,6 +72,13 @@ func SetUpAllRoutes(privateHost, publicHost, registryHost string, basicAuthMiddl
 	return rootRouter
 }
 
+func SetUpMetricsRoute(router *mux.Router, metricsHandler http.Handler) {
+	if metricsHandler == nil {
+		return
+	}
+	router.Path("/metrics").Methods("GET").Handler(metricsHandler)
+}
+
 func SetUpAppStashRoutes(router *mux.Router, appStashHandler *bitsgo.AppStashHandler) {
 	router.Path("/app_stash/entries").Methods("POST").HandlerFunc(appStashHandler.PostEntries)
 	router.Path("/app_stash/matches").Methods("POST").HandlerFunc(appStashHandler.PostMatches)
@@ -64,6 +86,7 @@ func SetUpAppStashRoutes(router *mux.Router, appStashHandler *bitsgo.AppStashHan
 }
 
 func SetUpPackageRoutes(router *mux.Router, resourceHandler *bitsgo.ResourceHandler) {
+	router.Path("/packages/{identifier}/upload_status").Methods("GET").HandlerFunc(delegateTo(resourceHandler.UploadStatus))
 	setUpDefaultMethodRoutes(router.Path("/packages/{identifier}").Subrouter(), resourceHandler)
 }
 
@@ -72,6 +95,7 @@ func SetUpBuildpackRoutes(router *mux.Router, resourceHandler *bitsgo.ResourceHa
 	// TODO: why do we need a version with a / in the end
 	router.Path("/buildpacks/").Methods("POST").HandlerFunc(delegateTo(resourceHandler.AddBuildpack))
 	router.Path("/buildpacks/{identifier}/metadata").Methods("GET").HandlerFunc(delegateTo(resourceHandler.BuildpackMetadata))
+	router.Path("/buildpacks/{identifier}/commit").Methods("POST").HandlerFunc(delegateTo(resourceHandler.CommitBuildpack))
 	setUpDefaultMethodRoutes(router.Path("/buildpacks/{identifier}").Subrouter(), resourceHandler)
 }
 
@@ -82,6 +106,21 @@ func SetUpDropletRoutes(router *mux.Router, resourceHandler *bitsgo.ResourceHand
 		resourceHandler)
 }
 
+// SetUpUploadSessionRoutes must be called before the routes of resourceType are set up, because droplet routes match any path.
+// A nil uploadSessionHandler disables resumable uploads.
+func SetUpUploadSessionRoutes(router *mux.Router, resourceType string, uploadSessionHandler *bitsgo.UploadSessionHandler) {
+	if uploadSessionHandler == nil {
+		return
+	}
+	uploadsRouter := router.Path("/" + resourceType + "/{identifier:[a-z0-9\\-]+}/uploads").Subrouter()
+	uploadsRouter.Methods("POST").HandlerFunc(delegateTo(uploadSessionHandler.Create))
+	uploadsRouter.Methods("PATCH").HandlerFunc(delegateTo(uploadSessionHandler.AppendChunk))
+	uploadsRouter.Methods("GET").HandlerFunc(delegateTo(uploadSessionHandler.Status))
+	uploadsRouter.Methods("PUT").HandlerFunc(delegateTo(uploadSessionHandler.Finalize))
+	uploadsRouter.Methods("DELETE").HandlerFunc(delegateTo(uploadSessionHandler.Cancel))
+	setRouteNotFoundStatusCode(uploadsRouter, http.StatusMethodNotAllowed)
+}
+
 func SetUpBuildpackCacheRoutes(router *mux.Router, resourceHandler *bitsgo.ResourceHandler) {
 	router.Path("/buildpack_cache/entries").Methods("DELETE").HandlerFunc(delegateTo(resourceHandler.DeleteDir))
 	router.Path("/buildpack_cache/entries/").Methods("DELETE").HandlerFunc(delegateTo(resourceHandler.DeleteDir))
@@ -108,6 +147,8 @@ func SetUpSignRoute(router *mux.Router,
 ) {
 	signRouter := router.PathPrefix("/sign").Subrouter()
 
+	signRouter.Path("/packages/{resource:[a-z0-9\\-]+/uploads}").Methods("GET").Handler(wrapWith(basicAuthMiddleware, signPackageURLHandler.ForUploadSessions()))
+	signRouter.Path("/droplets/{resource:[a-z0-9\\-]+/uploads}").Methods("GET").Handler(wrapWith(basicAuthMiddleware, signDropletURLHandler.ForUploadSessions()))
 	signRouter.Path("/packages/{resource:[a-z0-9\\-]+}").Methods("GET").Handler(wrapWith(basicAuthMiddleware, signPackageURLHandler))
 	signRouter.Path("/droplets/{resource:.+}").Methods("GET").Handler(wrapWith(basicAuthMiddleware, signDropletURLHandler))
 	signRouter.Path("/buildpacks").Methods("GET").Handler(wrapWith(basicAuthMiddleware, signBuildpackURLHandler))
@@ -148,9 +189,27 @@ func delegateWithQueryParamsExtractedTo(delegate func(http.ResponseWriter, *http
 }
 
 func AddImageHandler(ociRouter *mux.Router, handler *registry.ImageHandler) {
+	if handler.Auth != nil {
+		ociRouter.Path("/v2/token").Methods(http.MethodGet).HandlerFunc(handler.Auth.ServeToken)
+		protectedRouter := ociRouter.NewRoute().Subrouter()
+		protectedRouter.Use(middlewares.GorillaMiddlewareFrom(handler.Auth))
+		ociRouter = protectedRouter
+	}
 	ociRouter.Path("/v2").Methods(http.MethodGet).HandlerFunc(handler.ServeAPIVersion)
 	ociRouter.Path("/v2/").Methods(http.MethodGet).HandlerFunc(handler.ServeAPIVersion)
+	ociRouter.Path("/v2/_catalog").Methods(http.MethodGet).HandlerFunc(handler.ServeCatalog)
+	ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/tags/list").Methods(http.MethodGet).HandlerFunc(handler.ServeTags)
+	if handler.Uploads != nil {
+		ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/blobs/uploads/").Methods(http.MethodPost).HandlerFunc(handler.StartBlobUpload)
+		uploadRouter := ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/blobs/uploads/{uuid}").Subrouter()
+		uploadRouter.Methods(http.MethodPatch).HandlerFunc(handler.PatchBlobUpload)
+		uploadRouter.Methods(http.MethodPut).HandlerFunc(handler.CompleteBlobUpload)
+		uploadRouter.Methods(http.MethodGet).HandlerFunc(handler.GetBlobUploadStatus)
+		uploadRouter.Methods(http.MethodDelete).HandlerFunc(handler.CancelBlobUpload)
+		setRouteNotFoundStatusCode(uploadRouter, http.StatusMethodNotAllowed)
+		ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/manifests/{tag}").Methods(http.MethodPut).HandlerFunc(handler.PutManifest)
+	}
 	ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/manifests/{tag}").Methods(http.MethodGet, http.MethodHead).HandlerFunc(handler.ServeManifest)
 	ociRouter.Path("/v2/{space}/{name}/manifests/{tag}").Methods(http.MethodGet, http.MethodHead).HandlerFunc(handler.ServeManifest)
-	ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/blobs/{digest}").Methods(http.MethodGet).HandlerFunc(handler.ServeBlob)
+	ociRouter.Path("/v2/{name:[a-z0-9/\\.\\-_]+}/blobs/{digest}").Methods(http.MethodGet, http.MethodHead).HandlerFunc(handler.ServeBlob)
 }
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"archive/zip"
 
@@ -164,7 +165,7 @@ var _ = Describe("routes", func() {
 			It("reads the digest from the header", func() {
 				r, e := http.NewRequest("PUT", "/droplets/theguid", strings.NewReader("My test string"))
 				Expect(e).NotTo(HaveOccurred())
-				r.Header.Set("Digest", "sha256=checksum")
+				r.Header.Set("Digest", "sha256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76")
 
 				router.ServeHTTP(responseWriter, r)
 
@@ -179,11 +180,60 @@ var _ = Describe("routes", func() {
 						MatchRegexp(`.*"created_at" *:.*`),
 					)))
 
-				Expect(blobstoreEntries).To(HaveKeyWithValue("th/eg/theguid/checksum", []byte("My test string")))
+				Expect(blobstoreEntries).To(HaveKeyWithValue("th/eg/theguid/5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76", []byte("My test string")))
+			})
+
+			It("rejects the upload when the digest does not match the content", func() {
+				r, e := http.NewRequest("PUT", "/droplets/theguid", strings.NewReader("My test string"))
+				Expect(e).NotTo(HaveOccurred())
+				r.Header.Set("Digest", "sha256=0000000000000000000000000000000000000000000000000000000000000000")
+
+				router.ServeHTTP(responseWriter, r)
+
+				Expect(responseWriter.Code).To(Equal(http.StatusUnprocessableEntity))
+				Expect(blobstoreEntries).To(BeEmpty())
 			})
 		})
 	})
 
+	Describe("/droplets/{guid}/uploads", func() {
+		var stagingDir string
+
+		BeforeEach(func() {
+			var e error
+			stagingDir, e = ioutil.TempDir("", "upload-sessions")
+			Expect(e).NotTo(HaveOccurred())
+			sessions, e := bitsgo.NewUploadSessionStore(inmemory_blobstore.NewBlobstore(), stagingDir, time.Hour)
+			Expect(e).NotTo(HaveOccurred())
+			handler := bitsgo.NewResourceHandler(decorator.ForBlobstoreWithPathPartitioning(blobstore), appstashBlobstore, "droplet", statsd.NewMetricsService(), 0, false)
+			SetUpUploadSessionRoutes(router, "droplets", bitsgo.NewUploadSessionHandler(handler, sessions))
+			SetUpDropletRoutes(router, handler)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(stagingDir)
+		})
+
+		It("stores the droplet uploaded in chunks under its sha256", func() {
+			router.ServeHTTP(responseWriter, httptest.NewRequest("POST", "/droplets/theguid/uploads", nil))
+			Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
+
+			for _, chunk := range []string{"My test ", "string"} {
+				responseWriter = httptest.NewRecorder()
+				router.ServeHTTP(responseWriter, httptest.NewRequest("PATCH", "/droplets/theguid/uploads", strings.NewReader(chunk)))
+				Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
+			}
+
+			responseWriter = httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "/droplets/theguid/uploads", nil)
+			r.Header.Set("Digest", "sha256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76")
+			router.ServeHTTP(responseWriter, r)
+
+			Expect(responseWriter.Code).To(Equal(http.StatusCreated))
+			Expect(blobstoreEntries).To(HaveKeyWithValue("th/eg/theguid/5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76", []byte("My test string")))
+		})
+	})
+
 	Describe("/buildpacks/{guid}", func() {
 		BeforeEach(func() {
 			SetUpBuildpackRoutes(
@@ -191,6 +241,33 @@ var _ = Describe("routes", func() {
 				bitsgo.NewResourceHandler(decorator.ForBlobstoreWithPathPartitioning(blobstore), appstashBlobstore, "buildpack", statsd.NewMetricsService(), 0, false))
 		})
 		ItSupportsMethodsGetPutDeleteFor("/buildpacks/theguid", "buildpack", "th/eg/theguid")
+
+		Context("POST /buildpacks/{guid}/commit", func() {
+			It("removes the uncommitted marker", func() {
+				blobstoreEntries["th/eg/theguid"] = []byte("thecontent")
+				blobstoreEntries["un/co/uncommitted/theguid"] = []byte("2018-03-01T12:00:00Z")
+
+				router.ServeHTTP(responseWriter, httptest.NewRequest("POST", "/buildpacks/theguid/commit", nil))
+
+				Expect(responseWriter.Code).To(Equal(http.StatusNoContent))
+				Expect(blobstoreEntries).To(HaveKey("th/eg/theguid"))
+				Expect(blobstoreEntries).NotTo(HaveKey("un/co/uncommitted/theguid"))
+			})
+
+			It("succeeds when the buildpack is already committed", func() {
+				blobstoreEntries["th/eg/theguid"] = []byte("thecontent")
+
+				router.ServeHTTP(responseWriter, httptest.NewRequest("POST", "/buildpacks/theguid/commit", nil))
+
+				Expect(responseWriter.Code).To(Equal(http.StatusNoContent))
+			})
+
+			It("returns StatusNotFound when the buildpack does not exist", func() {
+				router.ServeHTTP(responseWriter, httptest.NewRequest("POST", "/buildpacks/theguid/commit", nil))
+
+				Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+			})
+		})
 	})
 
 	Describe("/buildpack_cache/entries", func() {
@@ -293,6 +370,34 @@ var _ = Describe("routes", func() {
 		})
 	})
 
+	Describe("/metrics", func() {
+		BeforeEach(func() {
+			SetUpMetricsRoute(
+				router.Host("internal.example.com").Subrouter(),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("some metrics")) }))
+			SetUpDropletRoutes(
+				router.Host("public.example.com").Subrouter(),
+				bitsgo.NewResourceHandler(decorator.ForBlobstoreWithPathPartitioning(blobstore), appstashBlobstore, "droplet", statsd.NewMetricsService(), 0, false))
+		})
+
+		It("serves metrics via the private host only", func() {
+			for _, host := range []string{"public.example.com", "public.example.com:443", "PUBLIC.example.com", "registry.example.com", "10.0.0.1:8000"} {
+				responseWriter = httptest.NewRecorder()
+				request := httptest.NewRequest("GET", "/metrics", nil)
+				request.Host = host
+				router.ServeHTTP(responseWriter, request)
+				Expect(responseWriter.Body.String()).NotTo(ContainSubstring("some metrics"), host)
+			}
+
+			responseWriter = httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/metrics", nil)
+			request.Host = "internal.example.com"
+			router.ServeHTTP(responseWriter, request)
+			Expect(responseWriter.Code).To(Equal(http.StatusOK))
+			Expect(responseWriter.Body.String()).To(Equal("some metrics"))
+		})
+	})
+
 	Describe("/app_stash", func() {
 		BeforeEach(func() {
 			SetUpAppStashRoutes(router, bitsgo.NewAppStashHandlerWithSizeThresholds(blobstore, 0, 0, math.MaxUint64, NewMockMetricsService()))
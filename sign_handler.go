@@ -12,6 +12,11 @@ type ResourceSigner interface {
 	Sign(resource string, method string, expirationTime time.Time) (signedURL string)
 }
 
+// TenantResourceSigner signs URLs whose uploads are charged to the quota of tenant.
+type TenantResourceSigner interface {
+	SignForTenant(resource string, method string, expirationTime time.Time, tenant string) (signedURL string)
+}
+
 type SignResourceHandler struct {
 	clock                                clock.Clock
 	putResourceSigner, getResourceSigner ResourceSigner
@@ -25,6 +30,16 @@ func NewSignResourceHandler(getResourceSigner, putResourceSigner ResourceSigner)
 	}
 }
 
+// ForUploadSessions returns a handler which signs all verbs for bits-service itself, because upload sessions
+// are never served by the blobstore directly.
+func (handler *SignResourceHandler) ForUploadSessions() *SignResourceHandler {
+	return &SignResourceHandler{
+		getResourceSigner: handler.putResourceSigner,
+		putResourceSigner: handler.putResourceSigner,
+		clock:             handler.clock,
+	}
+}
+
 func (handler *SignResourceHandler) Sign(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
 	method := params["verb"]
 	var signer ResourceSigner
@@ -36,7 +51,7 @@ func (handler *SignResourceHandler) Sign(responseWriter http.ResponseWriter, req
 	switch method {
 	case "get":
 		signer = handler.getResourceSigner
-	case "put", "post":
+	case "put", "post", "patch", "delete":
 		signer = handler.putResourceSigner
 	default:
 		responseWriter.WriteHeader(http.StatusBadRequest)
@@ -44,6 +59,12 @@ func (handler *SignResourceHandler) Sign(responseWriter http.ResponseWriter, req
 		return
 	}
 
-	signature := signer.Sign(params["resource"], method, handler.clock.Now().Add(1*time.Hour))
+	expirationTime := handler.clock.Now().Add(1 * time.Hour)
+	var signature string
+	if tenantSigner, ok := signer.(TenantResourceSigner); ok && method != "get" && request.Header.Get(TenantHeader) != "" {
+		signature = tenantSigner.SignForTenant(params["resource"], method, expirationTime, request.Header.Get(TenantHeader))
+	} else {
+		signature = signer.Sign(params["resource"], method, expirationTime)
+	}
 	fmt.Fprint(responseWriter, signature)
 }
@@ -60,4 +60,36 @@ var _ = Describe("SignResourceHandler", func() {
 		Expect(recorder.Code).To(Equal(http.StatusOK))
 		Expect(recorder.Body.String()).To(Equal("Some put signature"))
 	})
+
+	It("Signs all verbs of upload sessions with the put signer", func() {
+		When(putSigner.Sign(AnyString(), AnyString(), AnyTime())).ThenReturn("Some put signature")
+
+		handler := bitsgo.NewSignResourceHandler(getSigner, putSigner).ForUploadSessions()
+		for _, verb := range []string{"get", "patch", "delete"} {
+			recorder = httptest.NewRecorder()
+			handler.Sign(recorder, httputil.NewRequest("GET", "/foo", nil).Build(), map[string]string{"verb": verb, "resource": "theguid/uploads"})
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(Equal("Some put signature"))
+		}
+		getSigner.VerifyWasCalled(Never()).Sign(AnyString(), AnyString(), AnyTime())
+	})
+
+	It("Signs a PUT URL for the tenant in the request header", func() {
+		handler := bitsgo.NewSignResourceHandler(getSigner, &tenantEchoingSigner{})
+		request := httputil.NewRequest("PUT", "/bar", nil).WithHeader(bitsgo.TenantHeader, "space-guid").Build()
+
+		handler.Sign(recorder, request, map[string]string{"verb": "put", "resource": "foobar"})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("foobar for space-guid"))
+	})
 })
+
+type tenantEchoingSigner struct{}
+
+func (signer *tenantEchoingSigner) Sign(resource string, method string, expirationTime time.Time) string {
+	return resource
+}
+
+func (signer *tenantEchoingSigner) SignForTenant(resource string, method string, expirationTime time.Time, tenant string) string {
+	return resource + " for " + tenant
+}
@@ -0,0 +1,56 @@
+package bitsgo
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TracerName = "github.com/cloudfoundry-incubator/bits-service"
+
+// Tracer uses the globally registered TracerProvider, so spans are only recorded once main has set one up.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// BlobstoreContextBinder is implemented by Blobstores which need the context of the request they are used for,
+// e.g. to record their calls as part of the request's trace.
+type BlobstoreContextBinder interface {
+	WithContext(ctx context.Context) Blobstore
+}
+
+// UpdaterContextBinder is the Updater counterpart of BlobstoreContextBinder.
+type UpdaterContextBinder interface {
+	WithContext(ctx context.Context) Updater
+}
+
+func BlobstoreWithContext(blobstore Blobstore, ctx context.Context) Blobstore {
+	if binder, ok := blobstore.(BlobstoreContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return blobstore
+}
+
+func UpdaterWithContext(updater Updater, ctx context.Context) Updater {
+	if binder, ok := updater.(UpdaterContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return updater
+}
+
+// DetachedContext keeps the trace and tenant of ctx, but not its cancellation or deadline.
+// Async work started by a request must use it, because the request's context is canceled once the response is sent.
+func DetachedContext(ctx context.Context) context.Context {
+	return ContextWithTenant(trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx)), TenantFrom(ctx))
+}
+
+// EndSpan records e, unless it is a *NotFoundError, which callers usually expect, and ends span.
+func EndSpan(span trace.Span, e error) {
+	if e != nil && !IsNotFoundError(e) {
+		span.RecordError(e)
+		span.SetStatus(codes.Error, e.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// NewOTLPExporter sends spans via OTLP/HTTP, usually to a collector running next to bits-service.
+func NewOTLPExporter(endpoint string, insecure bool) (sdktrace.SpanExporter, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, e := otlptracehttp.New(context.Background(), options...)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not create OTLP exporter for endpoint %v", endpoint)
+	}
+	return exporter, nil
+}
+
+// NewWriterExporter writes spans as JSON to writer. It is meant for tests and debugging.
+func NewWriterExporter(writer io.Writer) (sdktrace.SpanExporter, error) {
+	exporter, e := stdouttrace.New(stdouttrace.WithWriter(writer))
+	if e != nil {
+		return nil, errors.Wrap(e, "Could not create stdout exporter")
+	}
+	return exporter, nil
+}
+
+// Register makes a TracerProvider exporting to exporter the global one and propagates traces
+// via W3C traceparent headers. New traces are sampled with samplingRatio, traces started
+// by a caller follow the caller's decision. The returned function flushes outstanding spans.
+func Register(exporter sdktrace.SpanExporter, serviceName string, samplingRatio float64) (shutdown func(context.Context) error) {
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tracerProvider.Shutdown
+}
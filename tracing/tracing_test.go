@@ -0,0 +1,47 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/bits-service/tracing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var _ = Describe("Tracing", func() {
+	It("exports spans of sampled traces and propagates them as traceparent", func() {
+		var buffer bytes.Buffer
+		exporter, e := tracing.NewWriterExporter(&buffer)
+		Expect(e).NotTo(HaveOccurred())
+		shutdown := tracing.Register(exporter, "bits-service-test", 1)
+
+		ctx, span := otel.Tracer("test").Start(context.Background(), "some-span")
+		header := http.Header{}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+		span.End()
+		Expect(shutdown(context.Background())).To(Succeed())
+
+		Expect(header.Get("traceparent")).To(ContainSubstring(span.SpanContext().TraceID().String()))
+		Expect(buffer.String()).To(SatisfyAll(
+			ContainSubstring(`"Name":"some-span"`),
+			ContainSubstring(span.SpanContext().TraceID().String()),
+			ContainSubstring("bits-service-test")))
+	})
+
+	It("does not export spans of traces which are not sampled", func() {
+		var buffer bytes.Buffer
+		exporter, e := tracing.NewWriterExporter(&buffer)
+		Expect(e).NotTo(HaveOccurred())
+		shutdown := tracing.Register(exporter, "bits-service-test", 0)
+
+		_, span := otel.Tracer("test").Start(context.Background(), "some-span")
+		span.End()
+		Expect(shutdown(context.Background())).To(Succeed())
+
+		Expect(buffer.String()).To(BeEmpty())
+	})
+})
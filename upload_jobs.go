@@ -0,0 +1,270 @@
+package bitsgo
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	UploadJobStateProcessing = "PROCESSING_UPLOAD"
+	UploadJobStateReady      = "READY"
+	UploadJobStateFailed     = "FAILED"
+)
+
+type UploadJob struct {
+	// ID tells jobs for the same guid apart. Jobs recorded by older versions have none.
+	ID     string `json:"id,omitempty"`
+	Guid   string `json:"guid"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+	Sha1   string `json:"sha1"`
+	Sha256 string `json:"sha256"`
+	// Resumed uploads are charged to this tenant's quota.
+	Tenant    string    `json:"tenant,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UploadJobStore keeps async upload jobs and their bits on disk, so that uploads interrupted by a restart
+// can be resumed. For every guid it stores the latest job in <guid>.json and, until a job is finished,
+// its bits in <guid>.<job id>.bits. This way, a new job for a guid never touches the bits of older ones still running.
+type UploadJobStore struct {
+	dir   string
+	mutex sync.Mutex
+	// Finished jobs are removed after this time. Until then their status can be queried.
+	retention time.Duration
+}
+
+func NewUploadJobStore(dir string, retention time.Duration) (*UploadJobStore, error) {
+	e := os.MkdirAll(dir, 0700)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not create upload jobs directory %v", dir)
+	}
+	return &UploadJobStore{dir: dir, retention: retention}, nil
+}
+
+// Add moves tempFilename into the store and records a new job in state PROCESSING_UPLOAD.
+// An existing job for the same guid is replaced. If it is still running, it finishes without recording its status.
+func (store *UploadJobStore) Add(guid string, tempFilename string, sha1 string, sha256 string, tenant string) (*UploadJob, error) {
+	now := time.Now()
+	job := &UploadJob{
+		ID:        uuid.NewV4().String(),
+		Guid:      guid,
+		State:     UploadJobStateProcessing,
+		Sha1:      sha1,
+		Sha256:    sha256,
+		Tenant:    tenant,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	e := moveFile(tempFilename, store.BitsPath(job))
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not move %v into upload jobs directory", tempFilename)
+	}
+	e = store.save(job)
+	if e != nil {
+		os.Remove(store.BitsPath(job))
+		return nil, e
+	}
+	return job, nil
+}
+
+// Finish records the outcome of a job and removes its bits. A nil uploadErr means the job is READY.
+func (store *UploadJobStore) Finish(job *UploadJob, uploadErr error) error {
+	job.State = UploadJobStateReady
+	job.Error = ""
+	if uploadErr != nil {
+		job.State = UploadJobStateFailed
+		job.Error = uploadErr.Error()
+	}
+	job.UpdatedAt = time.Now()
+	e := store.saveUnlessReplaced(job)
+	if e != nil {
+		return e
+	}
+	e = os.Remove(store.BitsPath(job))
+	if e != nil && !os.IsNotExist(e) {
+		return errors.Wrapf(e, "Could not remove bits of upload job %v", job.Guid)
+	}
+	return store.removeFinishedJobsOlderThan(time.Now().Add(-store.retention))
+}
+
+// Get returns a *NotFoundError if there is no job for guid.
+func (store *UploadJobStore) Get(guid string) (*UploadJob, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.load(store.jobPath(guid))
+}
+
+// Pending returns all jobs still in state PROCESSING_UPLOAD, e.g. because the process was restarted while uploading them.
+func (store *UploadJobStore) Pending() ([]*UploadJob, error) {
+	jobs, e := store.all()
+	if e != nil {
+		return nil, e
+	}
+	var pending []*UploadJob
+	for _, job := range jobs {
+		if job.State == UploadJobStateProcessing {
+			pending = append(pending, job)
+		}
+	}
+	return pending, nil
+}
+
+func (store *UploadJobStore) BitsPath(job *UploadJob) string {
+	if job.ID == "" {
+		return filepath.Join(store.dir, job.Guid+".bits")
+	}
+	return filepath.Join(store.dir, job.Guid+"."+job.ID+".bits")
+}
+
+func (store *UploadJobStore) jobPath(guid string) string {
+	return filepath.Join(store.dir, guid+".json")
+}
+
+func (store *UploadJobStore) save(job *UploadJob) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.write(job)
+}
+
+// saveUnlessReplaced does not save job, when a newer job for the same guid replaced it.
+func (store *UploadJobStore) saveUnlessReplaced(job *UploadJob) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	current, e := store.load(store.jobPath(job.Guid))
+	if e != nil && !IsNotFoundError(e) {
+		return e
+	}
+	if current != nil && current.ID != job.ID {
+		return nil
+	}
+	return store.write(job)
+}
+
+// write must be called with mutex locked.
+func (store *UploadJobStore) write(job *UploadJob) error {
+	content, e := json.Marshal(job)
+	if e != nil {
+		return errors.WithStack(e)
+	}
+	// Writing to a temporary file first makes sure a crash never leaves a partially written job behind.
+	tempFile, e := ioutil.TempFile(store.dir, job.Guid+".json.tmp")
+	if e != nil {
+		return errors.Wrapf(e, "Could not save upload job %v", job.Guid)
+	}
+	_, e = tempFile.Write(content)
+	if e == nil {
+		e = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if e == nil {
+		e = closeErr
+	}
+	if e == nil {
+		e = os.Rename(tempFile.Name(), store.jobPath(job.Guid))
+	}
+	if e != nil {
+		os.Remove(tempFile.Name())
+		return errors.Wrapf(e, "Could not save upload job %v", job.Guid)
+	}
+	return nil
+}
+
+func (store *UploadJobStore) load(path string) (*UploadJob, error) {
+	content, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		return nil, NewNotFoundErrorWithKey(path)
+	}
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not read upload job %v", path)
+	}
+	var job UploadJob
+	e = json.Unmarshal(content, &job)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not parse upload job %v", path)
+	}
+	return &job, nil
+}
+
+func (store *UploadJobStore) all() ([]*UploadJob, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	paths, e := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if e != nil {
+		return nil, errors.WithStack(e)
+	}
+	var jobs []*UploadJob
+	for _, path := range paths {
+		job, e := store.load(path)
+		if IsNotFoundError(e) {
+			continue
+		}
+		if e != nil {
+			return nil, e
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (store *UploadJobStore) removeFinishedJobsOlderThan(t time.Time) error {
+	jobs, e := store.all()
+	if e != nil {
+		return e
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, job := range jobs {
+		if job.State != UploadJobStateProcessing && job.UpdatedAt.Before(t) {
+			e = os.Remove(store.jobPath(job.Guid))
+			if e != nil && !os.IsNotExist(e) {
+				return errors.Wrapf(e, "Could not remove upload job %v", job.Guid)
+			}
+		}
+	}
+	return nil
+}
+
+// moveFile falls back to copying, because the temporary directory is often on a different file system.
+func moveFile(from, to string) error {
+	e := os.Rename(from, to)
+	if e == nil {
+		return nil
+	}
+	if linkErr, ok := e.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return e
+	}
+	source, e := os.Open(from)
+	if e != nil {
+		return e
+	}
+	defer source.Close()
+	destination, e := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if e != nil {
+		return e
+	}
+	_, e = io.Copy(destination, source)
+	if e == nil {
+		e = destination.Sync()
+	}
+	closeErr := destination.Close()
+	if e == nil {
+		e = closeErr
+	}
+	if e != nil {
+		os.Remove(to)
+		return e
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,102 @@
+package bitsgo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/bits-service"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("UploadJobStore", func() {
+	var (
+		dir        string
+		store      *UploadJobStore
+		bitsSource string
+	)
+
+	BeforeEach(func() {
+		var e error
+		dir, e = ioutil.TempDir("", "upload-jobs")
+		Expect(e).NotTo(HaveOccurred())
+		store, e = NewUploadJobStore(filepath.Join(dir, "jobs"), time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+		bitsSource = filepath.Join(dir, "bits")
+		Expect(ioutil.WriteFile(bitsSource, []byte("the bits"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("moves the bits into the store and records the job as processing", func() {
+		job, e := store.Add("theguid", bitsSource, "sha1", "sha256", "")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(UploadJobStateProcessing))
+
+		Expect(bitsSource).NotTo(BeAnExistingFile())
+		Expect(ioutil.ReadFile(store.BitsPath(job))).To(Equal([]byte("the bits")))
+
+		job, e = store.Get("theguid")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(job.Guid).To(Equal("theguid"))
+		Expect(job.State).To(Equal(UploadJobStateProcessing))
+		Expect(job.Sha256).To(Equal("sha256"))
+	})
+
+	It("lists unfinished jobs as pending, also in a new store for the same directory", func() {
+		_, e := store.Add("theguid", bitsSource, "sha1", "sha256", "")
+		Expect(e).NotTo(HaveOccurred())
+
+		store, e = NewUploadJobStore(filepath.Join(dir, "jobs"), time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+		pending, e := store.Pending()
+		Expect(e).NotTo(HaveOccurred())
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].Guid).To(Equal("theguid"))
+	})
+
+	It("records failures with their error and removes the bits", func() {
+		job, e := store.Add("theguid", bitsSource, "sha1", "sha256", "")
+		Expect(e).NotTo(HaveOccurred())
+
+		Expect(store.Finish(job, errors.New("some error"))).To(Succeed())
+
+		job, e = store.Get("theguid")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(job.State).To(Equal(UploadJobStateFailed))
+		Expect(job.Error).To(Equal("some error"))
+		Expect(store.BitsPath(job)).NotTo(BeAnExistingFile())
+		Expect(store.Pending()).To(BeEmpty())
+	})
+
+	It("keeps the bits of a job when a new one for the same guid is added and finished meanwhile", func() {
+		job, e := store.Add("theguid", bitsSource, "sha1", "sha256", "")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(bitsSource, []byte("new bits"), 0600)).To(Succeed())
+		newJob, e := store.Add("theguid", bitsSource, "new-sha1", "new-sha256", "")
+		Expect(e).NotTo(HaveOccurred())
+
+		Expect(store.Finish(job, errors.New("some error"))).To(Succeed())
+
+		Expect(ioutil.ReadFile(store.BitsPath(newJob))).To(Equal([]byte("new bits")))
+		current, e := store.Get("theguid")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(current.State).To(Equal(UploadJobStateProcessing))
+		Expect(current.Sha1).To(Equal("new-sha1"))
+
+		Expect(store.Finish(newJob, nil)).To(Succeed())
+
+		Expect(store.BitsPath(newJob)).NotTo(BeAnExistingFile())
+		current, e = store.Get("theguid")
+		Expect(e).NotTo(HaveOccurred())
+		Expect(current.State).To(Equal(UploadJobStateReady))
+	})
+
+	It("returns a NotFoundError for unknown jobs", func() {
+		_, e := store.Get("unknown")
+		Expect(e).To(BeAssignableToTypeOf(&NotFoundError{}))
+	})
+})
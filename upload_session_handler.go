@@ -0,0 +1,186 @@
+package bitsgo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/cloudfoundry-incubator/bits-service/util"
+)
+
+// UploadSessionHandler serves resumable uploads of packages and droplets. It follows the chunked upload flow of
+// the OCI distribution spec: POST starts a session, PATCH appends a chunk, GET reports the progress,
+// PUT with a Digest header finalizes the upload and DELETE cancels it.
+//
+// There is at most one session per resource, so the session is addressed by the resource's identifier.
+type UploadSessionHandler struct {
+	resourceHandler *ResourceHandler
+	sessions        *UploadSessionStore
+}
+
+func NewUploadSessionHandler(resourceHandler *ResourceHandler, sessions *UploadSessionStore) *UploadSessionHandler {
+	return &UploadSessionHandler{resourceHandler: resourceHandler, sessions: sessions}
+}
+
+var contentRangeRegex = regexp.MustCompile(`^(?:bytes )?(\d+)-(\d+)(?:/(?:\d+|\*))?$`)
+
+func (handler *UploadSessionHandler) Create(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	e := handler.sessions.Create(params["identifier"])
+	if handleUploadSessionError(e, responseWriter, request) {
+		return
+	}
+	responseWriter.Header().Set("Location", request.URL.Path)
+	writeUploadProgress(responseWriter, 0)
+	responseWriter.WriteHeader(http.StatusAccepted)
+}
+
+// AppendChunk appends the request body at the offset given in the Content-Range header or, without one,
+// at the session's current offset.
+func (handler *UploadSessionHandler) AppendChunk(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	if !HandleBodySizeLimits(responseWriter, request, handler.resourceHandler.maxBodySizeLimit) {
+		return
+	}
+	var offset int64
+	if contentRange := request.Header.Get("Content-Range"); contentRange != "" {
+		match := contentRangeRegex.FindStringSubmatch(contentRange)
+		if match == nil {
+			badRequest(responseWriter, request, "Content-Range must have format <start>-<end>, but is '%v'", contentRange)
+			return
+		}
+		// The regex guarantees that both are numbers
+		start, _ := strconv.ParseInt(match[1], 10, 64)
+		end, _ := strconv.ParseInt(match[2], 10, 64)
+		if end < start || (request.ContentLength != -1 && end-start+1 != request.ContentLength) {
+			badRequest(responseWriter, request, "Content-Range '%v' does not match Content-Length %v", contentRange, request.ContentLength)
+			return
+		}
+		offset = start
+	} else {
+		var e error
+		offset, e = handler.sessions.Offset(params["identifier"])
+		if handleUploadSessionError(e, responseWriter, request) {
+			return
+		}
+	}
+
+	newOffset, e := handler.sessions.Append(params["identifier"], offset, request.Body, int64(handler.resourceHandler.maxBodySizeLimit))
+	if handleUploadSessionError(e, responseWriter, request) {
+		return
+	}
+	responseWriter.Header().Set("Location", request.URL.Path)
+	writeUploadProgress(responseWriter, newOffset)
+	responseWriter.WriteHeader(http.StatusAccepted)
+}
+
+func (handler *UploadSessionHandler) Status(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	offset, e := handler.sessions.Offset(params["identifier"])
+	if handleUploadSessionError(e, responseWriter, request) {
+		return
+	}
+	responseWriter.Header().Set("Location", request.URL.Path)
+	writeUploadProgress(responseWriter, offset)
+	responseWriter.WriteHeader(http.StatusNoContent)
+}
+
+// Finalize verifies the uploaded bits against the Digest header and stores them as the resource.
+// A non-empty request body is appended as last chunk first. The session is kept when storing fails, so that clients can retry.
+func (handler *UploadSessionHandler) Finalize(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	digest := request.Header.Get("Digest")
+	if digest == "" {
+		badRequest(responseWriter, request, "No Digest header")
+		return
+	}
+	expectedDigests, e := ParseDigestHeader(digest)
+	if e != nil {
+		badRequest(responseWriter, request, "%v", e.Error())
+		return
+	}
+
+	if request.ContentLength != 0 {
+		offset, e := handler.sessions.Offset(params["identifier"])
+		if handleUploadSessionError(e, responseWriter, request) {
+			return
+		}
+		_, e = handler.sessions.Append(params["identifier"], offset, request.Body, int64(handler.resourceHandler.maxBodySizeLimit))
+		if handleUploadSessionError(e, responseWriter, request) {
+			return
+		}
+	}
+
+	e = handler.sessions.Finalize(params["identifier"], func(bitsFilename string) bool {
+		file, e := os.Open(bitsFilename)
+		util.PanicOnError(e)
+		defer file.Close()
+
+		digestWriter := newDigestWriter(expectedDigests)
+		size, e := io.Copy(digestWriter, file)
+		util.PanicOnError(e)
+		e = digestWriter.Verify(expectedDigests)
+		if e != nil {
+			logger.From(request).Infow("Rejecting upload", "error", e)
+			responseWriter.WriteHeader(http.StatusUnprocessableEntity)
+			util.FprintDescriptionAndCodeAsJSON(responseWriter, 290010, "%v", e.Error())
+			return false
+		}
+		_, e = file.Seek(0, io.SeekStart)
+		util.PanicOnError(e)
+
+		if handler.resourceHandler.resourceType == "droplet" {
+			return handler.storeDroplet(responseWriter, request, params["identifier"], bitsFilename, digestWriter.Sha256())
+		}
+		return handler.resourceHandler.addOrReplaceFrom(responseWriter, request, params, file, size)
+	})
+	handleUploadSessionError(e, responseWriter, request)
+}
+
+func (handler *UploadSessionHandler) storeDroplet(responseWriter http.ResponseWriter, request *http.Request, identifier string, bitsFilename string, sha256 string) (stored bool) {
+	e := handler.resourceHandler.uploadFileWithRetries(request.Context(), bitsFilename, identifier+"/"+sha256, logger.From(request))
+	writeResponseBasedOn("", e, responseWriter, request, http.StatusCreated, &ResponseBody{Guid: identifier, State: "READY", Type: "bits", CreatedAt: time.Now(), Sha256: sha256})
+	return e == nil
+}
+
+func (handler *UploadSessionHandler) Cancel(responseWriter http.ResponseWriter, request *http.Request, params map[string]string) {
+	e := handler.sessions.Delete(params["identifier"])
+	if handleUploadSessionError(e, responseWriter, request) {
+		return
+	}
+	responseWriter.WriteHeader(http.StatusNoContent)
+}
+
+// writeUploadProgress sets the Range header as the OCI distribution spec does and the Upload-Offset header as tus.io does.
+func writeUploadProgress(responseWriter http.ResponseWriter, offset int64) {
+	if offset > 0 {
+		responseWriter.Header().Set("Range", fmt.Sprintf("0-%v", offset-1))
+	}
+	responseWriter.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+}
+
+func handleUploadSessionError(e error, responseWriter http.ResponseWriter, request *http.Request) (wasError bool) {
+	switch e := e.(type) {
+	case nil:
+		return false
+	case *NotFoundError:
+		responseWriter.WriteHeader(http.StatusNotFound)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 290012, "No upload session found. Start one with POST.")
+	case *UploadOffsetMismatchError:
+		logger.From(request).Infow("Rejecting chunk", "error", e)
+		writeUploadProgress(responseWriter, e.Offset)
+		responseWriter.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 290013, "%v", e.Error())
+	case *UploadSessionBusyError:
+		responseWriter.WriteHeader(http.StatusConflict)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 290014, "%v", e.Error())
+	case *UploadTooLargeError:
+		logger.From(request).Infow("Rejecting chunk", "error", e)
+		responseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+		util.FprintDescriptionAndCodeAsJSON(responseWriter, 290015, "%v", e.Error())
+	default:
+		panic(e)
+	}
+	return true
+}
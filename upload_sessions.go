@@ -0,0 +1,364 @@
+package bitsgo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bits-service/logger"
+	"github.com/pkg/errors"
+)
+
+type UploadOffsetMismatchError struct {
+	error
+	// Offset is where the next chunk must start.
+	Offset int64
+}
+
+func NewUploadOffsetMismatchError(expectedOffset int64, offset int64) *UploadOffsetMismatchError {
+	return &UploadOffsetMismatchError{fmt.Errorf("Chunk must start at offset %v, but starts at %v", expectedOffset, offset), expectedOffset}
+}
+
+type UploadSessionBusyError struct {
+	error
+}
+
+func NewUploadSessionBusyError(identifier string) *UploadSessionBusyError {
+	return &UploadSessionBusyError{fmt.Errorf("Upload session %v is busy with another request", identifier)}
+}
+
+type UploadTooLargeError struct {
+	error
+}
+
+func NewUploadTooLargeError(maxSize int64) *UploadTooLargeError {
+	return &UploadTooLargeError{fmt.Errorf("Upload exceeds maximum size of %v bytes", maxSize)}
+}
+
+// UploadSessionStore keeps the chunks of resumable uploads in a blobstore which all bits-service instances share,
+// so that every request of an upload can go to a different instance. There is at most one session per identifier.
+// A session consists of <identifier>/session.<time> and <identifier>/chunk.<start>-<end>.<time> for every chunk,
+// where <time> is when it was written in Unix nanoseconds. Sessions which were not written to for longer than ttl are removed by RemoveExpired.
+//
+// Chunks are buffered in tempDir while they are received, and all chunks of a session are assembled there when it is finalized.
+// Requests for the same session are serialized per instance only. When chunks for the same offset arrive via different instances
+// at the same time, the longest one is kept.
+type UploadSessionStore struct {
+	store   Blobstore
+	tempDir string
+	ttl     time.Duration
+	mutex   sync.Mutex
+	// Sessions currently appended to or finalized by this instance
+	busy map[string]bool
+}
+
+type uploadSessionBlob struct {
+	path       string
+	identifier string
+	isChunk    bool
+	start, end int64
+	written    time.Time
+}
+
+func NewUploadSessionStore(store Blobstore, tempDir string, ttl time.Duration) (*UploadSessionStore, error) {
+	e := os.MkdirAll(tempDir, 0700)
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not create upload sessions directory %v", tempDir)
+	}
+	return &UploadSessionStore{store: store, tempDir: tempDir, ttl: ttl, busy: make(map[string]bool)}, nil
+}
+
+// Create starts a new session for identifier, discarding an existing one.
+func (store *UploadSessionStore) Create(identifier string) error {
+	e := store.acquire(identifier)
+	if e != nil {
+		return e
+	}
+	defer store.release(identifier)
+
+	e = store.remove(identifier)
+	if e != nil {
+		return e
+	}
+	return errors.Wrapf(
+		store.store.Put(fmt.Sprintf("%v/session.%v", identifier, time.Now().UnixNano()), bytes.NewReader(nil)),
+		"Could not create upload session %v", identifier)
+}
+
+// Offset returns a *NotFoundError if there is no session for identifier.
+func (store *UploadSessionStore) Offset(identifier string) (int64, error) {
+	blobs, e := store.blobsOf(identifier)
+	if e != nil {
+		return 0, e
+	}
+	_, offset := chunkChainOf(blobs)
+	return offset, nil
+}
+
+// Append adds chunk at offset, which must be the session's current offset. A maxSize of 0 means unlimited.
+// When reading chunk fails, everything read so far is kept, so that the client can resume from the new offset.
+func (store *UploadSessionStore) Append(identifier string, offset int64, chunk io.Reader, maxSize int64) (newOffset int64, err error) {
+	e := store.acquire(identifier)
+	if e != nil {
+		return 0, e
+	}
+	defer store.release(identifier)
+
+	currentOffset, e := store.Offset(identifier)
+	if e != nil {
+		return 0, e
+	}
+	if offset != currentOffset {
+		return currentOffset, NewUploadOffsetMismatchError(currentOffset, offset)
+	}
+	file, e := ioutil.TempFile(store.tempDir, "chunk")
+	if e != nil {
+		return currentOffset, errors.Wrapf(e, "Could not buffer chunk of upload session %v", identifier)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if maxSize > 0 {
+		chunk = io.LimitReader(chunk, maxSize-currentOffset+1)
+	}
+	written, copyErr := io.Copy(file, chunk)
+	newOffset = currentOffset + written
+	if maxSize > 0 && newOffset > maxSize {
+		return currentOffset, NewUploadTooLargeError(maxSize)
+	}
+	if written > 0 {
+		_, e = file.Seek(0, io.SeekStart)
+		if e != nil {
+			return currentOffset, errors.Wrapf(e, "Could not read buffered chunk of upload session %v", identifier)
+		}
+		e = store.store.Put(fmt.Sprintf("%v/chunk.%v-%v.%v", identifier, currentOffset, newOffset, time.Now().UnixNano()), file)
+		if e != nil {
+			return currentOffset, errors.Wrapf(e, "Could not write chunk of upload session %v", identifier)
+		}
+	}
+	if copyErr != nil {
+		return newOffset, errors.Wrapf(copyErr, "Could not read chunk of upload session %v", identifier)
+	}
+	return newOffset, nil
+}
+
+// Finalize calls finalize with a file holding all chunks of the session and removes the session, when finalize returns true.
+// Otherwise the session is kept, so that the client can retry.
+func (store *UploadSessionStore) Finalize(identifier string, finalize func(bitsFilename string) (done bool)) error {
+	e := store.acquire(identifier)
+	if e != nil {
+		return e
+	}
+	defer store.release(identifier)
+
+	blobs, e := store.blobsOf(identifier)
+	if e != nil {
+		return e
+	}
+	bitsFilename, e := store.assemble(identifier, blobs)
+	if e != nil {
+		return e
+	}
+	defer os.Remove(bitsFilename)
+	if !finalize(bitsFilename) {
+		return nil
+	}
+	return store.remove(identifier)
+}
+
+// Delete returns a *NotFoundError if there is no session for identifier.
+func (store *UploadSessionStore) Delete(identifier string) error {
+	e := store.acquire(identifier)
+	if e != nil {
+		return e
+	}
+	defer store.release(identifier)
+
+	if _, e = store.blobsOf(identifier); e != nil {
+		return e
+	}
+	return store.remove(identifier)
+}
+
+// blobsOf returns a *NotFoundError if there is no session for identifier.
+func (store *UploadSessionStore) blobsOf(identifier string) ([]uploadSessionBlob, error) {
+	var (
+		blobs     []uploadSessionBlob
+		hasMarker bool
+	)
+	e := store.forEachBlob(identifier+"/", func(blob uploadSessionBlob) {
+		if blob.identifier != identifier {
+			return
+		}
+		blobs = append(blobs, blob)
+		hasMarker = hasMarker || !blob.isChunk
+	})
+	if e != nil {
+		return nil, errors.Wrapf(e, "Could not read upload session %v", identifier)
+	}
+	if !hasMarker {
+		return nil, NewNotFoundErrorWithKey(identifier)
+	}
+	return blobs, nil
+}
+
+// assemble downloads the chunks of a session into a temporary file and returns its name.
+func (store *UploadSessionStore) assemble(identifier string, blobs []uploadSessionBlob) (string, error) {
+	file, e := ioutil.TempFile(store.tempDir, "upload")
+	if e != nil {
+		return "", errors.Wrapf(e, "Could not assemble upload session %v", identifier)
+	}
+	defer file.Close()
+	chain, _ := chunkChainOf(blobs)
+	for _, chunk := range chain {
+		e = store.copyChunk(file, chunk)
+		if e != nil {
+			os.Remove(file.Name())
+			return "", errors.Wrapf(e, "Could not assemble upload session %v", identifier)
+		}
+	}
+	return file.Name(), nil
+}
+
+func (store *UploadSessionStore) copyChunk(dest io.Writer, chunk uploadSessionBlob) error {
+	body, e := store.store.Get(chunk.path)
+	if e != nil {
+		return e
+	}
+	defer body.Close()
+	_, e = io.Copy(dest, body)
+	return e
+}
+
+func (store *UploadSessionStore) acquire(identifier string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.busy[identifier] {
+		return NewUploadSessionBusyError(identifier)
+	}
+	store.busy[identifier] = true
+	return nil
+}
+
+func (store *UploadSessionStore) release(identifier string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.busy, identifier)
+}
+
+func (store *UploadSessionStore) remove(identifier string) error {
+	return errors.Wrapf(store.store.DeleteDir(identifier+"/"), "Could not remove upload session %v", identifier)
+}
+
+// RemoveExpired removes the sessions without new chunks within the TTL. Sessions this instance is busy with are kept.
+func (store *UploadSessionStore) RemoveExpired() error {
+	return store.removeSessionsOlderThan(time.Now().Add(-store.ttl))
+}
+
+func (store *UploadSessionStore) RemoveExpiredPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		e := store.RemoveExpired()
+		if e != nil {
+			logger.Log.Errorw("Could not remove expired upload sessions", "error", e)
+		}
+	}
+}
+
+func (store *UploadSessionStore) removeSessionsOlderThan(t time.Time) error {
+	lastWrites := make(map[string]time.Time)
+	e := store.forEachBlob("", func(blob uploadSessionBlob) {
+		if blob.written.After(lastWrites[blob.identifier]) {
+			lastWrites[blob.identifier] = blob.written
+		}
+	})
+	if e != nil {
+		return errors.Wrap(e, "Could not list upload sessions")
+	}
+	for identifier, lastWrite := range lastWrites {
+		if !lastWrite.Before(t) || store.acquire(identifier) != nil {
+			continue
+		}
+		e = store.remove(identifier)
+		store.release(identifier)
+		if e != nil {
+			return errors.Wrapf(e, "Could not remove expired upload session %v", identifier)
+		}
+	}
+	return nil
+}
+
+// forEachBlob skips blobs which do not belong to a session.
+func (store *UploadSessionStore) forEachBlob(prefix string, f func(blob uploadSessionBlob)) error {
+	pageToken := ""
+	for {
+		infos, nextPageToken, e := store.store.List(prefix, pageToken)
+		if e != nil {
+			return e
+		}
+		for _, info := range infos {
+			if blob, ok := parseUploadSessionBlob(info.Path); ok {
+				f(blob)
+			}
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+func parseUploadSessionBlob(path string) (uploadSessionBlob, bool) {
+	separatorIndex := strings.LastIndex(path, "/")
+	if separatorIndex == -1 {
+		return uploadSessionBlob{}, false
+	}
+	blob := uploadSessionBlob{path: path, identifier: path[:separatorIndex]}
+	parts := strings.Split(path[separatorIndex+1:], ".")
+	switch {
+	case len(parts) == 2 && parts[0] == "session":
+	case len(parts) == 3 && parts[0] == "chunk":
+		if _, e := fmt.Sscanf(parts[1], "%d-%d", &blob.start, &blob.end); e != nil || blob.end <= blob.start {
+			return uploadSessionBlob{}, false
+		}
+		blob.isChunk = true
+	default:
+		return uploadSessionBlob{}, false
+	}
+	nanos, e := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if e != nil {
+		return uploadSessionBlob{}, false
+	}
+	blob.written = time.Unix(0, nanos)
+	return blob, true
+}
+
+// chunkChainOf returns the chunks which make up the upload, in order, and the offset of the next chunk.
+// Chunks which do not continue the chain, e.g. because another instance wrote a longer one at the same offset, are ignored.
+func chunkChainOf(blobs []uploadSessionBlob) (chain []uploadSessionBlob, offset int64) {
+	var chunks []uploadSessionBlob
+	for _, blob := range blobs {
+		if blob.isChunk {
+			chunks = append(chunks, blob)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].start != chunks[j].start {
+			return chunks[i].start < chunks[j].start
+		}
+		return chunks[i].end > chunks[j].end
+	})
+	for _, chunk := range chunks {
+		if chunk.start == offset {
+			chain = append(chain, chunk)
+			offset = chunk.end
+		}
+	}
+	return chain, offset
+}
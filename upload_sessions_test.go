@@ -0,0 +1,241 @@
+package bitsgo_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/cloudfoundry-incubator/bits-service"
+	inmemory "github.com/cloudfoundry-incubator/bits-service/blobstores/inmemory"
+	. "github.com/petergtz/pegomock"
+)
+
+var _ = Describe("UploadSessionStore", func() {
+	var (
+		dir          string
+		sessionBlobs *inmemory.Blobstore
+		store        *UploadSessionStore
+	)
+
+	BeforeEach(func() {
+		var e error
+		dir, e = ioutil.TempDir("", "upload-sessions")
+		Expect(e).NotTo(HaveOccurred())
+		sessionBlobs = inmemory.NewBlobstore()
+		store, e = NewUploadSessionStore(sessionBlobs, filepath.Join(dir, "sessions"), time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("appends chunks at the current offset", func() {
+		Expect(store.Create("theguid")).To(Succeed())
+
+		Expect(store.Append("theguid", 0, strings.NewReader("the "), 0)).To(BeEquivalentTo(4))
+		Expect(store.Append("theguid", 4, strings.NewReader("bits"), 0)).To(BeEquivalentTo(8))
+		Expect(store.Offset("theguid")).To(BeEquivalentTo(8))
+
+		Expect(store.Finalize("theguid", func(bitsFilename string) bool {
+			Expect(ioutil.ReadFile(bitsFilename)).To(Equal([]byte("the bits")))
+			return true
+		})).To(Succeed())
+		_, e := store.Offset("theguid")
+		Expect(e).To(BeAssignableToTypeOf(&NotFoundError{}))
+	})
+
+	It("rejects chunks which do not start at the current offset", func() {
+		Expect(store.Create("theguid")).To(Succeed())
+		Expect(store.Append("theguid", 0, strings.NewReader("the "), 0)).To(BeEquivalentTo(4))
+
+		_, e := store.Append("theguid", 2, strings.NewReader("bits"), 0)
+
+		Expect(e).To(BeAssignableToTypeOf(&UploadOffsetMismatchError{}))
+		Expect(e.(*UploadOffsetMismatchError).Offset).To(BeEquivalentTo(4))
+		Expect(store.Offset("theguid")).To(BeEquivalentTo(4))
+	})
+
+	It("discards chunks which would exceed the maximum size", func() {
+		Expect(store.Create("theguid")).To(Succeed())
+		Expect(store.Append("theguid", 0, strings.NewReader("the "), 6)).To(BeEquivalentTo(4))
+
+		_, e := store.Append("theguid", 4, strings.NewReader("bits"), 6)
+
+		Expect(e).To(BeAssignableToTypeOf(&UploadTooLargeError{}))
+		Expect(store.Offset("theguid")).To(BeEquivalentTo(4))
+	})
+
+	It("keeps the session when finalizing does not succeed", func() {
+		Expect(store.Create("theguid")).To(Succeed())
+
+		Expect(store.Finalize("theguid", func(string) bool { return false })).To(Succeed())
+
+		Expect(store.Offset("theguid")).To(BeEquivalentTo(0))
+	})
+
+	It("removes expired sessions", func() {
+		past := time.Now().Add(-2 * time.Hour).UnixNano()
+		Expect(sessionBlobs.Put(fmt.Sprintf("expired/session.%v", past), strings.NewReader(""))).To(Succeed())
+		Expect(sessionBlobs.Put(fmt.Sprintf("expired/chunk.0-4.%v", past), strings.NewReader("the "))).To(Succeed())
+		Expect(store.Offset("expired")).To(BeEquivalentTo(4))
+		Expect(store.Create("theguid")).To(Succeed())
+
+		Expect(store.RemoveExpired()).To(Succeed())
+
+		_, e := store.Offset("expired")
+		Expect(e).To(BeAssignableToTypeOf(&NotFoundError{}))
+		Expect(sessionBlobs.Entries).To(HaveLen(1))
+	})
+
+	It("continues sessions started via other instances", func() {
+		otherStore, e := NewUploadSessionStore(sessionBlobs, filepath.Join(dir, "other-sessions"), time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+
+		Expect(store.Create("theguid")).To(Succeed())
+		Expect(store.Append("theguid", 0, strings.NewReader("the "), 0)).To(BeEquivalentTo(4))
+		Expect(otherStore.Offset("theguid")).To(BeEquivalentTo(4))
+		Expect(otherStore.Append("theguid", 4, strings.NewReader("bits"), 0)).To(BeEquivalentTo(8))
+
+		Expect(store.Finalize("theguid", func(bitsFilename string) bool {
+			Expect(ioutil.ReadFile(bitsFilename)).To(Equal([]byte("the bits")))
+			return true
+		})).To(Succeed())
+		Expect(sessionBlobs.Entries).To(BeEmpty())
+		Expect(ioutil.ReadDir(filepath.Join(dir, "sessions"))).To(BeEmpty())
+	})
+
+	It("keeps the longest of the chunks written at the same offset", func() {
+		Expect(store.Create("theguid")).To(Succeed())
+		Expect(sessionBlobs.Put("theguid/chunk.0-4.1", strings.NewReader("the "))).To(Succeed())
+		Expect(sessionBlobs.Put("theguid/chunk.0-2.2", strings.NewReader("th"))).To(Succeed())
+
+		Expect(store.Offset("theguid")).To(BeEquivalentTo(4))
+	})
+
+	It("returns a NotFoundError for unknown sessions", func() {
+		_, e := store.Append("unknown", 0, strings.NewReader("bits"), 0)
+		Expect(e).To(BeAssignableToTypeOf(&NotFoundError{}))
+		Expect(store.Delete("unknown")).To(BeAssignableToTypeOf(&NotFoundError{}))
+	})
+})
+
+var _ = Describe("UploadSessionHandler", func() {
+	var (
+		dir            string
+		blobstore      *MockBlobstore
+		handler        *UploadSessionHandler
+		responseWriter *httptest.ResponseRecorder
+		params         map[string]string
+	)
+
+	BeforeEach(func() {
+		var e error
+		dir, e = ioutil.TempDir("", "upload-sessions")
+		Expect(e).NotTo(HaveOccurred())
+		sessions, e := NewUploadSessionStore(inmemory.NewBlobstore(), dir, time.Hour)
+		Expect(e).NotTo(HaveOccurred())
+		blobstore = NewMockBlobstore()
+		handler = NewUploadSessionHandler(
+			NewResourceHandler(blobstore, NewMockBlobstore(), "droplet", NewMockMetricsService(), 20, false),
+			sessions)
+		responseWriter = httptest.NewRecorder()
+		params = map[string]string{"identifier": "someguid"}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	request := func(method string, body string, headers ...string) *http.Request {
+		request := httptest.NewRequest(method, "/droplets/someguid/uploads", strings.NewReader(body))
+		for i := 0; i < len(headers); i += 2 {
+			request.Header.Set(headers[i], headers[i+1])
+		}
+		return request
+	}
+
+	It("stores the droplet under its sha256 once all chunks are uploaded and the digest matches", func() {
+		handler.Create(responseWriter, request("POST", ""), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
+		Expect(responseWriter.Header().Get("Location")).To(Equal("/droplets/someguid/uploads"))
+		Expect(responseWriter.Header().Get("Upload-Offset")).To(Equal("0"))
+
+		responseWriter = httptest.NewRecorder()
+		handler.AppendChunk(responseWriter, request("PATCH", "My test ", "Content-Range", "0-7"), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusAccepted))
+		Expect(responseWriter.Header().Get("Range")).To(Equal("0-7"))
+
+		responseWriter = httptest.NewRecorder()
+		handler.Status(responseWriter, request("GET", ""), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusNoContent))
+		Expect(responseWriter.Header().Get("Upload-Offset")).To(Equal("8"))
+
+		responseWriter = httptest.NewRecorder()
+		handler.Finalize(responseWriter, request("PUT", "string", "Digest", "sha256=5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusCreated))
+		blobstore.VerifyWasCalledOnce().Put(EqString("someguid/5358c37942b0126084bb16f7d602788d00416e01bc3fd0132f4458dd355d8e76"), anyReadSeeker())
+
+		responseWriter = httptest.NewRecorder()
+		handler.Status(responseWriter, request("GET", ""), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("tells the client where to resume when a chunk starts at the wrong offset", func() {
+		handler.Create(responseWriter, request("POST", ""), params)
+		handler.AppendChunk(httptest.NewRecorder(), request("PATCH", "My test "), params)
+
+		responseWriter = httptest.NewRecorder()
+		handler.AppendChunk(responseWriter, request("PATCH", "My", "Content-Range", "2-3"), params)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+		Expect(responseWriter.Header().Get("Upload-Offset")).To(Equal("8"))
+		Expect(responseWriter.Header().Get("Range")).To(Equal("0-7"))
+	})
+
+	It("returns StatusBadRequest when Content-Range does not match the chunk's length", func() {
+		handler.Create(responseWriter, request("POST", ""), params)
+
+		responseWriter = httptest.NewRecorder()
+		handler.AppendChunk(responseWriter, request("PATCH", "My test ", "Content-Range", "0-3"), params)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects uploads exceeding the maximum body size", func() {
+		handler.Create(responseWriter, request("POST", ""), params)
+		handler.AppendChunk(httptest.NewRecorder(), request("PATCH", strings.Repeat("x", 15)), params)
+
+		responseWriter = httptest.NewRecorder()
+		handler.AppendChunk(responseWriter, request("PATCH", strings.Repeat("x", 15)), params)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("keeps the session when the digest does not match, so that it can be cancelled", func() {
+		handler.Create(responseWriter, request("POST", ""), params)
+		handler.AppendChunk(httptest.NewRecorder(), request("PATCH", "My test string"), params)
+
+		responseWriter = httptest.NewRecorder()
+		handler.Finalize(responseWriter, request("PUT", "", "Digest", "sha256="+strings.Repeat("0", 64)), params)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(responseWriter.Body.String()).To(ContainSubstring(`"code":290010`))
+		blobstore.VerifyWasCalled(Never()).Put(AnyString(), anyReadSeeker())
+
+		responseWriter = httptest.NewRecorder()
+		handler.Cancel(responseWriter, request("DELETE", ""), params)
+		Expect(responseWriter.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("returns StatusNotFound for chunks without session", func() {
+		handler.AppendChunk(responseWriter, request("PATCH", "My test string"), params)
+
+		Expect(responseWriter.Code).To(Equal(http.StatusNotFound))
+	})
+})